@@ -0,0 +1,54 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// Aggregated output assertions:
+// By default Define asserts every checked digest bit against its public Out bit, NHashes × 256
+// AssertIsEqual calls for the plain circuit. WithOutputAssertion folds them instead: each instance's
+// comparison becomes one CompareDigest "matched" wire (the AND of the bitwise XNORs), asserted to be 1
+// per instance (AggregatePerInstance) or ANDed over all instances into a single wire asserted once
+// (AggregateGlobal). The AND is what makes this sound: a single differing bit zeroes its XNOR and with it
+// the product, whereas a XOR (sum) of the difference bits would let two flipped bits cancel.
+//
+// Trade-off, per instance of c checked bits: c assertions become one, at the price of c XOR and c NOT
+// gates (the NOT is a subtraction from the free constant 1) and c-1 AND gates, and of ⌈log2 c⌉ more
+// multiplication layers (8 for a full digest) for the balanced product; AggregateGlobal adds n-1 AND
+// gates and ⌈log2 n⌉ layers on top. The assertion surface shrinks; the gate count grows by about 3c per
+// instance, against roughly 230k for the hash itself, so whether the layered circuit gets smaller
+// depends on what ecgo makes of the output layer. TestAggregatedOutput logs the gate statistics of the
+// three modes. AggregateGlobal needs every instance's wire in one place, so it is built sequentially
+// (no parallel Define).
+
+// OutputAssertion selects how Define asserts the digests against Out.
+type OutputAssertion int
+
+const (
+	AssertEachBit        OutputAssertion = iota // one AssertIsEqual per checked bit (the default)
+	AggregatePerInstance                        // one assertion per instance
+	AggregateGlobal                             // one assertion for the whole circuit
+)
+
+// WithOutputAssertion sets how the digests are asserted.
+func WithOutputAssertion(m OutputAssertion) CircuitOption {
+	return func(t *keccak256Circuit) { t.Outputs = m }
+}
+
+// assertDigest asserts out (the computed bits, at least len(expected)) against expected the way m says;
+// for AggregateGlobal nothing is asserted and the matched wire is returned for the caller to fold.
+func assertDigest(api frontend.API, m OutputAssertion, out []frontend.Variable, expected []frontend.Variable) frontend.Variable {
+	if m == AssertEachBit {
+		for j := range expected {
+			// Compares each output bit from the internal computation (out[j]) to the expected public output.
+			api.AssertIsEqual(out[j], expected[j])
+		}
+		return nil
+	}
+	matched := CompareDigest(api, out[:len(expected)], expected)
+	if m == AggregatePerInstance {
+		api.AssertIsEqual(matched, 1)
+		return nil
+	}
+	return matched
+}
@@ -0,0 +1,326 @@
+package keccakgf2
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/consensys/gnark/frontend"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Witness anonymization:
+// A failing keccak256Circuit assignment usually cannot be shared as is, because P holds the private
+// messages. AnonymizeFailure classifies the failure and, where the class does not depend on the message
+// values, rebuilds it around random messages:
+//   length-mismatch  the instance count and every Out length are kept, all values are synthetic
+//   non-boolean      the offending inputs keep their position and value, everything else is synthetic
+//   digest-mismatch  Out is the synthetic digest with the same bits flipped as in the original, so the
+//                    same Out[k][i] assertions fail; this reveals which bits differed, not their values
+// Anything else (every digest matches its message, yet the circuit rejects) is value-dependent: the
+// messages are redacted and only the structure and the public inputs (Context, Out) are kept.
+// The artifact is JSON (anonymizedCase); its Case rebuilds the reproducer with witnessCase.assignment.
+// Use -anonymize case.json to turn a case written with witnessCase.WriteFile into a shareable artifact.
+
+const (
+	failureLength         = "length-mismatch"
+	failureNonBoolean     = "non-boolean"
+	failureDigest         = "digest-mismatch"
+	failureValueDependent = "value-dependent"
+)
+
+// witnessCase is a keccak256Circuit assignment in JSON form. Bit vectors are strings of '0'/'1', character i
+// being bit i (LSB first within each byte); Values overrides inputs that are not a 0/1 bit.
+type witnessCase struct {
+	Context  string      `json:"context"`
+	Messages []string    `json:"messages,omitempty"`
+	Out      []string    `json:"out"`
+	Values   []caseValue `json:"values,omitempty"`
+}
+
+// caseValue is an input whose value is not a 0/1 bit, e.g. {"P[2][5]", "2"}. Value is fmt's %v of the
+// original; on the way back an integer becomes an int, "<nil>" nil and anything else stays a string.
+type caseValue struct {
+	Input string `json:"input"`
+	Value string `json:"value"`
+}
+
+// anonymizedCase is the shareable artifact of a failing assignment.
+type anonymizedCase struct {
+	Class        string   `json:"class"`
+	Instances    int      `json:"instances"`
+	ContextBytes int      `json:"contextBytes"`
+	DigestBits   []int    `json:"digestBits"`
+	Failures     []string `json:"failures"`
+	// Redacted is set when Case carries no messages (value-dependent failures).
+	Redacted bool        `json:"redacted"`
+	Case     witnessCase `json:"case"`
+}
+
+// newWitnessCase encodes assignment; inputs that are not a 0/1 bit are read as 0 in the bit strings and
+// the messages, and recorded in Values.
+func newWitnessCase(assignment *keccak256Circuit) *witnessCase {
+	wc := &witnessCase{Context: bitString(assignment.Context)}
+	for k := range assignment.P {
+		msg := make([]byte, 64)
+		for i, v := range assignment.P[k] {
+			b, _ := assignedBit(v)
+			msg[i/8] |= byte(b) << (i % 8)
+		}
+		wc.Messages = append(wc.Messages, hex.EncodeToString(msg))
+	}
+	for k := range assignment.Out {
+		wc.Out = append(wc.Out, bitString(assignment.Out[k]))
+	}
+	for _, l := range inputLeaves(assignment) {
+		if _, ok := assignedBit(l.value.Interface()); !ok {
+			wc.Values = append(wc.Values, caseValue{l.name, fmt.Sprint(l.value.Interface())})
+		}
+	}
+	return wc
+}
+
+// bitString writes bits as '0'/'1' characters; anything that is not a 0/1 bit is written as '0'.
+func bitString(bits []frontend.Variable) string {
+	var sb strings.Builder
+	for _, v := range bits {
+		b, _ := assignedBit(v)
+		sb.WriteByte(byte('0' + b))
+	}
+	return sb.String()
+}
+
+// parseBitString is the inverse of bitString.
+func parseBitString(s string) ([]frontend.Variable, error) {
+	bits := make([]frontend.Variable, len(s))
+	for i := range s {
+		if s[i] != '0' && s[i] != '1' {
+			return nil, fmt.Errorf("character %d is %q, not 0/1", i, s[i])
+		}
+		bits[i] = int(s[i] - '0')
+	}
+	return bits, nil
+}
+
+// assignment rebuilds the keccak256Circuit assignment of c. A redacted case has no messages and cannot be rebuilt.
+func (c *witnessCase) assignment() (*keccak256Circuit, error) {
+	if len(c.Messages) == 0 {
+		return nil, fmt.Errorf("witness case: no messages (redacted)")
+	}
+	a := &keccak256Circuit{P: make([][64 * 8]frontend.Variable, len(c.Messages)), Out: make([][]frontend.Variable, len(c.Out))}
+	for k, m := range c.Messages {
+		msg, err := decodeHex(m)
+		if err != nil || len(msg) != 64 {
+			return nil, fmt.Errorf("witness case: message %d is not 64 bytes of hex", k)
+		}
+		copy(a.P[k][:], bitsOf(msg))
+	}
+	for k, s := range c.Out {
+		bits, err := parseBitString(s)
+		if err != nil {
+			return nil, fmt.Errorf("witness case: Out[%d]: %w", k, err)
+		}
+		a.Out[k] = bits
+	}
+	if c.Context != "" {
+		bits, err := parseBitString(c.Context)
+		if err != nil {
+			return nil, fmt.Errorf("witness case: Context: %w", err)
+		}
+		a.Context = bits
+	}
+	leaves := map[string]reflect.Value{}
+	for _, l := range inputLeaves(a) {
+		leaves[l.name] = l.value
+	}
+	for _, cv := range c.Values {
+		leaf, ok := leaves[cv.Input]
+		if !ok {
+			return nil, fmt.Errorf("witness case: %s is not an input", cv.Input)
+		}
+		if cv.Value == "<nil>" {
+			leaf.Set(reflect.Zero(leaf.Type()))
+		} else if n, err := strconv.Atoi(cv.Value); err == nil {
+			leaf.Set(reflect.ValueOf(n))
+		} else {
+			leaf.Set(reflect.ValueOf(cv.Value))
+		}
+	}
+	return a, nil
+}
+
+// WriteFile writes c as indented JSON.
+func (c *witnessCase) WriteFile(path string) error {
+	raw, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, raw, 0o644)
+}
+
+// readWitnessCase reads a case written by WriteFile.
+func readWitnessCase(path string) (*witnessCase, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &witnessCase{}
+	if err := json.Unmarshal(raw, c); err != nil {
+		return nil, fmt.Errorf("witness case %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// AnonymizeFile reads a witness case written with witnessCase.WriteFile and returns its anonymized
+// artifact as indented JSON; the synthetic messages are read from rnd.
+func AnonymizeFile(path string, rnd io.Reader) ([]byte, error) {
+	wc, err := readWitnessCase(path)
+	if err != nil {
+		return nil, err
+	}
+	assignment, err := wc.assignment()
+	if err != nil {
+		return nil, err
+	}
+	artifact, err := AnonymizeFailure(assignment, rnd)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(artifact, "", "  ")
+}
+
+// classifyFailure returns the failure class of a keccak256Circuit assignment and the failing checks, in
+// the order length, non-boolean, digest; an assignment without any of them is value-dependent. A context
+// that is not whole bytes has no digest to compare with and is a length failure.
+func classifyFailure(a *keccak256Circuit) (string, []string) {
+	if _, err := a.checkBits(); err != nil {
+		return failureLength, []string{err.Error()}
+	}
+	var nonBoolean []string
+	for _, l := range inputLeaves(a) {
+		if _, ok := assignedBit(l.value.Interface()); !ok {
+			nonBoolean = append(nonBoolean, fmt.Sprintf("%s = %v", l.name, l.value.Interface()))
+		}
+	}
+	if len(nonBoolean) > 0 {
+		return failureNonBoolean, nonBoolean
+	}
+	var mismatches []string
+	for k := range a.Out {
+		diff, err := digestDiff(a, k)
+		if err != nil {
+			return failureLength, []string{err.Error()}
+		}
+		if len(diff) > 0 {
+			mismatches = append(mismatches, fmt.Sprintf("Out[%d]: %d of %d bits differ", k, len(diff), len(a.Out[k])))
+		}
+	}
+	if len(mismatches) > 0 {
+		return failureDigest, mismatches
+	}
+	return failureValueDependent, []string{"every digest matches its message; the failure depends on the values"}
+}
+
+// digestDiff returns the positions where Out[k] differs from the digest of Context || P[k]. a must have
+// consistent lengths; a context or message that is not whole bytes of 0/1 values is an error.
+func digestDiff(a *keccak256Circuit, k int) ([]int, error) {
+	context, err := assignedBytes(a.Context)
+	if err != nil {
+		return nil, fmt.Errorf("context: %w", err)
+	}
+	msg, err := assignedBytes(a.P[k][:])
+	if err != nil {
+		return nil, fmt.Errorf("P[%d]: %w", k, err)
+	}
+	want := bitsOf(crypto.Keccak256(context, msg))
+	var diff []int
+	for i := range a.Out[k] {
+		if a.Out[k][i] != want[i] {
+			diff = append(diff, i)
+		}
+	}
+	return diff, nil
+}
+
+// AnonymizeFailure turns a failing assignment into a shareable artifact; the synthetic messages are read from rnd.
+// The context is public and kept as is.
+func AnonymizeFailure(assignment *keccak256Circuit, rnd io.Reader) (*anonymizedCase, error) {
+	if assignment.PublicP != nil {
+		return nil, fmt.Errorf("AnonymizeFailure: the messages are public inputs, the case can be shared as is")
+	}
+	class, failures := classifyFailure(assignment)
+	ac := &anonymizedCase{
+		Class:        class,
+		Instances:    len(assignment.P),
+		ContextBytes: len(assignment.Context) / 8,
+		Failures:     failures,
+	}
+	for k := range assignment.Out {
+		ac.DigestBits = append(ac.DigestBits, len(assignment.Out[k]))
+	}
+	if class == failureValueDependent {
+		ac.Redacted = true
+		ac.Case = *newWitnessCase(assignment)
+		ac.Case.Messages = nil
+		return ac, nil
+	}
+
+	synthetic := &keccak256Circuit{
+		P:       make([][64 * 8]frontend.Variable, len(assignment.P)),
+		Out:     make([][]frontend.Variable, len(assignment.Out)),
+		Context: make([]frontend.Variable, len(assignment.Context)),
+	}
+	for i, v := range assignment.Context {
+		b, _ := assignedBit(v)
+		synthetic.Context[i] = b
+	}
+	context, err := assignedBytes(synthetic.Context[:len(synthetic.Context)/8*8])
+	if err != nil {
+		return nil, err
+	}
+	digests := make([][]frontend.Variable, len(assignment.Out))
+	for k := range synthetic.P {
+		msg := make([]byte, 64)
+		if _, err := io.ReadFull(rnd, msg); err != nil {
+			return nil, err
+		}
+		copy(synthetic.P[k][:], bitsOf(msg))
+		if k < len(digests) {
+			digests[k] = bitsOf(crypto.Keccak256(context, msg))
+		}
+	}
+	for k := range synthetic.Out {
+		synthetic.Out[k] = make([]frontend.Variable, len(assignment.Out[k]))
+		for i := range synthetic.Out[k] {
+			synthetic.Out[k][i] = 0
+			if digests[k] != nil && i < len(digests[k]) {
+				synthetic.Out[k][i] = digests[k][i]
+			}
+		}
+	}
+	switch class {
+	case failureNonBoolean:
+		// the offending values go back to their positions (Case.Values)
+		wc := newWitnessCase(synthetic)
+		wc.Values = newWitnessCase(assignment).Values
+		ac.Case = *wc
+		return ac, nil
+	case failureDigest:
+		for k := range synthetic.Out {
+			diff, err := digestDiff(assignment, k)
+			if err != nil {
+				return nil, err
+			}
+			for _, i := range diff {
+				synthetic.Out[k][i] = 1 - synthetic.Out[k][i].(int)
+			}
+		}
+	}
+	ac.Case = *newWitnessCase(synthetic)
+	return ac, nil
+}
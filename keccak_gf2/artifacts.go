@@ -0,0 +1,160 @@
+package keccakgf2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/field/gf2"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/irwg"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/verifier"
+)
+
+// Sized artifact sets:
+// A circuit hashes messages of one fixed length, so a prover taking messages of any length up to some
+// maximum keeps one compiled circuit per length it serves. BuildArtifacts compiles a list of sizes into a
+// directory, one single-message keccakMultiSizeCircuit per size, through checkpointedBuild (an interrupted
+// build resumes where it stopped), and writes sizes.json, the manifest mapping every size to its artifact
+// and fingerprint. At solve time LoadArtifacts reads the manifest and Route picks the artifact for the
+// length of an incoming message; an artifact is loaded, and its fingerprint checked, on first use.
+
+// sizeManifestFile is the manifest of an artifact directory.
+const sizeManifestFile = "sizes.json"
+
+// SizedArtifact is the compiled circuit for messages of Bytes bytes: Name.circuit and Name.solver in the
+// artifact directory, with the verifier.Fingerprint of the circuit.
+type SizedArtifact struct {
+	Bytes       int    `json:"bytes"`
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// SizeManifest is sizes.json, sorted by size.
+type SizeManifest struct {
+	Sizes []SizedArtifact `json:"sizes"`
+}
+
+// sizedTargetName is the artifact name for n-byte messages.
+func sizedTargetName(n int) string {
+	return fmt.Sprintf("keccak-%d", n)
+}
+
+// BuildArtifacts compiles one circuit per message size (in bytes; 0 is the empty message) into dir and
+// writes its manifest. If ctx ends first the error wraps ctx.Err(), and a later call with the same dir
+// only compiles the missing sizes.
+func BuildArtifacts(ctx context.Context, dir string, sizes []int) (*SizeManifest, error) {
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("artifacts: no message sizes")
+	}
+	sorted := append([]int{}, sizes...)
+	sort.Ints(sorted)
+	var targets []buildTarget
+	for i, n := range sorted {
+		if n < 0 {
+			return nil, fmt.Errorf("artifacts: message size %d is negative", n)
+		}
+		if i > 0 && sorted[i-1] == n {
+			return nil, fmt.Errorf("artifacts: message size %d is listed twice", n)
+		}
+		targets = append(targets, buildTarget{Name: sizedTargetName(n), Circuit: newKeccakMultiSizeCircuit([]int{n}, false)})
+	}
+	built, err := (&checkpointedBuild{Dir: dir, Field: gf2.ScalarField, OnBuilt: func(name string) {
+		logger.Debugf("artifacts: compiled %s", name)
+	}}).Run(ctx, targets)
+	if err != nil {
+		return nil, fmt.Errorf("artifacts: %w", err)
+	}
+	m := &SizeManifest{}
+	for _, n := range sorted {
+		name := sizedTargetName(n)
+		m.Sizes = append(m.Sizes, SizedArtifact{Bytes: n, Name: name, Fingerprint: built[name].Fingerprint})
+	}
+	raw, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, sizeManifestFile), raw, 0o644); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ArtifactSet is a loaded artifact directory.
+type ArtifactSet struct {
+	Dir      string
+	Manifest SizeManifest
+	loaded   map[int]*builtCircuit
+}
+
+// LoadArtifacts reads the manifest of an artifact directory written by BuildArtifacts; the circuits are
+// loaded on first use.
+func LoadArtifacts(dir string) (*ArtifactSet, error) {
+	raw, err := os.ReadFile(filepath.Join(dir, sizeManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	s := &ArtifactSet{Dir: dir, loaded: map[int]*builtCircuit{}}
+	if err := json.Unmarshal(raw, &s.Manifest); err != nil {
+		return nil, fmt.Errorf("%s: %w", sizeManifestFile, err)
+	}
+	return s, nil
+}
+
+// Route returns the artifact for messages of n bytes.
+func (s *ArtifactSet) Route(n int) (SizedArtifact, error) {
+	compiled := make([]int, len(s.Manifest.Sizes))
+	for i, a := range s.Manifest.Sizes {
+		if a.Bytes == n {
+			return a, nil
+		}
+		compiled[i] = a.Bytes
+	}
+	return SizedArtifact{}, fmt.Errorf("no compiled artifact for %d-byte messages in %s (compiled sizes: %v)", n, s.Dir, compiled)
+}
+
+// load returns the compiled circuit of a, reading it on first use and checking it against the manifest.
+func (s *ArtifactSet) load(a SizedArtifact) (*builtCircuit, error) {
+	if b, ok := s.loaded[a.Bytes]; ok {
+		return b, nil
+	}
+	rc, fp, err := verifier.LoadCircuit(filepath.Join(s.Dir, a.Name+".circuit"))
+	if err != nil {
+		return nil, err
+	}
+	if fp != a.Fingerprint {
+		return nil, fmt.Errorf("artifact %s: circuit fingerprint %s, %s says %s", a.Name, fp, sizeManifestFile, a.Fingerprint)
+	}
+	raw, err := os.ReadFile(filepath.Join(s.Dir, a.Name+".solver"))
+	if err != nil {
+		return nil, err
+	}
+	b := &builtCircuit{Circuit: rc, Solver: ecgo.DeserializeInputSolver(raw), Fingerprint: fp}
+	s.loaded[a.Bytes] = b
+	return b, nil
+}
+
+// Solve routes msg to the artifact for its length, solves the assignment with its Keccak-256 digest and
+// checks the witness; it returns the artifact used and the witness.
+func (s *ArtifactSet) Solve(msg []byte) (SizedArtifact, *irwg.Witness, error) {
+	a, err := s.Route(len(msg))
+	if err != nil {
+		return SizedArtifact{}, nil, err
+	}
+	b, err := s.load(a)
+	if err != nil {
+		return SizedArtifact{}, nil, err
+	}
+	assignment := newKeccakMultiSizeCircuit([]int{len(msg)}, false)
+	putBits(assignment.P[0], msg)
+	digest := CircuitKeccak256(msg)
+	putBits(assignment.Out[0][:], digest[:])
+	wit, err := solveChecked(b.Solver, b.Circuit, assignment, true)
+	if err != nil {
+		return SizedArtifact{}, nil, fmt.Errorf("artifact %s: %w", a.Name, err)
+	}
+	return a, wit, nil
+}
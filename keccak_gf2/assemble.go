@@ -0,0 +1,410 @@
+package keccakgf2
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/irwg"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/layered"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/verifier"
+	"github.com/consensys/gnark/frontend"
+)
+
+// Per-instance builds:
+// The instances of a batch circuit are independent circuits side by side, unless the batch compares them
+// with each other (Distinct, AggregateGlobal). Such a batch can be compiled one instance at a
+// time: every distinct instance (instanceKey) is compiled on its own into a piece, written to disk and
+// dropped, and the batch circuit is assembled from the pieces' layered circuits. Layer i of the assembled
+// circuit places layer i of every instance's piece next to the others as a sub-circuit, in instance
+// order, so the instances keep their own wires; identical instances share one sub-circuit with several
+// allocations. Public inputs are referenced by gate coefficients, so the piece circuits that reference
+// one are copied per instance with the public inputs renumbered into the batch order (batchPublic).
+// The batch witness is assembled the same way: assembledSolver solves every instance with its piece's
+// input solver and places the private inputs at the instance's input offset and the public inputs at
+// their batch positions, so the public inputs, the layout and the verifier are those of a whole compile.
+// The assembled circuit is not the one ecgo builds for the whole batch (its fingerprint differs), but the
+// same pieces always assemble into the same circuit, which is what checkpointing relies on.
+// Memory is that of compiling one instance plus the assembled layered circuit, instead of the compiler
+// state of the whole batch; low-memory mode (lowmem.go) and checkpointed builds (checkpoint.go) use it.
+
+// errUnequalDepth is returned by assembleInstances for pieces with different numbers of layers, which
+// would have to be padded with relay layers to be placed side by side.
+var errUnequalDepth = errors.New("only instances of equal depth can be assembled")
+
+// instancedCircuit is a batch circuit that can be compiled one instance at a time.
+type instancedCircuit interface {
+	frontend.Circuit
+	// instanceCount returns the number of instances.
+	instanceCount() int
+	// splittable reports whether the instances are independent; only then can the batch be assembled.
+	splittable() bool
+	// instance returns instance k as a one-instance circuit of the same kind: a template of a template,
+	// an assignment (sharing the values) of an assignment.
+	instance(k int) frontend.Circuit
+	// instanceKey is the same for instances that compile to the same circuit.
+	instanceKey(k int) string
+	// batchPublic maps public input i of instance k to its index among the public inputs of the batch.
+	batchPublic(k, i int) int
+	// publicCount returns the number of public inputs of the batch.
+	publicCount() int
+}
+
+func (t *keccak256Circuit) instanceCount() int { return len(t.Out) }
+
+func (t *keccak256Circuit) splittable() bool { return !t.Distinct && t.Outputs != AggregateGlobal }
+
+func (t *keccak256Circuit) instance(k int) frontend.Circuit {
+	c := *t
+	if t.P != nil {
+		c.P = t.P[k : k+1 : k+1]
+	}
+	if t.PublicP != nil {
+		c.PublicP = t.PublicP[k : k+1 : k+1]
+	}
+	c.Out = t.Out[k : k+1 : k+1]
+	return &c
+}
+
+func (t *keccak256Circuit) instanceKey(k int) string { return "" }
+
+// batchPublic follows the public-input order of keccak256Circuit: the digests, the shared context and
+// the public messages (see publicinput.go).
+func (t *keccak256Circuit) batchPublic(k, i int) int {
+	n, checkBits, context := len(t.Out), len(t.Out[0]), len(t.Context)
+	switch {
+	case i < checkBits:
+		return k*checkBits + i
+	case i < checkBits+context:
+		return n*checkBits + i - checkBits
+	default:
+		return n*checkBits + context + k*64*8 + i - checkBits - context
+	}
+}
+
+func (t *keccak256Circuit) publicCount() int {
+	return len(t.Out)*len(t.Out[0]) + len(t.Context) + len(t.PublicP)*64*8
+}
+
+func (t *keccakMultiSizeCircuit) instanceCount() int { return len(t.P) }
+
+func (t *keccakMultiSizeCircuit) splittable() bool { return true }
+
+func (t *keccakMultiSizeCircuit) instance(k int) frontend.Circuit {
+	return &keccakMultiSizeCircuit{P: t.P[k : k+1 : k+1], Out: t.Out[k : k+1 : k+1], shared: t.shared}
+}
+
+func (t *keccakMultiSizeCircuit) instanceKey(k int) string { return fmt.Sprint(len(t.P[k])) }
+
+func (t *keccakMultiSizeCircuit) batchPublic(k, i int) int { return 256*k + i }
+
+func (t *keccakMultiSizeCircuit) publicCount() int { return 256 * len(t.Out) }
+
+func (t *variantBatchCircuit) instanceCount() int { return len(t.P) }
+
+func (t *variantBatchCircuit) splittable() bool { return true }
+
+func (t *variantBatchCircuit) instance(k int) frontend.Circuit {
+	return &variantBatchCircuit{P: t.P[k : k+1 : k+1], Out: t.Out[k : k+1 : k+1], Variants: t.Variants[k : k+1 : k+1]}
+}
+
+func (t *variantBatchCircuit) instanceKey(k int) string { return t.Variants[k] }
+
+func (t *variantBatchCircuit) batchPublic(k, i int) int {
+	for _, out := range t.Out[:k] {
+		i += len(out)
+	}
+	return i
+}
+
+func (t *variantBatchCircuit) publicCount() int { return t.batchPublic(len(t.Out), 0) }
+
+// instanceBuild compiles an instancedCircuit piece by piece into Dir and assembles it.
+type instanceBuild struct {
+	Field *big.Int
+	Dir   string
+	Name  string
+	// Done holds the fingerprint of every piece already on disk, by piece name; pieces compiled by run
+	// are added to it. nil is an empty map.
+	Done map[string]string
+	// Before, if set, runs before a piece is compiled; an error stops the build with everything compiled
+	// so far on disk and in Done.
+	Before func(piece string) error
+	// Saved, if set, runs after a piece is compiled and written.
+	Saved func(piece string) error
+}
+
+// piece is a compiled instance.
+type piece struct {
+	name   string
+	built  *builtCircuit
+	public map[uint64]bool // circuits of built.Circuit that reference a public input, directly or below
+}
+
+// pieceName is the file name (without extension) of the piece of the instances with key; first is the
+// first of them.
+func (b *instanceBuild) pieceName(first int) string {
+	return fmt.Sprintf("%s.instance-%d", b.Name, first)
+}
+
+// run compiles the pieces of circuit that are not in Done, assembles the batch circuit, writes it to
+// Dir/Name.circuit and returns it with an assembledSolver for assignments of circuit's kind.
+func (b *instanceBuild) run(circuit instancedCircuit) (*builtCircuit, error) {
+	if !circuit.splittable() {
+		return nil, fmt.Errorf("%s: the instances of %T are not independent", b.Name, circuit)
+	}
+	if b.Done == nil {
+		b.Done = map[string]string{}
+	}
+	byKey := map[string]*piece{}
+	pieces := make([]*piece, circuit.instanceCount())
+	for k := range pieces {
+		key := circuit.instanceKey(k)
+		if p, ok := byKey[key]; ok {
+			pieces[k] = p
+			continue
+		}
+		name := b.pieceName(k)
+		circuitPath, solverPath := filepath.Join(b.Dir, name+".circuit"), filepath.Join(b.Dir, name+".solver")
+		fp, ok := b.Done[name]
+		if !ok {
+			if b.Before != nil {
+				if err := b.Before(name); err != nil {
+					return nil, err
+				}
+			}
+			built, err := compileToDisk(b.Field, circuit.instance(k), circuitPath, solverPath)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			fp = built.Fingerprint
+			b.Done[name] = fp
+			if b.Saved != nil {
+				if err := b.Saved(name); err != nil {
+					return nil, err
+				}
+			}
+		}
+		// read back in every mode: the pieces are small next to the compiler state just dropped
+		built, err := loadBuilt(circuitPath, solverPath, fp)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		p := &piece{name: name, built: built, public: publicCircuits(built.Circuit)}
+		byKey[key], pieces[k] = p, p
+	}
+	c, err := assembleInstances(pieces, circuit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name, err)
+	}
+	serialized := c.Serialize()
+	if err := os.WriteFile(filepath.Join(b.Dir, b.Name+".circuit"), serialized, 0o644); err != nil {
+		return nil, err
+	}
+	return &builtCircuit{Circuit: c, Solver: newAssembledSolver(c, pieces, circuit), Fingerprint: verifier.Fingerprint(serialized)}, nil
+}
+
+// publicCircuits returns the circuits of c whose gates, or those of a sub-circuit, have a public input
+// as coefficient.
+func publicCircuits(c *layered.RootCircuit) map[uint64]bool {
+	public := map[uint64]bool{}
+	seen := map[uint64]bool{}
+	var visit func(id uint64) bool
+	visit = func(id uint64) bool {
+		if seen[id] {
+			return public[id]
+		}
+		seen[id] = true
+		sc := c.Circuits[id]
+		uses := false
+		for _, g := range sc.Mul {
+			uses = uses || g.Coef.Type == layered.PublicInput
+		}
+		for _, g := range sc.Add {
+			uses = uses || g.Coef.Type == layered.PublicInput
+		}
+		for _, g := range sc.Cst {
+			uses = uses || g.Coef.Type == layered.PublicInput
+		}
+		for _, sub := range sc.SubCircuits {
+			// no short cut: every sub-circuit is visited once
+			uses = visit(sub.Id) || uses
+		}
+		public[id] = uses
+		return uses
+	}
+	for _, id := range c.Layers {
+		visit(id)
+	}
+	return public
+}
+
+// assembleInstances lays the pieces of the instances of batch (pieces[k] for instance k) side by side,
+// layer by layer; see the comment at the top of the file.
+func assembleInstances(pieces []*piece, batch instancedCircuit) (*layered.RootCircuit, error) {
+	first := pieces[0].built.Circuit
+	for _, p := range pieces {
+		c := p.built.Circuit
+		if len(c.Layers) != len(first.Layers) {
+			return nil, fmt.Errorf("piece %s has %d layers, piece %s %d: %w", p.name, len(c.Layers), pieces[0].name, len(first.Layers), errUnequalDepth)
+		}
+		if c.ExpectedNumOutputZeroes != c.NumActualOutputs {
+			return nil, fmt.Errorf("piece %s has outputs that are not asserted zero", p.name)
+		}
+	}
+	res := &layered.RootCircuit{Field: first.Field, NumPublicInputs: batch.publicCount(), Circuits: map[uint64]*layered.Circuit{}}
+	var next uint64
+	add := func(sc *layered.Circuit) uint64 {
+		id := next
+		res.Circuits[id] = sc
+		next++
+		return id
+	}
+
+	// shared[p][id] is the copy of a circuit of piece p without public inputs, used by all its instances
+	shared := map[*piece]map[uint64]uint64{}
+	var copyCircuit func(p *piece, id uint64, k int, own map[uint64]uint64) uint64
+	copyCircuit = func(p *piece, id uint64, k int, own map[uint64]uint64) uint64 {
+		memo := shared[p]
+		if p.public[id] {
+			memo = own
+		}
+		if nid, ok := memo[id]; ok {
+			return nid
+		}
+		sc := *p.built.Circuit.Circuits[id]
+		if p.public[id] {
+			remap := func(c *layered.Coef) {
+				if c.Type == layered.PublicInput {
+					c.PublicInputId = uint64(batch.batchPublic(k, int(c.PublicInputId)))
+				}
+			}
+			sc.Mul = append([]layered.GateMul(nil), sc.Mul...)
+			for i := range sc.Mul {
+				remap(&sc.Mul[i].Coef)
+			}
+			sc.Add = append([]layered.GateAdd(nil), sc.Add...)
+			for i := range sc.Add {
+				remap(&sc.Add[i].Coef)
+			}
+			sc.Cst = append([]layered.GateCst(nil), sc.Cst...)
+			for i := range sc.Cst {
+				remap(&sc.Cst[i].Coef)
+			}
+		}
+		subs := make([]layered.SubCircuit, len(sc.SubCircuits))
+		for i, sub := range sc.SubCircuits {
+			subs[i] = layered.SubCircuit{Id: copyCircuit(p, sub.Id, k, own), Allocations: sub.Allocations}
+		}
+		sc.SubCircuits = subs
+		nid := add(&sc)
+		memo[id] = nid
+		return nid
+	}
+
+	layerIds := make([][]uint64, len(pieces)) // layerIds[k][i]: the copy of layer i of instance k
+	for k, p := range pieces {
+		if shared[p] == nil {
+			shared[p] = map[uint64]uint64{}
+		}
+		own := map[uint64]uint64{}
+		for _, id := range p.built.Circuit.Layers {
+			layerIds[k] = append(layerIds[k], copyCircuit(p, id, k, own))
+		}
+	}
+	for i := range first.Layers {
+		layer := &layered.Circuit{}
+		var in, out uint64
+		index := map[uint64]int{} // sub-circuit id → position in layer.SubCircuits
+		for k, p := range pieces {
+			sc := p.built.Circuit.Circuits[p.built.Circuit.Layers[i]]
+			id := layerIds[k][i]
+			j, ok := index[id]
+			if !ok {
+				j = len(layer.SubCircuits)
+				index[id] = j
+				layer.SubCircuits = append(layer.SubCircuits, layered.SubCircuit{Id: id})
+			}
+			layer.SubCircuits[j].Allocations = append(layer.SubCircuits[j].Allocations, layered.Allocation{InputOffset: in, OutputOffset: out})
+			in += sc.InputLen
+			out += sc.OutputLen
+		}
+		layer.InputLen, layer.OutputLen = nextPowerOfTwo(in), nextPowerOfTwo(out)
+		res.Layers = append(res.Layers, add(layer))
+	}
+	// every output position is asserted zero: those of the instances, and the padding, which no gate drives
+	outputs := res.Circuits[res.Layers[len(res.Layers)-1]].OutputLen
+	res.NumActualOutputs, res.ExpectedNumOutputZeroes = int(outputs), int(outputs)
+	return res, nil
+}
+
+// nextPowerOfTwo returns the smallest power of two >= n (1 for 0), the length of a layer.
+func nextPowerOfTwo(n uint64) uint64 {
+	p := uint64(1)
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// assembledSolver solves assignments of an assembled batch circuit instance by instance; see the comment
+// at the top of the file.
+type assembledSolver struct {
+	pieces  []*piece
+	offsets []uint64 // input offset of every instance in the input layer
+	inputs  uint64   // length of the input layer
+	public  int      // public inputs of the batch
+}
+
+func newAssembledSolver(c *layered.RootCircuit, pieces []*piece, batch instancedCircuit) *assembledSolver {
+	s := &assembledSolver{pieces: pieces, public: batch.publicCount()}
+	for _, p := range pieces {
+		s.offsets = append(s.offsets, s.inputs)
+		s.inputs += p.built.Circuit.Circuits[p.built.Circuit.Layers[0]].InputLen
+	}
+	s.inputs = c.Circuits[c.Layers[0]].InputLen
+	return s
+}
+
+func (s *assembledSolver) SolveInput(assignment frontend.Circuit, nbThreads int) (*irwg.Witness, error) {
+	batch, ok := assignment.(instancedCircuit)
+	if !ok || batch.instanceCount() != len(s.pieces) {
+		return nil, fmt.Errorf("assembled solver: %T is not an assignment of a %d-instance batch", assignment, len(s.pieces))
+	}
+	values := make([]*big.Int, int(s.inputs)+s.public)
+	var field *big.Int
+	for k, p := range s.pieces {
+		w, err := p.built.Solver.SolveInput(batch.instance(k), nbThreads)
+		if err != nil {
+			return nil, fmt.Errorf("instance %d: %w", k, err)
+		}
+		field = w.Field
+		copy(values[s.offsets[k]:], w.Values[:w.NumInputsPerWitness])
+		for i := 0; i < w.NumPublicInputsPerWitness; i++ {
+			values[int(s.inputs)+batch.batchPublic(k, i)] = w.Values[w.NumInputsPerWitness+i]
+		}
+	}
+	for i, v := range values {
+		if v == nil {
+			// input padding between the instances
+			values[i] = new(big.Int)
+		}
+	}
+	return &irwg.Witness{NumWitnesses: 1, NumInputsPerWitness: int(s.inputs), NumPublicInputsPerWitness: s.public, Field: field, Values: values}, nil
+}
+
+func (s *assembledSolver) SolveInputs(assignments []frontend.Circuit) (*irwg.Witness, error) {
+	res := &irwg.Witness{NumInputsPerWitness: int(s.inputs), NumPublicInputsPerWitness: s.public}
+	for z, a := range assignments {
+		w, err := s.SolveInput(a, 0)
+		if err != nil {
+			return nil, fmt.Errorf("assignment %d: %w", z, err)
+		}
+		res.NumWitnesses++
+		res.Field = w.Field
+		res.Values = append(res.Values, w.Values...)
+	}
+	return res, nil
+}
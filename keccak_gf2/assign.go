@@ -0,0 +1,316 @@
+package keccakgf2
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"sync"
+
+	"github.com/consensys/gnark/frontend"
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/sha3"
+)
+
+// Randomness for message generation is always an injected io.Reader:
+// crypto/rand for normal runs, seededReader for reproducible ones (every artifact, witness included,
+// is then byte-stable across runs).
+
+// defaultReader is the production randomness source.
+var defaultReader io.Reader = crand.Reader
+
+// seededReader returns a deterministic byte stream for the given seed.
+func seededReader(seed int64) io.Reader {
+	return rand.New(rand.NewSource(seed))
+}
+
+// randomAssignment builds a full keccak256Circuit assignment for n instances: n random 64-byte messages read
+// from rnd as the private inputs, and their Keccak-256 digests as the public outputs.
+// context must be the circuit's public context (empty for the context-free circuit); the digests then
+// commit to context || message. opts are those of the circuit (WithSHA3 changes the digests).
+// The messages go through AssignmentFromHex, as a caller's hex test vectors would.
+func randomAssignment(rnd io.Reader, n int, context []byte, opts ...CircuitOption) (*keccak256Circuit, error) {
+	msgHex := make([]string, n)
+	msg := make([]byte, 64)
+	for k := range msgHex {
+		// Generate random 64-byte(i.e., 512 bits) message
+		if _, err := io.ReadFull(rnd, msg); err != nil {
+			return nil, err
+		}
+		msgHex[k] = hex.EncodeToString(msg)
+	}
+	if len(context) > 0 {
+		opts = append(opts[:len(opts):len(opts)], WithContext(context))
+	}
+	return AssignmentFromHex(msgHex, opts...)
+}
+
+// assignMessages builds a keccak256Circuit assignment with one instance per 64-byte message, exposing the
+// first checkBits bits of each digest (CheckBits for the full digest).
+func assignMessages(msgs [][]byte, context []byte, checkBits int, opts ...CircuitOption) (*keccak256Circuit, error) {
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("assignMessages: no messages")
+	}
+	circuit := NewTruncatedKeccak256Circuit(len(msgs), checkBits, opts...)
+	if len(context) > 0 {
+		circuit.Context = bitsOf(context)
+	}
+	if err := FillAssignment(circuit, msgs); err != nil {
+		return nil, err
+	}
+	return circuit, nil
+}
+
+// FillAssignment fills an allocated assignment (e.g. NewKeccak256Circuit(len(msgs)), with Context already
+// assigned if the circuit has one) from plain messages: P[k] (PublicP[k] if the messages are public inputs)
+// gets the bits of msgs[k] and Out[k] the first
+// len(Out[k]) bits of Keccak-256(Context || msgs[k]) (SHA3-256 if c was built WithSHA3). Every message
+// must be 64 bytes, one per instance; on an error c is left untouched.
+func FillAssignment(c *keccak256Circuit, msgs [][]byte) error {
+	context, err := checkFill(c, msgs)
+	if err != nil {
+		return err
+	}
+	if c.SHA3 {
+		fillDigests(c, msgs, sha3Digests(context, msgs))
+		return nil
+	}
+	fillDigests(c, msgs, referenceDigests(context, msgs))
+	return nil
+}
+
+// checkFill validates the arguments of FillAssignment and returns the assigned context.
+func checkFill(c *keccak256Circuit, msgs [][]byte) ([]byte, error) {
+	if c == nil {
+		return nil, fmt.Errorf("FillAssignment: assignment is nil")
+	}
+	if len(msgs) != len(c.messages()) {
+		return nil, fmt.Errorf("FillAssignment: %d messages for %d instances", len(msgs), len(c.messages()))
+	}
+	if _, err := c.checkBits(); err != nil {
+		return nil, fmt.Errorf("FillAssignment: %w", err)
+	}
+	context, err := assignedBytes(c.Context)
+	if err != nil {
+		return nil, fmt.Errorf("FillAssignment: Context: %w", err)
+	}
+	for k, data := range msgs {
+		if len(data) != 64 {
+			return nil, fmt.Errorf("FillAssignment: message %d is %d bytes, expected 64", k, len(data))
+		}
+	}
+	return context, nil
+}
+
+// fillDigests writes msgs[k] into message k (P[k] or PublicP[k]) and the leading bits of digests[k] into Out[k]; the arguments
+// have been validated by checkFill.
+func fillDigests(c *keccak256Circuit, msgs [][]byte, digests [][32]byte) {
+	for k, data := range msgs {
+		// P[k] is the private message, bit 0 the least significant bit of byte 0 (see bitconv.go);
+		// Out[k] the leading checkBits bits of its digest, which the circuit must match (Define).
+		putBits(c.messages()[k][:], data)
+		putBits(c.Out[k], digests[k][:(len(c.Out[k])+7)/8])
+	}
+}
+
+// Assigning by hand:
+// A bit of P, PublicP, Out or Context may be assigned as any Go integer, a bool or a big.Int (*big.Int)
+// equal to 0 or 1 (assignedBit); Preflight and the other readers accept all of them, and the checked solver
+// hands the ecgo solver a copy with bools, the one form it cannot take, turned into ints; the caller's
+// assignment is left as it was. The setters below assign whole
+// messages and digests from bytes or 64-bit words instead of 512 single bits, and FlipInputBit flips one
+// message bit whatever its Go type.
+
+// SetMessage assigns the 64-byte msg to message k of c (P[k], or PublicP[k] if the messages are public).
+func SetMessage(c *keccak256Circuit, k int, msg []byte) error {
+	if k < 0 || k >= len(c.messages()) {
+		return fmt.Errorf("SetMessage: instance %d out of range [0, %d)", k, len(c.messages()))
+	}
+	if len(msg) != 64 {
+		return fmt.Errorf("SetMessage: message is %d bytes, expected 64", len(msg))
+	}
+	putBits(c.messages()[k][:], msg)
+	return nil
+}
+
+// SetMessageWords assigns message k of c from 8 64-bit words, each the little-endian value of 8 message
+// bytes (the Keccak lanes the message fills).
+func SetMessageWords(c *keccak256Circuit, k int, words []uint64) error {
+	if len(words) != 8 {
+		return fmt.Errorf("SetMessageWords: %d words, expected 8", len(words))
+	}
+	msg := make([]byte, 64)
+	for i, w := range words {
+		binary.LittleEndian.PutUint64(msg[8*i:], w)
+	}
+	return SetMessage(c, k, msg)
+}
+
+// SetDigest assigns the leading len(Out[k]) bits of digest to Out[k].
+func SetDigest(c *keccak256Circuit, k int, digest []byte) error {
+	if k < 0 || k >= len(c.Out) {
+		return fmt.Errorf("SetDigest: instance %d out of range [0, %d)", k, len(c.Out))
+	}
+	if 8*len(digest) < len(c.Out[k]) {
+		return fmt.Errorf("SetDigest: %d bytes for %d digest bits", len(digest), len(c.Out[k]))
+	}
+	putBits(c.Out[k], digest[:(len(c.Out[k])+7)/8])
+	return nil
+}
+
+// FlipInputBit flips bit of message instance (P or PublicP), which may be assigned as any bit type; the
+// flipped bit is an int.
+func FlipInputBit(c *keccak256Circuit, instance, bit int) error {
+	if instance < 0 || instance >= len(c.messages()) {
+		return fmt.Errorf("FlipInputBit: instance %d out of range [0, %d)", instance, len(c.messages()))
+	}
+	if bit < 0 || bit >= 64*8 {
+		return fmt.Errorf("FlipInputBit: bit %d out of range [0, %d)", bit, 64*8)
+	}
+	v := &c.messages()[instance][bit]
+	b, ok := assignedBit(*v)
+	if !ok {
+		return fmt.Errorf("FlipInputBit: %s[%d][%d] is %v, not a 0/1 bit", c.messageField(), instance, bit, *v)
+	}
+	*v = 1 - b
+	return nil
+}
+
+// Reference hashing:
+// The digests of an assignment come from go-ethereum's Keccak-256, one reused sponge per worker. Large
+// batches are split into chunks of hashChunk messages and hashed on up to assignWorkers goroutines;
+// smaller ones are hashed inline, where starting workers would cost more than it saves.
+
+// assignWorkers bounds the goroutines of referenceDigests.
+var assignWorkers = runtime.GOMAXPROCS(0)
+
+const hashChunk = 256
+
+// referenceDigests returns Keccak-256(context || msgs[k]) for every k.
+func referenceDigests(context []byte, msgs [][]byte) [][32]byte {
+	digests := make([][32]byte, len(msgs))
+	hashInto(digests, context, msgs)
+	return digests
+}
+
+// hashInto is referenceDigests into digests, len(digests) = len(msgs).
+func hashInto(digests [][32]byte, context []byte, msgs [][]byte) {
+	hash := func(lo, hi int) {
+		h := crypto.NewKeccakState()
+		for k := lo; k < hi; k++ {
+			h.Reset()
+			h.Write(context)
+			h.Write(msgs[k])
+			h.Read(digests[k][:])
+		}
+	}
+	chunks := (len(msgs) + hashChunk - 1) / hashChunk
+	if chunks <= 1 || assignWorkers <= 1 {
+		hash(0, len(msgs))
+		return
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < assignWorkers && w < chunks; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				hash(i*hashChunk, min((i+1)*hashChunk, len(msgs)))
+			}
+		}()
+	}
+	for i := 0; i < chunks; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// sha3Digests returns SHA3-256(context || msgs[k]) for every k, from x/crypto.
+func sha3Digests(context []byte, msgs [][]byte) [][32]byte {
+	digests := make([][32]byte, len(msgs))
+	for k, msg := range msgs {
+		digests[k] = sha3.Sum256(append(append([]byte{}, context...), msg...))
+	}
+	return digests
+}
+
+// assignBatch builds len(msgs)/n assignments of n instances each from msgs, in order, for the circuit
+// with the given context, hashing all messages in one referenceDigests pass: the way to build thousands
+// of assignments, where FillAssignment per assignment would hash every few messages inline.
+func assignBatch(msgs [][]byte, n int, context []byte) ([]frontend.Circuit, error) {
+	return new(assignBuffer).assign(msgs, n, context)
+}
+
+// assignBuffer builds batch after batch of assignments into the same memory. A chunked solve solves the
+// assignments of one batch before it builds the next, so the next batch can overwrite them: after the
+// first batch of a shape, assign allocates nothing, and so neither faults in fresh pages nor starts the
+// garbage collector (whose write barrier would slow every bit stored while it marks). The zero value is
+// ready to use; the assignments of one call are valid until the next.
+type assignBuffer struct {
+	circuits    []keccak256Circuit
+	assignments []frontend.Circuit
+	digests     [][32]byte
+}
+
+// assign is assignBatch into b: every message, digest and context bit of the returned assignments is
+// overwritten, so nothing of the previous batch is left in them.
+func (b *assignBuffer) assign(msgs [][]byte, n int, context []byte) ([]frontend.Circuit, error) {
+	if n < 1 || len(msgs) == 0 || len(msgs)%n != 0 {
+		return nil, fmt.Errorf("assignBatch: %d messages do not make assignments of %d instances", len(msgs), n)
+	}
+	for k, data := range msgs {
+		if len(data) != 64 {
+			return nil, fmt.Errorf("assignBatch: message %d is %d bytes, expected 64", k, len(data))
+		}
+	}
+	count := len(msgs) / n
+	if len(b.circuits) != count || len(b.circuits[0].P) != n || len(b.circuits[0].Context) != 8*len(context) {
+		b.circuits = newKeccak256Circuits(count, n, len(context))
+		b.assignments = make([]frontend.Circuit, count)
+		for z := range b.circuits {
+			b.assignments[z] = &b.circuits[z]
+		}
+		b.digests = make([][32]byte, len(msgs))
+	}
+	hashInto(b.digests, context, msgs)
+	for z := range b.circuits {
+		c := &b.circuits[z]
+		if len(context) > 0 {
+			putBits(c.Context, context)
+		}
+		fillDigests(c, msgs[z*n:(z+1)*n], b.digests[z*n:(z+1)*n])
+	}
+	return b.assignments, nil
+}
+
+// newKeccak256Circuits returns count empty assignments of newKeccak256Circuit(n, contextBytes) whose
+// messages, digests and contexts lie in one allocation each. Thousands of assignments allocated one by
+// one keep the garbage collector marking, and so the write barrier on, while their bits are stored.
+// Every slice is capped at its own end, so that appending to one assignment cannot write into the next.
+// Reused through assignBuffer, they let BenchmarkAssign10k build 10k messages at 7-9x the msgs/s of
+// BenchmarkAssign10kBaseline on one core (240k-340k against 29k-39k); TestAssignSpeedup requires 5x.
+func newKeccak256Circuits(count, n, contextBytes int) []keccak256Circuit {
+	circuits := make([]keccak256Circuit, count)
+	ps := make([][64 * 8]frontend.Variable, count*n)
+	outs := make([][]frontend.Variable, count*n)
+	digests := make([]frontend.Variable, count*n*CheckBits)
+	contexts := make([]frontend.Variable, count*8*contextBytes)
+	for z := range circuits {
+		c := &circuits[z]
+		c.P = ps[z*n : (z+1)*n : (z+1)*n]
+		c.Out = outs[z*n : (z+1)*n : (z+1)*n]
+		for k := range c.Out {
+			i := z*n + k
+			c.Out[k] = digests[i*CheckBits : (i+1)*CheckBits : (i+1)*CheckBits]
+		}
+		if contextBytes > 0 {
+			c.Context = contexts[z*8*contextBytes : (z+1)*8*contextBytes : (z+1)*8*contextBytes]
+		}
+	}
+	return circuits
+}
@@ -0,0 +1,69 @@
+package keccakgf2
+
+import (
+	"fmt"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/irwg"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/verifier"
+	"github.com/consensys/gnark/frontend"
+)
+
+// Batch ordering contract:
+// SolveInputs lays the assignments out back to back in the order they are passed, so the z-th block of
+// NumInputsPerWitness + NumPublicInputsPerWitness values in the witness belongs to assignments[z].
+// Nothing in the witness itself says which caller-side message that was, so every batch we write carries
+// an envelope (verifier.Envelope) recording Indices[z] = the caller's index of assignments[z].
+// Readers must map public inputs back to messages through Indices, never through the batch position.
+
+type batchSolver interface {
+	SolveInputs(assignments []frontend.Circuit) (*irwg.Witness, error)
+}
+
+// solveBatch solves assignments as one multi-witness and records the caller indices alongside it.
+// indices must hold one distinct non-negative caller index per assignment.
+// keccak256Circuit assignments are preflighted first (see preflight.go).
+func solveBatch(is batchSolver, assignments []frontend.Circuit, indices []int) (*verifier.Envelope, error) {
+	if len(indices) != len(assignments) {
+		return nil, fmt.Errorf("solveBatch: %d indices for %d assignments", len(indices), len(assignments))
+	}
+	seen := make(map[int]bool, len(indices))
+	for _, idx := range indices {
+		if idx < 0 || seen[idx] {
+			return nil, fmt.Errorf("solveBatch: index %d is negative or repeated", idx)
+		}
+		seen[idx] = true
+	}
+	if !skipPreflight {
+		for z, a := range assignments {
+			if kc, ok := a.(*keccak256Circuit); ok {
+				if err := Preflight(kc); err != nil {
+					return nil, fmt.Errorf("solveBatch: assignment %d (index %d): %w", z, indices[z], err)
+				}
+			}
+		}
+	}
+	wit, err := is.SolveInputs(assignments)
+	if err != nil {
+		return nil, err
+	}
+	if wit.NumWitnesses != len(assignments) {
+		return nil, fmt.Errorf("solveBatch: solver returned %d witnesses for %d assignments", wit.NumWitnesses, len(assignments))
+	}
+	return &verifier.Envelope{Indices: indices, Witness: wit}, nil
+}
+
+// digestLayout is the verifier-side description of the public inputs of newKeccak256Circuit(n, contextBytes).
+func digestLayout(n int, contextBytes int) verifier.Layout {
+	return verifier.Layout{Instances: n, CheckBits: CheckBits, ContextBytes: contextBytes}
+}
+
+// countingSolver forwards to is and counts the batches it was asked to solve.
+type countingSolver struct {
+	is    batchSolver
+	calls int
+}
+
+func (s *countingSolver) SolveInputs(assignments []frontend.Circuit) (*irwg.Witness, error) {
+	s.calls++
+	return s.is.SolveInputs(assignments)
+}
@@ -0,0 +1,202 @@
+package keccakgf2
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/field/gf2"
+	"github.com/consensys/gnark/frontend"
+)
+
+// Gadget benchmarks:
+// The benchmarks below time the construction of single gadgets against the recording API of gatestats.go,
+// so no constraint system is built and one run takes milliseconds. Inputs are symbolic wires, which the
+// gadgets cannot fold, so every call a gadget makes is counted and reported as a custom metric per op:
+// api-calls/op, one metric per kind, and the split into linear/op (XOR and NOT gates) and nonlinear/op
+// (AND gates, the ones that cost over GF(2)). Sponge gadgets also report permutations/op, the keccakF
+// calls SpongePermutations (ParallelHashPermutations) counts for their lengths, so that a squeeze past the
+// rate shows up as a second permutation rather than only as more gates. A step whose time or call count jumps after a change has
+// grown an accidental loop. go test -bench . runs them.
+
+// gadgetBenchmark is one gadget construction: Run is called once per benchmark op. Perms is the number of
+// permutations a sponge gadget runs, 0 for the others.
+type gadgetBenchmark struct {
+	Name  string
+	Run   func(api frontend.API)
+	Perms int
+}
+
+// gadgetBenchmarks lists the benchmarked gadgets, from the absorb step to the whole hash.
+func gadgetBenchmarks() []gadgetBenchmark {
+	state := symbolicState()
+	block := symbolicBits(Keccak256Config.RateBits)
+	msg := symbolicBits(64 * 8)
+	long := symbolicBits(1024 * 8)
+	var state800 [25][32]frontend.Variable
+	for i := range state800 {
+		copy(state800[i][:], symbolicBits(32))
+	}
+	var state400 [25][16]frontend.Variable
+	for i := range state400 {
+		copy(state400[i][:], symbolicBits(16))
+	}
+	var state200 [25][8]frontend.Variable
+	for i := range state200 {
+		copy(state200[i][:], symbolicBits(8))
+	}
+	var sha256State [8]sha256Word
+	for i := range sha256State {
+		copy(sha256State[i][:], symbolicBits(32))
+	}
+	return []gadgetBenchmark{
+		{"xorIn", func(api frontend.API) { xorIn(api, copyState(state), block, Keccak256Config.RateBits) }, 0},
+		{"keccakRound", func(api frontend.API) { keccakP(api, state, 23, 1) }, 0},
+		{"keccakF", func(api frontend.API) { keccakF(api, state) }, 0},
+		{"KeccakF800", func(api frontend.API) { KeccakF800(api, state800) }, 0},
+		{"KeccakF400", func(api frontend.API) { KeccakF400(api, state400) }, 0},
+		{"KeccakF200", func(api frontend.API) { KeccakF200(api, state200) }, 0},
+		{"computeKeccak", func(api frontend.API) { computeKeccak(api, msg) }, SpongePermutations(Keccak256Config.RateBits, 64, 256)},
+		{"Sha3_224", func(api frontend.API) { Sha3_224(api, msg) }, SpongePermutations(Sha3_224Config.RateBits, 64, Sha3_224Config.OutputBits)},
+		{"sha256Compress", func(api frontend.API) { sha256Compress(api, sha256State, msg, nil) }, 0},
+		{"Sha256", func(api frontend.API) { Sha256(api, msg) }, 0},
+		// the same kilobyte hashed in four 256-byte ParallelHash blocks and serially
+		{"ParallelHash128", func(api frontend.API) { ParallelHash128(api, long, 256, 256, nil) }, ParallelHashPermutations(Shake128Rate, 1024, 256, 256)},
+		{"Shake128", func(api frontend.API) { Shake128(api, long, 256) }, SpongePermutations(Shake128Rate, 1024, 256)},
+		// 2000 output bits: a second permutation between the two extractions
+		{"Shake128Squeeze", func(api frontend.API) { Shake128(api, msg, 2000) }, SpongePermutations(Shake128Rate, 64, 2000)},
+	}
+}
+
+// symbolicBits returns n symbolic wires.
+func symbolicBits(n int) []frontend.Variable {
+	bits := make([]frontend.Variable, n)
+	for i := range bits {
+		bits[i] = &symbolicWire{}
+	}
+	return bits
+}
+
+// symbolicLanes returns n symbolic 64-bit lanes.
+func symbolicLanes(n int) [][]frontend.Variable {
+	lanes := make([][]frontend.Variable, n)
+	for i := range lanes {
+		lanes[i] = symbolicBits(64)
+	}
+	return lanes
+}
+
+// symbolicState returns a Keccak state with every bit symbolic.
+func symbolicState() [][]frontend.Variable {
+	return symbolicLanes(25)
+}
+
+// calls is the number of API calls counted in s.
+func (s *GateStats) calls() int {
+	return s.Add + s.Sub + s.Mul + s.Assert + s.Boolean
+}
+
+// linear is the number of linear gates counted in s: XOR (Add) and NOT (Sub).
+func (s *GateStats) linear() int {
+	return s.Add + s.Sub
+}
+
+// nonlinear is the number of nonlinear gates counted in s: AND (Mul).
+func (s *GateStats) nonlinear() int {
+	return s.Mul
+}
+
+// gadgetCalls counts the API calls of one run of g.
+func gadgetCalls(g gadgetBenchmark) *GateStats {
+	stats := &GateStats{}
+	g.Run(&recordingAPI{field: gf2.ScalarField, stats: stats})
+	return stats
+}
+
+// benchmark wraps g as a testing.B benchmark reporting its API calls per op.
+func (g gadgetBenchmark) benchmark(b *testing.B) {
+	stats := &GateStats{}
+	api := &recordingAPI{field: gf2.ScalarField, stats: stats}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		g.Run(api)
+	}
+	b.StopTimer()
+	n := float64(b.N)
+	b.ReportMetric(float64(stats.calls())/n, "api-calls/op")
+	b.ReportMetric(float64(stats.Add)/n, "add/op")
+	b.ReportMetric(float64(stats.Sub)/n, "sub/op")
+	b.ReportMetric(float64(stats.Mul)/n, "mul/op")
+	b.ReportMetric(float64(stats.linear())/n, "linear/op")
+	b.ReportMetric(float64(stats.nonlinear())/n, "nonlinear/op")
+	if g.Perms > 0 {
+		b.ReportMetric(float64(g.Perms), "permutations/op")
+	}
+}
+
+func BenchmarkXorIn(b *testing.B)           { benchmarkGadget(b, "xorIn") }
+func BenchmarkKeccakRound(b *testing.B)     { benchmarkGadget(b, "keccakRound") }
+func BenchmarkKeccakF(b *testing.B)         { benchmarkGadget(b, "keccakF") }
+func BenchmarkKeccakF800(b *testing.B)      { benchmarkGadget(b, "KeccakF800") }
+func BenchmarkKeccakF400(b *testing.B)      { benchmarkGadget(b, "KeccakF400") }
+func BenchmarkKeccakF200(b *testing.B)      { benchmarkGadget(b, "KeccakF200") }
+func BenchmarkComputeKeccak(b *testing.B)   { benchmarkGadget(b, "computeKeccak") }
+func BenchmarkSha3_224(b *testing.B)        { benchmarkGadget(b, "Sha3_224") }
+func BenchmarkSha256Compress(b *testing.B)  { benchmarkGadget(b, "sha256Compress") }
+func BenchmarkSha256(b *testing.B)          { benchmarkGadget(b, "Sha256") }
+func BenchmarkParallelHash128(b *testing.B) { benchmarkGadget(b, "ParallelHash128") }
+func BenchmarkShake128(b *testing.B)        { benchmarkGadget(b, "Shake128") }
+func BenchmarkShake128Squeeze(b *testing.B) { benchmarkGadget(b, "Shake128Squeeze") }
+
+func benchmarkGadget(b *testing.B, name string) {
+	for _, g := range gadgetBenchmarks() {
+		if g.Name == name {
+			g.benchmark(b)
+			return
+		}
+	}
+	b.Fatalf("unknown gadget %q", name)
+}
+
+// BenchmarkParallelDefine compiles 16 inlined instances with Define building them sequentially and
+// traced on 4 goroutines (see parallel.go): go test -bench ParallelDefine -benchtime 3x compares the two.
+func BenchmarkParallelDefine(b *testing.B) {
+	configured := defineWorkers
+	b.Cleanup(func() { defineWorkers = configured })
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			defineWorkers = workers
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := compileCircuit(gf2.ScalarField, NewKeccak256Circuit(16, WithInlinedKeccak())); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkMultiSizeCompile compiles a 32-, a 64- and a 96-byte message as three circuits with a permutation
+// each and as one circuit sharing it (see multisize.go): go test -bench MultiSizeCompile -benchtime 3x
+// compares the two.
+func BenchmarkMultiSizeCompile(b *testing.B) {
+	sizes := []int{32, 64, 96}
+	b.Run("separate", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, n := range sizes {
+				if _, err := compileCircuit(gf2.ScalarField, newKeccakMultiSizeCircuit([]int{n}, false)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("shared", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := compileCircuit(gf2.ScalarField, newKeccakMultiSizeCircuit(sizes, true)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package keccakgf2
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// Bit representations:
+// Bits show up in three forms: []frontend.Variable (circuit inputs, assignments and constants, where a
+// constant bit is a Go int 0 or 1), []int (plain bits in reference code and checks) and []uint (the round
+// constants). Every conversion between them, and from and to bytes, goes through this file, always LSB
+// first within each byte. Converting back from variables is checked: anything that is not an assigned
+// 0/1 (a wire, nil, 2) is an error naming its position instead of a silent coercion. An assigned bit may be
+// any Go integer, a bool or a big.Int (assignedBit); the gadgets only fold Go ints (constBitValue).
+
+// bitsOf expands bytes into assignment bits, LSB first within each byte (the circuit.P order).
+func bitsOf(b []byte) []frontend.Variable {
+	res := make([]frontend.Variable, 8*len(b))
+	for i := range b {
+		for j := 0; j < 8; j++ {
+			res[i*8+j] = int((b[i] >> j) & 1)
+		}
+	}
+	return res
+}
+
+// byteBits[x] are the bits of byte x as constant variables, LSB first; putBits copies them instead of
+// unpacking every bit into a fresh slice.
+var byteBits = func() (t [256][8]frontend.Variable) {
+	for x := range t {
+		for j := 0; j < 8; j++ {
+			t[x][j] = (x >> j) & 1
+		}
+	}
+	return t
+}()
+
+// putBits writes the bits of b into dst in the bitsOf order, without allocating. dst may end inside the
+// last byte of b, whose remaining bits are dropped (a truncated digest). The bits are stored one by one:
+// a copy of 8 interface values per byte costs a bulk write barrier call each.
+func putBits(dst []frontend.Variable, b []byte) {
+	for i, x := range b {
+		bits, d := &byteBits[x], dst[8*i:]
+		for j := 0; j < 8 && j < len(d); j++ {
+			d[j] = bits[j]
+		}
+	}
+}
+
+// BitsToVariables turns plain bits into constant variables; every bit must be 0 or 1.
+func BitsToVariables(bits []int) ([]frontend.Variable, error) {
+	res := make([]frontend.Variable, len(bits))
+	for i, b := range bits {
+		if b != 0 && b != 1 {
+			return nil, fmt.Errorf("bit %d is %d, not 0/1", i, b)
+		}
+		res[i] = b
+	}
+	return res, nil
+}
+
+// VariablesToBits reads back assigned bits; a wire or any value other than 0 and 1 (see assignedBit) is an
+// error.
+func VariablesToBits(vs []frontend.Variable) ([]int, error) {
+	res := make([]int, len(vs))
+	for i, v := range vs {
+		b, ok := assignedBit(v)
+		if !ok {
+			return nil, fmt.Errorf("bit %d is %v, not a constant 0/1", i, v)
+		}
+		res[i] = b
+	}
+	return res, nil
+}
+
+// assignedBit reports whether v is an assigned bit, and its value: a Go integer of any kind, a big.Int or
+// *big.Int equal to 0 or 1, or a bool (true is 1).
+func assignedBit(v frontend.Variable) (int, bool) {
+	switch x := v.(type) {
+	case bool:
+		if x {
+			return 1, true
+		}
+		return 0, true
+	case *big.Int:
+		if x == nil || !x.IsInt64() {
+			return 0, false
+		}
+		if n := x.Int64(); n == 0 || n == 1 {
+			return int(n), true
+		}
+		return 0, false
+	case big.Int:
+		return assignedBit(&x)
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := rv.Int(); n == 0 || n == 1 {
+			return int(n), true
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n := rv.Uint(); n <= 1 {
+			return int(n), true
+		}
+	}
+	return 0, false
+}
+
+// UintBitsFromBytes expands bytes into uint bits, LSB first within each byte.
+func UintBitsFromBytes(b []byte) []uint {
+	res := make([]uint, 8*len(b))
+	for i := range b {
+		for j := 0; j < 8; j++ {
+			res[i*8+j] = uint((b[i] >> j) & 1)
+		}
+	}
+	return res
+}
+
+// assignedBytes packs assigned bits (0/1, see assignedBit; LSB first within each byte) back into bytes.
+func assignedBytes(bits []frontend.Variable) ([]byte, error) {
+	if len(bits)%8 != 0 {
+		return nil, fmt.Errorf("%d bits is not a whole number of bytes", len(bits))
+	}
+	plain, err := VariablesToBits(bits)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]byte, len(bits)/8)
+	for i, b := range plain {
+		res[i/8] |= byte(b) << (i % 8)
+	}
+	return res, nil
+}
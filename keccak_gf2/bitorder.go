@@ -0,0 +1,40 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// Byte and bit order conversions.
+// Both are pure wire permutations: they only reorder references to existing variables and emit no gates.
+// In the digest layout (bit 8*i+j = bit j of byte i):
+//   ReverseBytes turns hash[0..n) into hash[n-1..0], i.e. big-endian ↔ little-endian;
+//   ReverseBitsInBytes turns LSB-first bytes into MSB-first bytes and back.
+
+// ReverseBytes returns the bytes of bits in reverse order, keeping the bit order within each byte.
+func ReverseBytes(bits []frontend.Variable) []frontend.Variable {
+	checkByteAligned(bits)
+	n := len(bits) / 8
+	res := make([]frontend.Variable, 0, len(bits))
+	for i := n - 1; i >= 0; i-- {
+		res = append(res, bits[8*i:8*i+8]...)
+	}
+	return res
+}
+
+// ReverseBitsInBytes reverses the bit order within each byte, keeping the byte order.
+func ReverseBitsInBytes(bits []frontend.Variable) []frontend.Variable {
+	checkByteAligned(bits)
+	res := make([]frontend.Variable, len(bits))
+	for i := 0; i < len(bits); i += 8 {
+		for j := 0; j < 8; j++ {
+			res[i+j] = bits[i+7-j]
+		}
+	}
+	return res
+}
+
+func checkByteAligned(bits []frontend.Variable) {
+	if len(bits)%8 != 0 {
+		panic("bit slice length is not a whole number of bytes")
+	}
+}
@@ -0,0 +1,237 @@
+package keccakgf2
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/spec"
+)
+
+// Bit-level reference:
+// keccakF1600Ref (permcheck.go) works on uint64 lanes in the spec's x+5y order, so it can only be compared
+// with the circuit after whole rounds and after a layout conversion. bitState is the plain-Go mirror of the
+// circuit's own state: 25 lanes of 64 bits, lane (x, y) at index 5x+y (LayoutInternal, the "column-major"
+// indexing noted in keccakF's θ comments), bit j of a lane at index j (LSB first). The step functions
+// bitTheta, bitRhoPi, bitChi and bitIota are the four steps of a keccakF round on that layout, written
+// from FIPS 202 section 3.2 rather than from the gadget (θ uses the full column parity, not the circuit's
+// d/da split), so a refactor of keccakF can be checked step by step against the wires a StepObserver
+// (trace.go) hands out. bitKeccak256 runs the whole sponge on it for a final check against x/crypto.
+// θ, ρπ and ι take the lane width w: a Keccak-f[25w] state keeps its lanes in bits 0..w-1 and zeros above,
+// which χ leaves zero, so the same steps check the 8-bit lanes of Keccak-f[200] in a fraction of the time.
+
+// bitState is a Keccak-f[1600] state in the circuit's layout: lane 5x+y, LSB first; a narrower Keccak-f
+// uses the low w bits of every lane.
+type bitState [25][64]int
+
+// bitStateFromLanes converts lanes indexed x+5y (LayoutSpec) into a bitState.
+func bitStateFromLanes(lanes [25]uint64) bitState {
+	var a bitState
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 5; y++ {
+			for j := 0; j < 64; j++ {
+				a[LayoutInternal.Index(x, y)][j] = int(lanes[LayoutSpec.Index(x, y)]>>j) & 1
+			}
+		}
+	}
+	return a
+}
+
+// lanes converts a back into lanes indexed x+5y (LayoutSpec).
+func (a bitState) lanes() [25]uint64 {
+	var lanes [25]uint64
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 5; y++ {
+			for j := 0; j < 64; j++ {
+				lanes[LayoutSpec.Index(x, y)] |= uint64(a[LayoutInternal.Index(x, y)][j]) << j
+			}
+		}
+	}
+	return lanes
+}
+
+// bitTheta: A[x,y] ^= C[x-1] ^ rot(C[x+1], 1), with C[x] the parity of column x.
+func bitTheta(a bitState, w int) bitState {
+	var c [5][64]int
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 5; y++ {
+			for j := 0; j < w; j++ {
+				c[x][j] ^= a[LayoutInternal.Index(x, y)][j]
+			}
+		}
+	}
+	for x := 0; x < 5; x++ {
+		for j := 0; j < w; j++ {
+			d := c[(x+4)%5][j] ^ c[(x+1)%5][(j+w-1)%w]
+			for y := 0; y < 5; y++ {
+				a[LayoutInternal.Index(x, y)][j] ^= d
+			}
+		}
+	}
+	return a
+}
+
+// bitRhoPi returns B with B[y, 2x+3y] = rot(A[x,y], r[x,y]); the tables of the spec package are indexed x+5y.
+func bitRhoPi(a bitState, w int) bitState {
+	offsets := spec.RotationOffsetsFor(w)
+	var b bitState
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 5; y++ {
+			dst := spec.PiPermutation[LayoutSpec.Index(x, y)]
+			r := offsets[LayoutSpec.Index(x, y)]
+			for j := 0; j < w; j++ {
+				b[LayoutInternal.Index(dst%5, dst/5)][(j+r)%w] = a[LayoutInternal.Index(x, y)][j]
+			}
+		}
+	}
+	return b
+}
+
+// bitChi: A[x,y] = B[x,y] ^ (¬B[x+1,y] ∧ B[x+2,y]).
+func bitChi(b bitState) bitState {
+	var a bitState
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 5; y++ {
+			for j := 0; j < 64; j++ {
+				a[LayoutInternal.Index(x, y)][j] = b[LayoutInternal.Index(x, y)][j] ^ (1-b[LayoutInternal.Index((x+1)%5, y)][j])&b[LayoutInternal.Index((x+2)%5, y)][j]
+			}
+		}
+	}
+	return a
+}
+
+// bitIota: A[0,0] ^= RC[round], cut to w bits.
+func bitIota(a bitState, round, w int) bitState {
+	for j := 0; j < w; j++ {
+		a[LayoutInternal.Index(0, 0)][j] ^= int(roundConstants[round]>>j) & 1
+	}
+	return a
+}
+
+// bitKeccakF is Keccak-f[25w] on a bitState: the four steps, 12+2·log2(w) rounds (24 for w = 64).
+func bitKeccakF(a bitState, w int) bitState {
+	for r := 0; r < 12+2*bits.TrailingZeros(uint(w)); r++ {
+		a = bitIota(bitChi(bitRhoPi(bitTheta(a, w), w)), r, w)
+	}
+	return a
+}
+
+// bitKeccak256 is Keccak-256 of msg through bitKeccakF: pad10*1 with DomainKeccak at the rate of
+// Keccak256Config.
+func bitKeccak256(msg []byte) []byte {
+	r := rateOf(Keccak256Config.RateBits)
+	padded := append(append([]byte{}, msg...), DomainKeccak)
+	for len(padded)%r.Bytes != 0 {
+		padded = append(padded, 0)
+	}
+	padded[len(padded)-1] |= 0x80
+	var a bitState
+	for blk := 0; blk < len(padded); blk += r.Bytes {
+		lanes := a.lanes()
+		for i := 0; i < r.Lanes; i++ {
+			lanes[i] ^= binary.LittleEndian.Uint64(padded[blk+8*i:])
+		}
+		a = bitKeccakF(bitStateFromLanes(lanes), 64)
+	}
+	var out []byte
+	lanes := a.lanes()
+	for _, lane := range lanes[:Keccak256Config.OutputBits/64] {
+		out = binary.LittleEndian.AppendUint64(out, lane)
+	}
+	return out
+}
+
+// SHA-256 bit-level reference:
+// bitWord is a SHA-256 word as the gadgets of sha256.go hold it, bit j (weight 2^j) at index j. The
+// functions below are FIPS 180-4 section 6.2.2 written out on such words, independently of the gadget:
+// Ch and Maj in their textbook form ((e ∧ f) ⊕ (¬e ∧ g) and three ANDs) and additions with integer
+// carries, so that a change to the gadget's AND/XOR networks or its ripple-carry adder is checked round
+// by round against the wires a sha256RoundObserver hands out.
+
+// bitWord is a 32-bit word, LSB first.
+type bitWord [32]int
+
+// bitWordOf converts x into a bitWord.
+func bitWordOf(x uint32) bitWord {
+	var w bitWord
+	for j := range w {
+		w[j] = int(x>>j) & 1
+	}
+	return w
+}
+
+// bitWordAdd is a + b mod 2^32, bit by bit with an integer carry.
+func bitWordAdd(a, b bitWord) bitWord {
+	var s bitWord
+	carry := 0
+	for j := range s {
+		sum := a[j] + b[j] + carry
+		s[j], carry = sum&1, sum>>1
+	}
+	return s
+}
+
+// bitWordSigma is ROTR^r1(x) ⊕ ROTR^r2(x) ⊕ op3(x), op3 = ROTR^n (shift false) or SHR^n (shift true).
+func bitWordSigma(x bitWord, r1, r2, n int, shift bool) bitWord {
+	var r bitWord
+	for j := range r {
+		r[j] = x[(j+r1)%32] ^ x[(j+r2)%32]
+		if !shift || j+n < 32 {
+			r[j] ^= x[(j+n)%32]
+		}
+	}
+	return r
+}
+
+// bitSha256Schedule is the message schedule W0..W63 of a 64-byte block.
+func bitSha256Schedule(block []byte) [64]bitWord {
+	var w [64]bitWord
+	for t := 0; t < 16; t++ {
+		w[t] = bitWordOf(binary.BigEndian.Uint32(block[4*t:]))
+	}
+	for t := 16; t < 64; t++ {
+		w[t] = bitWordAdd(bitWordAdd(bitWordSigma(w[t-2], 17, 19, 10, true), w[t-7]), bitWordAdd(bitWordSigma(w[t-15], 7, 18, 3, true), w[t-16]))
+	}
+	return w
+}
+
+// bitSha256Round is round t of the compression function on the working variables s = a..h with schedule
+// word w.
+func bitSha256Round(s [8]bitWord, w bitWord, t int) [8]bitWord {
+	a, b, c, d, e, f, g, h := s[0], s[1], s[2], s[3], s[4], s[5], s[6], s[7]
+	var ch, maj bitWord
+	for j := 0; j < 32; j++ {
+		ch[j] = (e[j] & f[j]) ^ ((1 - e[j]) & g[j])
+		maj[j] = (a[j] & b[j]) ^ (a[j] & c[j]) ^ (b[j] & c[j])
+	}
+	t1 := bitWordAdd(bitWordAdd(h, bitWordSigma(e, 6, 11, 25, false)), bitWordAdd(bitWordAdd(ch, bitWordOf(sha256K[t])), w))
+	t2 := bitWordAdd(bitWordSigma(a, 2, 13, 22, false), maj)
+	return [8]bitWord{bitWordAdd(t1, t2), a, b, c, bitWordAdd(d, t1), e, f, g}
+}
+
+// bitSha256 is SHA-256 of msg through bitSha256Schedule and bitSha256Round.
+func bitSha256(msg []byte) []byte {
+	padded := append(append([]byte{}, msg...), sha256Padding(len(msg))...)
+	var h [8]bitWord
+	for i, x := range sha256IV {
+		h[i] = bitWordOf(x)
+	}
+	for blk := 0; blk < len(padded); blk += 64 {
+		w := bitSha256Schedule(padded[blk : blk+64])
+		s := h
+		for t := 0; t < 64; t++ {
+			s = bitSha256Round(s, w[t], t)
+		}
+		for i := range h {
+			h[i] = bitWordAdd(h[i], s[i])
+		}
+	}
+	var out []byte
+	for _, word := range h {
+		var x uint32
+		for j, b := range word {
+			x |= uint32(b) << j
+		}
+		out = binary.BigEndian.AppendUint32(out, x)
+	}
+	return out
+}
@@ -0,0 +1,52 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// Booleanity of the inputs:
+// The gadgets treat every input as a bit: XOR is api.Add, AND is api.Mul, NOT is 1 - x. That is bit logic
+// only for values 0 and 1, and nothing in the hash itself constrains the inputs. Over GF(2) every field
+// element is a bit: AssertIsBoolean is x*(1-x) = 0, which holds for both elements, so the assertion would
+// cost gates and check nothing, and no circuit of the package emits it there (the checked solver still
+// refuses an input of 2 as out of range). Over any other field, as when a gadget is lifted off GF(2), a
+// non-boolean input would silently turn into different semantics, so keccak256Circuit asserts, by default,
+// that every message bit (P or PublicP) and every context bit is boolean, and the other circuits assert
+// their private and message inputs through assertInputBits, by the same rule. Out needs no assertion: it is
+// asserted equal to the computed digest bits.
+//
+// compileCircuit accepts GF(2) only, so no circuit this package compiles carries a booleanity assertion
+// and WithoutBooleanity never changes one. The option is for the engines that define keccak256Circuit over
+// another field: gateStats and the fieldAPI evaluator of the tests, or a direct ecgo.Compile, where the
+// default costs one AssertIsBoolean per bit (512 per instance plus 8 per context byte). It is build-time
+// only, so assignments and the solving paths are the same either way.
+
+// CircuitOption configures a keccak256Circuit at construction (NewKeccak256Circuit and friends).
+type CircuitOption func(*keccak256Circuit)
+
+// WithoutBooleanity drops the booleanity assertions on the message and context bits off GF(2). Over
+// GF(2), the only field compileCircuit takes, it changes nothing: those assertions are never emitted there.
+func WithoutBooleanity() CircuitOption {
+	return func(t *keccak256Circuit) { t.NoBooleanity = true }
+}
+
+// booleanity reports whether Define asserts the input bits boolean on api: unless WithoutBooleanity, on
+// every field but GF(2).
+func (t *keccak256Circuit) booleanity(api frontend.API) bool {
+	return !t.NoBooleanity && !isGF2(api)
+}
+
+// assertInputBits is the booleanity policy of keccak256Circuit for the circuits without its option: it
+// asserts every bit of bits boolean off GF(2) and emits nothing over GF(2).
+func assertInputBits(api frontend.API, bits []frontend.Variable) {
+	if !isGF2(api) {
+		assertBooleans(api, bits)
+	}
+}
+
+// assertBooleans asserts that every bit of bits is 0 or 1.
+func assertBooleans(api frontend.API, bits []frontend.Variable) {
+	for _, b := range bits {
+		api.AssertIsBoolean(b)
+	}
+}
@@ -0,0 +1,172 @@
+package keccakgf2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/layered"
+	"github.com/consensys/gnark/frontend"
+)
+
+// Checkpointed builds:
+// ecgo compiles a circuit in one piece, so the unit of progress that can be saved is a compiled circuit.
+// A build matrix (several sizes or configurations) is run target by target, and a target whose instances
+// are independent (instancedCircuit, assemble.go) instance by instance: every compiled piece (an instance,
+// or a whole target that cannot be split) has its layered circuit and input solver written to the
+// checkpoint directory and is recorded in state.json with its fingerprint. Before every piece the context
+// is checked, so a job close to its deadline stops between instances with everything so far on disk, and
+// the next run with the same directory loads the finished pieces and only compiles the rest; a split
+// target is reassembled from its pieces, which takes no compile. state.json is replaced atomically (a
+// temporary file renamed over it), so an interrupted write never leaves a truncated state behind. In
+// low-memory mode (lowmem.go) the targets stay on disk: Run returns their fingerprints only, with Circuit
+// and Solver nil.
+
+// buildTarget is one circuit of a build matrix. Name must be unique and usable as a file name.
+type buildTarget struct {
+	Name    string
+	Circuit frontend.Circuit
+}
+
+// builtCircuit is a compiled (or reloaded) target.
+type builtCircuit struct {
+	Circuit     *layered.RootCircuit
+	Solver      inputSolver
+	Fingerprint string
+}
+
+type checkpointedBuild struct {
+	Dir   string
+	Field *big.Int
+	// OnBuilt, if set, is called after each piece is compiled and saved (not for reloaded ones), with the
+	// piece's name: the target's name, or its instance piece's (instanceBuild.pieceName).
+	OnBuilt func(name string)
+}
+
+// buildState is state.json: the fingerprint of every finished piece.
+type buildState struct {
+	Done map[string]string `json:"done"`
+}
+
+// Run builds every target, resuming from the checkpoint directory. If ctx ends first it returns ctx.Err()
+// (wrapped) after saving the state; the targets finished so far are kept for the next run.
+func (b *checkpointedBuild) Run(ctx context.Context, targets []buildTarget) (map[string]*builtCircuit, error) {
+	if err := os.MkdirAll(b.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	state := &buildState{Done: map[string]string{}}
+	if raw, err := os.ReadFile(filepath.Join(b.Dir, "state.json")); err == nil {
+		if err := json.Unmarshal(raw, state); err != nil {
+			return nil, fmt.Errorf("checkpoint state: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	built := make(map[string]*builtCircuit, len(targets))
+	for _, t := range targets {
+		if batch, ok := t.Circuit.(instancedCircuit); ok && batch.splittable() && batch.instanceCount() > 1 {
+			bc, err := b.runInstances(ctx, t.Name, batch, state)
+			if err == nil {
+				if lowMemory {
+					bc = &builtCircuit{Fingerprint: bc.Fingerprint}
+				}
+				built[t.Name] = bc
+				continue
+			}
+			if !errors.Is(err, errUnequalDepth) {
+				return nil, err
+			}
+			logger.Infof("%s: %v; compiling the target whole", t.Name, err)
+		}
+
+		circuitPath := filepath.Join(b.Dir, t.Name+".circuit")
+		solverPath := filepath.Join(b.Dir, t.Name+".solver")
+		if fp, ok := state.Done[t.Name]; ok {
+			if lowMemory {
+				built[t.Name] = &builtCircuit{Fingerprint: fp}
+				continue
+			}
+			bc, err := loadBuilt(circuitPath, solverPath, fp)
+			if err != nil {
+				return nil, fmt.Errorf("checkpoint %s: %w", t.Name, err)
+			}
+			built[t.Name] = bc
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("build stopped before %s with %d pieces done: %w", t.Name, len(state.Done), err)
+		}
+		bc, err := compileToDisk(b.Field, t.Circuit, circuitPath, solverPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.Name, err)
+		}
+		state.Done[t.Name] = bc.Fingerprint
+		if err := b.saved(state, t.Name); err != nil {
+			return nil, err
+		}
+		built[t.Name] = bc
+	}
+	return built, nil
+}
+
+// runInstances builds the split target name through instanceBuild, checkpointing every piece in state.
+func (b *checkpointedBuild) runInstances(ctx context.Context, name string, batch instancedCircuit, state *buildState) (*builtCircuit, error) {
+	return (&instanceBuild{Field: b.Field, Dir: b.Dir, Name: name, Done: state.Done,
+		Before: func(piece string) error {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("build stopped before %s with %d pieces done: %w", piece, len(state.Done), err)
+			}
+			return nil
+		},
+		Saved: func(piece string) error { return b.saved(state, piece) },
+	}).run(batch)
+}
+
+// saved records that piece was compiled: it writes state.json and calls OnBuilt.
+func (b *checkpointedBuild) saved(state *buildState, piece string) error {
+	raw, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(b.Dir, "state.json"), raw); err != nil {
+		return fmt.Errorf("checkpoint state: %w", err)
+	}
+	if b.OnBuilt != nil {
+		b.OnBuilt(piece)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data: it writes a temporary file in the same directory and renames it
+// over path, so that path holds either the old or the new contents, never a part.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
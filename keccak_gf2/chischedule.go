@@ -0,0 +1,192 @@
+package keccakgf2
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/field/gf2"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/layered"
+	"github.com/consensys/gnark/frontend"
+)
+
+// χ scheduling:
+// In the layered circuit θ, ρ, π and ι are linear and fold into the add gates of whichever layer consumes
+// them, so every round of keccakF is one multiplication layer holding all 1600 AND gates of its χ step.
+// There is no θ layer to interleave χ with: the only lever on the width is to move part of χ one layer
+// later. ecgo places a gate right after its deepest input, so a product only moves if one of its operands
+// does, and the one way to move a wire that survives ecgo's folding of linear combinations is to square
+// it (x·x = x over GF(2)), which is a multiplication gate itself, in the layer being relieved.
+//
+// ChiStaggered therefore delays 3 of the 5 lanes of rows y = 0, 2, 4 ({0, 1, 3}, which between them feed
+// every product of their row: product x reads lanes x+1 and x+2): per round the first layer holds the
+// 640 products of rows 1 and 3 plus 576 squares, the second layer the 960 delayed products. The widest
+// layer goes from 1600 to 1216 multiplications, for 576 more gates per round and twice the depth (48
+// multiplication layers per permutation instead of 24). Whether that pays depends on how the prover's
+// cost scales with width against depth; TestChiSchedule logs, and -layer-widths prints, the layer-width
+// histogram of a circuit under either schedule.
+// The schedule is a circuit option (WithChiSchedule, -chi): keccakF takes it as a PermOption, and a
+// staggered keccak256Circuit hashes through staggeredHash, whose functions are separate from computeKeccak
+// and friends so that the memoized sub-circuits of the two schedules stay apart (see memoize.go).
+
+// ChiSchedule is the layer layout of χ.
+type ChiSchedule int
+
+const (
+	ChiSingleLayer ChiSchedule = iota // all products of a round in one layer (the default)
+	ChiStaggered                      // three fifths of them one layer later
+)
+
+func (s ChiSchedule) String() string {
+	if s == ChiStaggered {
+		return "staggered"
+	}
+	return "single"
+}
+
+// ParseChiSchedule parses the -chi flag: "single" or "staggered".
+func ParseChiSchedule(s string) (ChiSchedule, error) {
+	for _, c := range []ChiSchedule{ChiSingleLayer, ChiStaggered} {
+		if s == c.String() {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown χ schedule %q (single or staggered)", s)
+}
+
+// WithChiSchedule builds the circuit with the χ layer layout s.
+func WithChiSchedule(s ChiSchedule) CircuitOption {
+	return func(t *keccak256Circuit) { t.Chi = s }
+}
+
+// withChiSchedule makes keccakF lay out χ by s.
+func withChiSchedule(s ChiSchedule) PermOption {
+	return PermOption{chi: s}
+}
+
+// staggeredKeccakF is keccakF under ChiStaggered, the permutation of the staggered hash functions.
+func staggeredKeccakF(api frontend.API, a [][]frontend.Variable) [][]frontend.Variable {
+	return keccakF(api, a, withChiSchedule(ChiStaggered))
+}
+
+// staggeredKeccak256, staggeredKeccak128 and staggeredSha3_256 are contextKeccak, contextKeccak128 and
+// Sha3_256 under ChiStaggered; they take messages of any byte length, with or without a context.
+func staggeredKeccak256(api frontend.API, in []frontend.Variable) []frontend.Variable {
+	return spongeWith(api, staggeredKeccakF, in, Keccak256Config.RateBits, DomainKeccak, 256)
+}
+
+func staggeredKeccak128(api frontend.API, in []frontend.Variable) []frontend.Variable {
+	return spongeWith(api, staggeredKeccakF, in, Keccak256Config.RateBits, DomainKeccak, TruncatedBits)
+}
+
+func staggeredSha3_256(api frontend.API, in []frontend.Variable) []frontend.Variable {
+	return spongeWith(api, staggeredKeccakF, in, Sha3_256Config.RateBits, Sha3_256Config.DomainSep, Sha3_256Config.OutputBits)
+}
+
+// staggeredHash is the staggered hash function of an instance of defineInstance.
+func staggeredHash(sha3 bool, checkBits int) func(frontend.API, []frontend.Variable) []frontend.Variable {
+	switch {
+	case sha3:
+		return staggeredSha3_256
+	case checkBits <= TruncatedBits:
+		return staggeredKeccak128
+	}
+	return staggeredKeccak256
+}
+
+// staggeredLanes are the lanes (LayoutInternal index 5x+y) whose χ operands ChiStaggered delays.
+var staggeredLanes = func() []int {
+	var lanes []int
+	for _, y := range []int{0, 2, 4} {
+		for _, x := range []int{0, 1, 3} {
+			lanes = append(lanes, LayoutInternal.Index(x, y))
+		}
+	}
+	return lanes
+}()
+
+// staggerChi replaces the staggered lanes of b, the χ input of a round, by their squares, moving every
+// product of rows 0, 2 and 4 one layer later.
+func staggerChi(api frontend.API, b *[25][]frontend.Variable) {
+	for _, i := range staggeredLanes {
+		delayed := make([]frontend.Variable, len(b[i]))
+		for j, v := range b[i] {
+			if _, ok := constBitValue(v); ok {
+				delayed[j] = v
+				continue
+			}
+			delayed[j] = api.Mul(v, v)
+		}
+		b[i] = delayed
+	}
+}
+
+// LayerWidth is the size of one layer of a layered circuit: its output wires and its gates, sub-circuit
+// instances included.
+type LayerWidth struct {
+	Layer   int
+	Outputs int
+	Mul     int
+	Add     int
+}
+
+// LayerWidths returns the widths of every layer of c, input side first.
+func LayerWidths(c *layered.RootCircuit) []LayerWidth {
+	type gates struct{ mul, add int }
+	counted := map[uint64]gates{}
+	var count func(id uint64) gates
+	count = func(id uint64) gates {
+		if g, ok := counted[id]; ok {
+			return g
+		}
+		sc := c.Circuits[id]
+		g := gates{mul: len(sc.Mul), add: len(sc.Add)}
+		for _, sub := range sc.SubCircuits {
+			s := count(sub.Id)
+			g.mul += s.mul * len(sub.Allocations)
+			g.add += s.add * len(sub.Allocations)
+		}
+		counted[id] = g
+		return g
+	}
+	res := make([]LayerWidth, len(c.Layers))
+	for i, id := range c.Layers {
+		g := count(id)
+		res[i] = LayerWidth{Layer: i, Outputs: int(c.Circuits[id].OutputLen), Mul: g.mul, Add: g.add}
+	}
+	return res
+}
+
+// WriteLayerHistogram prints one line per layer with its multiplication count as a bar of up to 50
+// characters, scaled to the widest layer.
+func WriteLayerHistogram(w io.Writer, widths []LayerWidth) error {
+	widest := 1
+	for _, l := range widths {
+		if l.Mul > widest {
+			widest = l.Mul
+		}
+	}
+	for _, l := range widths {
+		bar := strings.Repeat("#", (50*l.Mul+widest-1)/widest)
+		if _, err := fmt.Fprintf(w, "layer %3d  %8d outputs %8d mul %8d add  %s\n", l.Layer, l.Outputs, l.Mul, l.Add, bar); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCircuitLayers compiles the batch circuit of o and prints its layer-width histogram to w.
+func WriteCircuitLayers(w io.Writer, o Options) error {
+	template := newKeccak256Circuit(o.Instances, len(o.Context))
+	for _, opt := range o.circuitOptions() {
+		opt(template)
+	}
+	cr, err := compileCircuit(gf2.ScalarField, template)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%d instances, χ schedule %s:\n", o.Instances, o.ChiSchedule); err != nil {
+		return err
+	}
+	return WriteLayerHistogram(w, LayerWidths(cr.GetLayeredCircuit()))
+}
@@ -0,0 +1,40 @@
+package keccakgf2
+
+import (
+	"fmt"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/field/gf2"
+	"github.com/consensys/gnark/frontend"
+)
+
+// Circuit-exact hashing:
+// evalBytes runs a gadget on constant inputs through the recording API of gatestats.go: the gadgets fold
+// constant bits instead of emitting gates, so the whole computation collapses into the output constants.
+// The result is by construction what the circuit computes (same padding, bit order and squeeze) rather
+// than what a separate reference implementation computes. CircuitKeccak256 is the one hash preflight and
+// corpus generation need; the tests pin every other gadget through evalBytes directly.
+
+// evalBytes returns the output bits of f, evaluated on constants over GF(2), as bytes (bit i of the output
+// is bit i%8 of byte i/8). f closes over its constant inputs, e.g. bitsOf(msg).
+func evalBytes(f func(frontend.API) []frontend.Variable) []byte {
+	out, err := assignedBytes(f(&recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}))
+	if err != nil {
+		// a gadget emitted a gate on constant operands instead of folding it (see gatestats.go)
+		panic(fmt.Sprintf("evalBytes: output is not constant: %v", err))
+	}
+	return out
+}
+
+// CircuitKeccak256 returns Keccak-256(msg) as the circuit computes it: computeKeccak for 64-byte messages,
+// the general sponge (as for a context prefix) for any other length. TestCircuitKeccak256 pins it to
+// go-ethereum.
+func CircuitKeccak256(msg []byte) [32]byte {
+	var digest [32]byte
+	copy(digest[:], evalBytes(func(api frontend.API) []frontend.Variable {
+		if len(msg) == 64 {
+			return computeKeccak(api, bitsOf(msg))
+		}
+		return keccakSponge(api, bitsOf(msg), Keccak256Config.RateBits, DomainKeccak, 256)
+	}))
+	return digest
+}
@@ -0,0 +1,153 @@
+// Command keccak_gf2 builds, solves and checks a batch of the keccak_gf2 circuit, writing circuit.txt,
+// layout.json and witness.env to the working directory, or runs one of its modes (-example, -check,
+// -anonymize, -gencorpus, -variants, -artifacts, -external, -diff). The tests and the gadget benchmarks
+// are go tests: run go test ./... (with -bench . for the benchmarks) from the keccak_gf2 directory.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	keccakgf2 "github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/verifier"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "keccak_gf2: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run parses the flags and runs the selected mode, or builds a batch; the first failure is returned.
+func run() error {
+	o := keccakgf2.DefaultOptions()
+	seed := flag.Int64("seed", 0, "seed for reproducible messages (0: use crypto/rand)")
+	contextFlag := flag.String("context", "", "bind every witness to this public context string")
+	flag.BoolVar(&o.Verbose, "v", false, "also log diagnostics (to stderr)")
+	flag.BoolVar(&o.Quiet, "q", false, "log nothing (to stderr)")
+	example := flag.Bool("example", false, "run the toy example only and print its digest to stdout")
+	flag.BoolVar(&o.SkipPreflight, "no-preflight", false, "skip re-deriving the digests of every assignment before solving")
+	flag.BoolVar(&o.PublicInput, "public-input", false, "make the messages public inputs (transparent-hash mode) of the batch circuit")
+	flag.BoolVar(&o.SHA3, "sha3", false, "prove SHA3-256 instead of Keccak-256 digests in the batch circuit (combines with -public-input)")
+	variants := flag.String("variants", "", "prove a mix of digest variants (comma-separated, cycled over the -n instances: keccak256, keccak384, keccak512, sha3-224, sha3-256, sha3-384, sha3-512): build, solve and check a batch of that circuit and exit")
+	flag.IntVar(&o.Instances, "n", o.Instances, "number of Keccak-256 instances per assignment")
+	flag.IntVar(&o.DefineWorkers, "define-workers", o.DefineWorkers, "goroutines tracing circuit instances during Define (1: sequential)")
+	flag.BoolVar(&o.LowMemory, "low-memory", false, "compile with less peak memory and more time: one instance at a time, assembled into the batch circuit through disk (see lowmem.go)")
+	anonymize := flag.String("anonymize", "", "print a shareable, anonymized artifact of the failing witness case in this file (see anonymize.go) and exit")
+	check := flag.String("check", "", "check the batch in this witness envelope against -circuit, write the verdict to -verdict and exit with its status (0 passed, 1 failed, 2 error)")
+	circuitFile := flag.String("circuit", "circuit.txt", "compiled circuit read by -check")
+	verdictFile := flag.String("verdict", "verdict.json", "verdict file written by -check")
+	artifacts := flag.String("artifacts", "", "compile one circuit per -sizes message size into this directory with its sizes.json manifest (see artifacts.go) and exit; an interrupted build resumes")
+	sizes := flag.String("sizes", "0,32,64,135,136", "comma-separated message sizes in bytes compiled by -artifacts")
+	external := flag.String("external", "", "cross-check the digests of a solved batch against this command (arguments split on spaces; hex messages in, hex digests out, one per line, see external.go) and exit")
+	serveHash := flag.Bool("serve-hash", false, "answer every hex message line on stdin with its hex Keccak-256 on stdout (the -external protocol) and exit")
+	diff := flag.String("diff", "", "compare two witness envelopes (comma-separated paths) built for the circuit of -n, -context and -public-input, print their first difference and exit with status 1 if they differ")
+	diffFull := flag.Bool("diff-full", false, "with -diff, also compare the private inputs")
+	chi := flag.String("chi", keccakgf2.ChiSingleLayer.String(), "layer layout of the χ step: single or staggered (see chischedule.go)")
+	layerWidths := flag.Bool("layer-widths", false, "compile the circuit of -n and -context, print its layer-width histogram to stdout and exit")
+	gencorpus := flag.String("gencorpus", "", "regenerate the test corpus (seed -seed, or the testdata/ seed if 0) into this directory and exit")
+	flag.Parse()
+	if o.Verbose && o.Quiet {
+		return fmt.Errorf("-v and -q are mutually exclusive")
+	}
+	if o.Instances < 1 {
+		return fmt.Errorf("-n must be at least 1")
+	}
+	if o.DefineWorkers < 1 {
+		return fmt.Errorf("-define-workers must be at least 1")
+	}
+	schedule, err := keccakgf2.ParseChiSchedule(*chi)
+	if err != nil {
+		return fmt.Errorf("-chi: %w", err)
+	}
+	o.ChiSchedule = schedule
+	if *variants != "" {
+		if o.Variants, err = keccakgf2.ParseVariants(*variants); err != nil {
+			return fmt.Errorf("-variants: %w", err)
+		}
+		if o.ChiSchedule != keccakgf2.ChiSingleLayer {
+			return fmt.Errorf("-chi applies to the Keccak-256 batch circuit, not to -variants")
+		}
+	}
+	o.Seed = *seed
+	o.Context = []byte(*contextFlag)
+	keccakgf2.Configure(o)
+
+	switch {
+	case *gencorpus != "":
+		corpus := int64(keccakgf2.CorpusSeed)
+		if o.Seed != 0 {
+			corpus = o.Seed
+		}
+		if err := keccakgf2.GenerateCorpus(*gencorpus, corpus); err != nil {
+			return err
+		}
+		keccakgf2.Infof("wrote corpus (seed %d) to %s", corpus, *gencorpus)
+	case *artifacts != "":
+		var list []int
+		for _, f := range strings.Split(*sizes, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(f))
+			if err != nil {
+				return fmt.Errorf("-sizes: %w", err)
+			}
+			list = append(list, n)
+		}
+		m, err := keccakgf2.BuildArtifacts(context.Background(), *artifacts, list)
+		if err != nil {
+			return err
+		}
+		keccakgf2.Infof("wrote %d artifacts and sizes.json to %s", len(m.Sizes), *artifacts)
+	case *diff != "":
+		paths := strings.Split(*diff, ",")
+		if len(paths) != 2 {
+			return fmt.Errorf("-diff: expected two comma-separated paths, got %q", *diff)
+		}
+		same, err := keccakgf2.DiffWitnessFiles(os.Stdout, paths[0], paths[1], keccakgf2.WitnessNames(o), *diffFull)
+		if err != nil {
+			return err
+		}
+		if !same {
+			os.Exit(1)
+		}
+	case *layerWidths:
+		return keccakgf2.WriteCircuitLayers(os.Stdout, o)
+	case *serveHash:
+		return keccakgf2.ServeHashLines(os.Stdin, os.Stdout)
+	case *external != "":
+		return keccakgf2.ExternalCheck(o, strings.Fields(*external))
+	case *check != "":
+		v := verifier.CheckFiles(*circuitFile, *check)
+		if err := v.WriteFile(*verdictFile); err != nil {
+			keccakgf2.Infof("writing %s: %v", *verdictFile, err)
+			os.Exit(verifier.ExitError)
+		}
+		if v.Error != "" {
+			keccakgf2.Infof("check: %s", v.Error)
+		} else {
+			keccakgf2.Infof("check: %d of %d assignments passed, failed: %v", v.Passed, v.Total, v.Failed)
+		}
+		os.Exit(v.ExitCode())
+	case *anonymize != "":
+		raw, err := keccakgf2.AnonymizeFile(*anonymize, o.Reader())
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(raw))
+	case len(o.Variants) > 0:
+		return keccakgf2.BuildVariantBatch(o)
+	case *example:
+		digest, err := keccakgf2.ToyExample()
+		if err != nil {
+			return err
+		}
+		fmt.Println(digest)
+	default:
+		return keccakgf2.BuildBatch(o)
+	}
+	return nil
+}
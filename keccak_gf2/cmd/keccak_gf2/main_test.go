@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/irwg"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/verifier"
+)
+
+func TestMain(m *testing.M) {
+	// the test binary doubles as the command line, so that exit statuses can be checked from a parent
+	if os.Getenv("KECCAK_GF2_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs the command line with args in dir as a child process and returns its exit status and stderr.
+func runMain(t *testing.T, dir string, args ...string) (int, string) {
+	t.Helper()
+	code, _, stderr := runMainIO(t, dir, "", args...)
+	return code, stderr
+}
+
+// runMainIO is runMain with stdin, also returning stdout.
+func runMainIO(t *testing.T, dir, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr strings.Builder
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "KECCAK_GF2_MAIN=1")
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), stdout.String(), stderr.String()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0, stdout.String(), stderr.String()
+}
+
+// TestCheckExitStatus builds a batch with the default mode, corrupts one assignment of a copy of its
+// envelope, and runs -check on both: the intact batch exits with ExitPassed, the corrupted one with
+// ExitFailed and its caller index in the verdict, and a missing envelope with ExitError.
+func TestCheckExitStatus(t *testing.T) {
+	dir := t.TempDir()
+	if code, stderr := runMain(t, dir, "-q", "-seed", "1", "-n", "2"); code != 0 {
+		t.Fatalf("building a batch exited %d: %s", code, stderr)
+	}
+	venv, err := verifier.LoadEnvelope(filepath.Join(dir, "witness.env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := &verifier.Envelope{Indices: venv.Indices, Witness: &irwg.Witness{}}
+	*corrupt.Witness = *venv.Witness
+	corrupt.Witness.Values = append([]*big.Int{}, venv.Witness.Values...)
+	perAssignment := venv.Witness.NumInputsPerWitness + venv.Witness.NumPublicInputsPerWitness
+	flipped := 4*perAssignment + venv.Witness.NumInputsPerWitness
+	corrupt.Witness.Values[flipped] = new(big.Int).Sub(big.NewInt(1), corrupt.Witness.Values[flipped])
+	if err := os.WriteFile(filepath.Join(dir, "corrupt.env"), corrupt.Serialize(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []struct {
+		envelope string
+		code     int
+		failed   []int
+	}{{"witness.env", verifier.ExitPassed, []int{}}, {"corrupt.env", verifier.ExitFailed, []int{venv.Indices[4]}}, {"missing.env", verifier.ExitError, []int{}}} {
+		os.Remove(filepath.Join(dir, "verdict.json"))
+		code, stderr := runMain(t, dir, "-q", "-check", e.envelope, "-circuit", "circuit.txt", "-verdict", "verdict.json")
+		raw, err := os.ReadFile(filepath.Join(dir, "verdict.json"))
+		if err != nil {
+			t.Fatalf("-check %s exited %d without a verdict: %v (%s)", e.envelope, code, err, stderr)
+		}
+		var verdict verifier.Verdict
+		if err := json.Unmarshal(raw, &verdict); err != nil {
+			t.Fatal(err)
+		}
+		if code != e.code || verdict.ExitCode() != e.code || fmt.Sprint(verdict.Failed) != fmt.Sprint(e.failed) {
+			t.Fatalf("-check %s exited %d with verdict %s", e.envelope, code, raw)
+		}
+	}
+}
+
+// TestUsageError checks that a failing run prints its error and exits with status 1 instead of panicking.
+func TestUsageError(t *testing.T) {
+	code, stderr := runMain(t, t.TempDir(), "-n", "0")
+	if code != 1 || stderr != "keccak_gf2: -n must be at least 1\n" {
+		t.Fatalf("-n 0 exited %d and printed %q", code, stderr)
+	}
+}
+
+// TestOutputRouting checks that log lines go to stderr only: -gencorpus logs its progress line by default
+// and with -v, nothing with -q, and never writes stdout; -serve-hash with -v writes exactly the digests to
+// stdout and nothing to stderr.
+func TestOutputRouting(t *testing.T) {
+	for _, e := range []struct {
+		flags  []string
+		stderr string
+	}{{[]string{"-q"}, ""}, {nil, "wrote corpus"}, {[]string{"-v"}, "wrote corpus"}} {
+		code, stdout, stderr := runMainIO(t, t.TempDir(), "", append(e.flags, "-gencorpus", "corpus")...)
+		if code != 0 || stdout != "" || (e.stderr == "") != (stderr == "") || !strings.Contains(stderr, e.stderr) {
+			t.Fatalf("-gencorpus with %v exited %d, stdout %q, stderr %q", e.flags, code, stdout, stderr)
+		}
+	}
+	code, stdout, stderr := runMainIO(t, t.TempDir(), "\n00\n", "-v", "-serve-hash")
+	want := "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470\n" +
+		"bc36789e7a1e281436464229828f817d6612f7b477d66591ff96a9e064bcc98a\n"
+	if code != 0 || stdout != want || stderr != "" {
+		t.Fatalf("-serve-hash exited %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+}
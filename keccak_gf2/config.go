@@ -0,0 +1,74 @@
+package keccakgf2
+
+import (
+	"fmt"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// Keccak configurations:
+// KeccakConfig names the four parameters that tell the members of the Keccak family apart, so that one
+// code path (KeccakWithConfig) can express Keccak-256, Keccak-512, SHA3 and reduced-round variants:
+// the rate, the output length, the number of Keccak-p rounds and the domain separation byte.
+// Keccak256Config is exactly computeKeccak.
+
+// KeccakConfig parametrises KeccakWithConfig.
+type KeccakConfig struct {
+	RateBits   int  // sponge rate in bits, a positive multiple of 64 below 1600
+	OutputBits int  // digest length in bits; outputs longer than the rate are squeezed in several passes
+	Rounds     int  // Keccak-p rounds per permutation, 1..24 (24: Keccak-f[1600])
+	DomainSep  byte // domain separation byte (DomainKeccak, DomainSHA3, ...)
+}
+
+// Keccak256Config, Keccak384Config and Keccak512Config are the legacy (pre-FIPS 202) Keccak-256,
+// Keccak-384 and Keccak-512.
+var (
+	Keccak256Config = KeccakConfig{RateBits: 1088, OutputBits: 256, Rounds: 24, DomainSep: DomainKeccak}
+	Keccak384Config = KeccakConfig{RateBits: 832, OutputBits: 384, Rounds: 24, DomainSep: DomainKeccak}
+	Keccak512Config = KeccakConfig{RateBits: 576, OutputBits: 512, Rounds: 24, DomainSep: DomainKeccak}
+)
+
+// Validate rejects configurations that do not describe a Keccak sponge.
+func (cfg KeccakConfig) Validate() error {
+	if cfg.RateBits <= 0 || cfg.RateBits%64 != 0 || cfg.RateBits >= 1600 {
+		return fmt.Errorf("keccak config: rate %d is not a positive multiple of 64 below 1600", cfg.RateBits)
+	}
+	if cfg.OutputBits <= 0 {
+		return fmt.Errorf("keccak config: output of %d bits, expected a positive length", cfg.OutputBits)
+	}
+	if cfg.Rounds < 1 || cfg.Rounds > 24 {
+		return fmt.Errorf("keccak config: %d rounds, expected 1..24", cfg.Rounds)
+	}
+	if cfg.DomainSep == 0 || cfg.DomainSep&0x80 != 0 {
+		return fmt.Errorf("keccak config: domain byte %#02x carries no padding bit or overlaps the final one", cfg.DomainSep)
+	}
+	return nil
+}
+
+// WithOutputBits returns cfg with the digest length set to n bits, e.g. Keccak256Config.WithOutputBits(128)
+// for the first 128 bits of Keccak-256: the sponge is unchanged, only fewer bits are squeezed.
+func (cfg KeccakConfig) WithOutputBits(n int) KeccakConfig {
+	cfg.OutputBits = n
+	return cfg
+}
+
+// Function Purpose:
+	// The Keccak sponge described by cfg over a compile-time-length, byte-aligned message; an invalid
+	// cfg is a circuit-build-time error and panics.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: message bits, LSB first within each byte, len(msg) % 8 == 0
+	// - `cfg`: rate, output length, rounds and domain byte
+// Outputs:
+	// - cfg.OutputBits digest bits, LSB first within each byte
+// Gate Count:
+	// that of keccakSponge, with each permutation costing cfg.Rounds/24 of keccakF
+func KeccakWithConfig(api frontend.API, msg []frontend.Variable, cfg KeccakConfig) []frontend.Variable {
+	if err := cfg.Validate(); err != nil {
+		panic(err)
+	}
+	perm := func(api frontend.API, a [][]frontend.Variable) [][]frontend.Variable {
+		return keccakP(api, a, 24-cfg.Rounds, cfg.Rounds)
+	}
+	return spongeWith(api, perm, msg, cfg.RateBits, cfg.DomainSep, cfg.OutputBits)
+}
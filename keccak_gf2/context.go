@@ -0,0 +1,45 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// Context binding (replay protection):
+// A circuit built with newKeccak256Circuit(contextBytes > 0) gets a public Context input of contextBytes bytes,
+// and every instance then proves Out[i] = Keccak-256(Context || P[i]) instead of Keccak-256(P[i]).
+// The context is absorbed as a public prefix, so a witness produced under one context has digests that
+// cannot satisfy the circuit under any other context, and a verifier reads the context it is accepting
+// straight from the public inputs (verifier.PublicContexts).
+
+// newKeccak256Circuit returns a circuit (or an empty assignment) with n instances and a contextBytes-byte
+// public context. contextBytes = 0 gives the plain, context-free circuit, i.e. NewKeccak256Circuit(n).
+func newKeccak256Circuit(n int, contextBytes int) *keccak256Circuit {
+	if contextBytes < 0 {
+		panic("negative context length")
+	}
+	circuit := NewKeccak256Circuit(n)
+	if contextBytes > 0 {
+		circuit.Context = make([]frontend.Variable, 8*contextBytes)
+	}
+	return circuit
+}
+
+// WithContext binds an assignment to the public context: Context gets the bits of context. A template
+// only needs the length, as newKeccak256Circuit allocates it.
+func WithContext(context []byte) CircuitOption {
+	return func(t *keccak256Circuit) { t.Context = bitsOf(context) }
+}
+
+// contextKeccak computes Keccak-256(ctx || P) through the general sponge (the prefix pushes the message off
+// the single-block fast path of computeKeccak as soon as ctx is longer than 72 bytes). It takes ctx || P
+// concatenated, so that memorizedCall can instantiate it (the context length is the same for every
+// instance of a circuit).
+func contextKeccak(api frontend.API, in []frontend.Variable) []frontend.Variable {
+	return keccakSponge(api, in, Keccak256Config.RateBits, DomainKeccak, 256)
+}
+
+// contextKeccak128 is contextKeccak squeezing only the first TruncatedBits bits (see computeKeccak128).
+func contextKeccak128(api frontend.API, in []frontend.Variable) []frontend.Variable {
+	cfg := Keccak256Config.WithOutputBits(TruncatedBits)
+	return keccakSponge(api, in, cfg.RateBits, cfg.DomainSep, cfg.OutputBits)
+}
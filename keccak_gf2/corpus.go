@@ -0,0 +1,113 @@
+package keccakgf2
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+)
+
+// Test corpus:
+// GenerateCorpus derives every fixture file from a seed through the off-circuit code (CircuitKeccak256,
+// merkleTree/merkleOpening, sampleIndices), so fixtures never have to be edited by hand: change the
+// format here and regenerate with -gencorpus.
+// CorpusSeed is the seed the checked-in testdata/ was generated with.
+const CorpusSeed = 995
+
+// corpusFiles are the files GenerateCorpus writes, in order.
+var corpusFiles = []string{"messages.hex", "digests.hex", "merkle.json", "abi.hex", "indices.json"}
+
+// GenerateCorpus writes the corpus for seed into dir:
+//   messages.hex  16 random messages of 64 bytes, one hex string per line
+//   digests.hex   their Keccak-256 digests, same order
+//   merkle.json   the Merkle tree over the first NHashes digests and the opening of every leaf
+//   abi.hex       abi.encode(uint256 i, bytes32 digest_i) for every message, one per line
+//   indices.json  sampleIndices(digest_i, sampleK, sampleM) for every message
+func GenerateCorpus(dir string, seed int64) error {
+	rnd := seededReader(seed)
+	msgs := make([][]byte, 16)
+	digests := make([][32]byte, len(msgs))
+	for i := range msgs {
+		msgs[i] = make([]byte, 64)
+		if _, err := io.ReadFull(rnd, msgs[i]); err != nil {
+			return err
+		}
+		digests[i] = CircuitKeccak256(msgs[i])
+	}
+
+	var messagesHex, digestsHex, abiHex bytes.Buffer
+	indices := make([][]uint64, len(msgs))
+	for i := range msgs {
+		fmt.Fprintln(&messagesHex, hex.EncodeToString(msgs[i]))
+		fmt.Fprintln(&digestsHex, hex.EncodeToString(digests[i][:]))
+		word := make([]byte, 32)
+		new(big.Int).SetInt64(int64(i)).FillBytes(word)
+		fmt.Fprintln(&abiHex, hex.EncodeToString(append(word, digests[i][:]...)))
+		indices[i] = sampleIndices(digests[i][:], sampleK, sampleM)
+	}
+
+	levels := merkleTree(digests[:NHashes])
+	type opening struct {
+		Leaf  int      `json:"leaf"`
+		Proof []string `json:"proof"`
+	}
+	tree := struct {
+		Levels   [][]string `json:"levels"`
+		Openings []opening  `json:"openings"`
+	}{}
+	for _, level := range levels {
+		var hexes []string
+		for _, node := range level {
+			hexes = append(hexes, hex.EncodeToString(node[:]))
+		}
+		tree.Levels = append(tree.Levels, hexes)
+	}
+	for k := 0; k < NHashes; k++ {
+		o := opening{Leaf: k}
+		for _, sib := range merkleOpening(levels, k) {
+			o.Proof = append(o.Proof, hex.EncodeToString(sib[:]))
+		}
+		tree.Openings = append(tree.Openings, o)
+	}
+	merkleJSON, err := json.MarshalIndent(tree, "", "  ")
+	if err != nil {
+		return err
+	}
+	indicesJSON, err := json.MarshalIndent(indices, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	contents := [][]byte{messagesHex.Bytes(), digestsHex.Bytes(), merkleJSON, abiHex.Bytes(), indicesJSON}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for i, name := range corpusFiles {
+		if err := os.WriteFile(filepath.Join(dir, name), contents[i], 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameCorpus reports whether dirs a and b hold byte-identical corpus files.
+func sameCorpus(a string, b string) (bool, error) {
+	for _, name := range corpusFiles {
+		x, err := os.ReadFile(filepath.Join(a, name))
+		if err != nil {
+			return false, err
+		}
+		y, err := os.ReadFile(filepath.Join(b, name))
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(x, y) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,210 @@
+package keccakgf2
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// Digest bit layout (shared by every helper in this file):
+// A digest is a flat []frontend.Variable where bit 8*i+j is bit j (LSB first) of byte i.
+// This is exactly the order used by copyOutUnaligned and by main when it fills circuit.Out:
+//   outBits[i*8+j] = (hash[i] >> j) & 1
+// So "the first n bytes" of hash[:] are digest[:8*n] and "the last n bytes" are digest[len-8*n:].
+
+// Function Purpose:
+	// Returns the bits of the first `nBytes` bytes of a digest, i.e. the circuit view of hash[:nBytes].
+	// e.g. a 4-byte function selector is DigestPrefixBits(digest, 4).
+// Inputs:
+	// - `digest`: digest bits in the LSB-first-within-byte layout, len(digest) must be a multiple of 8
+	// - `nBytes`: number of leading bytes to keep, 1 <= nBytes <= len(digest)/8
+// Outputs:
+	// - a slice of 8*nBytes variables (a view into digest, no gates are emitted)
+func DigestPrefixBits(digest []frontend.Variable, nBytes int) []frontend.Variable {
+	checkDigestSlice(digest, nBytes)
+	return digest[:8*nBytes]
+}
+
+// Function Purpose:
+	// Returns the bits of the last `nBytes` bytes of a digest, i.e. the circuit view of hash[len(hash)-nBytes:].
+	// e.g. an Ethereum address is DigestSuffixBits(digest, 20).
+// Inputs:
+	// - `digest`: digest bits in the LSB-first-within-byte layout, len(digest) must be a multiple of 8
+	// - `nBytes`: number of trailing bytes to keep, 1 <= nBytes <= len(digest)/8
+// Outputs:
+	// - a slice of 8*nBytes variables (a view into digest, no gates are emitted)
+func DigestSuffixBits(digest []frontend.Variable, nBytes int) []frontend.Variable {
+	checkDigestSlice(digest, nBytes)
+	return digest[len(digest)-8*nBytes:]
+}
+
+// checkDigestSlice rejects truncation requests that would silently cut a byte in half or read past the digest.
+// These are circuit-build-time errors, so panicking here mirrors the rest of the builder code.
+func checkDigestSlice(digest []frontend.Variable, nBytes int) {
+	if len(digest)%8 != 0 {
+		panic("digest length is not a whole number of bytes")
+	}
+	if nBytes < 1 || 8*nBytes > len(digest) {
+		panic("nBytes out of range for digest")
+	}
+}
+
+// Byte-grouped digests:
+// Byte-oriented gadgets (address truncation, Merkle packing, bytes32 constants) index a digest by byte,
+// so DigestBytes groups the same bits as [byte][bit]: d[i][j] is bit j (LSB first) of hash[i], i.e. digest[8*i+j].
+// The conversions only regroup wires; no gates are emitted.
+
+// Keccak256Digest is a Keccak-256 digest grouped by byte, d[i] = hash[i] (crypto.Keccak256Hash order), LSB first within each byte.
+type Keccak256Digest = [32][8]frontend.Variable
+
+// DigestBitsToBytes groups digest bits by byte: res[i][j] = digest[8*i+j]. len(digest) must be a multiple of 8.
+func DigestBitsToBytes(digest []frontend.Variable) [][8]frontend.Variable {
+	if len(digest)%8 != 0 {
+		panic("digest length is not a whole number of bytes")
+	}
+	res := make([][8]frontend.Variable, len(digest)/8)
+	for i := range res {
+		copy(res[i][:], digest[8*i:8*i+8])
+	}
+	return res
+}
+
+// BytesToDigestBits is the inverse of DigestBitsToBytes: the flat digest layout used for circuit.Out.
+func BytesToDigestBits(digest [][8]frontend.Variable) []frontend.Variable {
+	res := make([]frontend.Variable, 0, 8*len(digest))
+	for i := range digest {
+		res = append(res, digest[i][:]...)
+	}
+	return res
+}
+
+// Function Purpose:
+	// computeKeccak with the digest grouped by byte, for byte-oriented downstream gadgets.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `P`: the 64-byte message, 512 bits LSB first within each byte
+// Outputs:
+	// - the digest, d[i] = hash[i]
+// Gate Count:
+	// the same as computeKeccak
+func ComputeKeccakBytes(api frontend.API, P []frontend.Variable) Keccak256Digest {
+	var d Keccak256Digest
+	copy(d[:], DigestBitsToBytes(computeKeccak(api, P)))
+	return d
+}
+
+// Function Purpose:
+	// Soft digest comparison: instead of emitting AssertIsEqual per bit, returns a single wire that is
+	// 1 iff out == expected bit-for-bit and 0 otherwise, so callers can combine many comparisons
+	// (e.g. "at least 6 of 8 matched") before asserting anything.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `out`: computed digest bits
+	// - `expected`: expected digest bits (same length as out)
+// Outputs:
+	// - the "matched" bit: ∏ XNOR(out[i], expected[i]) = ∏ (1 ⊕ out[i] ⊕ expected[i])
+// Gate Count:
+	// n XOR gates (plus the constant 1, free over GF(2)) for the XNORs, n-1 AND gates for the product.
+	// Over other fields the XORs take the generic form of xor (1 extra AND each), so the comparison stays correct.
+	// The product is reduced as a balanced tree so the multiplication depth is ⌈log2 n⌉ instead of n.
+func CompareDigest(api frontend.API, out []frontend.Variable, expected []frontend.Variable) frontend.Variable {
+	if len(out) != len(expected) || len(out) == 0 {
+		panic("CompareDigest: length mismatch")
+	}
+	eq := not(api, xor(api, out, expected))
+	return andTree(api, eq)
+}
+
+// andTree returns the AND of all bits in a, as a balanced binary tree of api.Mul.
+func andTree(api frontend.API, a []frontend.Variable) frontend.Variable {
+	for len(a) > 1 {
+		next := make([]frontend.Variable, 0, (len(a)+1)/2)
+		for i := 0; i+1 < len(a); i += 2 {
+			next = append(next, api.Mul(a[i], a[i+1]))
+		}
+		if len(a)%2 == 1 {
+			next = append(next, a[len(a)-1])
+		}
+		a = next
+	}
+	return a[0]
+}
+
+// Function Purpose:
+	// Asserts that a digest equals a constant given as a hex string, e.g. the familiar
+	// "0xc5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470" form printed by hash.Hex().
+	// The hex is parsed at circuit-build time and laid out in the digest bit order, so callers never
+	// have to hand-convert a constant into LSB-first bits.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `digest`: digest bits, LSB first within each byte
+	// - `hexStr`: the expected bytes in hex, with or without a 0x prefix; must be exactly len(digest)/8 bytes
+// Outputs:
+	// - a build-time error for malformed or wrongly sized hex, nil otherwise
+// Gate Count:
+	// none beyond the len(digest) assertions against constants
+func AssertDigestEqualsHex(api frontend.API, digest []frontend.Variable, hexStr string) error {
+	b, err := decodeHex(hexStr)
+	if err != nil {
+		return fmt.Errorf("AssertDigestEqualsHex: %w", err)
+	}
+	if 8*len(b) != len(digest) {
+		return fmt.Errorf("AssertDigestEqualsHex: %d-byte constant for a %d-bit digest", len(b), len(digest))
+	}
+	want := constBits(b)
+	for i := range digest {
+		api.AssertIsEqual(digest[i], want[i])
+	}
+	return nil
+}
+
+// Canonical digest → field element mapping (for feeding a digest into a prime-field outer circuit):
+// the digest bytes are read as one big-endian integer, as Ethereum does for bytes32 → uint256,
+// and split into 256/limbBits limbs of limbBits bits, most significant limb first.
+// With limbBits = 128 that is limbs = [hash[0:16] as uint128, hash[16:32] as uint128].
+// Each limb is < 2^limbBits, and the mapping is only used with fields of more than limbBits bits,
+// so no limb ever wraps around the modulus and the mapping is injective.
+
+// Function Purpose:
+	// Packs digest bits into field limbs according to the canonical mapping above.
+	// This only makes sense over a prime field; over GF(2) a "limb" would collapse to a single bit.
+// Inputs:
+	// - `api`: the constraint system builder (field must have more than limbBits bits)
+	// - `digest`: 8*n digest bits, LSB first within each byte (the copyOutUnaligned layout), bits already boolean
+	// - `limbBits`: limb width, a multiple of 8 that divides 8*n
+// Outputs:
+	// - the limbs, most significant first
+// Gate Count:
+	// one linear combination of limbBits terms per limb (api.FromBinary)
+func DigestToFieldLimbs(api frontend.API, digest []frontend.Variable, limbBits int) []frontend.Variable {
+	checkLimbs(len(digest), limbBits)
+	if api.Compiler().FieldBitLen() <= limbBits {
+		panic("DigestToFieldLimbs: field too small for the limb width")
+	}
+	nBytes := len(digest) / 8
+	limbBytes := limbBits / 8
+	limbs := make([]frontend.Variable, nBytes/limbBytes)
+	for l := range limbs {
+		// FromBinary is LSB first: the limb's bytes are big-endian, so reverse them (bits within a byte are already LSB first)
+		limbs[l] = api.FromBinary(ReverseBytes(digest[8*l*limbBytes : 8*(l+1)*limbBytes])...)
+	}
+	return limbs
+}
+
+// DigestToLimbs is the off-circuit side of DigestToFieldLimbs: the same limbs computed from the digest bytes.
+func DigestToLimbs(digest []byte, limbBits int) []*big.Int {
+	checkLimbs(8*len(digest), limbBits)
+	limbBytes := limbBits / 8
+	limbs := make([]*big.Int, len(digest)/limbBytes)
+	for l := range limbs {
+		limbs[l] = new(big.Int).SetBytes(digest[l*limbBytes : (l+1)*limbBytes])
+	}
+	return limbs
+}
+
+func checkLimbs(nBits int, limbBits int) {
+	if limbBits <= 0 || limbBits%8 != 0 || nBits%limbBits != 0 {
+		panic("limb width must be a positive multiple of 8 dividing the digest length")
+	}
+}
@@ -0,0 +1,66 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// keccakDispatchCircuit proves, for a private calldata blob of fixed length:
+//   - the calldata starts with one of the public 4-byte function selectors, and
+//   - Keccak-256(calldata) is the public Digest.
+// Calldata longer than 135 bytes spans several sponge blocks. Selectors are 32-bit, so membership costs
+// K 32-bit comparisons rather than K digest comparisons.
+type keccakDispatchCircuit struct {
+	Calldata  []frontend.Variable
+	Selectors [][32]frontend.Variable `gnark:",public"`
+	Digest    [256]frontend.Variable  `gnark:",public"`
+}
+
+// newKeccakDispatchCircuit returns a circuit (or an empty assignment) for calldataBytes of calldata and k allowed selectors.
+func newKeccakDispatchCircuit(calldataBytes int, k int) *keccakDispatchCircuit {
+	if calldataBytes < 4 || k < 1 {
+		panic("dispatch circuit needs at least a selector of calldata and one allowed selector")
+	}
+	return &keccakDispatchCircuit{Calldata: make([]frontend.Variable, 8*calldataBytes), Selectors: make([][32]frontend.Variable, k)}
+}
+
+func (t *keccakDispatchCircuit) Define(api frontend.API) error {
+	selector := DigestPrefixBits(t.Calldata, 4)
+	allowed := make([][]frontend.Variable, len(t.Selectors))
+	for i := range t.Selectors {
+		allowed[i] = t.Selectors[i][:]
+	}
+	api.AssertIsEqual(IsMember(api, selector, allowed), 1)
+
+	digest := keccakSponge(api, t.Calldata, Keccak256Config.RateBits, DomainKeccak, 256)
+	for j := 0; j < 256; j++ {
+		api.AssertIsEqual(digest[j], t.Digest[j])
+	}
+	return nil
+}
+
+// Function Purpose:
+	// Returns 1 iff x equals at least one entry of list (all the same width).
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `x`: the value, as bits
+	// - `list`: the candidate values, as bits
+// Outputs:
+	// - the membership bit: ¬∏(¬eq_i), with eq_i = CompareDigest(x, list[i])
+// Gate Count:
+	// len(list) CompareDigest calls plus len(list)-1 AND gates
+func IsMember(api frontend.API, x []frontend.Variable, list [][]frontend.Variable) frontend.Variable {
+	if len(list) == 0 {
+		panic("IsMember: empty list")
+	}
+	missed := make([]frontend.Variable, len(list))
+	for i, v := range list {
+		missed[i] = api.Sub(1, CompareDigest(api, x, v))
+	}
+	return api.Sub(1, andTree(api, missed))
+}
+
+// functionSelector is the Solidity selector of a canonical signature such as "transfer(address,uint256)".
+func functionSelector(signature string) []byte {
+	return crypto.Keccak256([]byte(signature))[:4]
+}
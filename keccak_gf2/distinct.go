@@ -0,0 +1,34 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// Digest distinctness:
+// A keccak256Circuit with Distinct set also proves that its committed digests are pairwise distinct, e.g.
+// for deduplication. Out[i] is already asserted to be the digest of instance i, so the public Out vectors
+// are compared directly: every pair gets a CompareDigest equality bit, asserted to be 0. Two equal
+// messages (under the same context) then have no valid witness. With a truncated circuit only the
+// checked prefixes are compared, so distinctness holds for the first checkBits bits.
+// The pair count grows as n(n-1)/2; above distinctWarnInstances instances Define logs a warning.
+
+// distinctWarnInstances is the largest instance count for which the pairwise check is built silently.
+const distinctWarnInstances = 16
+
+// Function Purpose:
+	// Asserts that the digests are pairwise distinct.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `digests`: digest bit vectors, all of the same length
+// Gate Count:
+	// n(n-1)/2 CompareDigest calls of m XOR + m-1 AND gates each, m being the digest length
+func assertDistinctDigests(api frontend.API, digests [][]frontend.Variable) {
+	if n := len(digests); n > distinctWarnInstances {
+		logger.Infof("warning: asserting distinctness of %d digests builds %d digest comparisons", n, n*(n-1)/2)
+	}
+	for i := 0; i < len(digests); i++ {
+		for j := i + 1; j < len(digests); j++ {
+			api.AssertIsEqual(CompareDigest(api, digests[i], digests[j]), 0)
+		}
+	}
+}
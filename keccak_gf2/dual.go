@@ -0,0 +1,26 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// keccakDualCircuit proves that one private 64-byte message has both the legacy Keccak-256 digest
+// Keccak and the FIPS 202 SHA3-256 digest SHA3 (migrating a commitment from one to the other).
+// Both hashes absorb the same message bits; their padded blocks differ only in the domain byte right
+// after the message (0x01 vs 0x06), so the two states cannot share a permutation call, but they share the
+// permutation sub-circuit itself through sharedKeccakF.
+type keccakDualCircuit struct {
+	P      [64 * 8]frontend.Variable
+	Keccak [256]frontend.Variable `gnark:",public"`
+	SHA3   [256]frontend.Variable `gnark:",public"`
+}
+
+func (t *keccakDualCircuit) Define(api frontend.API) error {
+	legacy := spongeWith(api, sharedKeccakF, t.P[:], Keccak256Config.RateBits, DomainKeccak, 256)
+	sha3 := spongeWith(api, sharedKeccakF, t.P[:], Sha3_256Config.RateBits, DomainSHA3, 256)
+	for j := 0; j < 256; j++ {
+		api.AssertIsEqual(legacy[j], t.Keccak[j])
+		api.AssertIsEqual(sha3[j], t.SHA3[j])
+	}
+	return nil
+}
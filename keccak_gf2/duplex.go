@@ -0,0 +1,81 @@
+package keccakgf2
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// Duplex construction:
+// A Duplex is the Keccak duplex of Bertoni et al.: every DuplexCall pads its input on its own with
+// pad10*1 (the domain byte merged in, as Pad101 does), XORs the single padded block into the state,
+// runs keccakF once and returns up to a rate's worth of output. Interleaved absorb/squeeze sequences
+// (absorb A, squeeze c1, absorb B, squeeze c2, ...) then cost one permutation per call, and every output
+// depends on all inputs before it. Because each call is padded to exactly one block, an input holds at
+// most rate/8 - 1 bytes (the last byte of the block is reserved for the padding) and an output at most
+// rate bits; both limits are checked at build time. The first call of a fresh Duplex is the plain sponge
+// over its input: DuplexCall(in, n) of NewDuplex(1088, DomainKeccak) is Keccak-256 for n = 256.
+// duplexRef is the same construction off-circuit on keccakF1600Ref.
+
+// Duplex is a Keccak duplex object; build it with NewDuplex.
+type Duplex struct {
+	state     [][]frontend.Variable
+	rate      int
+	domainSep byte
+}
+
+// NewDuplex returns a duplex with the all-zero state, the given rate in bits (a positive multiple of 64
+// below 1600) and domain separation byte.
+func NewDuplex(rate int, domainSep byte) *Duplex {
+	checkRate(rate)
+	return &Duplex{state: NewState(), rate: rate, domainSep: domainSep}
+}
+
+// Function Purpose:
+	// One duplexing call: pad the input to one rate-sized block, absorb it with keccakF and read outBits
+	// bits of the new state.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `input`: input bits, LSB first within each byte, len(input) % 8 == 0, at most rate - 8 bits
+	// - `outBits`: number of output bits, 0..rate (0 for an absorb-only call)
+// Outputs:
+	// - outBits bits, LSB first within each byte
+// Gate Count:
+	// one keccakF, plus the XOR of the input into the state (none for constant lanes, e.g. on the first call)
+func (d *Duplex) DuplexCall(api frontend.API, input []frontend.Variable, outBits int) []frontend.Variable {
+	if len(input)%8 != 0 {
+		panic("Duplex: input must be byte aligned")
+	}
+	if len(input) > d.rate-8 {
+		panic(fmt.Sprintf("Duplex: %d input bits, at most %d fit a %d-bit rate with its padding", len(input), d.rate-8, d.rate))
+	}
+	if outBits < 0 || outBits > d.rate {
+		panic(fmt.Sprintf("Duplex: %d output bits, expected 0..%d", outBits, d.rate))
+	}
+	d.state = Absorb(api, d.state, Pad101(input, d.rate, d.domainSep))
+	return copyOutUnaligned(api, d.state, d.rate, (outBits+7)/8)[:outBits]
+}
+
+// duplexRef is Duplex off-circuit, on keccakF1600Ref, with byte inputs and outputs.
+type duplexRef struct {
+	state     [25]uint64
+	rate      int // bytes
+	domainSep byte
+}
+
+func (d *duplexRef) call(input []byte, outBytes int) []byte {
+	block := make([]byte, d.rate)
+	copy(block, input)
+	block[len(input)] ^= d.domainSep
+	block[d.rate-1] ^= 0x80
+	for i := 0; i < d.rate/8; i++ {
+		d.state[i] ^= binary.LittleEndian.Uint64(block[8*i:])
+	}
+	d.state = keccakF1600Ref(d.state)
+	var out []byte
+	for i := 0; i < d.rate/8; i++ {
+		out = binary.LittleEndian.AppendUint64(out, d.state[i])
+	}
+	return out[:outBytes]
+}
@@ -0,0 +1,66 @@
+package keccakgf2
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/field/gf2"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/test"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/verifier"
+	"github.com/consensys/gnark/frontend"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// exampleMessage is the literal input of the toy example.
+const exampleMessage = "hello, expander! keccak over gf2"
+
+// keccakToyCircuit is the smallest useful circuit: one Keccak-256 of a 32-byte message.
+// It compiles and solves in well under a second, which makes it the documentation example.
+type keccakToyCircuit struct {
+	P   [32 * 8]frontend.Variable
+	Out [256]frontend.Variable `gnark:",public"`
+}
+
+func (t *keccakToyCircuit) Define(api frontend.API) error {
+	out := keccakSponge(api, t.P[:], Keccak256Config.RateBits, DomainKeccak, 256)
+	for j := 0; j < 256; j++ {
+		api.AssertIsEqual(out[j], t.Out[j])
+	}
+	return nil
+}
+
+// ToyExample runs Keccak256 on the literal example message. Run it with -example; the hex goes to stdout.
+func ToyExample() (string, error) {
+	return Keccak256([]byte(exampleMessage))
+}
+
+// Keccak256 is the whole life cycle on the toy circuit: build, compile, assign a 32-byte message and the
+// digest go-ethereum computes for it, solve, check, and return the digest read back from the public
+// outputs as hex. The circuit rejects the witness unless it hashes msg to that digest too.
+func Keccak256(msg []byte) (string, error) {
+	if len(msg) != 32 {
+		return "", fmt.Errorf("the toy circuit hashes 32-byte messages, got %d bytes", len(msg))
+	}
+	cr, err := compileCircuit(gf2.ScalarField, &keccakToyCircuit{})
+	if err != nil {
+		return "", err
+	}
+
+	assignment := &keccakToyCircuit{}
+	copy(assignment.P[:], bitsOf(msg))
+	copy(assignment.Out[:], bitsOf(crypto.Keccak256(msg)))
+
+	wit, err := cr.GetInputSolver().SolveInput(assignment, 0)
+	if err != nil {
+		return "", err
+	}
+	if !test.CheckCircuit(cr.GetLayeredCircuit(), wit) {
+		return "", errors.New("example witness does not satisfy the circuit")
+	}
+
+	digests, err := verifier.PublicDigests(wit, verifier.Layout{Instances: 1, CheckBits: 256})
+	if err != nil {
+		return "", err
+	}
+	return DigestBitsHex(digests[0][0])
+}
@@ -0,0 +1,204 @@
+package keccakgf2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/field/gf2"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/verifier"
+	"github.com/consensys/gnark/frontend"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// External cross-check:
+// For audits the circuit digests are compared against an independent Keccak-256 running as a separate
+// process. The protocol is one hex line per message on the command's stdin, answered by one hex line
+// with its 32-byte digest on stdout, in order; messages are streamed one at a time, so the command may
+// be as simple as a line-by-line loop. CrossCheck compares, per message, the external digest, the
+// internal reference (go-ethereum) and the digest extracted from the solved witness (its first CheckBits
+// bits, which is all a truncated circuit exposes), and reports every message where any two disagree.
+// ServeHashLines is the protocol's reference implementation; the go tests and -external use it as the
+// helper process.
+
+// ExternalHasher is a running external Keccak-256 command.
+type ExternalHasher struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Scanner
+}
+
+// StartExternalHasher starts argv[0] with the arguments argv[1:].
+func StartExternalHasher(argv []string) (*ExternalHasher, error) {
+	if len(argv) == 0 {
+		return nil, errors.New("external hasher: empty command")
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("external hasher: %w", err)
+	}
+	return &ExternalHasher{cmd: cmd, in: in, out: bufio.NewScanner(out)}, nil
+}
+
+// Digest sends msg and reads back its digest.
+func (h *ExternalHasher) Digest(msg []byte) ([]byte, error) {
+	if _, err := fmt.Fprintf(h.in, "%x\n", msg); err != nil {
+		return nil, fmt.Errorf("external hasher: %w", err)
+	}
+	if !h.out.Scan() {
+		if err := h.out.Err(); err != nil {
+			return nil, fmt.Errorf("external hasher: %w", err)
+		}
+		return nil, errors.New("external hasher: closed its output")
+	}
+	digest, err := hex.DecodeString(strings.TrimSpace(h.out.Text()))
+	if err != nil || len(digest) != 32 {
+		return nil, fmt.Errorf("external hasher: answered %q, not a 32-byte hex digest", h.out.Text())
+	}
+	return digest, nil
+}
+
+// Close closes the command's stdin and waits for it to exit.
+func (h *ExternalHasher) Close() error {
+	h.in.Close()
+	return h.cmd.Wait()
+}
+
+// ServeHashLines answers every hex line of r with the hex Keccak-256 of its bytes on w.
+func ServeHashLines(r io.Reader, w io.Writer) error {
+	lines := bufio.NewScanner(r)
+	lines.Buffer(nil, 1<<20)
+	for lines.Scan() {
+		msg, err := hex.DecodeString(strings.TrimSpace(lines.Text()))
+		if err != nil {
+			return fmt.Errorf("line %q: %w", lines.Text(), err)
+		}
+		if _, err := fmt.Fprintf(w, "%x\n", crypto.Keccak256(msg)); err != nil {
+			return err
+		}
+	}
+	return lines.Err()
+}
+
+// Disagreement is a message whose three digests are not all equal, as hex; Circuit holds the checked
+// bits only, packed into bytes (the tail of a partial byte zero).
+type Disagreement struct {
+	Message   int
+	Reference string
+	External  string
+	Circuit   string
+}
+
+func (d Disagreement) String() string {
+	return fmt.Sprintf("message %d: reference %s, external %s, circuit %s", d.Message, d.Reference, d.External, d.Circuit)
+}
+
+// CrossCheck sends every message to h and compares the answer with the reference digest and with the
+// circuit digest bits circuit[k] (a prefix of the digest, as verifier.PublicDigests returns it).
+func CrossCheck(h *ExternalHasher, msgs [][]byte, circuit [][]int) ([]Disagreement, error) {
+	if len(circuit) != len(msgs) {
+		return nil, fmt.Errorf("cross-check: %d circuit digests for %d messages", len(circuit), len(msgs))
+	}
+	var res []Disagreement
+	for k, msg := range msgs {
+		external, err := h.Digest(msg)
+		if err != nil {
+			return nil, err
+		}
+		reference := crypto.Keccak256(msg)
+		bits := circuit[k]
+		got := make([]byte, (len(bits)+7)/8)
+		for i, b := range bits {
+			got[i/8] |= byte(b) << (i % 8)
+		}
+		if !bytes.Equal(reference, external) || !equalPrefix(reference, got, len(bits)) {
+			res = append(res, Disagreement{Message: k, Reference: hex.EncodeToString(reference), External: hex.EncodeToString(external), Circuit: hex.EncodeToString(got)})
+		}
+	}
+	return res, nil
+}
+
+// equalPrefix reports whether the first n bits of a and b (LSB first within each byte) are equal.
+func equalPrefix(a, b []byte, n int) bool {
+	for i := 0; i < n; i++ {
+		if (a[i/8]>>(i%8))&1 != (b[i/8]>>(i%8))&1 {
+			return false
+		}
+	}
+	return true
+}
+
+// ExternalCheck solves 16 random assignments of the batch circuit of o, extracts their digests from the
+// witness and cross-checks every message (prefixed with the context, as hashed) against the external
+// command argv; any disagreement is an error listing them.
+func ExternalCheck(o Options, argv []string) error {
+	template := newKeccak256Circuit(o.Instances, len(o.Context))
+	WithChiSchedule(o.ChiSchedule)(template)
+	cr, err := compileCircuit(gf2.ScalarField, template)
+	if err != nil {
+		return err
+	}
+	rnd := o.Reader()
+	assignments := make([]frontend.Circuit, 16)
+	indices := make([]int, len(assignments))
+	var msgs [][]byte
+	for z := range assignments {
+		a, err := randomAssignment(rnd, o.Instances, o.Context)
+		if err != nil {
+			return err
+		}
+		for k := range a.P {
+			msg, err := assignedBytes(a.P[k][:])
+			if err != nil {
+				return err
+			}
+			msgs = append(msgs, append(append([]byte{}, o.Context...), msg...))
+		}
+		assignments[z], indices[z] = a, z
+	}
+	env, err := solveBatch(newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, template), assignments, indices)
+	if err != nil {
+		return fmt.Errorf("solving: %w", err)
+	}
+	digests, err := verifier.PublicDigests(env.Witness, template.layout())
+	if err != nil {
+		return err
+	}
+	var circuit [][]int
+	for _, d := range digests {
+		circuit = append(circuit, d...)
+	}
+	h, err := StartExternalHasher(argv)
+	if err != nil {
+		return err
+	}
+	disagreements, err := CrossCheck(h, msgs, circuit)
+	if cerr := h.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("external hasher: %w", cerr)
+	}
+	if err != nil {
+		return err
+	}
+	if len(disagreements) > 0 {
+		lines := make([]string, len(disagreements))
+		for i, d := range disagreements {
+			lines[i] = d.String()
+		}
+		return fmt.Errorf("external cross-check: %d of %d digests disagree:\n  %s", len(disagreements), len(msgs), strings.Join(lines, "\n  "))
+	}
+	logger.Infof("external cross-check: %d digests agree with %s", len(msgs), strings.Join(argv, " "))
+	return nil
+}
@@ -0,0 +1,51 @@
+package keccakgf2
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo"
+	"github.com/consensys/gnark/frontend"
+)
+
+// GF(2) guard rails:
+// orBit, popCount, subBorrow, ContainsBytes and Sha256 write XOR as a single api.Add, which is only XOR
+// when 1 + 1 = 0. Over any other field they would still build a circuit, just one that computes the wrong
+// digest. They therefore check the field they are compiled over and panic with a gf2OnlyError otherwise,
+// and compileCircuit refuses non-GF(2) fields up front with the same error, since the circuits of this
+// package are sized and pinned for GF(2).
+// xor itself picks the GF(2) form (1 Add) or the generic a + b - 2ab form (1 Mul + 2 linear ops) by field,
+// so keccakF, the sponge and the helpers built on them (CompareDigest, AbsorbU64Limbs) work over prime
+// fields as well.
+
+var gf2Modulus = big.NewInt(2)
+
+// gf2OnlyError reports a GF(2)-only helper used over another field.
+type gf2OnlyError struct {
+	helper string
+	field  *big.Int
+}
+
+func (e *gf2OnlyError) Error() string {
+	return fmt.Sprintf("%s is only correct over GF(2), where XOR is a single api.Add, but the field modulus is %s; compile over gf2.ScalarField", e.helper, e.field)
+}
+
+func isGF2(api frontend.API) bool {
+	return api.Compiler().Field().Cmp(gf2Modulus) == 0
+}
+
+// requireGF2 panics with a gf2OnlyError unless api builds over GF(2).
+func requireGF2(api frontend.API, helper string) {
+	if !isGF2(api) {
+		panic(&gf2OnlyError{helper: helper, field: api.Compiler().Field()})
+	}
+}
+
+// compileCircuit is ecgo.Compile for the circuits of this package, which are built for GF(2) and partly
+// from the GF(2)-only helpers: any field other than GF(2) is rejected before Define runs.
+func compileCircuit(field *big.Int, circuit frontend.Circuit) (*ecgo.CompileResult, error) {
+	if field.Cmp(gf2Modulus) != 0 {
+		return nil, fmt.Errorf("compileCircuit: %w", &gf2OnlyError{helper: fmt.Sprintf("%T", circuit), field: field})
+	}
+	return ecgo.Compile(field, circuit)
+}
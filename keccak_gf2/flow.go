@@ -0,0 +1,67 @@
+package keccakgf2
+
+import (
+	"fmt"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/irwg"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/layered"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/test"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/verifier"
+	"github.com/consensys/gnark/frontend"
+)
+
+// Solving and checking flow:
+// Every statement goes through the same two phases: the input solver turns an assignment into a witness,
+// and the layered checker runs the circuit on it. The helpers below run both and compare the verdict with
+// the expected one, returning an error that names the phase, carries the underlying ecgo error, and for a
+// rejected keccak256Circuit assignment lists the instances whose digests do not match their messages.
+// The tests report it through t.Fatal.
+
+type witnessSolver interface {
+	SolveInput(assignment frontend.Circuit, nbThreads int) (*irwg.Witness, error)
+}
+
+// solveChecked solves assignment and checks it against c; accept is the expected verdict. The witness is
+// returned for further use.
+func solveChecked(is witnessSolver, c *layered.RootCircuit, assignment frontend.Circuit, accept bool) (*irwg.Witness, error) {
+	if cs, ok := is.(*checkedSolver); ok && !accept {
+		// the rejection must come from the circuit, not from the preflight of SolveInput
+		is = cs.withoutPreflight()
+	}
+	wit, err := is.SolveInput(assignment, 0)
+	if err != nil {
+		return nil, fmt.Errorf("solving: %w", err)
+	}
+	if test.CheckCircuit(c, wit) == accept {
+		return wit, nil
+	}
+	if !accept {
+		return nil, fmt.Errorf("checking: the circuit accepts an assignment it should reject")
+	}
+	if a, ok := assignment.(*keccak256Circuit); ok {
+		if err := Preflight(a); err != nil {
+			return nil, fmt.Errorf("checking: the circuit rejects the assignment: %w", err)
+		}
+	}
+	return nil, fmt.Errorf("checking: the circuit rejects the assignment")
+}
+
+// expectVerdict is solveChecked without the witness.
+func expectVerdict(is witnessSolver, c *layered.RootCircuit, assignment frontend.Circuit, accept bool) error {
+	_, err := solveChecked(is, c, assignment, accept)
+	return err
+}
+
+// expectBatch checks every assignment of a batch envelope against c and names the rejected ones by caller index.
+func expectBatch(c *layered.RootCircuit, e *verifier.Envelope) error {
+	var rejected []int
+	for z, ok := range verifier.Check(c, e) {
+		if !ok {
+			rejected = append(rejected, e.Indices[z])
+		}
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("checking: the circuit rejects assignments %v of the batch", rejected)
+	}
+	return nil
+}
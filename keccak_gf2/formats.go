@@ -0,0 +1,43 @@
+package keccakgf2
+
+import (
+	"fmt"
+)
+
+// Artifact formats:
+// The files other tooling builds against are versioned, each by one constant: the witness envelope
+// (verifier.EnvelopeVersion, the number after its magic), layout.json (verifier.DescriptorVersion),
+// verdict.json (verifier.VerdictVersion), stats.json (StatsVersion) and the layered circuit of a
+// configuration (CircuitVersion: the bytes are ecgo's, the version covers the gates this package emits
+// for it). testdata/golden holds, per format and version, the serialization of a fixed artifact, and
+// TestGoldenFormats fails when a serialization changes while its version stays the same. A version bump
+// comes with a new golden (go test -run TestGoldenFormats -update-golden) and, for a format that is read
+// back, with an upgrader from the previous version registered in upgraders, so that artifacts written
+// before the bump stay loadable; the goldens of older versions stay in testdata/golden as the proof
+// (TestFormatMigration).
+
+// CircuitVersion is bumped whenever the gates of a configuration change, and with them its fingerprint.
+const CircuitVersion = 3
+
+// upgrader turns a serialized artifact of one version into the next version.
+type upgrader func([]byte) ([]byte, error)
+
+// upgraders[format][v] upgrades an artifact of the format from version v to v+1.
+var upgraders = map[string]map[int]upgrader{
+	"stats": {1: upgradeStatsV1, 2: upgradeStatsV2},
+}
+
+// upgradeArtifact brings b, an artifact of the format at version from, to version to, one step at a time.
+func upgradeArtifact(format string, b []byte, from, to int) ([]byte, error) {
+	for v := from; v < to; v++ {
+		u := upgraders[format][v]
+		if u == nil {
+			return nil, fmt.Errorf("%s version %d: no upgrader to version %d", format, v, v+1)
+		}
+		var err error
+		if b, err = u(b); err != nil {
+			return nil, fmt.Errorf("%s version %d: %w", format, v, err)
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,145 @@
+package keccakgf2
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// Constant-gate analysis:
+// gateStats runs a circuit's Define against a recording API instead of a real builder, with every input
+// replaced by an opaque symbolic wire, and counts the arithmetic calls per kind. A call whose operands are
+// all Go constants (ints, *big.Int) computes a constant: a builder may fold it, but it is wasted work at
+// best and a real gate at worst, so the gadgets fold these themselves and ConstGates should stay 0.
+
+// GateStats are the counts gathered by gateStats.
+type GateStats struct {
+	Add, Sub, Mul, Assert int
+	// Boolean counts AssertIsBoolean calls.
+	Boolean int
+	// ConstGates counts Add/Sub/Mul calls whose operands were all constants.
+	ConstGates int
+	// PublicInputs counts the circuit's public input wires (the leaves of its gnark:",public" fields);
+	// gadget-level counts leave it 0.
+	PublicInputs int
+}
+
+// symbolicWire stands for any non-constant value during the analysis.
+type symbolicWire struct{}
+
+type recordingAPI struct {
+	frontend.API // nil: calling anything not overridden below panics, which flags new API use here
+	field        *big.Int
+	stats        *GateStats
+}
+
+type recordingCompiler struct {
+	frontend.Compiler
+	field *big.Int
+}
+
+func (c recordingCompiler) Field() *big.Int  { return new(big.Int).Set(c.field) }
+func (c recordingCompiler) FieldBitLen() int { return c.field.BitLen() }
+
+func (r *recordingAPI) Compiler() frontend.Compiler { return recordingCompiler{field: r.field} }
+
+func (r *recordingAPI) record(count *int, in ...frontend.Variable) frontend.Variable {
+	*count++
+	for _, v := range in {
+		if _, ok := v.(*symbolicWire); ok {
+			return &symbolicWire{}
+		}
+	}
+	r.stats.ConstGates++
+	return &symbolicWire{}
+}
+
+func (r *recordingAPI) Add(a, b frontend.Variable, in ...frontend.Variable) frontend.Variable {
+	return r.record(&r.stats.Add, append([]frontend.Variable{a, b}, in...)...)
+}
+
+func (r *recordingAPI) Sub(a, b frontend.Variable, in ...frontend.Variable) frontend.Variable {
+	return r.record(&r.stats.Sub, append([]frontend.Variable{a, b}, in...)...)
+}
+
+func (r *recordingAPI) Mul(a, b frontend.Variable, in ...frontend.Variable) frontend.Variable {
+	return r.record(&r.stats.Mul, append([]frontend.Variable{a, b}, in...)...)
+}
+
+func (r *recordingAPI) AssertIsEqual(a, b frontend.Variable) {
+	r.stats.Assert++
+}
+
+func (r *recordingAPI) AssertIsBoolean(a frontend.Variable) {
+	r.stats.Boolean++
+}
+
+// ToBinary splits a constant into constant bits, the way the gadgets fold constant operands, so that
+// constant limbs evaluate through AbsorbU64Limbs. A symbolic value becomes n symbolic bits, counted as
+// the n boolean constraints the decomposition carries.
+func (r *recordingAPI) ToBinary(v frontend.Variable, n ...int) []frontend.Variable {
+	if len(n) != 1 {
+		panic("recordingAPI: ToBinary needs an explicit bit length")
+	}
+	bits := make([]frontend.Variable, n[0])
+	var x *big.Int
+	switch c := v.(type) {
+	case int:
+		x = big.NewInt(int64(c))
+	case uint64:
+		x = new(big.Int).SetUint64(c)
+	case *big.Int:
+		x = c
+	}
+	for i := range bits {
+		if x != nil {
+			bits[i] = int(x.Bit(i))
+			continue
+		}
+		bits[i] = &symbolicWire{}
+		r.stats.Boolean++
+	}
+	return bits
+}
+
+// gateStats analyses circuit over field. The circuit must be allocated the way it is compiled
+// (slices sized, build-time configuration set), e.g. NewKeccak256Circuit(n).
+// It also counts the public inputs, which external verifiers pay for one by one.
+func gateStats(field *big.Int, circuit frontend.Circuit) (*GateStats, error) {
+	stats := &GateStats{}
+	var fill func(v reflect.Value, public bool)
+	fill = func(v reflect.Value, public bool) {
+		switch v.Kind() {
+		case reflect.Ptr:
+			fill(v.Elem(), public)
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				if f := v.Type().Field(i); f.PkgPath == "" {
+					fill(v.Field(i), public || strings.Contains(f.Tag.Get("gnark"), ",public"))
+				}
+			}
+		case reflect.Array, reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				fill(v.Index(i), public)
+			}
+		case reflect.Interface:
+			v.Set(reflect.ValueOf(&symbolicWire{}))
+			if public {
+				stats.PublicInputs++
+			}
+		}
+	}
+	fill(reflect.ValueOf(circuit), false)
+	err := circuit.Define(&recordingAPI{field: field, stats: stats})
+	return stats, err
+}
+
+// constBitValue reports whether v is a constant bit as the gadgets write them (Go int 0 or 1), and its value.
+func constBitValue(v frontend.Variable) (int, bool) {
+	if x, ok := v.(int); ok && (x == 0 || x == 1) {
+		return x, true
+	}
+	return 0, false
+}
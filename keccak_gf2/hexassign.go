@@ -0,0 +1,73 @@
+package keccakgf2
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// Hex in and out:
+// Test vectors come as hex strings, digests are compared as hex strings. AssignmentFromHex turns messages
+// given in hex into a full assignment and DigestHex turns digest bits back into the hex of hash.Hex(), both
+// in the bit order of bitconv.go (LSB first within each byte), so callers never convert bits by hand. The
+// main flow builds its random assignments through AssignmentFromHex and logs their first digest through
+// DigestHex. Hex is accepted with or without a 0x prefix; odd-length or non-hex input is an error naming the
+// offending string and position.
+
+// decodeHex decodes s, with or without a 0x prefix.
+func decodeHex(s string) ([]byte, error) {
+	digits := strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	if len(digits)%2 != 0 {
+		return nil, fmt.Errorf("%d hex digits is not a whole number of bytes", len(digits))
+	}
+	b, err := hex.DecodeString(digits)
+	var invalid hex.InvalidByteError
+	if errors.As(err, &invalid) {
+		return nil, fmt.Errorf("%q is not a hex digit (position %d)", byte(invalid), strings.IndexByte(digits, byte(invalid)))
+	}
+	return b, err
+}
+
+// AssignmentFromHex builds a keccak256Circuit assignment with one instance per message, each 64 bytes
+// of hex, and their Keccak-256 digests as Out. opts are those of the circuit (WithContext for a bound
+// context, WithSHA3 for SHA3-256 digests).
+func AssignmentFromHex(msgHex []string, opts ...CircuitOption) (*keccak256Circuit, error) {
+	msgs := make([][]byte, len(msgHex))
+	for k, s := range msgHex {
+		msg, err := decodeHex(s)
+		if err != nil {
+			return nil, fmt.Errorf("AssignmentFromHex: message %d: %w", k, err)
+		}
+		msgs[k] = msg
+	}
+	circuit, err := assignMessages(msgs, nil, CheckBits, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("AssignmentFromHex: %w", err)
+	}
+	return circuit, nil
+}
+
+// DigestHex returns the hex of assigned digest bits (e.g. Out[k]); a truncated digest is padded with
+// zero bits to a whole byte.
+func DigestHex(out []frontend.Variable) (string, error) {
+	bits, err := VariablesToBits(out)
+	if err != nil {
+		return "", fmt.Errorf("DigestHex: %w", err)
+	}
+	return DigestBitsHex(bits)
+}
+
+// DigestBitsHex is DigestHex for plain bits, as verifier.PublicDigests returns them.
+func DigestBitsHex(bits []int) (string, error) {
+	b := make([]byte, (len(bits)+7)/8)
+	for i, x := range bits {
+		if x != 0 && x != 1 {
+			return "", fmt.Errorf("DigestBitsHex: bit %d is %d, not 0/1", i, x)
+		}
+		b[i/8] |= byte(x) << (i % 8)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,170 @@
+package keccakgf2
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"strings"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/irwg"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/layered"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/test"
+	"github.com/consensys/gnark/frontend"
+)
+
+// Error injection:
+// An ecgo witness holds the input layer of one assignment (private inputs, then public inputs); every
+// other wire is recomputed by the layered checker from it. injectErrors perturbs witness values one at a
+// time and reports the positions the checker still accepts. Any accepted position is an input the
+// circuit does not constrain; inputNames maps it back to the circuit field it came from.
+// The other wires cannot be set through a witness, so injectGateErrors flips them in the layered
+// evaluation instead (see evalLayers) and runs the zero checks of the output layer on what follows.
+
+// injectErrors returns the positions (into assignment 0 of w) whose perturbation c still accepts.
+// Each position is checked on its own copy of the witness, with value v replaced by v+1 mod the field.
+func injectErrors(c *layered.RootCircuit, w *irwg.Witness, positions []int) []int {
+	per := w.NumInputsPerWitness + w.NumPublicInputsPerWitness
+	accepted := []int{}
+	for _, pos := range positions {
+		if pos < 0 || pos >= per {
+			panic("injectErrors: position outside the assignment")
+		}
+		values := make([]*big.Int, per)
+		copy(values, w.Values[:per])
+		values[pos] = new(big.Int).Add(values[pos], big.NewInt(1))
+		values[pos].Mod(values[pos], w.Field)
+		perturbed := &irwg.Witness{
+			NumWitnesses:              1,
+			NumInputsPerWitness:       w.NumInputsPerWitness,
+			NumPublicInputsPerWitness: w.NumPublicInputsPerWitness,
+			Field:                     w.Field,
+			Values:                    values,
+		}
+		if test.CheckCircuit(c, perturbed) {
+			accepted = append(accepted, pos)
+		}
+	}
+	return accepted
+}
+
+// gateWire is a gate output of a layered circuit: wire Wire of the output of layer Layer.
+type gateWire struct {
+	Layer, Wire int
+}
+
+// injectGateErrors returns the gate outputs among wires whose flip c still accepts on assignment 0 of w,
+// and the number of flips it checked. Each flip is carried through the layers after it, and c accepts it if
+// the zero checks of the output layer (its first ExpectedNumOutputZeroes wires) hold under 64 draws of its random coefficients, so that a flip the checker
+// catches is missed with probability 2^-64. A flip that changes no wire of the next layer (a padding wire,
+// or one every reader masks on this assignment) is not checked.
+func injectGateErrors(c *layered.RootCircuit, w *irwg.Witness, wires []gateWire) ([]gateWire, int, error) {
+	if c.Field.Cmp(gf2Modulus) != 0 {
+		return nil, 0, fmt.Errorf("injectGateErrors: circuit over a field of %d bits, expected GF(2)", c.Field.BitLen())
+	}
+	in, pub := witnessMasks(w, 0)
+	e := &layerEval{c: c, pub: pub, rnd: rand.New(rand.NewSource(994))}
+	layers, err := e.layers(0, in)
+	if err != nil {
+		return nil, 0, fmt.Errorf("injectGateErrors: %w", err)
+	}
+	zeroChecks := func(out []uint64) bool {
+		return allZero(out[:min(c.ExpectedNumOutputZeroes, len(out))])
+	}
+	if !zeroChecks(layers[len(layers)-1]) {
+		return nil, 0, errors.New("injectGateErrors: the unperturbed witness is rejected")
+	}
+	accepted, checked := []gateWire{}, 0
+	for _, g := range wires {
+		if g.Layer < 0 || g.Layer >= len(layers) || g.Wire < 0 || g.Wire >= len(layers[g.Layer]) {
+			panic("injectGateErrors: wire outside the circuit")
+		}
+		out := append([]uint64{}, layers[g.Layer]...)
+		out[g.Wire] = ^out[g.Wire]
+		rest, err := e.layers(g.Layer+1, out)
+		if err != nil {
+			return nil, 0, fmt.Errorf("injectGateErrors: %w", err)
+		}
+		if len(rest) > 0 && equalWires(rest[0], layers[g.Layer+1]) {
+			continue
+		}
+		checked++
+		if len(rest) > 0 {
+			out = rest[len(rest)-1]
+		}
+		if zeroChecks(out) {
+			accepted = append(accepted, g)
+		}
+	}
+	return accepted, checked, nil
+}
+
+func allZero(v []uint64) bool {
+	for _, x := range v {
+		if x != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func equalWires(a, b []uint64) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
+
+// inputNames is the annotation table of a circuit's witness layout: name[pos] is the circuit field
+// (e.g. "P[3][17]", "Out[0][255]") at witness position pos, private inputs first, then public ones,
+// each in field declaration order.
+func inputNames(circuit frontend.Circuit) []string {
+	leaves := inputLeaves(circuit)
+	names := make([]string, len(leaves))
+	for i, l := range leaves {
+		names[i] = l.name
+	}
+	return names
+}
+
+// inputLeaf is one input of a circuit: its field path, its visibility and the (settable) variable.
+type inputLeaf struct {
+	name   string
+	public bool
+	value  reflect.Value
+}
+
+// inputLeaves lists the inputs of circuit in witness order (private inputs first, then public ones).
+func inputLeaves(circuit frontend.Circuit) []inputLeaf {
+	var private, public []inputLeaf
+	var walk func(v reflect.Value, name string, isPublic bool)
+	walk = func(v reflect.Value, name string, isPublic bool) {
+		switch v.Kind() {
+		case reflect.Ptr:
+			walk(v.Elem(), name, isPublic)
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				f := v.Type().Field(i)
+				if f.PkgPath != "" {
+					continue
+				}
+				walk(v.Field(i), name+f.Name, isPublic || strings.Contains(f.Tag.Get("gnark"), "public"))
+			}
+		case reflect.Array, reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i), fmt.Sprintf("%s[%d]", name, i), isPublic)
+			}
+		case reflect.Interface:
+			if isPublic {
+				public = append(public, inputLeaf{name, true, v})
+			} else {
+				private = append(private, inputLeaf{name, false, v})
+			}
+		}
+	}
+	walk(reflect.ValueOf(circuit), "", false)
+	return append(private, public...)
+}
@@ -0,0 +1,145 @@
+package keccakgf2
+
+import (
+	"encoding/binary"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// KangarooTwelve:
+// KangarooTwelve (KT128, RFC 9861) hashes with TurboSHAKE128: the SHAKE128 sponge (rate 1344) over
+// Keccak-p[1600, 12], the last 12 rounds of keccakF (keccakP(12, 12), round constants 12..23), so
+// every permutation costs half the gates of Keccak-256's. With S = msg || C || length_encode(|C|):
+//
+//	|S| <= 8192 bytes: KT128 = TurboSHAKE128(S, 0x07)
+//	otherwise:         S = S_0 || S_1 || ... in 8192-byte chunks, CV_i = TurboSHAKE128(S_i, 0x0B) (256 bits),
+//	                   KT128 = TurboSHAKE128(S_0 || 0x03 0x00^7 || CV_1 || ... || length_encode(n-1) || 0xFF 0xFF, 0x06)
+//
+// The domain byte of TurboSHAKE merges into pad10*1 like any other (Pad101), so TurboSHAKE128 is
+// KeccakWithConfig with 12 rounds. The chaining values of the full chunks go through one memoized
+// sub-circuit. k12Ref is the same construction off-circuit on keccakP1600Ref, checked against the RFC
+// test vectors.
+
+const (
+	// K12ChunkBytes is the KangarooTwelve chunk size: longer inputs switch to the tree mode.
+	K12ChunkBytes = 8192
+	// K12Rounds is the number of Keccak-p rounds of TurboSHAKE.
+	K12Rounds = 12
+
+	domainK12Single = 0x07 // the whole input in one node
+	domainK12Leaf   = 0x0B // a chaining value of the tree mode
+	domainK12Final  = 0x06 // the final node of the tree mode
+)
+
+// Function Purpose:
+	// TurboSHAKE128 of a compile-time-length, byte-aligned message: pad domain||10*1 to the 1344-bit rate,
+	// absorb with keccakP(12, 12), squeeze outputBits bits.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: message bits, LSB first within each byte, len(msg) % 8 == 0
+	// - `domain`: domain separation byte, 0x01..0x7F (0x1F for the plain XOF); others panic (KeccakConfig.Validate)
+	// - `outputBits`: number of output bits, positive
+// Outputs:
+	// - outputBits bits, LSB first within each byte
+// Gate Count:
+	// SpongePermutations(1344, len(msg)/8, outputBits) permutations of 12 rounds, each half a keccakF: outputs
+	// longer than 1344 bits permute again between extractions
+func TurboShake128(api frontend.API, msg []frontend.Variable, domain byte, outputBits int) []frontend.Variable {
+	return KeccakWithConfig(api, msg, KeccakConfig{RateBits: Shake128Rate, OutputBits: outputBits, Rounds: K12Rounds, DomainSep: domain})
+}
+
+// Function Purpose:
+	// KangarooTwelve (KT128) of a compile-time-length, byte-aligned message with customization string
+	// custom fixed at circuit-build time; the tree mode for inputs over 8192 bytes.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: message bits, LSB first within each byte, len(msg) % 8 == 0
+	// - `custom`: the customization string C
+	// - `outputBits`: number of output bits, positive
+// Outputs:
+	// - outputBits bits, LSB first within each byte
+// Gate Count:
+	// one 12-round permutation per 168 bytes of S (and of the final node in the tree mode, where every
+	// chunk after the first adds a 49-permutation leaf and 32 bytes to the final node)
+func KangarooTwelve(api frontend.API, msg []frontend.Variable, custom []byte, outputBits int) []frontend.Variable {
+	if len(msg)%8 != 0 {
+		panic("KangarooTwelve: message must be byte aligned")
+	}
+	s := append(append([]frontend.Variable{}, msg...), constBits(append(append([]byte{}, custom...), k12LengthEncode(uint64(len(custom)))...))...)
+	if len(s) <= 8*K12ChunkBytes {
+		return TurboShake128(api, s, domainK12Single, outputBits)
+	}
+	node := append(s[:8*K12ChunkBytes:8*K12ChunkBytes], constBits([]byte{0x03, 0, 0, 0, 0, 0, 0, 0})...)
+	n := 0
+	for off := 8 * K12ChunkBytes; off < len(s); off += 8 * K12ChunkBytes {
+		end := off + 8*K12ChunkBytes
+		if end > len(s) {
+			end = len(s)
+		}
+		node = append(node, memorizedCall(api, k12Leaf, s[off:end])...)
+		n++
+	}
+	node = append(node, constBits(append(k12LengthEncode(uint64(n)), 0xFF, 0xFF))...)
+	return TurboShake128(api, node, domainK12Final, outputBits)
+}
+
+// k12Leaf is the chaining value of one chunk; top-level so the memoization key is (function, input length).
+func k12Leaf(api frontend.API, chunk []frontend.Variable) []frontend.Variable {
+	return TurboShake128(api, chunk, domainK12Leaf, 256)
+}
+
+// k12LengthEncode(x) = x as big-endian bytes without leading zeros (none for 0) || their count.
+func k12LengthEncode(x uint64) []byte {
+	var b []byte
+	for ; x > 0; x >>= 8 {
+		b = append([]byte{byte(x)}, b...)
+	}
+	return append(b, byte(len(b)))
+}
+
+// turboShake128Ref is TurboSHAKE128 off-circuit, on keccakP1600Ref.
+func turboShake128Ref(msg []byte, domain byte, outBytes int) []byte {
+	const rate = Shake128Rate / 8
+	padded := append(append([]byte{}, msg...), domain)
+	for len(padded)%rate != 0 {
+		padded = append(padded, 0)
+	}
+	padded[len(padded)-1] |= 0x80
+	var a [25]uint64
+	for blk := 0; blk < len(padded); blk += rate {
+		for i := 0; i < rate/8; i++ {
+			a[i] ^= binary.LittleEndian.Uint64(padded[blk+8*i:])
+		}
+		a = keccakP1600Ref(a, K12Rounds)
+	}
+	var out []byte
+	for {
+		for i := 0; i < rate/8 && len(out) < outBytes; i++ {
+			out = binary.LittleEndian.AppendUint64(out, a[i])
+		}
+		if len(out) >= outBytes {
+			return out[:outBytes]
+		}
+		a = keccakP1600Ref(a, K12Rounds)
+	}
+}
+
+// k12Ref is KangarooTwelve off-circuit, on turboShake128Ref.
+func k12Ref(msg, custom []byte, outBytes int) []byte {
+	s := append(append(append([]byte{}, msg...), custom...), k12LengthEncode(uint64(len(custom)))...)
+	if len(s) <= K12ChunkBytes {
+		return turboShake128Ref(s, domainK12Single, outBytes)
+	}
+	node := append(append([]byte{}, s[:K12ChunkBytes]...), 0x03, 0, 0, 0, 0, 0, 0, 0)
+	n := 0
+	for off := K12ChunkBytes; off < len(s); off += K12ChunkBytes {
+		end := off + K12ChunkBytes
+		if end > len(s) {
+			end = len(s)
+		}
+		node = append(node, turboShake128Ref(s[off:end], domainK12Leaf, 32)...)
+		n++
+	}
+	node = append(append(node, k12LengthEncode(uint64(n))...), 0xFF, 0xFF)
+	return turboShake128Ref(node, domainK12Final, outBytes)
+}
@@ -0,0 +1,20 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// KeccakZero64 is Keccak-256 of 64 zero bytes, the known answer checked by keccakKATCircuit.
+const KeccakZero64 = "0xad3228b676f7d3cd4284a5443f17f1962b36e491b30a40b2405849e597ba5fb5"
+
+// keccakKATCircuit proves knowledge of a 64-byte message whose Keccak-256 is the build-time constant
+// expected (a hex string, see AssertDigestEqualsHex). The constant is baked into the compiled circuit,
+// so it has no public inputs of its own.
+type keccakKATCircuit struct {
+	P        [64 * 8]frontend.Variable
+	expected string
+}
+
+func (t *keccakKATCircuit) Define(api frontend.API) error {
+	return AssertDigestEqualsHex(api, computeKeccak(api, t.P[:]), t.expected)
+}
@@ -0,0 +1,696 @@
+// Package keccakgf2 builds Keccak-256, and the other members of the Keccak family, as circuits over GF(2)
+// for the Expander compiler collection (ecgo), together with the code to assign, solve and check batches
+// of them. cmd/keccak_gf2 is its command line and the verifier package its verifier-side half.
+package keccakgf2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/spec"
+	"github.com/consensys/gnark/frontend"
+)
+
+// NHashes is the default number of Keccak-256 instances per keccak256Circuit (the -n flag), and the fixed
+// instance count of the array-based circuits (Merkle, threshold).
+const NHashes = 8
+
+// CheckBits is the default number of digest bits each keccak256Circuit instance exposes and asserts
+// (the whole digest); NewTruncatedKeccak256Circuit takes a shorter prefix.
+const CheckBits = 256
+
+// TruncatedBits is the digest length of the truncated output mode: a circuit that checks at most this many
+// bits per instance (NewTruncatedKeccak256Circuit(n, TruncatedBits)) squeezes only them, with 128
+// public inputs and assertions per instance instead of 256. That is 128-bit collision resistance, enough
+// for commitments.
+const TruncatedBits = 128
+
+var rcs [][]uint
+
+func init() {
+	// Each round constant RC[i] is computed using a linear-feedback shift register (LFSR) defined in the spec,
+	// see spec.RoundConstants; rcs[i][j] is bit j of RC[i], taken from roundConstants so that the circuit and
+	// keccakF1600Ref share one table.
+	rcs = make([][]uint, 24)
+	for i := 0; i < 24; i++ {
+		rcs[i] = UintBitsFromBytes(binary.LittleEndian.AppendUint64(nil, roundConstants[i]))
+	}
+}
+// Function Purpose:
+	// This function models the absorb phase in the Keccak sponge construction.
+	// For each message block 𝑀𝑖,
+	// XOR it into the first r/w lanes of the Keccak state 𝑆[𝑥,𝑦],
+	// where 𝑤 = 64 and 𝑟 is the rate: 𝑟 = 1088 → 𝑟/𝑤 = 17 lanes for Keccak-256 (see spongeRate).
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `s`: The Keccak state A[x,y], as a flattened 1D array of 25 lanes (each lane is 64 bits)
+	// - `block`: The current message block, rate bits, LSB first within each byte
+	// - `rate`: the sponge rate in bits
+// Outputs:
+	// - `s`: The updated Keccak state after XORing the message block into the first r/w lanes
+// Gate Count:
+	// pure binary circuits: r/64 lanes × 64 bits = r XOR gates (1,088 for Keccak-256)
+	// word-boolean-circuits: r/64 lanes × 8 words = r/8 XOR word gates (136 for Keccak-256)
+func xorIn(api frontend.API, s [][]frontend.Variable, block []frontend.Variable, rate int) [][]frontend.Variable {
+	r := rateOf(rate)
+	if len(block) != r.Bits {
+		panic(fmt.Sprintf("xorIn: %d-bit block, the rate is %d", len(block), r.Bits))
+	}
+	buf := make([][]frontend.Variable, r.Lanes)
+	for i := range buf {
+		buf[i] = block[i*64 : (i+1)*64 : (i+1)*64]
+	}
+	// Traverses each lane in order: (x, y) → 5*x + y
+	// For the first r/64 lanes (< len(buf)), applies: s[5*x + y] = s[5*x + y] XOR buf[x + 5*y]
+	// (the state is in LayoutInternal, the block in LayoutSpec, see statelayout.go)
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 5; x++ {
+			if LayoutSpec.Index(x, y) < len(buf) {
+				// xor: lane level in code
+				// in circuit level: for each bit in the 64-bit lane, 1 Add gate is emitted (XOR in GF(2)), Therefore: 64 gates per lane
+				s[LayoutInternal.Index(x, y)] = xor(api, s[LayoutInternal.Index(x, y)], buf[LayoutSpec.Index(x, y)])
+			}
+		}
+	}
+	return s
+}
+
+// Function Purpose:
+	// full implementation of the Keccak-f[1600] permutation applied 24 times inside a zk circuit over GF(2)
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `a`: the state array (25 lanes, each 64 bits), laid out as a[0] to a[24]
+	//        The state corresponds to the 5×5 Keccak matrix A[x][y], flattened row-major
+	// 	      Each round modifies a copy of a using Keccak's 5 round steps; the caller's slices are left untouched
+	// - `opts`: optional, e.g. WithRoundObserver to see the state after every round (see trace.go)
+// Outputs:
+	// - `a`: the new state array after 24 rounds of Keccak-f[1600]
+func keccakF(api frontend.API, a [][]frontend.Variable, opts ...PermOption) [][]frontend.Variable {
+	return keccakP(api, a, 0, 24, opts...)
+}
+
+// keccakWidths are the lane widths keccakP accepts, those of Keccak-f[100] to Keccak-f[1600]. The
+// 1- and 2-bit lanes of Keccak-f[25] and Keccak-f[50] are left out: every ρ offset mod 2 is a toy.
+var keccakWidths = map[int]bool{4: true, 8: true, 16: true, 32: true, 64: true}
+
+// keccakP runs the rounds startRound .. startRound+numRounds-1 of keccakF, round i with ι constant RC[i].
+// Keccak-p[1600, n] of FIPS 202 section 3.3 is the window that ends at round 23, keccakP(24-n, n), whose
+// round r takes RC[24-n+r]. Other windows are not permutations of the standard (the first 12 rounds are not
+// Keccak-p[1600, 12]) but let a debugging circuit stop after any round, and keccakP(0, k) followed by
+// keccakP(k, 24-k) is keccakF. An empty or out-of-range window panics at circuit-build time.
+// The lane width w is that of the state and must be one of the five keccakWidths: 64 for Keccak-f[1600], or
+// 4, 8, 16 or 32 for the smaller members of the family, whose 12+2·log2(w) rounds take the ρ offsets mod w
+// and the first w bits of RC[i] (KeccakF800 is the 22-round window with w = 32, KeccakF400 the 20-round
+// one with w = 16).
+func keccakP(api frontend.API, a [][]frontend.Variable, startRound, numRounds int, opts ...PermOption) [][]frontend.Variable {
+	w := len(a[0])
+	if !keccakWidths[w] {
+		panic(fmt.Sprintf("keccakP: %d-bit lanes, expected 4, 8, 16, 32 or 64", w))
+	}
+	for _, lane := range a {
+		if len(lane) != w {
+			panic(fmt.Sprintf("keccakP: lanes of %d and %d bits", w, len(lane)))
+		}
+	}
+	rounds := 12 + 2*bits.TrailingZeros(uint(w))
+	if startRound < 0 || numRounds < 1 || startRound+numRounds > rounds {
+		panic(fmt.Sprintf("keccakP: rounds %d..%d, expected a non-empty window of 0..%d", startRound, startRound+numRounds-1, rounds-1))
+	}
+	offsets := spec.RotationOffsetsFor(w)
+	var observe RoundObserver
+	var observeStep StepObserver
+	chi := ChiSingleLayer
+	for _, o := range opts {
+		if o.observer != nil {
+			observe = o.observer
+		}
+		if o.step != nil {
+			observeStep = o.step
+		}
+		if o.chi != ChiSingleLayer {
+			chi = o.chi
+		}
+	}
+	// The rounds below overwrite lanes (and bits of lane 0) in place, so work on a copy of the caller's state.
+	a = copyState(a)
+	// It preallocates storage for temporary Keccak lanes used during each round.
+	// | Variable    | Size                | Purpose                                                                                 |
+	// | ----------- | ------------------- | --------------------------------------------------------------------------------------- |
+	// | `b[25][64]` | 25 lanes × 64 bits  | Stores intermediate results after ρ and π steps (rotated & permuted lanes)              |
+	// | `c[5][64]`  | 5 columns × 64 bits | Stores column parity for θ step                                                         |
+	// | `d[5][64]`  | 5 columns × 64 bits | Stores θ diffusion terms: $D[x] = C[x−1] ⊕ rot(C[x+1], 1)$                              |
+	// | `da[5][64]` | 5 lanes × 64 bits   | Similar to `d`, but uses direct lanes from `a` instead of `c` (optimizing lane-based θ) |
+	var b [25][]frontend.Variable
+	for i := 0; i < len(b); i++ {
+		b[i] = make([]frontend.Variable, w)
+		for j := 0; j < w; j++ {
+			b[i][j] = 0
+		}
+	}
+	var c [5][]frontend.Variable
+	for i := 0; i < len(c); i++ {
+		c[i] = make([]frontend.Variable, w)
+		for j := 0; j < w; j++ {
+			c[i][j] = 0
+		}
+	}
+	var d [5][]frontend.Variable
+	for i := 0; i < len(d); i++ {
+		d[i] = make([]frontend.Variable, w)
+		for j := 0; j < w; j++ {
+			d[i][j] = 0
+		}
+	}
+	var da [5][]frontend.Variable
+	for i := 0; i < len(d); i++ {
+		da[i] = make([]frontend.Variable, w)
+		for j := 0; j < w; j++ {
+			da[i][j] = 0
+		}
+	}
+
+	// Loop: 24 rounds (the window startRound .. startRound+numRounds-1 of them, see keccakP):
+	// Each round performs the full sequence: θ → ρ → π → χ → ι
+	for i := startRound; i < startRound+numRounds; i++ {
+		// -------------------------------- θ step --------------------------------
+		// θ step computes:
+		// C[x]=A[x,0]⊕A[x,1]⊕A[x,2]⊕A[x,3]⊕A[x,4] → column parity
+		// D[x]=C[x−1]⊕ROT(C[x+1],1) → mixes across columns
+		// A[x,y]=A[x,y]⊕D[x] → apply this to all lanes in column x
+
+		// This computes: C[x]=A[x,0]⊕A[x,1]⊕A[x,2]⊕A[x,3]⊕A[x,4] for x in 0..4
+		// assumes a[x+5*y] instead of a[5x+y], which suggests it's using column-major layout
+		// vanilla implementation would be: c[x] = a[x][0] ⊕ a[x][1] ⊕ a[x][2] ⊕ a[x][3] ⊕ a[x][4]
+			// Gate count: 
+				// pure binary circuits: 5 columns × 4 xor calls × 64 bits = 1280 XOR gates
+				// word-boolean-circuits: 5 columns × 4 xor calls × 8 words = 160 gates
+		c[0] = xor(api, xor(api, a[1], a[2]), xor(api, a[3], a[4]))
+		c[1] = xor(api, xor(api, a[6], a[7]), xor(api, a[8], a[9]))
+		c[2] = xor(api, xor(api, a[11], a[12]), xor(api, a[13], a[14]))
+		c[3] = xor(api, xor(api, a[16], a[17]), xor(api, a[18], a[19]))
+		c[4] = xor(api, xor(api, a[21], a[22]), xor(api, a[23], a[24]))
+
+		// This gives: D[x]=C[x−1]⊕ROT(C[x+1],1)
+		// each C[i] is 64 bits
+		// vanilla implementation would be: D[x] = C[x-1] ⊕ ROT(C[x+1], 1)
+		// Gate count:
+			// pure binary circuits: 5 columns × 1 xor call × 64 bits = 320 XOR gates
+			// word-boolean-circuits: 5 columns × 1 xor call × 8 words = 40 gates(XOR with rotate)
+		for j := 0; j < 5; j++ {
+			d[j] = xor(api, c[(j+4)%5], rotateLeft(c[(j+1)%5], 1))
+			// da[j]=A[j−1,0]⊕ROT(A[j+1,0],1)
+			da[j] = xor(api, a[((j+4)%5)*5], rotateLeft(a[((j+1)%5)*5], 1))
+		}
+		// A[x,y]=A[x,y]⊕D[x]
+		// Gate count:
+			// pure binary circuits: 5 columns × 5 rows × 64 bits = 1600 XOR gates
+			// word-boolean-circuits: 5 columns × 5 rows × 8 words = 200 gates
+		for j := 0; j < 25; j++ {
+			tmp := xor(api, da[j/5], a[j])
+			a[j] = xor(api, tmp, d[j/5])
+		}
+		if observeStep != nil {
+			observeStep(i, "theta", copyState(a))
+		}
+
+		// Case 1: Pure Keccak-style θ (Spec-Aligned)
+		// | Step                 | Calls  | Bits per call | Total XOR Gates (bit-level) | Total Word Gates (8-bit) |
+		// | -------------------- | ------ | ------------- | --------------------------- | ------------------------ |
+		// | `C[x]`: 5-input XOR  | 5 × 4  | 64            | 1280                        | 160                      |
+		// | `D[x]` (with rotate) | 5 × 1  | 64            | 320                         | 40 *(with rotate)        |
+		// | `A[x,y]` update      | 25 × 1 | 64            | 1600                        | 200                      |
+		// | **Total**            |        |               | **3200**                    | **400** ✅               |
+		
+		// Case 2: Implementation (with da[x])
+		// | Step                                 | Calls  | Bits per call | Total XOR Gates (bit-level) | Total Word Gates (8-bit) |
+		// | ------------------------------------ | ------ | ------------- | --------------------------- | ------------------------ |
+		// | `C[x]`: 4-input XOR (misses A\[x,0]) | 5 × 3  | 64            | 960                         | 120                      |
+		// | `D[x]` (with rotate)                 | 5 × 1  | 64            | 320                         | 40  *(with rotate)       |
+		// | `da[x]` (with rotate)                | 5 × 1  | 64            | 320                         | 40  *(with rotate)       |
+		// | `A[x,y]` update (2× XOR per lane)    | 25 × 2 | 64            | 3200                        | 400                      |
+		// | **Total**                            |        |               | **4800** ❌                  | **600** ❌                |
+		// This style of optimization comes from word-oriented ZK systems (e.g., Groth16, Halo2), where reducing logic depth or reusing intermediate wires (like da[x]) can help. 
+
+		// --------------------------- ρ and π step --------------------------------
+		/*Rho and pi steps*/
+		// ρ (Rho): Bitwise rotation of each lane (64-bit)
+		// π (Pi): Permutation of lane positions in the state
+		
+		// Purpose of this Code Block: b[...] = rotateLeft(a[...], ...)
+		// This entire block transforms the Keccak state a[0..24] into b[0..24], where:
+		// a[i] represents the lane A[x,y]
+		// b[i] is the rotated and permuted version B[y,(2x+3y)]
+		// ρ Step: Bit Rotation
+			// Each lane in the state is rotated left by a constant (different for each position), defined by Keccak-f's spec. For example:
+			// lane a[1] = A[0,1] is rotated left by 36 bits.
+			// The constants come from the Keccak rotation offset table, spec.RotationOffsetsFor(w) (spec.RotationOffsets for 64-bit lanes).
+			// These offsets are fixed for each position (x, y) in the Keccak 5×5 grid.
+		// π Step: Permutation
+			// B[y][(2x+3y)mod5]=ROT(A[x][y],r[x][y]), spec.PiPermutation gives the destination of each lane.
+		// Both tables are indexed x+5y (LayoutSpec), while a and b are indexed 5x+y (LayoutInternal).
+		for x := 0; x < 5; x++ {
+			for y := 0; y < 5; y++ {
+				dst := spec.PiPermutation[LayoutSpec.Index(x, y)]
+				b[LayoutInternal.Index(dst%5, dst/5)] = rotateLeft(a[LayoutInternal.Index(x, y)], offsets[LayoutSpec.Index(x, y)])
+			}
+		}
+		if observeStep != nil {
+			observeStep(i, "rhoPi", copyState(b[:]))
+		}
+
+		// gate count: Pure wire routing (no API ops)
+		// !! will meet problems if B = 8, cross-word rotations
+
+		// --------------------------- χ step --------------------------------
+		// A[x,y]=B[x,y]⊕(¬B[x+1,y]∧B[x+2,y])
+		// Each row (5 lanes) is updated using its neighbors
+		// This is the only nonlinear step in Keccak
+		/*Xi state*/
+		// a[x + 5*y] = b[x + 5*y] ⊕ (¬b[(x+1)%5 + 5*y] ∧ b[(x+2)%5 + 5*y])
+		// for each update, consists of:
+			// NOT (per bit): ¬b[i+1]
+			// 1 AND: (¬b[i+1]) ∧ b[i+2]
+            // 1 XOR: with b[i]
+		// gate count:
+			// pure binary circuits: 5 rows × 5 lanes × 64 bits = 1600 AND gates + 1600 XOR gates + 1600 NOT gates(equivalent to AND gates)
+			// word-boolean-circuits: 5 rows × 5 lanes × 8 words = 200 AND gates + 200 XOR gates + 200 NOT gates
+		// With ChiStaggered, part of the operands are delayed by one layer first (see chischedule.go).
+		if chi == ChiStaggered {
+			staggerChi(api, &b)
+		}
+		a[0] = xor(api, b[0], and(api, not(api, b[5]), b[10]))
+		a[1] = xor(api, b[1], and(api, not(api, b[6]), b[11]))
+		a[2] = xor(api, b[2], and(api, not(api, b[7]), b[12]))
+		a[3] = xor(api, b[3], and(api, not(api, b[8]), b[13]))
+		a[4] = xor(api, b[4], and(api, not(api, b[9]), b[14]))
+
+		a[5] = xor(api, b[5], and(api, not(api, b[10]), b[15]))
+		a[6] = xor(api, b[6], and(api, not(api, b[11]), b[16]))
+		a[7] = xor(api, b[7], and(api, not(api, b[12]), b[17]))
+		a[8] = xor(api, b[8], and(api, not(api, b[13]), b[18]))
+		a[9] = xor(api, b[9], and(api, not(api, b[14]), b[19]))
+
+		a[10] = xor(api, b[10], and(api, not(api, b[15]), b[20]))
+		a[11] = xor(api, b[11], and(api, not(api, b[16]), b[21]))
+		a[12] = xor(api, b[12], and(api, not(api, b[17]), b[22]))
+		a[13] = xor(api, b[13], and(api, not(api, b[18]), b[23]))
+		a[14] = xor(api, b[14], and(api, not(api, b[19]), b[24]))
+
+		a[15] = xor(api, b[15], and(api, not(api, b[20]), b[0]))
+		a[16] = xor(api, b[16], and(api, not(api, b[21]), b[1]))
+		a[17] = xor(api, b[17], and(api, not(api, b[22]), b[2]))
+		a[18] = xor(api, b[18], and(api, not(api, b[23]), b[3]))
+		a[19] = xor(api, b[19], and(api, not(api, b[24]), b[4]))
+
+		a[20] = xor(api, b[20], and(api, not(api, b[0]), b[5]))
+		a[21] = xor(api, b[21], and(api, not(api, b[1]), b[6]))
+		a[22] = xor(api, b[22], and(api, not(api, b[2]), b[7]))
+		a[23] = xor(api, b[23], and(api, not(api, b[3]), b[8]))
+		a[24] = xor(api, b[24], and(api, not(api, b[4]), b[9]))
+		if observeStep != nil {
+			// ι writes into lane 0 in place
+			observeStep(i, "chi", copyState(a))
+		}
+
+		// --------------------------- ι step --------------------------------
+		// XOR round constant RC[i] into a[0] (lane A[0,0]), A[0][0]=A[0][0]⊕RC[i]
+		// The rcs array stores RC[i] as bits
+		// Only bits where rcs[i][j] == 1 are flipped using 1 ⊕ a[0][j] = 1 - a[0][j]
+		///*Last step*/
+		// For each bit A[0][0],
+		// if the round constant RC[i][j]=1,
+		// then flip that bit: a[0][j]=1−a[0][j]
+		// !! rcs (the round constants used in the ι step) are public, fixed, and universal for all Keccak permutations of a given width.
+		for j := 0; j < len(a[0]); j++ {
+			if rcs[i][j] == 1 {
+				if x, ok := constBitValue(a[0][j]); ok {
+					a[0][j] = 1 ^ x
+				} else {
+					a[0][j] = api.Sub(1, a[0][j])
+				}
+			}
+		}
+		// gate count:
+			// pure binary circuits: 1 round constant × 64 bits = 64 NOT gates(equivalent to AND gates)
+			// word-boolean-circuits: 1 round constant × 8 words = 8 NOT gates(equivalent to AND gates)
+		if observeStep != nil {
+			observeStep(i, "iota", copyState(a))
+		}
+		if observe != nil {
+			// the next round replaces the lanes of a rather than writing into them, so a copy of the
+			// lane list is a stable snapshot
+			observe(i, append([][]frontend.Variable(nil), a...))
+		}
+	}
+
+	return a
+}
+
+// xor is bitwise XOR over any field: a single Add over GF(2), a + b - 2ab otherwise (see field.go).
+// Constant operand bits are folded as in xorBit.
+func xor(api frontend.API, a []frontend.Variable, b []frontend.Variable) []frontend.Variable {
+	gf2 := isGF2(api)
+	nbits := len(a)
+	bitsRes := make([]frontend.Variable, nbits)
+	for i := 0; i < nbits; i++ {
+		if r, ok := foldXor(api, a[i], b[i]); ok {
+			bitsRes[i] = r
+			continue
+		}
+		if !gf2 {
+			bitsRes[i] = api.Sub(api.Add(a[i], b[i]), api.Mul(2, a[i], b[i]))
+			continue
+		}
+		bitsRes[i] = api.Add(a[i], b[i])
+		//bitsRes[i] = api.(ecgo.API).ToSingleVariable(bitsRes[i])
+	}
+	return bitsRes
+}
+
+func and(api frontend.API, a []frontend.Variable, b []frontend.Variable) []frontend.Variable {
+	nbits := len(a)
+	bitsRes := make([]frontend.Variable, nbits)
+	for i := 0; i < nbits; i++ {
+		//x := api.(ecgo.API).ToSingleVariable(a[i])
+		//y := api.(ecgo.API).ToSingleVariable(b[i])
+		//fmt.Println(api.(ecgo.API).LayerOf(x))
+		//bitsRes[i] = api.Mul(x, y)
+		//fmt.Println(bitsRes[i])
+		bitsRes[i] = andBit(api, a[i], b[i])
+		//bitsRes[i] = api.(ecgo.API).ToSingleVariable(bitsRes[i])
+		//fmt.Println(bitsRes[i])
+	}
+	return bitsRes
+}
+
+func not(api frontend.API, a []frontend.Variable) []frontend.Variable {
+	bitsRes := make([]frontend.Variable, len(a))
+	for i := 0; i < len(a); i++ {
+		// But subtraction is same cost as addition in GF(2), so this is equivalent to: res[i] = Add(1, a[i])  // modulo 2
+		if x, ok := constBitValue(a[i]); ok {
+			bitsRes[i] = 1 ^ x
+			continue
+		}
+		bitsRes[i] = api.Sub(1, a[i])
+	}
+	return bitsRes
+}
+
+// Bit helpers:
+// xor, and and not work on bit slices; xorBit and andBit are their single-bit forms for gadgets that
+// combine bits one by one (the SHA-256 adders). Constant operand bits are folded instead of emitting gates
+// (see gatestats.go): two constants fold to a constant, and a constant 0 or 1 against a wire leaves the
+// wire, a NOT or a constant 0. Every fold holds over any field for 0/1 wires.
+
+// foldXor is a ⊕ b when a or b is a constant bit: the constant, the other operand (a constant 0) or
+// its NOT, a Sub (a constant 1). ok is false when both are wires.
+func foldXor(api frontend.API, a, b frontend.Variable) (r frontend.Variable, ok bool) {
+	x, aConst := constBitValue(a)
+	y, bConst := constBitValue(b)
+	switch {
+	case aConst && bConst:
+		return x ^ y, true
+	case aConst && x == 0:
+		return b, true
+	case bConst && y == 0:
+		return a, true
+	case aConst:
+		return api.Sub(1, b), true
+	case bConst:
+		return api.Sub(1, a), true
+	}
+	return nil, false
+}
+
+// xorBit is a ⊕ b over GF(2): folded as in foldXor, an Add otherwise.
+func xorBit(api frontend.API, a, b frontend.Variable) frontend.Variable {
+	if r, ok := foldXor(api, a, b); ok {
+		return r
+	}
+	return api.Add(a, b)
+}
+
+// andBit is a ∧ b: folded when either is a constant bit, a Mul otherwise.
+func andBit(api frontend.API, a, b frontend.Variable) frontend.Variable {
+	if x, ok := constBitValue(a); ok {
+		if x == 0 {
+			return 0
+		}
+		return b
+	}
+	if y, ok := constBitValue(b); ok {
+		if y == 0 {
+			return 0
+		}
+		return a
+	}
+	return api.Mul(a, b)
+}
+
+// rotateLeft(b,k)[i]=b[(i−k) mod n]
+// this is purely a Go-level wire reindexing operation, which just reordering references to existing frontend.Variables, not computing anything new.
+// What happens at the circuit level?
+// If:
+// a := []frontend.Variable{a₀, a₁, a₂, ..., a₆₃}
+// b := rotateLeft(a, 1)
+// Then:
+// b[0] = a[63]
+// b[1] = a[0]
+// b[2] = a[1]
+// ...
+// b[63] = a[62]
+// These are just wires reused in new places.
+// When you later do api.Add(b[i], ...), the constraint will refer to the original wire a[j], just in a different place.
+func rotateLeft(bits []frontend.Variable, k int) []frontend.Variable {
+	n := uint(len(bits))
+	s := uint(k) & (n - 1)
+	newBits := bits[n-s:]
+	return append(newBits, bits[:n-s]...)
+}
+
+// Function Purpose:
+	// Reads the first outputLen bytes of the rate part of the state (one squeeze pass, no permutation).
+	// Lanes are visited in output order (x+5y) and only as many as the output needs; the last lane is cut to the
+	// exact length, so 20 bytes are lanes 0 and 1 plus the low 32 bits of lane 2.
+// Inputs:
+	// - `s`: the state, 25 lanes in LayoutInternal
+	// - `rate`: the sponge rate in bits; outputLen must not exceed rate/8
+	// - `outputLen`: number of output bytes
+// Outputs:
+	// - exactly 8*outputLen bits, LSB first within each byte
+// Gate Count:
+	// none: wiring only
+func copyOutUnaligned(api frontend.API, s [][]frontend.Variable, rate, outputLen int) []frontend.Variable {
+	if outputLen < 0 || outputLen > rateOf(rate).Bytes {
+		panic("copyOutUnaligned: output must fit in the rate")
+	}
+	out := make([]frontend.Variable, 0, 8*outputLen)
+	for i := 0; len(out) < 8*outputLen; i++ {
+		lane := s[LayoutInternal.Index(i%5, i/5)]
+		need := 8*outputLen - len(out)
+		if need > len(lane) {
+			need = len(lane)
+		}
+		out = append(out, lane[:need]...)
+	}
+	return out
+}
+
+// keccak256Circuit proves that Out[i] is the first len(Out[i]) bits of Keccak-256(P[i]) for len(P) instances;
+// build it with NewKeccak256Circuit or NewTruncatedKeccak256Circuit.
+type keccak256Circuit struct {
+	P   [][64 * 8]frontend.Variable
+	Out [][]frontend.Variable `gnark:",public"`
+	// Context is the optional public context prefix (see context.go); nil for the plain circuit.
+	Context []frontend.Variable `gnark:",public"`
+	// PublicP holds the messages instead of P when they are public inputs (see publicinput.go); nil otherwise.
+	PublicP [][64 * 8]frontend.Variable `gnark:",public"`
+	// Distinct additionally asserts that the digests are pairwise distinct (see distinct.go); build-time only.
+	Distinct bool `gnark:"-"`
+	// NoBooleanity drops the booleanity assertions on the message and context bits off GF(2)
+	// (WithoutBooleanity, see booleanity.go); build-time only.
+	NoBooleanity bool `gnark:"-"`
+	// Outputs selects per-bit or aggregated digest assertions (WithOutputAssertion, see aggregate.go);
+	// build-time only.
+	Outputs OutputAssertion `gnark:"-"`
+	// SHA3 hashes with SHA3-256 instead of Keccak-256 (WithSHA3, see sha3.go); build-time only.
+	SHA3 bool `gnark:"-"`
+	// Inlined emits every instance's gates instead of one memoized sub-circuit (WithInlinedKeccak, see
+	// memoize.go); build-time only.
+	Inlined bool `gnark:"-"`
+	// Chi is the layer layout of the χ step (WithChiSchedule, see chischedule.go); build-time only.
+	Chi ChiSchedule `gnark:"-"`
+}
+
+func computeKeccak(api frontend.API, P []frontend.Variable) []frontend.Variable {
+	return computeKeccakConfig(api, P, Keccak256Config)
+}
+
+// computeKeccak128 is computeKeccak squeezing only the first TruncatedBits bits of the digest, the
+// output-length parameter of Keccak256Config.WithOutputBits. It is a function of its own, not a closure
+// over the length, so that the memoized sub-circuits of the two lengths stay apart (see memoize.go).
+func computeKeccak128(api frontend.API, P []frontend.Variable) []frontend.Variable {
+	return computeKeccakConfig(api, P, Keccak256Config.WithOutputBits(TruncatedBits))
+}
+
+func computeKeccakConfig(api frontend.API, P []frontend.Variable, cfg KeccakConfig) []frontend.Variable {
+	// Keccak-256 of one 64-byte message, composed from the sponge primitives of sponge.go.
+	if len(P) != 64*8 {
+		panic("computeKeccak: message must be 64 bytes")
+	}
+	// ----------------------------- Initialize Keccak State: 5×5×64 bits = 1600 bits -----------------------------
+	// ss is the Keccak state A[x][y], represented as a 1D array of 25 lanes.
+	// Each lane is 64 bits → total 1600 bits
+	// Initially all set to zero → corresponds to state := zero_state() in Keccak spec.
+	ss := NewState()
+	// The parameters are those of cfg (see config.go), Keccak256Config or a shorter output of it: rate 1088,
+	// 24 rounds, domain 0x01.
+
+	// -------------------------------- Apply pad10*1 padding to reach 136 bytes (1088 bits) ------------------------
+	// P is the 64-byte (512-bit) message input, already bit-decomposed.
+	// We need to pad from 64 bytes → 136 bytes (rate = 1088 bits = 136 bytes):
+	// 0x01 right after the message, 0x80 in byte 135, zeros in between (see padMessage).
+	// Pad101Blocks returns the padded message as blocks of 1088 bits (136 × 8): here exactly one.
+	blocks := Pad101Blocks(P, cfg.RateBits, cfg.DomainSep)
+
+	// -------------------------------- Absorb phase: inject padded message block ----------------------------------
+	// blocks[0] := input (512 bits) + pad10*1 = exactly 1088 bits = 1 block, split into 17 lanes of 64 bits
+	// state[0:r] ^= p, only the first 17 lanes of the state are XORed with the input block.
+	// Then applies full Keccak-f[1600], including 24 rounds of: θ → ρ → π → χ → ι
+	// Internally uses XOR, AND, NOT, ROTATE — all at bit-level with constraints.
+	for _, block := range blocks {
+		ss = Absorb(api, ss, block)
+	}
+
+	// ------------------------- Squeeze phase: extract 32-byte = 256-bit digest -----------------------------------
+	// Reads the first cfg.OutputBits bits (256, or 128 for computeKeccak128) from the rate portion of the state
+	// (first 136 bytes).
+	// For SHA3-256, 1 extraction round is enough
+	out := Squeeze(api, ss, cfg.RateBits, cfg.OutputBits)
+	// out is a cfg.OutputBits-length []frontend.Variable, representing the final Keccak digest in bit form.
+	return out
+}
+
+// Define(api frontend.API) is the core interface required by gnark and ecgo circuits. This function builds the constraints of the zk-SNARK circuit.
+// It is called by the compiler to generate the circuit. 
+// Inputs: 
+// - `api`: the constraint system builder — you use this to create gates
+// Outputs:
+// - `t`: circuit struct, now filled with symbolic variables (t.P[i][j], t.Out[i][j]) 
+// NewKeccak256Circuit returns a circuit (or an empty assignment) with n Keccak-256 instances.
+// Assignments must be built with the same n as the compiled circuit.
+func NewKeccak256Circuit(n int, opts ...CircuitOption) *keccak256Circuit {
+	return NewTruncatedKeccak256Circuit(n, CheckBits, opts...)
+}
+
+// NewTruncatedKeccak256Circuit returns a circuit with n instances that exposes and asserts only the first
+// checkBits bits of each digest; the public inputs shrink to checkBits per instance. With checkBits up to
+// TruncatedBits (SHA3 aside) only the first TruncatedBits digest bits are squeezed at all.
+func NewTruncatedKeccak256Circuit(n int, checkBits int, opts ...CircuitOption) *keccak256Circuit {
+	if n < 1 {
+		panic("NewKeccak256Circuit: need at least one instance")
+	}
+	if checkBits < 1 || checkBits > 256 {
+		panic(fmt.Sprintf("NewTruncatedKeccak256Circuit: %d check bits, expected 1..256", checkBits))
+	}
+	circuit := &keccak256Circuit{
+		P:   make([][64 * 8]frontend.Variable, n),
+		Out: make([][]frontend.Variable, n),
+	}
+	// one backing array for all digests; each Out[i] is capped so that appending to it cannot spill into Out[i+1]
+	outs := make([]frontend.Variable, n*checkBits)
+	for i := range circuit.Out {
+		circuit.Out[i] = outs[i*checkBits : (i+1)*checkBits : (i+1)*checkBits]
+	}
+	for _, opt := range opts {
+		opt(circuit)
+	}
+	return circuit
+}
+
+// checkBits is the number of digest bits per instance, or an error if the instances disagree.
+func (t *keccak256Circuit) checkBits() (int, error) {
+	if t.P != nil && t.PublicP != nil {
+		return 0, fmt.Errorf("messages in both P and PublicP")
+	}
+	if len(t.Out) != len(t.messages()) {
+		return 0, fmt.Errorf("%d digests for %d messages", len(t.Out), len(t.messages()))
+	}
+	for i := range t.Out {
+		if len(t.Out[i]) != len(t.Out[0]) || len(t.Out[i]) < 1 || len(t.Out[i]) > 256 {
+			return 0, fmt.Errorf("digest %d has %d bits, expected %d (at most 256)", i, len(t.Out[i]), len(t.Out[0]))
+		}
+	}
+	return len(t.Out[0]), nil
+}
+
+func (t *keccak256Circuit) Define(api frontend.API) error {
+	checkBits, err := t.checkBits()
+	if err != nil {
+		return fmt.Errorf("keccak256Circuit: %w", err)
+	}
+	if t.booleanity(api) {
+		assertBooleans(api, t.Context)
+	}
+	// Instances are independent: with defineWorkers > 1 they are traced concurrently and replayed into api
+	// in instance order (see parallel.go), which yields exactly the sequential circuit. Memoized instances
+	// are single sub-circuit calls and are always built sequentially (see memoize.go), and so are those of
+	// AggregateGlobal, whose digest checks are folded into one assertion (see aggregate.go).
+	if t.Inlined && t.Outputs != AggregateGlobal && defineWorkers > 1 && len(t.Out) > 1 {
+		if err := defineParallel(api, len(t.Out), defineWorkers, func(api frontend.API, i int) {
+			t.defineInstance(api, i, checkBits)
+		}); err != nil {
+			return err
+		}
+	} else {
+		var matched []frontend.Variable
+		for i := 0; i < len(t.Out); i++ {
+			// This iterates through the len(t.Out) hash computations (NHashes = 8 by default).
+			if m := t.defineInstance(api, i, checkBits); m != nil {
+				matched = append(matched, m)
+			}
+		}
+		if t.Outputs == AggregateGlobal {
+			api.AssertIsEqual(andTree(api, matched), 1)
+		}
+	}
+	if t.Distinct {
+		assertDistinctDigests(api, t.Out)
+	}
+	return nil
+}
+
+// defineInstance builds instance i: the digest of message i (after the context, if any) against Out[i].
+// With AggregateGlobal it returns the instance's matched wire instead of asserting it.
+func (t *keccak256Circuit) defineInstance(api frontend.API, i int, checkBits int) frontend.Variable {
+	// For each input block t.P[i] (512 bits), the hash is computed by computeKeccak (the general sponge over
+	// context || message, with a context; Sha3_256 for t.SHA3), as one memoized sub-circuit per instance
+	// unless t.Inlined (see memoize.go).
+	msg := t.messages()[i][:]
+	if t.booleanity(api) {
+		assertBooleans(api, msg)
+	}
+	hash, contextHash := computeKeccak, contextKeccak
+	switch {
+	case t.Chi == ChiStaggered:
+		hash = staggeredHash(t.SHA3, checkBits)
+		contextHash = hash
+	case t.SHA3:
+		hash, contextHash = Sha3_256, Sha3_256
+	case checkBits <= TruncatedBits:
+		hash, contextHash = computeKeccak128, contextKeccak128
+	}
+	var out []frontend.Variable
+	switch {
+	case t.Inlined && len(t.Context) == 0:
+		out = hash(api, msg)
+	case t.Inlined:
+		out = contextHash(api, append(append([]frontend.Variable{}, t.Context...), msg...))
+	case len(t.Context) == 0:
+		out = memorizedCall(api, hash, msg)
+	default:
+		out = memorizedCall(api, contextHash, append(append([]frontend.Variable{}, t.Context...), msg...))
+	}
+	return assertDigest(api, t.Outputs, out, t.Out[i][:checkBits])
+}
@@ -0,0 +1,3785 @@
+package keccakgf2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/field/gf2"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/irwg"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/layered"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/test"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/spec"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/verifier"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/sha3"
+)
+
+// The tests share one compiled NHashes-instance circuit without context, built once in TestMain.
+// Messages come from fixed seeds, so a failure reproduces.
+var (
+	compiled *layered.RootCircuit
+	solver   *checkedSolver
+)
+
+func TestMain(m *testing.M) {
+	// the test binary doubles as the external hasher of TestExternalCrossCheck
+	if mode := os.Getenv("KECCAK_GF2_HASHER"); mode != "" {
+		if err := helperHasher(mode); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// and as the compiler of TestLowMemoryCompile, which compares peak RSS across fresh processes
+	if mode := os.Getenv("KECCAK_GF2_COMPILE"); mode != "" {
+		if err := helperCompile(mode); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	circuit := NewKeccak256Circuit(NHashes)
+	cr, err := compileCircuit(gf2.ScalarField, circuit)
+	if err != nil {
+		panic(err)
+	}
+	compiled = cr.GetLayeredCircuit()
+	solver = newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, circuit)
+	code := m.Run()
+	removeSharedBatch()
+	os.Exit(code)
+}
+
+// TestSolveCorrectWitness solves an assignment with the true digests and checks the witness.
+func TestSolveCorrectWitness(t *testing.T) {
+	assignment, err := randomAssignment(seededReader(1), NHashes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expectVerdict(solver, compiled, assignment, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFlippedInputFails flips the first message bit of every instance, then a seeded sample of single
+// bits anywhere in any instance: flipping a bit alone must fail, and flipping it with the digest
+// recomputed for the new message must pass again. A bit that does not fail is unconstrained or aliased.
+func TestFlippedInputFails(t *testing.T) {
+	assignment, err := randomAssignment(seededReader(2), NHashes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flip := func(k, i int) {
+		if err := FlipInputBit(assignment, k, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for k := 0; k < NHashes; k++ {
+		flip(k, 0)
+	}
+	if err := expectVerdict(solver, compiled, assignment, false); err != nil {
+		t.Fatal(err)
+	}
+	for k := 0; k < NHashes; k++ {
+		flip(k, 0)
+	}
+
+	setDigest := func(k int) {
+		msg, err := assignedBytes(assignment.P[k][:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(assignment.Out[k], bitsOf(crypto.Keccak256(msg))[:CheckBits])
+	}
+	positions := rand.New(rand.NewSource(992))
+	for n := 0; n < 16; n++ {
+		k, i := positions.Intn(NHashes), positions.Intn(64*8)
+		flip(k, i)
+		for _, recompute := range []bool{false, true} {
+			if recompute {
+				setDigest(k)
+			}
+			if err := expectVerdict(solver, compiled, assignment, recompute); err != nil {
+				t.Fatalf("flipping P[%d][%d]: %v", k, i, err)
+			}
+		}
+		flip(k, i)
+		setDigest(k)
+	}
+}
+
+// TestBatchSolveInputs solves 16 assignments in one batch and checks every one of them through the
+// on-disk artifacts and the verifier package only, the way a verifying party without the gadget code would.
+func TestBatchSolveInputs(t *testing.T) {
+	rnd := seededReader(3)
+	assignments := make([]frontend.Circuit, 16)
+	identity := make([]int, len(assignments))
+	for z := range assignments {
+		a, err := randomAssignment(rnd, NHashes, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assignments[z], identity[z] = a, z
+	}
+	env, err := solveBatch(solver, assignments, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	circuitPath, envPath := filepath.Join(dir, "circuit.txt"), filepath.Join(dir, "witness.env")
+	if err := os.WriteFile(circuitPath, compiled.Serialize(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(envPath, env.Serialize(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	vc, _, err := verifier.LoadCircuit(circuitPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	venv, err := verifier.LoadEnvelope(envPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expectBatch(vc, venv); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// slowAssignment is the assignment builder before bulk bit copies and pooled hashing: bitsOf per message
+// and digest, and one go-ethereum Keccak-256 call per message.
+func slowAssignment(msgs [][]byte, context []byte) *keccak256Circuit {
+	c := newKeccak256Circuit(len(msgs), len(context))
+	if len(context) > 0 {
+		copy(c.Context, bitsOf(context))
+	}
+	for k, data := range msgs {
+		copy(c.P[k][:], bitsOf(data))
+		hash := crypto.Keccak256Hash(context, data)
+		copy(c.Out[k], bitsOf(hash[:])[:CheckBits])
+	}
+	return c
+}
+
+func randomMessages(seed int64, n int) [][]byte {
+	rnd := seededReader(seed)
+	msgs := make([][]byte, n)
+	for k := range msgs {
+		msgs[k] = make([]byte, 64)
+		rnd.Read(msgs[k])
+	}
+	return msgs
+}
+
+// TestFastAssignmentMatchesSlow builds 2000 messages into assignments on the worker pool and compares
+// a seeded sample of them with the slow path, with and without a context and with a truncated digest,
+// and checks that a second batch built into a reused assignBuffer keeps nothing of the first.
+func TestFastAssignmentMatchesSlow(t *testing.T) {
+	configured := assignWorkers
+	t.Cleanup(func() { assignWorkers = configured })
+	assignWorkers = 4
+	msgs := randomMessages(1013, 2000)
+	for _, context := range [][]byte{nil, []byte("batch 1013")} {
+		fast, err := assignBatch(msgs, NHashes, context)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sample := rand.New(rand.NewSource(1013))
+		for n := 0; n < 32; n++ {
+			z := sample.Intn(len(fast))
+			slow := slowAssignment(msgs[z*NHashes:(z+1)*NHashes], context)
+			if !reflect.DeepEqual(fast[z], slow) {
+				t.Fatalf("context %q: assignment %d differs from the slow path", context, z)
+			}
+		}
+	}
+	var buf assignBuffer
+	context := []byte("batch 1013")
+	for _, seed := range []int64{1013, 1014} {
+		batch := randomMessages(seed, 4*NHashes)
+		reused, err := buf.assign(batch, NHashes, context)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for z := range reused {
+			if !reflect.DeepEqual(reused[z], slowAssignment(batch[z*NHashes:(z+1)*NHashes], context)) {
+				t.Fatalf("batch %d: reused assignment %d differs from the slow path", seed, z)
+			}
+		}
+	}
+	truncated := NewTruncatedKeccak256Circuit(NHashes, 77)
+	if err := FillAssignment(truncated, msgs[:NHashes]); err != nil {
+		t.Fatal(err)
+	}
+	slow := slowAssignment(msgs[:NHashes], nil)
+	for k := range truncated.Out {
+		if !reflect.DeepEqual(truncated.P[k], slow.P[k]) || !reflect.DeepEqual(truncated.Out[k], slow.Out[k][:77]) {
+			t.Fatalf("truncated instance %d differs from the slow path", k)
+		}
+	}
+	if _, err := assignBatch(msgs[:NHashes+1], NHashes, nil); err == nil {
+		t.Fatal("assignBatch accepted a partial assignment")
+	}
+}
+
+// BenchmarkAssign10k builds 10k messages into the assignments of NHashes instances that a chunked solve
+// holds on to, batch after batch into one assignBuffer with pooled hashing; BenchmarkAssign10kBaseline does
+// the same with the builder it replaced (slowAssignment), so that the msgs/s of the two compare.
+// TestAssignSpeedup holds the first to 5x the second.
+func BenchmarkAssign10k(b *testing.B) {
+	var buf assignBuffer
+	benchmarkAssign10k(b, func(msgs [][]byte) []frontend.Circuit {
+		assignments, err := buf.assign(msgs, NHashes, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return assignments
+	})
+}
+
+func BenchmarkAssign10kBaseline(b *testing.B) {
+	benchmarkAssign10k(b, func(msgs [][]byte) []frontend.Circuit {
+		assignments := make([]frontend.Circuit, len(msgs)/NHashes)
+		for z := range assignments {
+			assignments[z] = slowAssignment(msgs[z*NHashes:(z+1)*NHashes], nil)
+		}
+		return assignments
+	})
+}
+
+func benchmarkAssign10k(b *testing.B, build func([][]byte) []frontend.Circuit) {
+	msgs := randomMessages(1013, 10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if assignments := build(msgs); len(assignments) != len(msgs)/NHashes {
+			b.Fatalf("%d assignments", len(assignments))
+		}
+	}
+	b.ReportMetric(float64(b.N*len(msgs))/b.Elapsed().Seconds(), "msgs/s")
+}
+
+// TestAssignSpeedup runs BenchmarkAssign10k and BenchmarkAssign10kBaseline and checks that assignBuffer
+// builds at least 5x the msgs/s of the slow path. It takes a few seconds and is skipped with -short.
+func TestAssignSpeedup(t *testing.T) {
+	if testing.Short() {
+		t.Skip("benchmarks the assignment builders")
+	}
+	fast, slow := testing.Benchmark(BenchmarkAssign10k), testing.Benchmark(BenchmarkAssign10kBaseline)
+	ratio := fast.Extra["msgs/s"] / slow.Extra["msgs/s"]
+	if ratio < 5 {
+		t.Fatalf("assignBuffer builds %.0f msgs/s, the slow path %.0f: %.1fx, want at least 5x", fast.Extra["msgs/s"], slow.Extra["msgs/s"], ratio)
+	}
+	t.Logf("assignBuffer builds %.0f msgs/s, the slow path %.0f: %.1fx", fast.Extra["msgs/s"], slow.Extra["msgs/s"], ratio)
+}
+
+// concurrentEnvelopes solves four single-assignment envelopes for the shared circuit, the last two with a
+// flipped public digest bit, and returns them with their expected verdicts.
+func concurrentEnvelopes(tb testing.TB) ([]*verifier.Envelope, []bool) {
+	var envs []*verifier.Envelope
+	var want []bool
+	for z := 0; z < 4; z++ {
+		a, err := randomAssignment(seededReader(int64(1019+z)), NHashes, nil)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		env, err := solveBatch(solver, []frontend.Circuit{a}, []int{z})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if z >= 2 {
+			v := env.Witness.Values[env.Witness.NumInputsPerWitness]
+			v.SetUint64(1 - v.Uint64())
+		}
+		envs, want = append(envs, env), append(want, z < 2)
+	}
+	return envs, want
+}
+
+// TestConcurrentCheck loads the circuit once and checks envelopes against it from many goroutines at once,
+// the way a verifier service does; run it with -race to catch any state the ecgo runtime mutates.
+func TestConcurrentCheck(t *testing.T) {
+	c := ecgo.DeserializeLayeredCircuit(compiled.Serialize())
+	envs, want := concurrentEnvelopes(t)
+	const goroutines, rounds = 16, 4
+	errs := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func(g int) {
+			for r := 0; r < rounds; r++ {
+				k := (g + r) % len(envs)
+				if got := verifier.Check(c, envs[k]); len(got) != 1 || got[0] != want[k] {
+					errs <- fmt.Errorf("goroutine %d, round %d: envelope %d checked %v, expected %v", g, r, k, got, want[k])
+					return
+				}
+			}
+			errs <- nil
+		}(g)
+	}
+	for g := 0; g < goroutines; g++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// BenchmarkConcurrentCheck checks envelopes against one shared circuit from GOMAXPROCS goroutines; compare
+// ns/op with -cpu 1,4,... for the scaling of a verifier service.
+func BenchmarkConcurrentCheck(b *testing.B) {
+	c := ecgo.DeserializeLayeredCircuit(compiled.Serialize())
+	envs, want := concurrentEnvelopes(b)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for k := 0; pb.Next(); k = (k + 1) % len(envs) {
+			if got := verifier.Check(c, envs[k]); got[0] != want[k] {
+				b.Errorf("envelope %d checked %v, expected %v", k, got[0], want[k])
+				return
+			}
+		}
+	})
+}
+
+// TestPublicInputWitnessSplit compiles the transparent-hash variant next to the shared private one and
+// solves the same messages with both: the message bits move from the private inputs to the public ones,
+// after the digests, the witness still checks, a flipped public message bit is rejected, and the verifier
+// reads digests and messages back from the public inputs.
+func TestPublicInputWitnessSplit(t *testing.T) {
+	template := NewPublicInputKeccak256Circuit(NHashes)
+	cr, err := compileCircuit(gf2.ScalarField, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, template)
+	msgs := randomMessages(1014, NHashes)
+	private, err := assignMessages(msgs, nil, CheckBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transparent := NewPublicInputKeccak256Circuit(NHashes)
+	if err := FillAssignment(transparent, msgs); err != nil {
+		t.Fatal(err)
+	}
+	pw, err := solveChecked(solver, compiled, private, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw, err := solveChecked(public, cr.GetLayeredCircuit(), transparent, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw.NumInputsPerWitness != NHashes*512 || pw.NumPublicInputsPerWitness != NHashes*CheckBits {
+		t.Fatalf("private variant has %d private and %d public inputs", pw.NumInputsPerWitness, pw.NumPublicInputsPerWitness)
+	}
+	if tw.NumInputsPerWitness != 0 || tw.NumPublicInputsPerWitness != NHashes*(CheckBits+512) {
+		t.Fatalf("public variant has %d private and %d public inputs", tw.NumInputsPerWitness, tw.NumPublicInputsPerWitness)
+	}
+
+	pd, err := verifier.PublicDigests(pw, private.layout())
+	if err != nil {
+		t.Fatal(err)
+	}
+	td, err := verifier.PublicDigests(tw, template.layout())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pd, td) {
+		t.Fatal("the two variants expose different digests")
+	}
+	messages, err := verifier.PublicMessages(tw, template.layout())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(messages[0], msgs) {
+		t.Fatal("public messages do not read back")
+	}
+	if _, err := verifier.PublicMessages(pw, private.layout()); err == nil {
+		t.Fatal("the private variant has no public messages")
+	}
+
+	if err := FlipInputBit(transparent, 3, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectVerdict(public, cr.GetLayeredCircuit(), transparent, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := Preflight(transparent); err == nil || !strings.Contains(err.Error(), "instance 3") {
+		t.Fatalf("preflight of a flipped public message: %v", err)
+	}
+}
+
+// TestAssignmentTypes assigns the same messages and digests as ints, bools, uint64s, *big.Ints, bytes and
+// 64-bit words, and solves and checks each: every form must pass preflight and the witness check without
+// the solver rewriting the caller's assignment (bools stay bools), and the assignment must fail with one
+// message bit flipped (FlipInputBit) and pass again with it flipped back.
+func TestAssignmentTypes(t *testing.T) {
+	msgs := randomMessages(1015, NHashes)
+	reference, err := assignMessages(msgs, nil, CheckBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digests := referenceDigests(nil, msgs)
+	convert := func(bit func(b int) frontend.Variable) *keccak256Circuit {
+		c := NewKeccak256Circuit(NHashes)
+		for k := range c.P {
+			for i, v := range reference.P[k] {
+				c.P[k][i] = bit(v.(int))
+			}
+			for i, v := range reference.Out[k] {
+				c.Out[k][i] = bit(v.(int))
+			}
+		}
+		return c
+	}
+	fromBytes := NewKeccak256Circuit(NHashes)
+	fromWords := NewKeccak256Circuit(NHashes)
+	for k, msg := range msgs {
+		words := make([]uint64, 8)
+		for i := range words {
+			words[i] = binary.LittleEndian.Uint64(msg[8*i:])
+		}
+		for _, err := range []error{
+			SetMessage(fromBytes, k, msg), SetDigest(fromBytes, k, digests[k][:]),
+			SetMessageWords(fromWords, k, words), SetDigest(fromWords, k, digests[k][:]),
+		} {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	forms := []struct {
+		name string
+		c    *keccak256Circuit
+	}{
+		{"int", reference},
+		{"bool", convert(func(b int) frontend.Variable { return b == 1 })},
+		{"uint64", convert(func(b int) frontend.Variable { return uint64(b) })},
+		{"*big.Int", convert(func(b int) frontend.Variable { return big.NewInt(int64(b)) })},
+		{"[]byte", fromBytes},
+		{"words", fromWords},
+	}
+	for _, f := range forms {
+		if err := Preflight(f.c); err != nil {
+			t.Fatalf("%s: %v", f.name, err)
+		}
+		typ := fmt.Sprintf("%T/%T", f.c.P[0][0], f.c.Out[0][0])
+		if err := expectVerdict(solver, compiled, f.c, true); err != nil {
+			t.Fatalf("%s: %v", f.name, err)
+		}
+		if solved := fmt.Sprintf("%T/%T", f.c.P[0][0], f.c.Out[0][0]); solved != typ {
+			t.Fatalf("%s: solving rewrote the caller's assignment from %s to %s", f.name, typ, solved)
+		}
+		for _, accept := range []bool{false, true} {
+			if err := FlipInputBit(f.c, 2, 300); err != nil {
+				t.Fatalf("%s: %v", f.name, err)
+			}
+			if err := expectVerdict(solver, compiled, f.c, accept); err != nil {
+				t.Fatalf("%s, bit flipped %v: %v", f.name, !accept, err)
+			}
+		}
+	}
+	if err := FlipInputBit(reference, NHashes, 0); err == nil {
+		t.Fatal("FlipInputBit accepted an instance out of range")
+	}
+	if err := SetMessage(reference, 0, msgs[0][:63]); err == nil {
+		t.Fatal("SetMessage accepted a 63-byte message")
+	}
+}
+
+// TestArtifactRouting compiles an artifact set for the empty message, one block and two blocks, and routes
+// one message of each size to its artifact through the manifest alone; a size without an artifact must
+// fail with an error naming it.
+func TestArtifactRouting(t *testing.T) {
+	dir := t.TempDir()
+	m, err := BuildArtifacts(context.Background(), dir, []int{200, 0, 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Sizes) != 3 || m.Sizes[0].Bytes != 0 || m.Sizes[2].Bytes != 200 {
+		t.Fatalf("manifest sizes %+v", m.Sizes)
+	}
+	set, err := LoadArtifacts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := seededReader(1016)
+	for _, n := range []int{64, 0, 200} {
+		msg := make([]byte, n)
+		rnd.Read(msg)
+		a, _, err := set.Solve(msg)
+		if err != nil {
+			t.Fatalf("%d-byte message: %v", n, err)
+		}
+		_, fp, err := verifier.LoadCircuit(filepath.Join(dir, sizedTargetName(n)+".circuit"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Bytes != n || a.Fingerprint != fp {
+			t.Fatalf("%d-byte message routed to %+v", n, a)
+		}
+	}
+	if _, _, err := set.Solve(make([]byte, 65)); err == nil || !strings.Contains(err.Error(), "no compiled artifact for 65-byte messages") {
+		t.Fatalf("routing a 65-byte message: %v", err)
+	}
+	if _, err := BuildArtifacts(context.Background(), t.TempDir(), []int{32, 32}); err == nil {
+		t.Fatal("BuildArtifacts accepted a duplicate size")
+	}
+}
+
+// fieldAPI runs Define on an assignment instead of building gates: every operation is evaluated on the
+// assigned values modulo field, and every failed assertion is recorded. Only the operations of
+// keccak256Circuit and FromBinary (for the digest limbs) are implemented.
+type fieldAPI struct {
+	frontend.API
+	field  *big.Int
+	failed []string
+}
+
+func (f *fieldAPI) Compiler() frontend.Compiler { return recordingCompiler{field: f.field} }
+
+func (f *fieldAPI) value(v frontend.Variable) *big.Int {
+	switch v := v.(type) {
+	case int:
+		return new(big.Int).Mod(big.NewInt(int64(v)), f.field)
+	case *big.Int:
+		return v
+	}
+	panic(fmt.Sprintf("fieldAPI: %T is not a value", v))
+}
+
+func (f *fieldAPI) fold(op func(z, x, y *big.Int) *big.Int, a, b frontend.Variable, in []frontend.Variable) frontend.Variable {
+	z := op(new(big.Int), f.value(a), f.value(b))
+	for _, v := range in {
+		op(z, z, f.value(v))
+	}
+	return z.Mod(z, f.field)
+}
+
+func (f *fieldAPI) Add(a, b frontend.Variable, in ...frontend.Variable) frontend.Variable {
+	return f.fold((*big.Int).Add, a, b, in)
+}
+
+func (f *fieldAPI) Sub(a, b frontend.Variable, in ...frontend.Variable) frontend.Variable {
+	return f.fold((*big.Int).Sub, a, b, in)
+}
+
+func (f *fieldAPI) Mul(a, b frontend.Variable, in ...frontend.Variable) frontend.Variable {
+	return f.fold((*big.Int).Mul, a, b, in)
+}
+
+func (f *fieldAPI) FromBinary(b ...frontend.Variable) frontend.Variable {
+	x := new(big.Int)
+	for i := len(b) - 1; i >= 0; i-- {
+		x.Lsh(x, 1).Add(x, f.value(b[i]))
+	}
+	return x.Mod(x, f.field)
+}
+
+func (f *fieldAPI) AssertIsEqual(a, b frontend.Variable) {
+	if f.value(a).Cmp(f.value(b)) != 0 {
+		f.failed = append(f.failed, fmt.Sprintf("%v != %v", f.value(a), f.value(b)))
+	}
+}
+
+func (f *fieldAPI) AssertIsBoolean(a frontend.Variable) {
+	if v := f.value(a); v.Sign() != 0 && v.Cmp(big.NewInt(1)) != 0 {
+		f.failed = append(f.failed, fmt.Sprintf("%v is not boolean", v))
+	}
+}
+
+// TestBooleanity checks where the booleanity assertions go. Over GF(2) they are never emitted (every
+// element is a bit), so the default circuit is the WithoutBooleanity one, and a non-boolean input is refused
+// by the solver as out of range. Over BN254 the default asserts every message and context bit boolean and
+// nothing else: a message bit of 2, with the digest the gadgets compute from it, is rejected by default and
+// accepted WithoutBooleanity, by the evaluated Define and by the compiled circuit alike.
+func TestBooleanity(t *testing.T) {
+	bn254 := ecc.BN254.ScalarField()
+	for _, contextBytes := range []int{0, 4} {
+		unchecked := newKeccak256Circuit(NHashes, contextBytes)
+		WithoutBooleanity()(unchecked)
+		for _, c := range []struct {
+			field    *big.Int
+			booleans int
+		}{{gf2.ScalarField, 0}, {bn254, NHashes*512 + 8*contextBytes}} {
+			checked, err := gateStats(c.field, newKeccak256Circuit(NHashes, contextBytes))
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain, err := gateStats(c.field, unchecked)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := *plain
+			want.Boolean = c.booleans
+			if plain.Boolean != 0 || *checked != want {
+				t.Fatalf("field %s, context %d bytes: booleanity costs %+v over %+v", c.field, contextBytes, *checked, *plain)
+			}
+		}
+	}
+
+	template := NewKeccak256Circuit(NHashes, WithoutBooleanity())
+	cr, err := compileCircuit(gf2.ScalarField, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifier.Fingerprint(cr.GetLayeredCircuit().Serialize()) != verifier.Fingerprint(compiled.Serialize()) {
+		t.Fatal("over GF(2), WithoutBooleanity compiles a different circuit")
+	}
+	assignment, err := randomAssignment(seededReader(1017), NHashes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bit := assignment.P[1][7]
+	assignment.P[1][7] = 2
+	if _, err := solver.SolveInput(assignment, 0); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Fatalf("solving a non-boolean input over GF(2): %v", err)
+	}
+	assignment.P[1][7] = bit
+
+	nonBoolean := func(opts ...CircuitOption) *keccak256Circuit {
+		a, err := randomAssignment(seededReader(1015), 1, nil, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.P[0][7] = 2
+		copy(a.Out[0], computeKeccak(&fieldAPI{field: bn254}, a.P[0][:]))
+		return a
+	}
+	for _, c := range []struct {
+		name   string
+		opts   []CircuitOption
+		accept bool
+	}{{"default", nil, false}, {"WithoutBooleanity", []CircuitOption{WithoutBooleanity()}, true}} {
+		a := nonBoolean(c.opts...)
+		api := &fieldAPI{field: bn254}
+		if err := a.Define(api); err != nil {
+			t.Fatal(err)
+		}
+		if accepted := len(api.failed) == 0; accepted != c.accept {
+			t.Fatalf("%s over BN254: Define on a message bit of 2 fails %v", c.name, api.failed)
+		}
+		if !c.accept && (len(api.failed) != 1 || api.failed[0] != "2 is not boolean") {
+			t.Fatalf("%s over BN254: Define on a message bit of 2 fails %v, want only its booleanity", c.name, api.failed)
+		}
+		cr, err := ecgo.Compile(bn254, NewKeccak256Circuit(1, c.opts...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		is := newCheckedSolver(cr.GetInputSolver(), bn254, NewKeccak256Circuit(1, c.opts...))
+		if err := expectVerdict(is, cr.GetLayeredCircuit(), a, c.accept); err != nil {
+			t.Fatalf("%s over BN254: %v", c.name, err)
+		}
+	}
+}
+
+// TestInputBitsPolicy checks that the circuits without the WithoutBooleanity option follow the same rule as
+// keccak256Circuit: no booleanity assertion over GF(2), one per bit through assertInputBits elsewhere.
+func TestInputBitsPolicy(t *testing.T) {
+	proof, err := LoadEthGetProof(filepath.Join("testdata", "storage_proof.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := storageProofAssignment(proof, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]frontend.Circuit{
+		"sha3":         NewSha3_224Circuit(2),
+		"keccak512":    &keccak512PairCircuit{},
+		"variants":     newVariantBatchCircuit([]string{"keccak256", "sha3-224"}),
+		"kmac":         newKmacCircuit(32, 16, 256, []byte("S")),
+		"substring":    newSubstringCircuit(16, 2),
+		"sha256":       NewSha256Circuit(1),
+		"keccakmac":    newKeccakMACCircuit(32, 16),
+		"storage":      newStorageProofCircuit(storage.Shape),
+		"keccak256":    NewKeccak256Circuit(2),
+		"with-context": newKeccak256Circuit(2, 4),
+	} {
+		stats, err := gateStats(gf2.ScalarField, c)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if stats.Boolean != 0 {
+			t.Fatalf("%s asserts %d bits boolean over GF(2)", name, stats.Boolean)
+		}
+	}
+	stats := &GateStats{}
+	assertInputBits(&recordingAPI{field: ecc.BN254.ScalarField(), stats: stats}, symbolicBits(24))
+	assertInputBits(&recordingAPI{field: gf2.ScalarField, stats: stats}, symbolicBits(24))
+	if stats.Boolean != 24 {
+		t.Fatalf("assertInputBits asserted %d of 24 bits boolean over BN254 and 24 over GF(2)", stats.Boolean)
+	}
+}
+
+// publicInputConfigs are the configurations pinned by testdata/public_inputs.json: each returns the
+// circuit to compile and a valid assignment of it.
+func publicInputConfigs(t *testing.T) map[string]func() (frontend.Circuit, frontend.Circuit) {
+	msgs := randomMessages(1018, NHashes)
+	context := []byte("public-input count pinned by 32B")
+	assign := func(context []byte, checkBits int) *keccak256Circuit {
+		a, err := assignMessages(msgs, context, checkBits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	return map[string]func() (frontend.Circuit, frontend.Circuit){
+		"plain": func() (frontend.Circuit, frontend.Circuit) {
+			return NewKeccak256Circuit(NHashes), assign(nil, CheckBits)
+		},
+		"truncated-128": func() (frontend.Circuit, frontend.Circuit) {
+			return NewTruncatedKeccak256Circuit(NHashes, 128), assign(nil, 128)
+		},
+		"context-32": func() (frontend.Circuit, frontend.Circuit) {
+			return newKeccak256Circuit(NHashes, len(context)), assign(context, CheckBits)
+		},
+		"public-messages": func() (frontend.Circuit, frontend.Circuit) {
+			return NewPublicInputKeccak256Circuit(NHashes), assign(nil, CheckBits).withPublicMessages()
+		},
+		"public-messages-context-32": func() (frontend.Circuit, frontend.Circuit) {
+			return newKeccak256Circuit(NHashes, len(context)).withPublicMessages(), assign(context, CheckBits).withPublicMessages()
+		},
+		"distinct": func() (frontend.Circuit, frontend.Circuit) {
+			c := NewKeccak256Circuit(NHashes)
+			c.Distinct = true
+			return c, assign(nil, CheckBits)
+		},
+		"no-booleanity": func() (frontend.Circuit, frontend.Circuit) {
+			return NewKeccak256Circuit(NHashes, WithoutBooleanity()), assign(nil, CheckBits)
+		},
+		"inlined": func() (frontend.Circuit, frontend.Circuit) {
+			return NewKeccak256Circuit(NHashes, WithInlinedKeccak()), assign(nil, CheckBits)
+		},
+		"sha3": func() (frontend.Circuit, frontend.Circuit) {
+			a, err := assignMessages(msgs, nil, CheckBits, WithSHA3())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return NewKeccak256Circuit(NHashes, WithSHA3()), a
+		},
+		"merkle-root": func() (frontend.Circuit, frontend.Circuit) {
+			a, _, err := randomMerkleAssignment(seededReader(1018))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return &keccakMerkleCircuit{}, a
+		},
+	}
+}
+
+// TestPublicInputCount compiles every supported configuration and compares the public inputs of a solved
+// witness with testdata/public_inputs.json. External verifiers hardcode these counts: a change here must
+// go out together with them, and the table is only updated then.
+func TestPublicInputCount(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "public_inputs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pinned map[string]int
+	if err := json.Unmarshal(raw, &pinned); err != nil {
+		t.Fatal(err)
+	}
+	configs := publicInputConfigs(t)
+	for name := range pinned {
+		if configs[name] == nil {
+			t.Errorf("testdata/public_inputs.json pins %q, which is not a configuration any more", name)
+		}
+	}
+	for name, build := range configs {
+		t.Run(name, func(t *testing.T) {
+			want, ok := pinned[name]
+			if !ok {
+				t.Fatalf("configuration %q has no pinned public-input count in testdata/public_inputs.json", name)
+			}
+			circuit, assignment := build()
+			cr, err := compileCircuit(gf2.ScalarField, circuit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			is := newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, circuit)
+			w, err := solveChecked(is, cr.GetLayeredCircuit(), assignment, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := int(w.NumPublicInputsPerWitness); got != want {
+				t.Fatalf("configuration %q now has %d public inputs, %+d from the %d pinned in testdata/public_inputs.json; deployed verifiers hardcode that count",
+					name, got, got-want, want)
+			}
+		})
+	}
+}
+
+var updateGolden = flag.Bool("update-golden", false, "rewrite the current-version goldens in testdata/golden")
+
+// goldenStats is the fixed stats.json artifact of the golden files.
+func goldenStats() *SolveStats {
+	return &SolveStats{
+		Version: StatsVersion, Assignments: 3, Instances: 8, Messages: 21, ContextBytes: 4,
+		Chunks: []ChunkStats{
+			{File: "witness-0000.env", BuildNs: []int64{1200, 1300}, SolveNs: []int64{25000, 25000}},
+			{File: "witness-0001.env", BuildNs: []int64{1250}, SolveNs: []int64{26000}},
+		},
+		FirstEstimateNs: 77250, TotalNs: 80000,
+	}
+}
+
+// goldenFormats are the versioned artifact formats (see formats.go): for each, its current version, the
+// file extension and the serialization of a fixed artifact. The envelope, layout and verdict are built by
+// hand rather than from a solve, so that only their formats are pinned. The circuit golden pins the gates
+// this package emits for one instance, as the counts of the recording API: the layered bytes are ecgo's.
+func goldenFormats(t *testing.T) map[string]struct {
+	version int
+	ext     string
+	build   func() []byte
+} {
+	fingerprint := strings.Repeat("ab", 32)
+	marshal := func(v interface{}) []byte {
+		raw, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	return map[string]struct {
+		version int
+		ext     string
+		build   func() []byte
+	}{
+		"envelope": {verifier.EnvelopeVersion, "env", func() []byte {
+			var values []*big.Int
+			for _, v := range []int64{1, 0, 1, 1, 0, 0, 1, 1, 0, 1} {
+				values = append(values, big.NewInt(v))
+			}
+			w := &irwg.Witness{NumWitnesses: 2, NumInputsPerWitness: 3, NumPublicInputsPerWitness: 2, Field: big.NewInt(2), Values: values}
+			return (&verifier.Envelope{Indices: []int{3, 7}, Witness: w}).Serialize()
+		}},
+		"layout": {verifier.DescriptorVersion, "json", func() []byte {
+			return marshal(verifier.Layout{Instances: 2, CheckBits: 8, ContextBytes: 1}.Describe(fingerprint))
+		}},
+		"verdict": {verifier.VerdictVersion, "json", func() []byte {
+			return marshal(&verifier.Verdict{
+				Version: verifier.VerdictVersion, Circuit: fingerprint,
+				Envelope: verifier.EnvelopeInfo{Path: "witness.env", Assignments: 2, Inputs: 3, PublicInputs: 2},
+				Total:    2, Passed: 1, Failed: []int{7}, WallTimeMs: 12,
+			})
+		}},
+		"stats": {StatsVersion, "json", func() []byte {
+			raw, err := goldenStats().Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return raw
+		}},
+		"circuit": {CircuitVersion, "json", func() []byte {
+			stats, err := gateStats(gf2.ScalarField, NewKeccak256Circuit(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return marshal(stats)
+		}},
+	}
+}
+
+// TestGoldenFormats serializes the fixed artifact of every format and compares it byte for byte with
+// testdata/golden/<format>.v<version>.<ext>: a format must not change without a version bump. With
+// -update-golden it writes the goldens of the current versions instead.
+func TestGoldenFormats(t *testing.T) {
+	for name, f := range goldenFormats(t) {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join("testdata", "golden", fmt.Sprintf("%s.v%d.%s", name, f.version, f.ext))
+			got := f.build()
+			if *updateGolden {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v (a version bump needs a new golden: go test -run TestGoldenFormats -update-golden)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("the %s format changed without a version bump: the serialization differs from %s; bump its version constant, register an upgrader from version %d and write the new golden with -update-golden",
+					name, path, f.version)
+			}
+		})
+	}
+}
+
+// TestFormatMigration reads the goldens of every older stats version through the registered upgraders
+// (version 1 predates the version field), and checks that versions without an upgrader path are refused
+// rather than misread. Every envelope version reads as the same envelope, and newer ones are refused.
+func TestFormatMigration(t *testing.T) {
+	for v := 1; v <= StatsVersion; v++ {
+		s, err := LoadSolveStats(filepath.Join("testdata", "golden", fmt.Sprintf("stats.v%d.json", v)))
+		if err != nil {
+			t.Fatalf("stats version %d: %v", v, err)
+		}
+		if !reflect.DeepEqual(s, goldenStats()) {
+			t.Fatalf("stats version %d reads as %+v, expected %+v", v, s, goldenStats())
+		}
+	}
+	if _, err := ParseSolveStats([]byte(fmt.Sprintf(`{"version": %d}`, StatsVersion+1))); err == nil {
+		t.Fatal("a stats file newer than this build is accepted")
+	}
+	if _, err := upgradeArtifact("stats", nil, 0, StatsVersion); err == nil {
+		t.Fatal("upgrading stats from version 0, which has no upgrader, succeeds")
+	}
+
+	var envelopes []*verifier.Envelope
+	for v := 1; v <= verifier.EnvelopeVersion; v++ {
+		raw, err := os.ReadFile(filepath.Join("testdata", "golden", fmt.Sprintf("envelope.v%d.env", v)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := verifier.ParseEnvelope(raw)
+		if err != nil {
+			t.Fatalf("envelope version %d: %v", v, err)
+		}
+		envelopes = append(envelopes, e)
+	}
+	for v, e := range envelopes {
+		if !reflect.DeepEqual(e, envelopes[0]) {
+			t.Fatalf("envelope version %d reads as %+v, version 1 as %+v", v+1, e, envelopes[0])
+		}
+	}
+	// the version is a number: one past this build, and one that no longer fits a digit, are refused
+	for _, v := range []uint32{verifier.EnvelopeVersion + 1, 10} {
+		raw := envelopes[0].Serialize()
+		binary.LittleEndian.PutUint32(raw[4:], v)
+		if _, err := verifier.ParseEnvelope(raw); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("version %d", v)) {
+			t.Fatalf("an envelope of version %d parses (%v)", v, err)
+		}
+	}
+}
+
+// TestMalformedEnvelope checks that ParseEnvelope refuses every truncation of a valid envelope and counts
+// that the envelope cannot hold, instead of allocating for them.
+func TestMalformedEnvelope(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "golden", fmt.Sprintf("envelope.v%d.env", verifier.EnvelopeVersion)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := range raw {
+		if _, err := verifier.ParseEnvelope(raw[:n]); err == nil {
+			t.Fatalf("the first %d of %d bytes of an envelope parse", n, len(raw))
+		}
+	}
+	header := binary.LittleEndian.AppendUint32([]byte("KWEV"), verifier.EnvelopeVersion)
+	huge := binary.LittleEndian.AppendUint32(nil, 0xFFFFFFFF)
+	for name, b := range map[string][]byte{
+		"indices": append(append([]byte{}, header...), huge...),
+		// one index, no inputs, field 2, then the value count
+		"values": append(append(append([]byte{}, header...), 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2), huge...),
+	} {
+		if _, err := verifier.ParseEnvelope(b); err == nil || !strings.Contains(err.Error(), "truncated") {
+			t.Fatalf("an envelope claiming %d %s: %v", uint32(0xFFFFFFFF), name, err)
+		}
+	}
+}
+
+// TestRoundTrace traces one instance of a solved witness off the compiled circuit and diffs the printed
+// trace with the reference one round by round; the last round must be the permutation of the padded
+// block where the circuit carries its bits. The SHA3-256 circuit of the same layout must not carry the
+// Keccak-256 rounds. Inverting the linear steps must give back the state, and an observer must not change
+// the gates keccakF emits.
+func TestRoundTrace(t *testing.T) {
+	msgs := randomMessages(1019, NHashes)
+	assignment, err := assignMessages(msgs, nil, CheckBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := solveChecked(solver, compiled, assignment, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	traces, err := TraceWitness(compiled, w, assignment.layout(), 0, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) != 1 || len(traces[0]) != 24 {
+		t.Fatalf("a 64-byte message should give one permutation of 24 rounds, got %d traces", len(traces))
+	}
+	var block [25]uint64
+	padded := append(append([]byte{}, msgs[5]...), make([]byte, 72)...)
+	padded[64] ^= DomainKeccak
+	padded[135] ^= 0x80
+	for i := 0; i < 17; i++ {
+		block[i] = binary.LittleEndian.Uint64(padded[8*i:])
+	}
+	ref := RefRoundTrace(block)
+	last := traces[0][23]
+	for i := range ref[23].Lanes {
+		ref[23].Lanes[i] &^= last.Missing[i]
+	}
+	ref[23].Missing = last.Missing
+	t.Logf("%d bits of the last round are not wires of the circuit", onesCount(last.Missing))
+	var got, want strings.Builder
+	if err := WriteRoundTrace(&got, traces[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteRoundTrace(&want, ref); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Fatalf("circuit trace differs from the reference:\n%s\nreference:\n%s", got.String(), want.String())
+	}
+	perm := keccakF1600Ref(block)
+	for i := range perm {
+		if last.Lanes[i] != perm[i]&^last.Missing[i] {
+			t.Fatal("the last traced round is not the permutation")
+		}
+	}
+
+	sha3, err := compileCircuit(gf2.ScalarField, NewKeccak256Circuit(NHashes, WithSHA3()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TraceWitness(sha3.GetLayeredCircuit(), w, assignment.layout(), 0, 5); err == nil || !strings.Contains(err.Error(), "round 0:") {
+		t.Fatalf("tracing Keccak-256 rounds on the SHA3-256 circuit: %v", err)
+	}
+
+	rnd := rand.New(rand.NewSource(1016))
+	for n := 0; n < 100; n++ {
+		var a [25]uint64
+		for i := range a {
+			a[i] = rnd.Uint64()
+		}
+		if thetaInverseRef(rhoPiInverseRef(thetaRhoPiRef(a))) != a {
+			t.Fatalf("inverting θ, ρ and π of %x", a)
+		}
+	}
+
+	state := symbolicState()
+	plain, observed := &GateStats{}, &GateStats{}
+	keccakF(&recordingAPI{field: gf2.ScalarField, stats: plain}, state)
+	rounds := 0
+	keccakF(&recordingAPI{field: gf2.ScalarField, stats: observed}, state, WithRoundObserver(func(int, [][]frontend.Variable) { rounds++ }))
+	if *plain != *observed || rounds != 24 {
+		t.Fatalf("observing %d rounds changed keccakF from %+v to %+v", rounds, *plain, *observed)
+	}
+}
+
+// helperHasher serves the external hasher protocol: "good" with ServeHashLines, "broken" with the digest
+// of line 3 off by one bit.
+func helperHasher(mode string) error {
+	if mode == "good" {
+		return ServeHashLines(os.Stdin, os.Stdout)
+	}
+	lines := bufio.NewScanner(os.Stdin)
+	for n := 0; lines.Scan(); n++ {
+		msg, err := hex.DecodeString(lines.Text())
+		if err != nil {
+			return err
+		}
+		digest := crypto.Keccak256(msg)
+		if n == 3 {
+			digest[0] ^= 1
+		}
+		fmt.Printf("%x\n", digest)
+	}
+	return lines.Err()
+}
+
+// TestExternalCrossCheck streams the messages of a solved assignment through the test binary acting as
+// the external hasher: a correct hasher agrees on every digest, a hasher that is wrong on one message and
+// a corrupted circuit digest are each reported for that message only.
+func TestExternalCrossCheck(t *testing.T) {
+	msgs := randomMessages(1020, NHashes)
+	assignment, err := assignMessages(msgs, nil, CheckBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := solveChecked(solver, compiled, assignment, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digests, err := verifier.PublicDigests(w, assignment.layout())
+	if err != nil {
+		t.Fatal(err)
+	}
+	crossCheck := func(mode string, circuit [][]int) []Disagreement {
+		t.Setenv("KECCAK_GF2_HASHER", mode)
+		h, err := StartExternalHasher([]string{os.Args[0]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := CrossCheck(h, msgs, circuit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	if d := crossCheck("good", digests[0]); len(d) != 0 {
+		t.Fatalf("a correct hasher disagrees: %v", d)
+	}
+	if d := crossCheck("broken", digests[0]); len(d) != 1 || d[0].Message != 3 || d[0].External == d[0].Reference || d[0].Circuit != d[0].Reference {
+		t.Fatalf("a hasher wrong on message 3: %v", d)
+	}
+	digests[0][5][17] ^= 1
+	if d := crossCheck("good", digests[0]); len(d) != 1 || d[0].Message != 5 || d[0].External != d[0].Reference || d[0].Circuit == d[0].Reference {
+		t.Fatalf("a corrupted circuit digest of message 5: %v", d)
+	}
+}
+
+// TestWitnessDiff writes the same seeded batch twice and a copy with one Out bit flipped: identical
+// envelopes give no output, and the flipped one is reported at its exact assignment, position and field.
+func TestWitnessDiff(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, flip bool) string {
+		rnd := seededReader(1021)
+		assignments := make([]frontend.Circuit, 4)
+		indices := make([]int, len(assignments))
+		for z := range assignments {
+			a, err := randomAssignment(rnd, NHashes, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if flip && z == 2 {
+				a.Out[6][200] = 1 - a.Out[6][200].(int)
+			}
+			assignments[z], indices[z] = a, 10+z
+		}
+		// the flipped digest fails the check, not the solver
+		env, err := solveBatch(solver, assignments, indices)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, env.Serialize(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	defer func(skip bool) { skipPreflight = skip }(skipPreflight)
+	skipPreflight = true
+	a, b, flipped := write("a.env", false), write("b.env", false), write("flipped.env", true)
+	names := WitnessNames(Options{Instances: NHashes})
+
+	var out strings.Builder
+	same, err := DiffWitnessFiles(&out, a, b, names, true)
+	if err != nil || !same || out.Len() != 0 {
+		t.Fatalf("identical witnesses: same %v, output %q, %v", same, out.String(), err)
+	}
+	same, err = DiffWitnessFiles(&out, a, flipped, names, false)
+	if err != nil || same {
+		t.Fatalf("flipped witness: same %v, %v", same, err)
+	}
+	want := fmt.Sprintf("public input: assignment 2 (index 12), position %d (Out[6][200])", NHashes*512+6*CheckBits+200)
+	if !strings.Contains(out.String(), want) {
+		t.Fatalf("flipped witness reported as %q, expected %q", out.String(), want)
+	}
+}
+
+// TestHexVectors builds assignments from hex test vectors and reads the digests back as hex: 64-byte
+// messages through AssignmentFromHex and the batch circuit (the all-zero one against KeccakZero64), the
+// canonical empty-string and "abc" vectors through the multi-size circuit, and malformed hex is refused.
+func TestHexVectors(t *testing.T) {
+	msgHex := make([]string, NHashes)
+	msgHex[0] = strings.Repeat("00", 64)
+	for k, msg := range randomMessages(1022, NHashes)[1:] {
+		msgHex[k+1] = "0x" + hex.EncodeToString(msg)
+	}
+	assignment, err := AssignmentFromHex(msgHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := solveChecked(solver, compiled, assignment, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digests, err := verifier.PublicDigests(w, assignment.layout())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, m := range msgHex {
+		msg, _ := hex.DecodeString(strings.TrimPrefix(m, "0x"))
+		want := hex.EncodeToString(crypto.Keccak256(msg))
+		if k == 0 && "0x"+want != KeccakZero64 {
+			t.Fatalf("go-ethereum disagrees with KeccakZero64: %s", want)
+		}
+		assigned, err := DigestHex(assignment.Out[k])
+		if err != nil {
+			t.Fatal(err)
+		}
+		solved, err := DigestBitsHex(digests[0][k])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if assigned != want || solved != want {
+			t.Fatalf("message %d: assigned %s, solved %s, expected %s", k, assigned, solved, want)
+		}
+	}
+
+	vectors := []struct{ msg, digest string }{
+		{"", "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{hex.EncodeToString([]byte("abc")), "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+	}
+	sizes := []int{0, 3}
+	cr, err := compileCircuit(gf2.ScalarField, newKeccakMultiSizeCircuit(sizes, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	multi := newKeccakMultiSizeCircuit(sizes, false)
+	for i, v := range vectors {
+		msg, err := decodeHex(v.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest, err := decodeHex(v.digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		putBits(multi.P[i], msg)
+		putBits(multi.Out[i][:], digest)
+	}
+	mw, err := solveChecked(cr.GetInputSolver(), cr.GetLayeredCircuit(), multi, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	solved, err := verifier.PublicDigests(mw, verifier.Layout{Instances: len(sizes), CheckBits: 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range vectors {
+		if got, err := DigestBitsHex(solved[0][i]); err != nil || got != v.digest {
+			t.Fatalf("Keccak-256(%q) reads back as %s (%v), expected %s", v.msg, got, err, v.digest)
+		}
+	}
+
+	for _, bad := range []struct{ hex, err string }{
+		{"abc", "not a whole number of bytes"},
+		{"0x" + strings.Repeat("0", 126) + "zz", `'z' is not a hex digit (position 126)`},
+		{strings.Repeat("00", 63), "is 63 bytes, expected 64"},
+	} {
+		in := append([]string{}, msgHex...)
+		in[2] = bad.hex
+		if _, err := AssignmentFromHex(in); err == nil || !strings.Contains(err.Error(), bad.err) {
+			t.Fatalf("AssignmentFromHex(%q): %v, expected %q", bad.hex, err, bad.err)
+		}
+	}
+	if _, err := DigestBitsHex([]int{0, 1, 2}); err == nil {
+		t.Fatal("DigestBitsHex accepted a 2")
+	}
+}
+
+// TestRandomAssignmentHex checks the main assignment path, which builds random assignments from hex through
+// AssignmentFromHex: with and without a context and SHA3-256 digests, it assigns exactly what assignMessages
+// does for the same messages.
+func TestRandomAssignmentHex(t *testing.T) {
+	for _, c := range []struct {
+		context []byte
+		opts    []CircuitOption
+	}{{nil, nil}, {[]byte("hex 1017"), nil}, {[]byte("hex 1017"), []CircuitOption{WithSHA3()}}} {
+		got, err := randomAssignment(seededReader(1017), 4, c.context, c.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := assignMessages(randomMessages(1017, 4), c.context, CheckBits, c.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.P, want.P) || !reflect.DeepEqual(got.Out, want.Out) || !reflect.DeepEqual(got.Context, want.Context) || got.SHA3 != want.SHA3 {
+			t.Fatalf("context %q, %d options: randomAssignment and assignMessages differ", c.context, len(c.opts))
+		}
+	}
+}
+
+// lowMemoryInstances is the batch size of TestLowMemoryCompile's peak-RSS comparison.
+const lowMemoryInstances = 8
+
+// helperCompile compiles a lowMemoryInstances batch the way -low-memory says ("low" or "normal") in a
+// temporary directory and prints the peak resident set size of the process in bytes.
+func helperCompile(mode string) error {
+	dir, err := os.MkdirTemp("", "keccak-lowmem")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	lowMemory = mode == "low"
+	if _, err := compileBuilt(gf2.ScalarField, NewKeccak256Circuit(lowMemoryInstances), dir, mode); err != nil {
+		return err
+	}
+	rss, ok := vmHWM()
+	if !ok {
+		return fmt.Errorf("no VmHWM in /proc/self/status")
+	}
+	fmt.Println(rss)
+	return nil
+}
+
+// TestLowMemoryCompile compiles a batch normally and in low-memory mode, each in a fresh process, and
+// checks that the per-instance build peaks at a lower resident set size. In-process it checks that the
+// assembled circuit keeps the public inputs of the whole compile, accepts correct batches and rejects a
+// wrong digest in any instance, that assembling again from the pieces on disk gives the same circuit,
+// that a batch with distinct digests falls back to a whole compile, and that a low-memory build matrix
+// keeps nothing in memory.
+func TestLowMemoryCompile(t *testing.T) {
+	if _, ok := vmHWM(); !ok {
+		t.Skip("needs /proc/self/status")
+	}
+	peak := map[string]uint64{}
+	for _, mode := range []string{"normal", "low"} {
+		cmd := exec.Command(os.Args[0], "-test.run=^$")
+		cmd.Env = append(os.Environ(), "KECCAK_GF2_COMPILE="+mode)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%s compile: %v", mode, err)
+		}
+		if peak[mode], err = strconv.ParseUint(strings.TrimSpace(string(out)), 10, 64); err != nil {
+			t.Fatalf("%s compile printed %q", mode, out)
+		}
+	}
+	t.Logf("%d instances: peak RSS %d MiB normal, %d MiB low-memory", lowMemoryInstances, peak["normal"]>>20, peak["low"]>>20)
+	if peak["low"] >= peak["normal"] {
+		t.Fatalf("low-memory compile peaked at %d bytes, the normal one at %d", peak["low"], peak["normal"])
+	}
+
+	normal, err := compileBuilt(gf2.ScalarField, NewKeccak256Circuit(4), "", "normal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(m bool) { lowMemory = m }(lowMemory)
+	lowMemory = true
+	dir := t.TempDir()
+	low, err := compileBuilt(gf2.ScalarField, NewKeccak256Circuit(4), dir, "low")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := low.Solver.(*assembledSolver); !ok {
+		t.Fatalf("low-memory build of 4 instances has a %T, expected an assembled build", low.Solver)
+	}
+	if low.Circuit.NumPublicInputs != normal.Circuit.NumPublicInputs {
+		t.Fatalf("assembled circuit has %d public inputs, the whole compile %d", low.Circuit.NumPublicInputs, normal.Circuit.NumPublicInputs)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "low.instance-0.circuit")); err != nil {
+		t.Fatalf("the instance piece is not on disk: %v", err)
+	}
+	is := newCheckedSolver(low.Solver, gf2.ScalarField, NewKeccak256Circuit(4))
+	assignments := make([]frontend.Circuit, 3)
+	indices := make([]int, len(assignments))
+	for z := range assignments {
+		a, err := randomAssignment(seededReader(int64(18+z)), 4, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assignments[z], indices[z] = a, z
+	}
+	env, err := solveBatch(is, assignments, indices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expectBatch(low.Circuit, env); err != nil {
+		t.Fatal(err)
+	}
+	for k := 0; k < 4; k++ {
+		a, err := randomAssignment(seededReader(18), 4, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Out[k][7] = 1 - a.Out[k][7].(int)
+		if err := expectVerdict(is, low.Circuit, a, false); err != nil {
+			t.Fatalf("wrong digest in instance %d: %v", k, err)
+		}
+	}
+	again, err := (&instanceBuild{Field: gf2.ScalarField, Dir: dir, Name: "low", Done: map[string]string{"low.instance-0": low.Solver.(*assembledSolver).pieces[0].built.Fingerprint},
+		Before: func(piece string) error { return fmt.Errorf("%s compiled again", piece) }}).run(NewKeccak256Circuit(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Fingerprint != low.Fingerprint {
+		t.Fatalf("reassembled fingerprint %s, first assembly %s", again.Fingerprint, low.Fingerprint)
+	}
+
+	distinctBatch := func() *keccak256Circuit {
+		c := NewKeccak256Circuit(2)
+		c.Distinct = true
+		return c
+	}
+	distinct, err := compileBuilt(gf2.ScalarField, distinctBatch(), dir, "distinct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lowMemory = false
+	whole, err := compileBuilt(gf2.ScalarField, distinctBatch(), "", "whole")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if distinct.Fingerprint != whole.Fingerprint {
+		t.Fatalf("distinct digests: low-memory fingerprint %s, normal %s", distinct.Fingerprint, whole.Fingerprint)
+	}
+	lowMemory = true
+
+	built, err := (&checkpointedBuild{Dir: filepath.Join(dir, "matrix"), Field: gf2.ScalarField}).Run(context.Background(),
+		[]buildTarget{{Name: "keccak-2", Circuit: NewKeccak256Circuit(2)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := built["keccak-2"]; b.Fingerprint == "" || b.Circuit != nil || b.Solver != nil {
+		t.Fatalf("low-memory build matrix kept %+v, expected a fingerprint only", b)
+	}
+}
+
+// TestMemoizedCompile compiles NHashes and 64 instances inlined and memoized, logs compile time and
+// serialized size of both, and checks that the memoized circuit, also after a serialization round trip,
+// accepts a batch of correct assignments and rejects a flipped message bit. The 64-instance inlined build is
+// skipped with -short.
+func TestMemoizedCompile(t *testing.T) {
+	for _, n := range []int{NHashes, 64} {
+		sizes := map[bool]int{}
+		for _, inlined := range []bool{true, false} {
+			if inlined && n > NHashes && testing.Short() {
+				continue
+			}
+			var opts []CircuitOption
+			if inlined {
+				opts = append(opts, WithInlinedKeccak())
+			}
+			start := time.Now()
+			cr, err := compileCircuit(gf2.ScalarField, NewKeccak256Circuit(n, opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			elapsed := time.Since(start)
+			serialized := cr.GetLayeredCircuit().Serialize()
+			sizes[inlined] = len(serialized)
+			t.Logf("%d instances, inlined %v: compiled in %v, %d bytes serialized", n, inlined, elapsed.Round(time.Millisecond), len(serialized))
+			if inlined {
+				continue
+			}
+
+			c := ecgo.DeserializeLayeredCircuit(serialized)
+			is := newCheckedSolver(ecgo.DeserializeInputSolver(cr.GetInputSolver().Serialize()), gf2.ScalarField, NewKeccak256Circuit(n))
+			assignments := make([]frontend.Circuit, 4)
+			indices := make([]int, len(assignments))
+			for z := range assignments {
+				if assignments[z], err = randomAssignment(seededReader(int64(1018+z)), n, nil); err != nil {
+					t.Fatal(err)
+				}
+				indices[z] = z
+			}
+			env, err := solveBatch(is, assignments, indices)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := expectBatch(c, env); err != nil {
+				t.Fatalf("%d memoized instances: %v", n, err)
+			}
+			wrong := assignments[0].(*keccak256Circuit)
+			if err := FlipInputBit(wrong, n-1, 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := expectVerdict(is, c, wrong, false); err != nil {
+				t.Fatalf("%d memoized instances: %v", n, err)
+			}
+		}
+		if inlined, ok := sizes[true]; ok {
+			t.Logf("%d instances: memoized circuit is %.1f%% of the inlined size", n, 100*float64(sizes[false])/float64(inlined))
+		}
+	}
+}
+
+// TestBitReference runs random states through keccakP, evaluated through the recording API where
+// every wire folds to a bit, and through the bit-level reference of bitref.go, and compares them after
+// every step of every round and after the last round, also against the lane references: 1000 states of
+// Keccak-f[200] (8-bit lanes, 18 rounds, milliseconds) against keccakFLanesRef, then 100 of keccakF against
+// keccakF1600Ref. bitKeccak256 must then agree with x/crypto on full hashes across block boundaries.
+func TestBitReference(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1019))
+	toBits := func(state [][]frontend.Variable) (bitState, error) {
+		var a bitState
+		for i, lane := range state {
+			for j, v := range lane {
+				b, ok := assignedBit(v)
+				if !ok {
+					return a, fmt.Errorf("lane %d bit %d is %v, not a constant bit", i, j, v)
+				}
+				a[i][j] = b
+			}
+		}
+		return a, nil
+	}
+	for _, width := range []struct{ w, states int }{{8, 1000}, {64, 100}} {
+		w := width.w
+		rounds := 12 + 2*bits.TrailingZeros(uint(w))
+		for n := 0; n < width.states; n++ {
+			var lanes [25]uint64
+			in := make([][]frontend.Variable, 25)
+			for i := range lanes {
+				lanes[i] = rnd.Uint64() & (uint64(1)<<w - 1)
+				in[i] = bitsOf(binary.LittleEndian.AppendUint64(nil, lanes[i]))[:w]
+			}
+			ref := bitStateFromLanes(lanes)
+			steps := map[string]func(bitState, int) bitState{
+				"theta": func(a bitState, _ int) bitState { return bitTheta(a, w) },
+				"rhoPi": func(a bitState, _ int) bitState { return bitRhoPi(a, w) },
+				"chi":   func(a bitState, _ int) bitState { return bitChi(a) },
+				"iota":  func(a bitState, round int) bitState { return bitIota(a, round, w) },
+			}
+			var failure error
+			observed := 0
+			eval := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+			out := keccakP(eval, ConvertState(in, LayoutSpec, LayoutInternal), 0, rounds, WithStepObserver(func(round int, step string, state [][]frontend.Variable) {
+				if failure != nil {
+					return
+				}
+				observed++
+				ref = steps[step](ref, round)
+				got, err := toBits(state)
+				if err != nil {
+					failure = fmt.Errorf("round %d, after %s: %w", round, step, err)
+				} else if got != ref {
+					failure = fmt.Errorf("round %d, after %s: circuit and bit reference differ", round, step)
+				}
+			}))
+			if failure != nil {
+				t.Fatalf("w = %d, state %d: %v", w, n, failure)
+			}
+			if observed != 4*rounds {
+				t.Fatalf("w = %d, state %d: observed %d steps, expected %d", w, n, observed, 4*rounds)
+			}
+			got, err := toBits(out)
+			if err != nil {
+				t.Fatalf("w = %d, state %d: %v", w, n, err)
+			}
+			if full := bitKeccakF(bitStateFromLanes(lanes), w); got != full || full != ref {
+				t.Fatalf("w = %d, state %d: keccakP and bitKeccakF differ after %d rounds", w, n, rounds)
+			}
+			want := keccakFLanesRef(w, lanes)
+			if w == 64 {
+				want = keccakF1600Ref(lanes)
+			}
+			if got.lanes() != want {
+				t.Fatalf("w = %d, state %d: keccakP and the lane reference differ after %d rounds", w, n, rounds)
+			}
+		}
+	}
+
+	for _, n := range []int{0, 1, 64, 135, 136, 137, 271, 272, 500} {
+		msg := make([]byte, n)
+		rnd.Read(msg)
+		h := sha3.NewLegacyKeccak256()
+		h.Write(msg)
+		if want := h.Sum(nil); !bytes.Equal(bitKeccak256(msg), want) {
+			t.Fatalf("bitKeccak256 of %d bytes is %x, x/crypto says %x", n, bitKeccak256(msg), want)
+		}
+	}
+}
+
+// TestAggregatedOutput builds a 2-instance circuit with per-bit, per-instance and global output assertions,
+// logs their gate statistics, and checks that a solved witness of each aggregated circuit is rejected
+// after flipping any single digest bit of any instance, which a masking aggregation would let through.
+func TestAggregatedOutput(t *testing.T) {
+	const n = 2
+	modes := []struct {
+		name    string
+		mode    OutputAssertion
+		asserts int
+	}{
+		{"each bit", AssertEachBit, n * CheckBits},
+		{"per instance", AggregatePerInstance, n},
+		{"global", AggregateGlobal, 1},
+	}
+	assignment, err := randomAssignment(seededReader(1020), n, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := digestLayout(n, 0)
+	for _, m := range modes {
+		stats, err := gateStats(gf2.ScalarField, NewKeccak256Circuit(n, WithOutputAssertion(m.mode)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("%s: %+v", m.name, *stats)
+		if stats.Assert != m.asserts {
+			t.Fatalf("%s: %d assertions, expected %d", m.name, stats.Assert, m.asserts)
+		}
+		if m.mode == AssertEachBit {
+			continue
+		}
+		cr, err := compileCircuit(gf2.ScalarField, NewKeccak256Circuit(n, WithOutputAssertion(m.mode)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := cr.GetLayeredCircuit()
+		w, err := solveChecked(newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, NewKeccak256Circuit(n)), c, assignment, true)
+		if err != nil {
+			t.Fatalf("%s: %v", m.name, err)
+		}
+		for k := 0; k < n; k++ {
+			for i := 0; i < CheckBits; i++ {
+				v := w.Values[w.NumInputsPerWitness+layout.DigestPosition(k, i)]
+				v.SetUint64(1 - v.Uint64())
+				if test.CheckCircuit(c, w) {
+					t.Fatalf("%s: the circuit accepts digest bit %d of instance %d flipped", m.name, i, k)
+				}
+				v.SetUint64(1 - v.Uint64())
+			}
+		}
+		if !test.CheckCircuit(c, w) {
+			t.Fatalf("%s: the restored witness is rejected", m.name)
+		}
+	}
+}
+
+// TestChiSchedule builds a 2-instance circuit under every χ schedule (WithChiSchedule): the digests must
+// check, a flipped message bit must be rejected, the round trace must match the reference, ChiStaggered
+// must cost exactly its squares (576 per round) on top of the single-layer build, and its widest layer
+// must hold fewer multiplications. The layer-width histograms are logged.
+func TestChiSchedule(t *testing.T) {
+	var lanes [25]uint64
+	for i := range lanes {
+		lanes[i] = uint64(i) * 0x9e3779b97f4a7c15
+	}
+	muls, widest := map[ChiSchedule]int{}, map[ChiSchedule]int{}
+	for _, schedule := range []ChiSchedule{ChiSingleLayer, ChiStaggered} {
+		stats, err := gateStats(gf2.ScalarField, NewKeccak256Circuit(2, WithChiSchedule(schedule)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		muls[schedule] = stats.Mul
+		trace, err := circuitRoundTrace(lanes, withChiSchedule(schedule))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(trace, RefRoundTrace(lanes)) {
+			t.Fatalf("%s: the round trace differs from the reference", schedule)
+		}
+
+		cr, err := compileCircuit(gf2.ScalarField, NewKeccak256Circuit(2, WithChiSchedule(schedule)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		is := newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, NewKeccak256Circuit(2, WithChiSchedule(schedule)))
+		assignment, err := randomAssignment(seededReader(1020), 2, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := expectVerdict(is, cr.GetLayeredCircuit(), assignment, true); err != nil {
+			t.Fatalf("%s: %v", schedule, err)
+		}
+		if err := FlipInputBit(assignment, 1, 300); err != nil {
+			t.Fatal(err)
+		}
+		if err := expectVerdict(is, cr.GetLayeredCircuit(), assignment, false); err != nil {
+			t.Fatalf("%s: %v", schedule, err)
+		}
+		widths := LayerWidths(cr.GetLayeredCircuit())
+		for _, l := range widths {
+			if l.Mul > widest[schedule] {
+				widest[schedule] = l.Mul
+			}
+		}
+		var hist strings.Builder
+		if err := WriteLayerHistogram(&hist, widths); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("χ schedule %s, 2 instances:\n%s", schedule, hist.String())
+	}
+	if extra := muls[ChiStaggered] - muls[ChiSingleLayer]; extra != 2*24*len(staggeredLanes)*64 {
+		t.Fatalf("ChiStaggered adds %d multiplications to 2 permutations, expected %d", extra, 2*24*len(staggeredLanes)*64)
+	}
+	if widest[ChiStaggered] >= widest[ChiSingleLayer] {
+		t.Fatalf("the widest layer holds %d multiplications under ChiStaggered and %d under ChiSingleLayer", widest[ChiStaggered], widest[ChiSingleLayer])
+	}
+}
+
+// TestSha3_256 checks the Sha3_256 gadget against x/crypto's sha3.Sum256 at every length around the block
+// boundaries, including 135 bytes, where the 0x06 suffix and the final 0x80 share a byte, and a WithSHA3
+// batch circuit: its assignments carry x/crypto's digests and check, and Keccak-256 digests do not.
+func TestSha3_256(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1021))
+	for _, n := range []int{0, 1, 64, 134, 135, 136, 137, 271, 272} {
+		msg := make([]byte, n)
+		rnd.Read(msg)
+		if got, want := evalBytes(func(api frontend.API) []frontend.Variable { return Sha3_256(api, bitsOf(msg)) }), sha3.Sum256(msg); !bytes.Equal(got, want[:]) {
+			t.Fatalf("Sha3_256 of %d bytes is %x, x/crypto says %x", n, got, want)
+		}
+	}
+
+	const n = 2
+	cr, err := compileCircuit(gf2.ScalarField, NewKeccak256Circuit(n, WithSHA3()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, NewKeccak256Circuit(n, WithSHA3()))
+	msgs := randomMessages(1021, n)
+	a, err := assignMessages(msgs, nil, CheckBits, WithSHA3())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, msg := range msgs {
+		want := sha3.Sum256(msg)
+		if got, err := assignedBytes(a.Out[k]); err != nil || !bytes.Equal(got, want[:]) {
+			t.Fatalf("instance %d is assigned %x (%v), x/crypto says %x", k, got, err, want)
+		}
+	}
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), a, true); err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := assignMessages(msgs, nil, CheckBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.SHA3 = true
+	if err := Preflight(legacy); err == nil {
+		t.Fatal("preflight accepts Keccak-256 digests for a SHA3-256 circuit")
+	}
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), legacy, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSha3_384 checks Sha3_384 against x/crypto's sha3.Sum384 for random 64-byte messages and around the
+// 104-byte block, and proves a batch of SHA3-384 digests, rejecting it once the last output byte of an
+// instance is corrupted.
+func TestSha3_384(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1023))
+	lengths := []int{0, 102, 103, 104, 207, 208}
+	for i := 0; i < 16; i++ {
+		lengths = append(lengths, 64)
+	}
+	for _, n := range lengths {
+		msg := make([]byte, n)
+		rnd.Read(msg)
+		if got, want := evalBytes(func(api frontend.API) []frontend.Variable { return Sha3_384(api, bitsOf(msg)) }), sha3.Sum384(msg); !bytes.Equal(got, want[:]) {
+			t.Fatalf("Sha3_384 of %d bytes is %x, x/crypto says %x", n, got, want)
+		}
+	}
+
+	const n = 2
+	cr, err := compileCircuit(gf2.ScalarField, NewSha3_384Circuit(n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, NewSha3_384Circuit(n))
+	a, err := sha3BatchAssignment(Sha3_384Config, randomMessages(1023, n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Out[0]) != 8*48 {
+		t.Fatalf("SHA3-384 digests of %d bits", len(a.Out[0]))
+	}
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), a, true); err != nil {
+		t.Fatal(err)
+	}
+	last, err := assignedBytes(a.Out[1][8*47:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	putBits(a.Out[1][8*47:], []byte{last[0] ^ 0xff})
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), a, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSha3_224 checks Sha3_224 against x/crypto's sha3.Sum224 for random 64-byte messages and around the
+// 144-byte block, pins the 18-lane absorb and the half-lane squeeze, and proves a batch of SHA3-224
+// digests, rejecting it once a bit of the last, partial lane is corrupted.
+func TestSha3_224(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1024))
+	lengths := []int{0, 142, 143, 144, 287, 288}
+	for i := 0; i < 16; i++ {
+		lengths = append(lengths, 64)
+	}
+	for _, n := range lengths {
+		msg := make([]byte, n)
+		rnd.Read(msg)
+		if got, want := evalBytes(func(api frontend.API) []frontend.Variable { return Sha3_224(api, bitsOf(msg)) }), sha3.Sum224(msg); !bytes.Equal(got, want[:]) {
+			t.Fatalf("Sha3_224 of %d bytes is %x, x/crypto says %x", n, got, want)
+		}
+	}
+
+	absorb := &GateStats{}
+	xorIn(&recordingAPI{field: gf2.ScalarField, stats: absorb}, symbolicState(), symbolicBits(1152), 1152)
+	if absorb.Add != 1152 || absorb.calls() != 1152 {
+		t.Fatalf("xorIn of 18 lanes makes %+v calls, expected 1152 Adds", *absorb)
+	}
+	state := symbolicState()
+	out := copyOutUnaligned(nil, state, 1152, 28)
+	if len(out) != 224 || out[223] != state[LayoutInternal.Index(3, 0)][31] {
+		t.Fatalf("the 28-byte squeeze is %d bits and does not end at bit 31 of lane 3", len(out))
+	}
+	for _, g := range gadgetBenchmarks() {
+		if g.Name == "Sha3_224" {
+			t.Logf("Sha3_224 of a 64-byte message: %+v", *gadgetCalls(g))
+		}
+	}
+
+	const n = 2
+	cr, err := compileCircuit(gf2.ScalarField, NewSha3_224Circuit(n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, NewSha3_224Circuit(n))
+	a, err := sha3BatchAssignment(Sha3_224Config, randomMessages(1024, n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), a, true); err != nil {
+		t.Fatal(err)
+	}
+	a.Out[0][223] = 1 - a.Out[0][223].(int)
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), a, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestKeccak512 checks Keccak512 against x/crypto's legacy Keccak-512 around the 72-byte block, and
+// proves both 512-bit digests of one 64-byte message in one circuit: they differ, and swapping them is
+// rejected, so the domain byte reaches the circuit.
+func TestKeccak512(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1025))
+	for _, n := range []int{0, 64, 70, 71, 72, 143, 144} {
+		msg := make([]byte, n)
+		rnd.Read(msg)
+		h := sha3.NewLegacyKeccak512()
+		h.Write(msg)
+		if got, want := evalBytes(func(api frontend.API) []frontend.Variable { return Keccak512(api, bitsOf(msg)) }), h.Sum(nil); !bytes.Equal(got, want) {
+			t.Fatalf("Keccak512 of %d bytes is %x, x/crypto says %x", n, got, want)
+		}
+	}
+
+	cr, err := compileCircuit(gf2.ScalarField, &keccak512PairCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, &keccak512PairCircuit{})
+	a, err := keccak512PairAssignment(randomMessages(1025, 1)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Keccak == a.SHA3 {
+		t.Fatal("Keccak-512 and SHA3-512 of the message are equal")
+	}
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), a, true); err != nil {
+		t.Fatal(err)
+	}
+	a.Keccak, a.SHA3 = a.SHA3, a.Keccak
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), a, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestKeccak384 checks Keccak384 against legacyKeccak384Ref around the 104-byte block, including
+// 103 bytes, where the 0x01 suffix and the final 0x80 share the last byte of the block, and a variant
+// batch mixing Keccak-384 with Keccak-512 and SHA3-256: it accepts the reference digests and rejects a
+// Keccak-384 digest assigned to a SHA3-384 instance.
+func TestKeccak384(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1026))
+	for _, n := range []int{0, 1, 64, 102, 103, 104, 105, 207, 208} {
+		msg := make([]byte, n)
+		rnd.Read(msg)
+		if got, want := evalBytes(func(api frontend.API) []frontend.Variable { return Keccak384(api, bitsOf(msg)) }), legacyKeccak384Ref(msg); !bytes.Equal(got, want) {
+			t.Fatalf("Keccak384 of %d bytes is %x, the reference says %x", n, got, want)
+		}
+	}
+	padded := Pad101(bitsOf(make([]byte, 103)), Keccak384Config.RateBits, Keccak384Config.DomainSep)
+	if last, err := assignedBytes(padded[len(padded)-8:]); err != nil || len(padded) != 832 || last[0] != 0x81 {
+		t.Fatalf("103 bytes pad to %d bits ending in %x (%v), expected 832 bits ending in 81", len(padded), last, err)
+	}
+
+	variants, err := ParseVariants("keccak384,keccak512,sha3-256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variants = instanceVariants(variants, 4)
+	cr, err := compileCircuit(gf2.ScalarField, newVariantBatchCircuit(variants))
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, newVariantBatchCircuit(variants))
+	msgs := randomMessages(1026, len(variants))
+	a, err := variantBatchAssignment(variants, msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), a, true); err != nil {
+		t.Fatal(err)
+	}
+	sha3Digests, err := variantBatchAssignment(append([]string{"sha3-384"}, variants[1:]...), msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), sha3Digests, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseVariants("keccak384,sha3-1024"); err == nil {
+		t.Fatal("ParseVariants accepts sha3-1024")
+	}
+}
+
+// TestShake128 checks Shake128 against x/crypto's SHAKE128 for outputs of 128, 1344 (exactly one rate)
+// and 2000 bits (a second squeeze permutation), and that its multiplications are SpongePermutations times
+// those of one keccakF.
+func TestShake128(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1027))
+	for _, n := range []int{0, 64, 167, 168} {
+		msg := make([]byte, n)
+		rnd.Read(msg)
+		for _, bits := range []int{128, 1344, 2000} {
+			want := make([]byte, bits/8)
+			h := sha3.NewShake128()
+			h.Write(msg)
+			h.Read(want)
+			if got := evalBytes(func(api frontend.API) []frontend.Variable { return Shake128(api, bitsOf(msg), bits) }); !bytes.Equal(got, want) {
+				t.Fatalf("Shake128 of %d bytes to %d bits is %x, x/crypto says %x", n, bits, got, want)
+			}
+		}
+	}
+
+	perm := &GateStats{}
+	keccakF(&recordingAPI{field: gf2.ScalarField, stats: perm}, symbolicState())
+	for _, c := range []struct{ msgBytes, bits, perms int }{{64, 128, 1}, {64, 1344, 1}, {64, 2000, 2}, {168, 2000, 3}, {64, 4033, 4}} {
+		if got := SpongePermutations(Shake128Rate, c.msgBytes, c.bits); got != c.perms {
+			t.Fatalf("SpongePermutations(%d bytes, %d bits) = %d, expected %d", c.msgBytes, c.bits, got, c.perms)
+		}
+		stats := &GateStats{}
+		Shake128(&recordingAPI{field: gf2.ScalarField, stats: stats}, symbolicBits(8*c.msgBytes), c.bits)
+		if stats.Mul != c.perms*perm.Mul {
+			t.Fatalf("Shake128 of %d bytes to %d bits builds %d AND, expected %d permutations of %d", c.msgBytes, c.bits, stats.Mul, c.perms, perm.Mul)
+		}
+	}
+}
+
+// TestShake256 checks Shake256 against x/crypto's SHAKE256 for outputs of 256, exactly 1088 (one rate)
+// and 1089 bits (the first needing a second permutation), and that it builds the same XOR and AND gates as
+// the Keccak-256 sponge of the same lengths while producing another output: only the domain byte differs,
+// and with its constant 1 bits the NOTs they fold into.
+func TestShake256(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1028))
+	for _, n := range []int{0, 64, 135, 136} {
+		msg := make([]byte, n)
+		rnd.Read(msg)
+		for _, bits := range []int{256, 1088, 1089} {
+			want := make([]byte, (bits+7)/8)
+			h := sha3.NewShake256()
+			h.Write(msg)
+			h.Read(want)
+			eval := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+			got, err := VariablesToBits(Shake256(eval, bitsOf(msg), bits))
+			if err != nil || len(got) != bits {
+				t.Fatalf("Shake256 of %d bytes to %d bits: %d bits (%v)", n, bits, len(got), err)
+			}
+			for i, b := range got {
+				if b != int(want[i/8]>>(i%8))&1 {
+					t.Fatalf("Shake256 of %d bytes to %d bits differs from x/crypto at bit %d", n, bits, i)
+				}
+			}
+		}
+	}
+
+	perm := &GateStats{}
+	keccakF(&recordingAPI{field: gf2.ScalarField, stats: perm}, symbolicState())
+	for _, c := range []struct{ bits, perms int }{{1088, 1}, {1089, 2}} {
+		shake, legacy := &GateStats{}, &GateStats{}
+		Shake256(&recordingAPI{field: gf2.ScalarField, stats: shake}, symbolicBits(64*8), c.bits)
+		keccakSponge(&recordingAPI{field: gf2.ScalarField, stats: legacy}, symbolicBits(64*8), Shake256Rate, DomainKeccak, c.bits)
+		if got := SpongePermutations(Shake256Rate, 64, c.bits); got != c.perms || shake.Mul != c.perms*perm.Mul {
+			t.Fatalf("Shake256 to %d bits: %d permutations counted, %d AND built, expected %d permutations of %d", c.bits, got, shake.Mul, c.perms, perm.Mul)
+		}
+		if shake.Add != legacy.Add || shake.Mul != legacy.Mul {
+			t.Fatalf("Shake256 to %d bits builds %+v, the Keccak-256 sponge %+v", c.bits, *shake, *legacy)
+		}
+	}
+	msg := randomMessages(1028, 1)[0]
+	shake := make([]byte, 32)
+	sha3.ShakeSum256(shake, msg)
+	if keccak := CircuitKeccak256(msg); bytes.Equal(shake, keccak[:]) {
+		t.Fatal("SHAKE256 and Keccak-256 of the message agree: the domain byte has no effect")
+	}
+}
+
+// TestCShake checks CShake128 and CShake256 against x/crypto's cSHAKE with empty and non-empty function
+// names and customization strings (one long enough for a two-block prefix), and that the prefix costs no
+// XOR or AND gates: a symbolic message builds the XOR and AND gates of the plain SHAKE gadget, and NOTs
+// where it meets the constant 1 bits of the prefixed state instead of the zero state.
+func TestCShake(t *testing.T) {
+	long := bytes.Repeat([]byte("customization "), 16)
+	msg := randomMessages(1029, 1)[0]
+	for _, c := range []struct {
+		name   string
+		gadget func(frontend.API, []frontend.Variable, []byte, []byte, int) []frontend.Variable
+		shake  func(frontend.API, []frontend.Variable, int) []frontend.Variable
+		ref    func(n, s []byte) sha3.ShakeHash
+	}{{"cSHAKE128", CShake128, Shake128, sha3.NewCShake128}, {"cSHAKE256", CShake256, Shake256, sha3.NewCShake256}} {
+		for _, ns := range [][2][]byte{{nil, nil}, {nil, []byte("Email Signature")}, {[]byte("KMAC"), nil}, {[]byte("TupleHash"), long}} {
+			for _, bits := range []int{256, 2000} {
+				want := make([]byte, bits/8)
+				h := c.ref(ns[0], ns[1])
+				h.Write(msg)
+				h.Read(want)
+				eval := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+				if got, err := assignedBytes(c.gadget(eval, bitsOf(msg), ns[0], ns[1], bits)); err != nil || !bytes.Equal(got, want) {
+					t.Fatalf("%s(N=%q, S of %d bytes) to %d bits is %x (%v), x/crypto says %x", c.name, ns[0], len(ns[1]), bits, got, err, want)
+				}
+			}
+			custom, plain := &GateStats{}, &GateStats{}
+			c.gadget(&recordingAPI{field: gf2.ScalarField, stats: custom}, symbolicBits(64*8), ns[0], ns[1], 512)
+			c.shake(&recordingAPI{field: gf2.ScalarField, stats: plain}, symbolicBits(64*8), 512)
+			if custom.Add != plain.Add || custom.Mul != plain.Mul || custom.Sub < plain.Sub {
+				t.Fatalf("%s(N=%q, S of %d bytes) builds %+v, the plain SHAKE %+v", c.name, ns[0], len(ns[1]), *custom, *plain)
+			}
+		}
+	}
+}
+
+// TestKMAC checks KMAC128 and KMAC256 against the NIST SP 800-185 KMAC samples 1-6 (32-byte key 0x40..0x5F)
+// and proves knowledge of a 32-byte key in a kmacCircuit: the right key is accepted, a key differing in
+// one bit is rejected by CheckCircuit.
+func TestKMAC(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = 0x40 + byte(i)
+	}
+	short := []byte{0x00, 0x01, 0x02, 0x03}
+	long := make([]byte, 200)
+	for i := range long {
+		long[i] = byte(i)
+	}
+	tagged := []byte("My Tagged Application")
+	for i, c := range []struct {
+		kmac   func(frontend.API, []frontend.Variable, []frontend.Variable, int, []byte) []frontend.Variable
+		msg    []byte
+		bits   int
+		s      []byte
+		expect string
+	}{
+		{KMAC128, short, 256, nil, "e5780b0d3ea6f7d3a429c5706aa43a00fadbd7d49628839e3187243f456ee14e"},
+		{KMAC128, short, 256, tagged, "3b1fba963cd8b0b59e8c1a6d71888b7143651af8ba0a7070c0979e2811324aa5"},
+		{KMAC128, long, 256, tagged, "1f5b4e6cca02209e0dcb5ca635b89a15e271ecc760071dfd805faa38f9729230"},
+		{KMAC256, short, 512, tagged, "20c570c31346f703c9ac36c61c03cb64c3970d0cfc787e9b79599d273a68d2f7f69d4cc3de9d104a351689f27cf6f5951f0103f33f4f24871024d9c27773a8dd"},
+		{KMAC256, long, 512, nil, "75358cf39e41494e949707927cee0af20a3ff553904c86b08f21cc414bcfd691589d27cf5e15369cbbff8b9a4c2eb17800855d0235ff635da82533ec6b759b69"},
+		{KMAC256, long, 512, tagged, "b58618f71f92e1d56c1b8c55ddd7cd188b97b4ca4d99831eb2699a837da2e4d970fbacfde50033aea585f1a2708510c32d07880801bd182898fe476876fc8965"},
+	} {
+		eval := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+		got, err := assignedBytes(c.kmac(eval, bitsOf(key), bitsOf(c.msg), c.bits, c.s))
+		if err != nil || hex.EncodeToString(got) != c.expect {
+			t.Fatalf("sample %d: KMAC is %x (%v), NIST says %s", i+1, got, err, c.expect)
+		}
+	}
+
+	msg := randomMessages(1030, 1)[0]
+	cr, err := compileCircuit(gf2.ScalarField, newKmacCircuit(32, len(msg), 256, tagged))
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, newKmacCircuit(32, len(msg), 256, tagged))
+	a, err := kmacAssignment(key, msg, evalBytes(func(api frontend.API) []frontend.Variable { return KMAC128(api, bitsOf(key), bitsOf(msg), 256, tagged) }), tagged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), a, true); err != nil {
+		t.Fatal(err)
+	}
+	wrong := append([]byte{}, key...)
+	wrong[7] ^= 0x10
+	putBits(a.Key, wrong)
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), a, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestKMAC256Circuit compiles a KMAC256Circuit for NIST KMAC sample 6 (32-byte key, 200-byte message,
+// 512-bit tag, S = "My Tagged Application"): the sample is accepted and the same key with KMAC128's tag is
+// rejected. It also counts the absorbed blocks: KMAC256 needs one keccakF more than KMAC128 for a
+// 140-byte message and none more for a 200-byte one.
+func TestKMAC256Circuit(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = 0x40 + byte(i)
+	}
+	msg := make([]byte, 200)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	s := []byte("My Tagged Application")
+	tag, _ := hex.DecodeString("b58618f71f92e1d56c1b8c55ddd7cd188b97b4ca4d99831eb2699a837da2e4d970fbacfde50033aea585f1a2708510c32d07880801bd182898fe476876fc8965")
+	if got := evalBytes(func(api frontend.API) []frontend.Variable { return KMAC256(api, bitsOf(key), bitsOf(msg), 512, s) }); !bytes.Equal(got, tag) {
+		t.Fatalf("KMAC256 is %x, NIST sample 6 says %x", got, tag)
+	}
+
+	perm := &GateStats{}
+	keccakF(&recordingAPI{field: gf2.ScalarField, stats: perm}, symbolicState())
+	for _, c := range []struct{ msgBytes, extra int }{{140, 1}, {200, 0}} {
+		s128, s256 := &GateStats{}, &GateStats{}
+		KMAC128(&recordingAPI{field: gf2.ScalarField, stats: s128}, symbolicBits(8*len(key)), symbolicBits(8*c.msgBytes), 512, s)
+		KMAC256(&recordingAPI{field: gf2.ScalarField, stats: s256}, symbolicBits(8*len(key)), symbolicBits(8*c.msgBytes), 512, s)
+		if s256.Mul-s128.Mul != c.extra*perm.Mul {
+			t.Fatalf("%d-byte message: KMAC256 builds %d AND, KMAC128 %d, expected %d keccakF of %d more", c.msgBytes, s256.Mul, s128.Mul, c.extra, perm.Mul)
+		}
+	}
+
+	cr, err := compileCircuit(gf2.ScalarField, NewKMAC256Circuit(len(key), len(msg), 512, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, NewKMAC256Circuit(len(key), len(msg), 512, s))
+	a, err := kmacAssignment(key, msg, tag, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), (*KMAC256Circuit)(a), true); err != nil {
+		t.Fatal(err)
+	}
+	putBits(a.Tag, evalBytes(func(api frontend.API) []frontend.Variable { return KMAC128(api, bitsOf(key), bitsOf(msg), 512, s) }))
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), (*KMAC256Circuit)(a), false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTupleHash checks TupleHash128 and TupleHash256 against the NIST SP 800-185 TupleHash samples 1-4, and
+// that the element boundaries are part of the digest: swapping two elements, or moving a byte from one
+// element to the next, changes it.
+func TestTupleHash(t *testing.T) {
+	tupleHash := func(hash func(frontend.API, [][]frontend.Variable, int, []byte) []frontend.Variable, elems [][]byte, bits int, s []byte) string {
+		wires := make([][]frontend.Variable, len(elems))
+		for i, e := range elems {
+			wires[i] = bitsOf(e)
+		}
+		eval := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+		out, err := assignedBytes(hash(eval, wires, bits, s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hex.EncodeToString(out)
+	}
+	x := [][]byte{{0x00, 0x01, 0x02}, {0x10, 0x11, 0x12, 0x13, 0x14, 0x15}}
+	x3 := append(append([][]byte{}, x...), []byte{0x20, 0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27, 0x28})
+	app := []byte("My Tuple App")
+	for i, c := range []struct {
+		hash   func(frontend.API, [][]frontend.Variable, int, []byte) []frontend.Variable
+		elems  [][]byte
+		bits   int
+		s      []byte
+		expect string
+	}{
+		{TupleHash128, x, 256, nil, "c5d8786c1afb9b82111ab34b65b2c0048fa64e6d48e263264ce1707d3ffc8ed1"},
+		{TupleHash128, x, 256, app, "75cdb20ff4db1154e841d758e24160c54bae86eb8c13e7f5f40eb35588e96dfb"},
+		{TupleHash128, x3, 256, app, "e60f202c89a2631eda8d4c588ca5fd07f39e5151998deccf973adb3804bb6e84"},
+		{TupleHash256, x, 512, nil, "cfb7058caca5e668f81a12a20a2195ce97a925f1dba3e7449a56f82201ec607311ac2696b1ab5ea2352df1423bde7bd4bb78c9aed1a853c78672f9eb23bbe194"},
+	} {
+		if got := tupleHash(c.hash, c.elems, c.bits, c.s); got != c.expect {
+			t.Fatalf("sample %d: TupleHash is %s, NIST says %s", i+1, got, c.expect)
+		}
+	}
+
+	rnd := rand.New(rand.NewSource(1032))
+	for trial := 0; trial < 8; trial++ {
+		// (contract, slot, value)
+		elems := [][]byte{make([]byte, 20), make([]byte, 32), make([]byte, 1+rnd.Intn(32))}
+		for _, e := range elems {
+			rnd.Read(e)
+		}
+		digest := tupleHash(TupleHash128, elems, 256, nil)
+		i, j := rnd.Intn(3), rnd.Intn(2)
+		if j >= i {
+			j++
+		}
+		swapped := append([][]byte{}, elems...)
+		swapped[i], swapped[j] = swapped[j], swapped[i]
+		if got := tupleHash(TupleHash128, swapped, 256, nil); got == digest {
+			t.Fatalf("swapping elements %d and %d of %x keeps the digest", i, j, elems)
+		}
+		shifted := [][]byte{elems[0][:19], append([]byte{elems[0][19]}, elems[1]...), elems[2]}
+		if got := tupleHash(TupleHash128, shifted, 256, nil); got == digest {
+			t.Fatalf("moving the last byte of element 0 into element 1 of %x keeps the digest", elems)
+		}
+	}
+}
+
+// TestTupleHashVar checks TupleHash128Var and TupleHash256Var, whose element lengths are witnesses, against
+// NIST SP 800-185 TupleHash samples 1, 2 and 4 and against TupleHash128 over the truncated elements for every
+// length up to the buffers, with garbage past the lengths. A length above its buffer fails an assertion.
+func TestTupleHashVar(t *testing.T) {
+	hashVar := func(hash func(frontend.API, [][]frontend.Variable, [][]frontend.Variable, int, []byte) []frontend.Variable, bufs [][]byte, lens []int, bits int, s []byte) (string, []string) {
+		api := &fieldAPI{field: gf2.ScalarField}
+		elems, lenBits := make([][]frontend.Variable, len(bufs)), make([][]frontend.Variable, len(bufs))
+		for i, b := range bufs {
+			elems[i] = bitsOf(b)
+			lenBits[i] = bitsOf([]byte{byte(lens[i])})[:6]
+		}
+		out := hash(api, elems, lenBits, bits, s)
+		digest := make([]byte, bits/8)
+		for i, v := range out {
+			digest[i/8] |= byte(api.value(v).Bit(0)) << (i % 8)
+		}
+		return hex.EncodeToString(digest), api.failed
+	}
+	garbage := func(e []byte, extra int) []byte {
+		return append(append([]byte{}, e...), bytes.Repeat([]byte{0xa5}, extra)...)
+	}
+	x := [][]byte{{0x00, 0x01, 0x02}, {0x10, 0x11, 0x12, 0x13, 0x14, 0x15}}
+	bufs := [][]byte{garbage(x[0], 2), garbage(x[1], 1)}
+	for i, c := range []struct {
+		hash   func(frontend.API, [][]frontend.Variable, [][]frontend.Variable, int, []byte) []frontend.Variable
+		bits   int
+		s      []byte
+		expect string
+	}{
+		{TupleHash128Var, 256, nil, "c5d8786c1afb9b82111ab34b65b2c0048fa64e6d48e263264ce1707d3ffc8ed1"},
+		{TupleHash128Var, 256, []byte("My Tuple App"), "75cdb20ff4db1154e841d758e24160c54bae86eb8c13e7f5f40eb35588e96dfb"},
+		{TupleHash256Var, 512, nil, "cfb7058caca5e668f81a12a20a2195ce97a925f1dba3e7449a56f82201ec607311ac2696b1ab5ea2352df1423bde7bd4bb78c9aed1a853c78672f9eb23bbe194"},
+	} {
+		if got, failed := hashVar(c.hash, bufs, []int{3, 6}, c.bits, c.s); got != c.expect || len(failed) != 0 {
+			t.Fatalf("case %d: TupleHashVar is %s (failed %v), NIST says %s", i+1, got, failed, c.expect)
+		}
+	}
+
+	rnd := rand.New(rand.NewSource(972))
+	bufs = [][]byte{make([]byte, 5), make([]byte, 3)}
+	for _, b := range bufs {
+		rnd.Read(b)
+	}
+	for l0 := 0; l0 <= len(bufs[0]); l0++ {
+		for l1 := 0; l1 <= len(bufs[1]); l1++ {
+			want := evalBytes(func(api frontend.API) []frontend.Variable {
+				return TupleHash128(api, [][]frontend.Variable{bitsOf(bufs[0][:l0]), bitsOf(bufs[1][:l1])}, 256, nil)
+			})
+			if got, failed := hashVar(TupleHash128Var, bufs, []int{l0, l1}, 256, nil); got != hex.EncodeToString(want) || len(failed) != 0 {
+				t.Fatalf("lengths %d, %d: TupleHash128Var is %s (failed %v), TupleHash128 %x", l0, l1, got, failed, want)
+			}
+		}
+	}
+	if _, failed := hashVar(TupleHash128Var, bufs, []int{6, 3}, 256, nil); len(failed) == 0 {
+		t.Fatal("a 6-byte length over a 5-byte buffer passes")
+	}
+}
+
+// TestLengthEncoding checks left_encode and right_encode against the SP 800-185 encodings of 0, 255, 256,
+// 65535 and 2^20, and left_encode of a witness length against the same values in buffers of 3 and 4 value
+// bytes: the encoding is left-aligned and zero-filled, and the size selector is one-hot on its length.
+func TestLengthEncoding(t *testing.T) {
+	for _, e := range []struct {
+		x           uint64
+		left, right string
+	}{
+		{0, "0100", "0001"},
+		{255, "01ff", "ff01"},
+		{256, "020100", "010002"},
+		{65535, "02ffff", "ffff02"},
+		{1 << 20, "03100000", "10000003"},
+	} {
+		left, right := leftEncode(e.x), rightEncode(e.x)
+		if hex.EncodeToString(left) != e.left || hex.EncodeToString(right) != e.right {
+			t.Fatalf("encodings of %d are %x and %x, want %s and %s", e.x, left, right, e.left, e.right)
+		}
+		for _, maxBytes := range []int{3, 4} {
+			var x [8]byte
+			binary.LittleEndian.PutUint64(x[:], e.x)
+			api := &fieldAPI{field: gf2.ScalarField}
+			enc, size := leftEncodeVar(api, bitsOf(x[:maxBytes]), maxBytes)
+			want := append(append([]byte{}, left...), make([]byte, maxBytes+1-len(left))...)
+			got := make([]byte, maxBytes+1)
+			for i, v := range enc {
+				got[i/8] |= byte(api.value(v).Bit(0)) << (i % 8)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("leftEncodeVar(%d) with %d bytes is %x, want %x", e.x, maxBytes, got, want)
+			}
+			for n := range size {
+				if selected := api.value(size[n]).Bit(0) == 1; selected != (n+2 == len(left)) {
+					t.Fatalf("leftEncodeVar(%d) with %d bytes selects the wrong length", e.x, maxBytes)
+				}
+			}
+		}
+	}
+}
+
+// TestParallelHash checks ParallelHash128 and ParallelHash256 against NIST SP 800-185 ParallelHash samples
+// 1, 2 and 4, and reports its cost against hashing the same kilobyte serially with Shake128: every
+// permutation counted by ParallelHashPermutations and SpongePermutations is one keccakF of AND gates.
+func TestParallelHash(t *testing.T) {
+	x := make([]byte, 0, 24)
+	for _, hi := range []byte{0x00, 0x10, 0x20} {
+		for lo := byte(0); lo < 8; lo++ {
+			x = append(x, hi+lo)
+		}
+	}
+	for i, c := range []struct {
+		hash   func(frontend.API, []frontend.Variable, int, int, []byte) []frontend.Variable
+		bits   int
+		s      []byte
+		expect string
+	}{
+		{ParallelHash128, 256, nil, "ba8dc1d1d979331d3f813603c67f72609ab5e44b94a0b8f9af46514454a2b4f5"},
+		{ParallelHash128, 256, []byte("Parallel Data"), "fc484dcb3f84dceedc353438151bee58157d6efed0445a81f165e495795b7206"},
+		{ParallelHash256, 512, nil, "bc1ef124da34495e948ead207dd9842235da432d2bbc54b4c110e64c451105531b7f2a3e0ce055c02805e7c2de1fb746af97a1dd01f43b824e31b87612410429"},
+	} {
+		eval := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+		got, err := assignedBytes(c.hash(eval, bitsOf(x), 8, c.bits, c.s))
+		if err != nil || hex.EncodeToString(got) != c.expect {
+			t.Fatalf("case %d: ParallelHash is %x (%v), NIST says %s", i+1, got, err, c.expect)
+		}
+	}
+
+	perm := &GateStats{}
+	keccakF(&recordingAPI{field: gf2.ScalarField, stats: perm}, symbolicState())
+	const size, block = 1024, 256
+	parallel, serial := &GateStats{}, &GateStats{}
+	ParallelHash128(&recordingAPI{field: gf2.ScalarField, stats: parallel}, symbolicBits(8*size), block, 256, nil)
+	Shake128(&recordingAPI{field: gf2.ScalarField, stats: serial}, symbolicBits(8*size), 256)
+	pp, sp := ParallelHashPermutations(Shake128Rate, size, block, 256), SpongePermutations(Shake128Rate, size, 256)
+	t.Logf("%d bytes: ParallelHash128 (B = %d) %d keccakF, %d add %d mul; SHAKE128 %d keccakF, %d add %d mul", size, block, pp, parallel.Add, parallel.Mul, sp, serial.Add, serial.Mul)
+	if pp != 9 || sp != 7 {
+		t.Fatalf("%d bytes: %d ParallelHash128 and %d SHAKE128 permutations, expected 9 and 7", size, pp, sp)
+	}
+	if parallel.Mul != pp*perm.Mul || serial.Mul != sp*perm.Mul {
+		t.Fatalf("%d bytes: ParallelHash128 builds %d AND, SHAKE128 %d, expected %d and %d keccakF of %d", size, parallel.Mul, serial.Mul, pp, sp, perm.Mul)
+	}
+}
+
+// TestKangarooTwelve checks k12Ref against the KT128 test vectors of RFC 9861 (ptn(n) is the byte pattern
+// 00 01 .. FA repeated), KangarooTwelve against k12Ref on both sides of the 8192-byte switch to the tree
+// mode, and logs its cost next to Keccak-256's: half the AND gates, 12 rounds instead of 24.
+func TestKangarooTwelve(t *testing.T) {
+	ptn := func(n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(i % 251)
+		}
+		return b
+	}
+	for _, c := range []struct {
+		msg, custom []byte
+		outBytes    int
+		expect      string
+	}{
+		{nil, nil, 32, "1ac2d450fc3b4205d19da7bfca1b37513c0803577ac7167f06fe2ce1f0ef39e5"},
+		{nil, nil, 64, "1ac2d450fc3b4205d19da7bfca1b37513c0803577ac7167f06fe2ce1f0ef39e54269c056b8c82e48276038b6d292966cc07a3d4645272e31ff38508139eb0a71"},
+		{ptn(17), nil, 32, "6bf75fa2239198db4772e36478f8e19b0f371205f6a9a93a273f51df37122888"},
+		{ptn(17 * 17), nil, 32, "0c315ebcdedbf61426de7dcf8fb725d1e74675d7f5327a5067f367b108ecb67c"},
+		{ptn(17 * 17 * 17), nil, 32, "cb552e2ec77d9910701d578b457ddf772c12e322e4ee7fe417f92c758f0d59d0"},
+		{ptn(17 * 17 * 17 * 17), nil, 32, "8701045e22205345ff4dda05555cbb5c3af1a771c2b89baef37db43d9998b9fe"},
+		{nil, ptn(1), 32, "fab658db63e94a246188bf7af69a133045f46ee984c56e3c3328caaf1aa1a583"},
+		{[]byte{0xFF}, ptn(41), 32, "d848c5068ced736f4462159b9867fd4c20b808acc3d5bc48e0b06ba0a3762ec4"},
+	} {
+		if got := hex.EncodeToString(k12Ref(c.msg, c.custom, c.outBytes)); got != c.expect {
+			t.Fatalf("KT128 of %d bytes, C of %d bytes: %s, RFC 9861 says %s", len(c.msg), len(c.custom), got, c.expect)
+		}
+	}
+
+	rnd := rand.New(rand.NewSource(1034))
+	for _, c := range []struct{ msgBytes, customBytes int }{{0, 0}, {64, 0}, {167, 3}, {8191, 0}, {8192, 0}, {8180, 20}, {3 * 8192, 5}} {
+		msg, custom := make([]byte, c.msgBytes), make([]byte, c.customBytes)
+		rnd.Read(msg)
+		rnd.Read(custom)
+		eval := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+		got, err := assignedBytes(KangarooTwelve(eval, bitsOf(msg), custom, 512))
+		if want := k12Ref(msg, custom, 64); err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("KangarooTwelve of %d bytes, C of %d bytes is %x (%v), k12Ref says %x", c.msgBytes, c.customBytes, got, err, want)
+		}
+	}
+
+	k12, keccak := &GateStats{}, &GateStats{}
+	KangarooTwelve(&recordingAPI{field: gf2.ScalarField, stats: k12}, symbolicBits(64*8), nil, 256)
+	computeKeccak(&recordingAPI{field: gf2.ScalarField, stats: keccak}, symbolicBits(64*8))
+	t.Logf("64-byte message: KangarooTwelve %d add %d sub %d mul, Keccak-256 %d add %d sub %d mul", k12.Add, k12.Sub, k12.Mul, keccak.Add, keccak.Sub, keccak.Mul)
+	if 2*k12.Mul != keccak.Mul {
+		t.Fatalf("64-byte message: KangarooTwelve builds %d AND, Keccak-256 %d; expected half", k12.Mul, keccak.Mul)
+	}
+}
+
+// TestTurboShake128 checks TurboShake128 against the TurboSHAKE128 test vectors of RFC 9861 for domain bytes
+// 0x1F and 0x07 (and the others of the RFC), including the tail of a 10032-byte output; that an output one
+// byte longer than the rate costs exactly one more 12-round permutation; and that domain bytes outside
+// 0x01..0x7F are refused.
+func TestTurboShake128(t *testing.T) {
+	ptn := func(n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(i % 251)
+		}
+		return b
+	}
+	ff := func(n int) []byte { return bytes.Repeat([]byte{0xFF}, n) }
+	for _, c := range []struct {
+		msg      []byte
+		domain   byte
+		outBytes int
+		expect   string // the last 32 bytes of the output
+	}{
+		{nil, 0x1F, 32, "1e415f1c5983aff2169217277d17bb538cd945a397ddec541f1ce41af2c1b74c"},
+		{nil, 0x1F, 64, "3e8ccae2a4dae56c84a04c2385c03c15e8193bdf58737363321691c05462c8df"},
+		{nil, 0x1F, 10032, "a3b9b0385900ce761f22aed548e754da10a5242d62e8c658e3f3a923a7555607"},
+		{ptn(1), 0x1F, 32, "55cedd6f60af7bb29a4042ae832ef3f58db7299f893ebb9247247d856958daa9"},
+		{ptn(17), 0x1F, 32, "9c97d036a3bac819db70ede0ca554ec6e4c2a1a4ffbfd9ec269ca6a111161233"},
+		{ptn(17 * 17), 0x1F, 32, "96c77c279e0126f7fc07c9b07f5cdae1e0be60bdbe10620040e75d7223a624d2"},
+		{ff(3), 0x01, 32, "bf323f940494e88ee1c540fe660be8a0c93f43d15ec006998462fa994eed5dab"},
+		{ff(1), 0x06, 32, "8ec9c66465ed0d4a6c35d13506718d687a25cb05c74cca1e42501abd83874a67"},
+		{ff(3), 0x07, 32, "b658576001cad9b1e5f399a9f77723bba05458042d68206f7252682dba3663ed"},
+		{ff(7), 0x0B, 32, "8deeaa1aec47ccee569f659c21dfa8e112db3cee37b18178b2acd805b799cc37"},
+		{ff(1), 0x30, 32, "553122e2135e363c3292bed2c6421fa232bab03daa07c7d6636603286506325b"},
+		{ff(3), 0x7F, 32, "16274cc656d44cefd422395d0f9053bda6d28e122aba15c765e5ad0e6eaf26f9"},
+	} {
+		eval := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+		got, err := assignedBytes(TurboShake128(eval, bitsOf(c.msg), c.domain, 8*c.outBytes))
+		if err != nil || hex.EncodeToString(got[len(got)-32:]) != c.expect {
+			t.Fatalf("TurboSHAKE128 of %d bytes, D = %#02x, to %d bytes ends in %x (%v), RFC 9861 says %s", len(c.msg), c.domain, c.outBytes, got[len(got)-32:], err, c.expect)
+		}
+		if want := turboShake128Ref(c.msg, c.domain, c.outBytes); !bytes.Equal(got, want) {
+			t.Fatalf("TurboSHAKE128 of %d bytes, D = %#02x: the circuit and turboShake128Ref disagree", len(c.msg), c.domain)
+		}
+	}
+
+	perm := &GateStats{}
+	keccakP(&recordingAPI{field: gf2.ScalarField, stats: perm}, symbolicState(), 24-K12Rounds, K12Rounds)
+	for _, c := range []struct{ bits, perms int }{{1344, 1}, {1352, 2}} {
+		stats := &GateStats{}
+		TurboShake128(&recordingAPI{field: gf2.ScalarField, stats: stats}, symbolicBits(64*8), 0x1F, c.bits)
+		if got := SpongePermutations(Shake128Rate, 64, c.bits); got != c.perms || stats.Mul != c.perms*perm.Mul {
+			t.Fatalf("TurboSHAKE128 to %d bits: %d permutations counted, %d AND built, expected %d permutations of %d", c.bits, got, stats.Mul, c.perms, perm.Mul)
+		}
+	}
+
+	for _, domain := range []byte{0x00, 0x80, 0xFF} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("TurboShake128 accepts domain byte %#02x", domain)
+				}
+			}()
+			TurboShake128(&recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}, nil, domain, 256)
+		}()
+	}
+}
+
+// TestKeccakRounds runs keccakP on constant states: the 24-round keccakF matches the published
+// zero-state vector and keccakF1600Ref, keccakP(12, 12) matches keccakP1600Ref(12) (rounds 12..23),
+// two windows 0..k-1 and k..23 compose to keccakF (and 0..k-1, k..17 to Keccak-f[200]), computeKeccak
+// builds the gates TestSpongePrimitives pins, and empty or out-of-range windows panic.
+func TestKeccakRounds(t *testing.T) {
+	eval := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+	lanesOf := func(s [][]frontend.Variable) [25]uint64 {
+		var lanes [25]uint64
+		for i, lane := range ConvertState(s, LayoutInternal, LayoutSpec) {
+			b, err := assignedBytes(lane)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lanes[i] = binary.LittleEndian.Uint64(b)
+		}
+		return lanes
+	}
+	if got := lanesOf(keccakF(eval, permState(permLanes([25]uint64{})))); got != KeccakFZeroState {
+		t.Fatalf("keccakF of the zero state is %x, expected %x", got, KeccakFZeroState)
+	}
+	rnd := rand.New(rand.NewSource(1036))
+	for trial := 0; trial < 4; trial++ {
+		var a [25]uint64
+		for i := range a {
+			a[i] = rnd.Uint64()
+		}
+		in := permState(permLanes(a))
+		if got, want := lanesOf(keccakF(eval, in)), keccakF1600Ref(a); got != want {
+			t.Fatalf("keccakF disagrees with keccakF1600Ref on %x", a)
+		}
+		if got, want := lanesOf(keccakP(eval, in, 12, 12)), keccakP1600Ref(a, 12); got != want {
+			t.Fatalf("keccakP(12, 12) disagrees with keccakP1600Ref(12) on %x", a)
+		}
+		k := 1 + rnd.Intn(23)
+		if got, want := lanesOf(keccakP(eval, keccakP(eval, in, 0, k), k, 24-k)), keccakF1600Ref(a); got != want {
+			t.Fatalf("rounds 0..%d then %d..23 disagree with keccakF1600Ref on %x", k-1, k, a)
+		}
+	}
+
+	// the same split on the 18 rounds of Keccak-f[200]
+	for trial := 0; trial < 64; trial++ {
+		var a [25]uint64
+		in := make([][]frontend.Variable, 25)
+		for i := range a {
+			a[i] = uint64(rnd.Intn(256))
+			in[i] = bitsOf([]byte{byte(a[i])})
+		}
+		k := 1 + rnd.Intn(17)
+		out := keccakP(eval, keccakP(eval, ConvertState(in, LayoutSpec, LayoutInternal), 0, k), k, 18-k)
+		want := keccakFLanesRef(8, a)
+		for i, lane := range ConvertState(out, LayoutInternal, LayoutSpec) {
+			if b, err := assignedBytes(lane); err != nil || uint64(b[0]) != want[i] {
+				t.Fatalf("Keccak-f[200] rounds 0..%d then %d..17 disagree with keccakFLanesRef on %x", k-1, k, a)
+			}
+		}
+	}
+
+	// the counts TestSpongePrimitives has pinned since before the round window
+	composed, err := gateStats(gf2.ScalarField, NewKeccak256Circuit(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *composed != (GateStats{Add: 151296, Sub: 38491, Mul: 38400, Assert: 256, PublicInputs: 256}) {
+		t.Fatalf("computeKeccak builds %+v", *composed)
+	}
+
+	for _, w := range [][2]int{{-1, 1}, {0, 0}, {20, 5}, {0, 25}, {24, 1}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("keccakP accepts rounds %d..%d", w[0], w[0]+w[1]-1)
+				}
+			}()
+			keccakP(eval, symbolicState(), w[0], w[1])
+		}()
+	}
+	small := make([][]frontend.Variable, 25)
+	for i := range small {
+		small[i] = symbolicBits(8)
+	}
+	for _, w := range [][2]int{{0, 19}, {18, 1}, {17, 2}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("keccakP accepts rounds %d..%d of 8-bit lanes", w[0], w[0]+w[1]-1)
+				}
+			}()
+			keccakP(eval, small, w[0], w[1])
+		}()
+	}
+	for _, rounds := range []int{0, 25} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("keccakP accepts Keccak-p[1600, %d]", rounds)
+				}
+			}()
+			keccakP(eval, symbolicState(), 24-rounds, rounds)
+		}()
+	}
+}
+
+// keccakF800Rounds and keccakF800Rho are the round constants and ρ offsets (x+5y order) of the Keccak
+// team's KeccakP-800 reference code, copied as published rather than derived by spec.
+var (
+	keccakF800Rounds = [22]uint32{
+		0x00000001, 0x00008082, 0x0000808a, 0x80008000, 0x0000808b, 0x80000001, 0x80008081, 0x00008009,
+		0x0000008a, 0x00000088, 0x80008009, 0x8000000a, 0x8000808b, 0x0000008b, 0x00008089, 0x00008003,
+		0x00008002, 0x00000080, 0x0000800a, 0x8000000a, 0x80008081, 0x00008080,
+	}
+	keccakF800Rho = [25]int{0, 1, 30, 28, 27, 4, 12, 6, 23, 20, 3, 10, 11, 25, 7, 9, 13, 15, 21, 8, 18, 2, 29, 24, 14}
+)
+
+// keccakF800External is Keccak-f[800] on 32-bit words after the Keccak reference code, from the published
+// tables above only: it shares neither spec nor keccakFLanesRef with the gadget.
+func keccakF800External(a [25]uint32) [25]uint32 {
+	for _, rc := range keccakF800Rounds {
+		var c [5]uint32
+		for x := 0; x < 5; x++ {
+			c[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+		}
+		var b [25]uint32
+		for x := 0; x < 5; x++ {
+			d := c[(x+4)%5] ^ bits.RotateLeft32(c[(x+1)%5], 1)
+			for y := 0; y < 5; y++ {
+				// B[y, 2x+3y] = ROT(A[x, y] ^ D[x], r[x, y])
+				b[y+5*((2*x+3*y)%5)] = bits.RotateLeft32(a[x+5*y]^d, keccakF800Rho[x+5*y])
+			}
+		}
+		for y := 0; y < 5; y++ {
+			for x := 0; x < 5; x++ {
+				a[x+5*y] = b[x+5*y] ^ (^b[(x+1)%5+5*y] & b[(x+2)%5+5*y])
+			}
+		}
+		a[0] ^= rc
+	}
+	return a
+}
+
+// TestKeccakF800 checks KeccakF800 against keccakF800External and keccakFLanesRef on random states and the
+// all-zero state, pins the tables spec derives for 32-bit lanes to the published ones, and compares its gate
+// counts, as the gadget benchmarks report them, with keccakF's: 22 rounds of half-width lanes, 11/24 of the
+// AND gates.
+func TestKeccakF800(t *testing.T) {
+	if got := spec.RotationOffsetsFor(32); got != keccakF800Rho {
+		t.Fatalf("RotationOffsetsFor(32) = %v, want %v", got, keccakF800Rho)
+	}
+	for i, rc := range spec.RoundConstants(32, 22) {
+		if rc != uint64(keccakF800Rounds[i]) {
+			t.Fatalf("RoundConstants(32, 22)[%d] = %08x, want %08x", i, rc, keccakF800Rounds[i])
+		}
+	}
+
+	rnd := rand.New(rand.NewSource(1037))
+	for trial := 0; trial < 9; trial++ {
+		var a [25]uint64
+		var a32 [25]uint32
+		var in [25][32]frontend.Variable
+		for i := range a {
+			if trial > 0 {
+				a32[i] = rnd.Uint32()
+			}
+			a[i] = uint64(a32[i])
+			copy(in[i][:], bitsOf(binary.LittleEndian.AppendUint32(nil, a32[i])))
+		}
+		out := KeccakF800(&recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}, in)
+		want, ref := keccakF800External(a32), keccakFLanesRef(32, a)
+		for i := range out {
+			b, err := assignedBytes(out[i][:])
+			if err != nil || binary.LittleEndian.Uint32(b) != want[i] {
+				t.Fatalf("KeccakF800 lane %d of %x is %x (%v), keccakF800External says %08x", i, a32, b, err, want[i])
+			}
+			if ref[i] != uint64(want[i]) {
+				t.Fatalf("keccakFLanesRef lane %d of %x is %08x, keccakF800External says %08x", i, a32, ref[i], want[i])
+			}
+		}
+	}
+
+	calls := map[string]*GateStats{}
+	for _, g := range gadgetBenchmarks() {
+		if g.Name == "keccakF" || g.Name == "KeccakF800" {
+			calls[g.Name] = gadgetCalls(g)
+		}
+	}
+	f800, f1600 := calls["KeccakF800"], calls["keccakF"]
+	t.Logf("KeccakF800 %d add %d sub %d mul, keccakF %d add %d sub %d mul", f800.Add, f800.Sub, f800.Mul, f1600.Add, f1600.Sub, f1600.Mul)
+	if f800.Mul != 22*800 || 24*f800.Mul != 11*f1600.Mul {
+		t.Fatalf("KeccakF800 builds %d AND, keccakF %d; expected 22 rounds of 800", f800.Mul, f1600.Mul)
+	}
+}
+
+// TestKeccakF400 checks KeccakF400 against keccakFLanesRef and the all-zero known answer of Keccak-f[400],
+// pins the mod-16 ρ offsets to the Keccak reference table, runs every width keccakP accepts against
+// the reference and checks that the others panic.
+func TestKeccakF400(t *testing.T) {
+	// the ρ offsets of the Keccak reference (x+5y order) reduced mod 16
+	if got, want := spec.RotationOffsetsFor(16), [25]int{0, 1, 14, 12, 11, 4, 12, 6, 7, 4, 3, 10, 11, 9, 7, 9, 13, 15, 5, 8, 2, 2, 13, 8, 14}; got != want {
+		t.Fatalf("RotationOffsetsFor(16) = %v, want %v", got, want)
+	}
+	// Keccak-f[400] of the all-zero state
+	zero := [25]uint64{
+		0x09f5, 0x40ac, 0x0fa9, 0x14f5, 0xe89f, 0xeca0, 0x5bd1, 0x7870, 0xeff0, 0xbf8f, 0x0337, 0x6052, 0xdc75,
+		0x0ec9, 0xe776, 0x5246, 0x59a1, 0x5d81, 0x6d95, 0x6e14, 0x633e, 0x58ee, 0x71ff, 0x714c, 0xb38e,
+	}
+	if got := keccakFLanesRef(16, [25]uint64{}); got != zero {
+		t.Fatalf("keccakFLanesRef(16, 0) = %04x, want %04x", got, zero)
+	}
+
+	laneBits := func(v uint64, w int) []frontend.Variable {
+		return bitsOf(binary.LittleEndian.AppendUint64(nil, v))[:w]
+	}
+	laneWord := func(lane []frontend.Variable) (uint64, error) {
+		plain, err := VariablesToBits(lane)
+		var v uint64
+		for j, b := range plain {
+			v |= uint64(b) << j
+		}
+		return v, err
+	}
+	rnd := rand.New(rand.NewSource(1038))
+	for trial := 0; trial < 8; trial++ {
+		var a [25]uint64
+		var in [25][16]frontend.Variable
+		for i := range a {
+			if trial > 0 {
+				a[i] = uint64(rnd.Intn(1 << 16))
+			}
+			copy(in[i][:], laneBits(a[i], 16))
+		}
+		out := KeccakF400(&recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}, in)
+		want := keccakFLanesRef(16, a)
+		for i := range out {
+			if v, err := laneWord(out[i][:]); err != nil || v != want[i] {
+				t.Fatalf("KeccakF400 lane %d of %04x is %04x (%v), keccakFLanesRef says %04x", i, a, v, err, want[i])
+			}
+		}
+	}
+
+	for _, w := range []int{4, 8, 16, 32, 64} {
+		var a [25]uint64
+		lanes := make([][]frontend.Variable, 25)
+		for i := range a {
+			a[i] = rnd.Uint64() & (uint64(1)<<w - 1)
+			lanes[i] = laneBits(a[i], w)
+		}
+		out := keccakFLanes(&recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}, lanes)
+		want := keccakFLanesRef(w, a)
+		for i := range out {
+			if v, err := laneWord(out[i]); err != nil || v != want[i] {
+				t.Fatalf("w = %d: lane %d is %x (%v), keccakFLanesRef says %x", w, i, v, err, want[i])
+			}
+		}
+	}
+	if want := keccakP1600Ref([25]uint64{1, 2, 3}, 24); keccakFLanesRef(64, [25]uint64{1, 2, 3}) != want {
+		t.Fatalf("keccakFLanesRef(64, ·) is not Keccak-f[1600]")
+	}
+	for _, w := range []int{1, 2, 12, 48, 128} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("keccakP accepted %d-bit lanes", w)
+				}
+			}()
+			lanes := make([][]frontend.Variable, 25)
+			for i := range lanes {
+				lanes[i] = symbolicBits(w)
+			}
+			keccakP(&recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}, lanes, 0, 1)
+		}()
+	}
+
+	for _, g := range gadgetBenchmarks() {
+		if g.Name == "KeccakF400" {
+			if stats := gadgetCalls(g); stats.Mul != 20*400 {
+				t.Fatalf("KeccakF400 builds %d AND, expected 20 rounds of 400", stats.Mul)
+			}
+		}
+	}
+}
+
+// TestKeccakF200 checks KeccakF200 against the all-zero known answer of Keccak-f[200] and keccakFLanesRef
+// on random states, and its 18 rounds of 200 AND gates.
+func TestKeccakF200(t *testing.T) {
+	zero := [25]uint64{
+		0x3c, 0x28, 0x26, 0x84, 0x1c, 0xb3, 0x5c, 0x17, 0x1e, 0xaa, 0xe9, 0xb8, 0x11,
+		0x13, 0x4c, 0xea, 0xa3, 0x85, 0x2c, 0x69, 0xd2, 0xc5, 0xab, 0xaf, 0xea,
+	}
+	if got := keccakFLanesRef(8, [25]uint64{}); got != zero {
+		t.Fatalf("keccakFLanesRef(8, 0) = %02x, want %02x", got, zero)
+	}
+	rnd := rand.New(rand.NewSource(1039))
+	for trial := 0; trial < 64; trial++ {
+		var a [25]uint64
+		var in [25][8]frontend.Variable
+		for i := range a {
+			if trial > 0 {
+				a[i] = uint64(rnd.Intn(256))
+			}
+			copy(in[i][:], bitsOf([]byte{byte(a[i])}))
+		}
+		out := KeccakF200(&recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}, in)
+		want := keccakFLanesRef(8, a)
+		for i := range out {
+			if b, err := assignedBytes(out[i][:]); err != nil || uint64(b[0]) != want[i] {
+				t.Fatalf("KeccakF200 lane %d of %02x is %x (%v), keccakFLanesRef says %02x", i, a, b, err, want[i])
+			}
+		}
+	}
+	for _, g := range gadgetBenchmarks() {
+		if g.Name == "KeccakF200" {
+			if stats := gadgetCalls(g); stats.Mul != 18*200 {
+				t.Fatalf("KeccakF200 builds %d AND, expected 18 rounds of 200", stats.Mul)
+			}
+		}
+	}
+}
+
+// TestDuplex runs interleaved duplex calls of varying input and output lengths against duplexRef, checks
+// that the first call is the plain sponge (Keccak-256), that every call costs one keccakF, and that inputs
+// without room for the padding and outputs beyond the rate are refused at build time.
+func TestDuplex(t *testing.T) {
+	eval := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+	rnd := rand.New(rand.NewSource(1040))
+	for _, c := range []struct {
+		rate   int
+		domain byte
+	}{{1088, DomainKeccak}, {Shake128Rate, DomainSHAKE}, {576, 0x02}} {
+		d, ref := NewDuplex(c.rate, c.domain), &duplexRef{rate: c.rate / 8, domainSep: c.domain}
+		for call, inBytes := range []int{0, 1, 17, c.rate/8 - 1, 64, 0, c.rate/8 - 1, 5} {
+			in := make([]byte, inBytes)
+			rnd.Read(in)
+			outBytes := rnd.Intn(c.rate/8 + 1)
+			got, err := assignedBytes(d.DuplexCall(eval, bitsOf(in), 8*outBytes))
+			if want := ref.call(in, outBytes); err != nil || !bytes.Equal(got, want) {
+				t.Fatalf("rate %d, call %d (%d bytes in, %d out): %x (%v), duplexRef says %x", c.rate, call, inBytes, outBytes, got, err, want)
+			}
+		}
+	}
+
+	msg := randomMessages(1040, 1)[0]
+	got, err := assignedBytes(NewDuplex(Keccak256Config.RateBits, DomainKeccak).DuplexCall(eval, bitsOf(msg), 256))
+	if err != nil || !bytes.Equal(got, crypto.Keccak256(msg)) {
+		t.Fatalf("the first duplex call is %x (%v), not Keccak-256 %x", got, err, crypto.Keccak256(msg))
+	}
+
+	stats := &GateStats{}
+	d := NewDuplex(Keccak256Config.RateBits, DomainKeccak)
+	for i := 0; i < 3; i++ {
+		d.DuplexCall(&recordingAPI{field: gf2.ScalarField, stats: stats}, symbolicBits(8*(32+i)), 128)
+	}
+	if stats.Mul != 3*38400 {
+		t.Fatalf("3 duplex calls build %d AND, expected 3 keccakF", stats.Mul)
+	}
+
+	for _, c := range []struct{ inBits, outBits int }{{1088, 8}, {4, 8}, {0, 1089}, {0, -1}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("DuplexCall accepts %d input bits and %d output bits at rate 1088", c.inBits, c.outBits)
+				}
+			}()
+			NewDuplex(Keccak256Config.RateBits, DomainKeccak).DuplexCall(eval, symbolicBits(c.inBits), c.outBits)
+		}()
+	}
+}
+
+// TestTranscript pins a transcript to challenges computed from its documented encoding outside this
+// package, runs randomized sequences of appends and challenges through Transcript and NativeTranscript
+// and requires identical bits, and checks label separation and the keccakF count of a challenge.
+func TestTranscript(t *testing.T) {
+	eval := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+	hexOf := func(bits []int) string {
+		b := make([]byte, (len(bits)+7)/8)
+		for i, v := range bits {
+			b[i/8] |= byte(v) << (i % 8)
+		}
+		return hex.EncodeToString(b)
+	}
+	circuitBits := func(vs []frontend.Variable) []int {
+		bits, err := VariablesToBits(vs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bits
+	}
+
+	root := make([]byte, 32)
+	for i := range root {
+		root[i] = byte(i)
+	}
+	tr, native := NewTranscript("keccak-gf2 transcript"), NewNativeTranscript("keccak-gf2 transcript")
+	rootBits := circuitBits(bitsOf(root))
+	tr.Append(eval, "root", bitsOf(root))
+	native.Append("root", rootBits)
+	tr.Append(eval, "flag", []frontend.Variable{1, 0, 1})
+	native.Append("flag", []int{1, 0, 1})
+	for _, c := range []struct {
+		label string
+		n     int
+		want  string // hex of the first bytes of the challenge
+	}{{"alpha", 128, "9bf72f6e82a7b545443b84ef5b8a3065"}, {"beta", 1500, "5fc2255dfe5a642e9ed458cc1f838557"}} {
+		got, gotNative := hexOf(circuitBits(tr.Challenge(eval, c.label, c.n))), hexOf(native.Challenge(c.label, c.n))
+		if !strings.HasPrefix(got, c.want) || got != gotNative {
+			t.Fatalf("challenge %q is %s in-circuit and %s natively, want %s...", c.label, got, gotNative, c.want)
+		}
+	}
+	ones := make([]frontend.Variable, 3000)
+	nativeOnes := make([]int, 3000)
+	for i := range ones {
+		ones[i], nativeOnes[i] = 1, 1
+	}
+	tr.Append(eval, "big", ones)
+	native.Append("big", nativeOnes)
+	if got, gotNative := hexOf(circuitBits(tr.Challenge(eval, "gamma", 13))), hexOf(native.Challenge("gamma", 13)); got != "4a18" || gotNative != got {
+		t.Fatalf("challenge gamma is %s in-circuit and %s natively, want 4a18", got, gotNative)
+	}
+
+	rnd := rand.New(rand.NewSource(1041))
+	labels := []string{"", "a", "commitment", "evaluation point"}
+	for seq := 0; seq < 20; seq++ {
+		tr, native := NewTranscript(labels[seq%len(labels)]), NewNativeTranscript(labels[seq%len(labels)])
+		ops := 1 + rnd.Intn(12)
+		for op := 0; op < ops; op++ {
+			label := labels[rnd.Intn(len(labels))]
+			if rnd.Intn(2) == 0 {
+				bits := make([]int, rnd.Intn(2500))
+				vs := make([]frontend.Variable, len(bits))
+				for i := range bits {
+					bits[i] = rnd.Intn(2)
+					vs[i] = bits[i]
+				}
+				tr.Append(eval, label, vs)
+				native.Append(label, bits)
+				continue
+			}
+			n := 1 + rnd.Intn(3000)
+			if got, want := circuitBits(tr.Challenge(eval, label, n)), native.Challenge(label, n); !reflect.DeepEqual(got, want) {
+				t.Fatalf("sequence %d, operation %d: %d-bit challenge %q differs between Transcript and NativeTranscript", seq, op, n, label)
+			}
+		}
+	}
+
+	a, b := NewNativeTranscript("p"), NewNativeTranscript("p")
+	a.Append("x", rootBits)
+	b.Append("y", rootBits)
+	if reflect.DeepEqual(a.Challenge("c", 128), b.Challenge("c", 128)) {
+		t.Fatal("the label of appended data does not change the challenge")
+	}
+
+	for _, c := range []struct{ dataBits, perms int }{{256, 1}, {1400, 2}} {
+		stats := &GateStats{}
+		tr := NewTranscript("p")
+		tr.Append(&recordingAPI{field: gf2.ScalarField, stats: stats}, "data", symbolicBits(c.dataBits))
+		tr.Challenge(&recordingAPI{field: gf2.ScalarField, stats: stats}, "c", 128)
+		if stats.Mul != c.perms*38400 {
+			t.Fatalf("a challenge over %d data bits builds %d AND, expected %d keccakF", c.dataBits, stats.Mul, c.perms)
+		}
+	}
+}
+
+// TestSpongeRate checks what spongeRate derives for the five standard rates (SHAKE128, SHA3-224,
+// SHA3-256/Keccak-256/SHAKE256, SHA3-384, SHA3-512): block bytes, lanes, and the padding indices that Pad101
+// writes, around the block boundaries. computeKeccak must still agree with go-ethereum on a corpus of 1000
+// random messages.
+func TestSpongeRate(t *testing.T) {
+	for _, c := range []struct{ bits, bytes, lanes int }{{1344, 168, 21}, {1152, 144, 18}, {1088, 136, 17}, {832, 104, 13}, {576, 72, 9}} {
+		r := rateOf(c.bits)
+		if r != (spongeRate{Bits: c.bits, Bytes: c.bytes, Lanes: c.lanes}) {
+			t.Fatalf("rateOf(%d) = %+v, expected %d bytes in %d lanes", c.bits, r, c.bytes, c.lanes)
+		}
+		for _, msgBytes := range []int{0, 1, 64, c.bytes - 2, c.bytes - 1, c.bytes, c.bytes + 1, 2*c.bytes - 1} {
+			paddedBytes, domainIndex, lastIndex := r.padIndices(msgBytes)
+			if paddedBytes%c.bytes != 0 || paddedBytes <= msgBytes || paddedBytes-msgBytes > c.bytes ||
+				domainIndex != msgBytes || lastIndex != paddedBytes-1 {
+				t.Fatalf("rate %d, %d-byte message: padIndices = %d, %d, %d", c.bits, msgBytes, paddedBytes, domainIndex, lastIndex)
+			}
+			padded, err := assignedBytes(Pad101(bitsOf(make([]byte, msgBytes)), c.bits, DomainSHA3))
+			if err != nil || len(padded) != paddedBytes {
+				t.Fatalf("rate %d: Pad101 of %d bytes gives %d bytes (%v), expected %d", c.bits, msgBytes, len(padded), err, paddedBytes)
+			}
+			want := make([]byte, paddedBytes)
+			want[domainIndex] ^= DomainSHA3
+			want[lastIndex] ^= 0x80
+			if !bytes.Equal(padded, want) {
+				t.Fatalf("rate %d: Pad101 of %d zero bytes is %x", c.bits, msgBytes, padded)
+			}
+		}
+	}
+	if paddedBytes, domainIndex, lastIndex := rateOf(1088).padIndices(135); paddedBytes != 136 || domainIndex != 135 || lastIndex != 135 {
+		t.Fatal("a 135-byte Keccak-256 message should share its last byte between 0x01 and 0x80")
+	}
+	for _, rate := range []int{0, 1000, 1600, -64} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("rateOf accepts %d", rate)
+				}
+			}()
+			rateOf(rate)
+		}()
+	}
+
+	eval := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+	for i, msg := range randomMessages(1042, 1000) {
+		got, err := assignedBytes(computeKeccak(eval, bitsOf(msg)))
+		if err != nil || !bytes.Equal(got, crypto.Keccak256(msg)) {
+			t.Fatalf("message %d: computeKeccak gives %x (%v), go-ethereum %x", i, got, err, crypto.Keccak256(msg))
+		}
+	}
+}
+
+// TestKeccakMAC checks KeccakMAC against go-ethereum's Keccak-256 of key || msg for inputs of one and
+// several blocks, compiles a circuit for a 32-byte key and a 200-byte message that accepts the right key
+// and rejects a wrong one, and refuses a circuit whose Key does not have KeyBytes bytes.
+func TestKeccakMAC(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1043))
+	for _, c := range []struct{ keyBytes, msgBytes int }{{32, 0}, {32, 64}, {32, 103}, {32, 104}, {32, 200}, {1, 135}, {200, 300}} {
+		key, msg := make([]byte, c.keyBytes), make([]byte, c.msgBytes)
+		rnd.Read(key)
+		rnd.Read(msg)
+		if got, want := evalBytes(func(api frontend.API) []frontend.Variable { return KeccakMAC(api, bitsOf(key), bitsOf(msg)) }), crypto.Keccak256(append(append([]byte{}, key...), msg...)); !bytes.Equal(got, want) {
+			t.Fatalf("%d-byte key, %d-byte message: KeccakMAC is %x, go-ethereum says %x", c.keyBytes, c.msgBytes, got, want)
+		}
+	}
+	stats := &GateStats{}
+	KeccakMAC(&recordingAPI{field: gf2.ScalarField, stats: stats}, symbolicBits(8*32), symbolicBits(8*200))
+	if perms := SpongePermutations(1088, 232, 256); perms != 2 || stats.Mul != perms*38400 {
+		t.Fatalf("KeccakMAC of 232 bytes builds %d AND, expected %d keccakF", stats.Mul, perms)
+	}
+
+	key, msg := make([]byte, 32), make([]byte, 200)
+	rnd.Read(key)
+	rnd.Read(msg)
+	cr, err := compileCircuit(gf2.ScalarField, newKeccakMACCircuit(32, 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, newKeccakMACCircuit(32, 200))
+	a, err := keccakMACAssignment(key, msg, crypto.Keccak256(append(append([]byte{}, key...), msg...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), a, true); err != nil {
+		t.Fatal(err)
+	}
+	wrong := append([]byte{}, key...)
+	wrong[31] ^= 0x01
+	putBits(a.Key, wrong)
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), a, false); err != nil {
+		t.Fatal(err)
+	}
+
+	shifted := newKeccakMACCircuit(32, 200)
+	shifted.KeyBytes = 33
+	if _, err := compileCircuit(gf2.ScalarField, shifted); err == nil {
+		t.Fatal("a circuit with 32 key bytes compiles as a 33-byte key circuit")
+	}
+}
+
+// TestPad101Blocks pads messages of 0 to 300 bytes for four sponges and checks the blocks byte by byte
+// (domain byte, Domain|0x80 when they share the last byte, a whole extra block on a boundary) and by
+// outcome: absorbing them with keccakF1600Ref gives the x/crypto digest, so the padding is the one
+// x/crypto/sha3 applies internally.
+func TestPad101Blocks(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		rate   int
+		domain byte
+		sum    func([]byte) []byte
+	}{
+		{"Keccak-256", 1088, DomainKeccak, func(m []byte) []byte { return crypto.Keccak256(m) }},
+		{"SHA3-256", 1088, DomainSHA3, func(m []byte) []byte { d := sha3.Sum256(m); return d[:] }},
+		{"SHA3-512", 576, DomainSHA3, func(m []byte) []byte { d := sha3.Sum512(m); return d[:] }},
+		{"SHAKE128", 1344, DomainSHAKE, func(m []byte) []byte { d := make([]byte, 32); sha3.ShakeSum128(d, m); return d }},
+	} {
+		rateBytes := c.rate / 8
+		for n := 0; n <= 300; n++ {
+			msg := make([]byte, n)
+			for i := range msg {
+				msg[i] = byte(3*i + n)
+			}
+			blocks := Pad101Blocks(bitsOf(msg), c.rate, c.domain)
+			if len(blocks) != n/rateBytes+1 {
+				t.Fatalf("%s, %d bytes: %d blocks, expected %d", c.name, n, len(blocks), n/rateBytes+1)
+			}
+			var padded []byte
+			var a [25]uint64
+			for i, block := range blocks {
+				b, err := assignedBytes(block)
+				if err != nil || len(b) != rateBytes {
+					t.Fatalf("%s, %d bytes: block %d has %d bytes (%v)", c.name, n, i, len(b), err)
+				}
+				padded = append(padded, b...)
+				for j := 0; j < rateBytes/8; j++ {
+					a[j] ^= binary.LittleEndian.Uint64(b[8*j:])
+				}
+				a = keccakF1600Ref(a)
+			}
+			want := append(append([]byte{}, msg...), make([]byte, len(padded)-n)...)
+			want[n] ^= c.domain
+			want[len(want)-1] ^= 0x80
+			if !bytes.Equal(padded, want) {
+				t.Fatalf("%s, %d bytes: padded to %x", c.name, n, padded[n:])
+			}
+			var out []byte
+			for j := 0; j < rateBytes/8; j++ {
+				out = binary.LittleEndian.AppendUint64(out, a[j])
+			}
+			if digest := c.sum(msg); !bytes.Equal(out[:len(digest)], digest) {
+				t.Fatalf("%s, %d bytes: the padded blocks hash to %x, x/crypto says %x", c.name, n, out[:len(digest)], digest)
+			}
+		}
+	}
+}
+
+// TestTruncatedKeccak128 checks the 128-bit output mode: computeKeccak128 squeezes exactly the first 128
+// bits of computeKeccak, the truncated circuit saves 128 public inputs and assertions per instance and no
+// other gate, its digests are the first 16 bytes of Keccak-256, a flipped bit 127 is rejected, and a
+// 256-bit digest does not fit its witness.
+func TestTruncatedKeccak128(t *testing.T) {
+	const n = 2
+	api := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+	msg := symbolicBits(64 * 8)
+	full, short := computeKeccak(api, msg), computeKeccak128(api, msg)
+	if len(full) != 256 || len(short) != TruncatedBits || Keccak256Config.WithOutputBits(TruncatedBits) != (KeccakConfig{RateBits: 1088, OutputBits: 128, Rounds: 24, DomainSep: DomainKeccak}) {
+		t.Fatalf("computeKeccak squeezes %d bits, computeKeccak128 %d", len(full), len(short))
+	}
+	plain, err := gateStats(gf2.ScalarField, NewKeccak256Circuit(n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated, err := gateStats(gf2.ScalarField, NewTruncatedKeccak256Circuit(n, TruncatedBits))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("full: %+v, truncated: %+v", *plain, *truncated)
+	want := *plain
+	want.Assert -= n * 128
+	want.PublicInputs -= n * 128
+	if plain.PublicInputs != n*256 || *truncated != want {
+		t.Fatalf("truncated circuit costs %+v, expected %+v", *truncated, want)
+	}
+
+	cr, err := compileCircuit(gf2.ScalarField, NewTruncatedKeccak256Circuit(n, TruncatedBits))
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, NewTruncatedKeccak256Circuit(n, TruncatedBits))
+	msgs := randomMessages(1045, n)
+	assignment := NewTruncatedKeccak256Circuit(n, TruncatedBits)
+	for k, m := range msgs {
+		putBits(assignment.P[k][:], m)
+		putBits(assignment.Out[k], crypto.Keccak256Hash(m).Bytes()[:16])
+	}
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), assignment, true); err != nil {
+		t.Fatal(err)
+	}
+	assignment.Out[n-1][127] = 1 - assignment.Out[n-1][127].(int)
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), assignment, false); err != nil {
+		t.Fatalf("flipped bit 127: %v", err)
+	}
+	fullDigests := NewKeccak256Circuit(n)
+	for k, m := range msgs {
+		putBits(fullDigests.P[k][:], m)
+		putBits(fullDigests.Out[k], crypto.Keccak256Hash(m).Bytes())
+	}
+	if _, err := is.SolveInput(fullDigests, 0); err == nil || !strings.Contains(err.Error(), "Out[0][128] is not an input") {
+		t.Fatalf("a 256-bit digest solved into the truncated witness: %v", err)
+	}
+}
+
+// TestSha256 checks the SHA-256 gadget: Sha256 (through evalBytes) and bitSha256 against crypto/sha256
+// for messages of 0..130 bytes; sha256Schedule and every round of sha256Compress against the bit-level
+// reference for random chaining values and blocks; the gate counts, pinned with their split between the
+// adders and the rest; and a compiled 2-instance sha256Circuit, which must accept crypto/sha256's digests
+// and reject a flipped digest or message bit.
+func TestSha256(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1046))
+	for n := 0; n <= 130; n++ {
+		msg := make([]byte, n)
+		rnd.Read(msg)
+		want := sha256.Sum256(msg)
+		if got := evalBytes(func(api frontend.API) []frontend.Variable { return Sha256(api, bitsOf(msg)) }); !bytes.Equal(got, want[:]) {
+			t.Fatalf("Sha256 of %d bytes is %x, crypto/sha256 says %x", n, got, want)
+		}
+		if got := bitSha256(msg); !bytes.Equal(got, want[:]) {
+			t.Fatalf("bitSha256 of %d bytes is %x, crypto/sha256 says %x", n, got, want)
+		}
+	}
+
+	toWord := func(w sha256Word) (bitWord, error) {
+		var b bitWord
+		for j, v := range w {
+			x, ok := assignedBit(v)
+			if !ok {
+				return b, fmt.Errorf("bit %d is %v, not a constant bit", j, v)
+			}
+			b[j] = x
+		}
+		return b, nil
+	}
+	for n := 0; n < 100; n++ {
+		block := make([]byte, 64)
+		rnd.Read(block)
+		var h [8]sha256Word
+		var ref [8]bitWord
+		for i := range h {
+			x := rnd.Uint32()
+			h[i], ref[i] = sha256Const(x), bitWordOf(x)
+		}
+		eval := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+		schedule, w := sha256Schedule(eval, bitsOf(block)), bitSha256Schedule(block)
+		for i := range schedule {
+			if got, err := toWord(schedule[i]); err != nil || got != w[i] {
+				t.Fatalf("block %d: schedule word %d differs from the bit reference (%v)", n, i, err)
+			}
+		}
+		var failure error
+		observed := 0
+		start := ref
+		out := sha256Compress(eval, h, bitsOf(block), func(round int, state [8]sha256Word) {
+			if failure != nil {
+				return
+			}
+			observed++
+			ref = bitSha256Round(ref, w[round], round)
+			for i := range state {
+				if got, err := toWord(state[i]); err != nil {
+					failure = fmt.Errorf("round %d, word %d: %w", round, i, err)
+				} else if got != ref[i] {
+					failure = fmt.Errorf("round %d: circuit and bit reference differ in word %d", round, i)
+				}
+			}
+		})
+		if failure != nil {
+			t.Fatalf("block %d: %v", n, failure)
+		}
+		if observed != 64 {
+			t.Fatalf("block %d: observed %d rounds, expected 64", n, observed)
+		}
+		for i := range out {
+			if got, err := toWord(out[i]); err != nil || got != bitWordAdd(start[i], ref[i]) {
+				t.Fatalf("block %d: chaining value word %d differs from the bit reference (%v)", n, i, err)
+			}
+		}
+		if eval.stats.ConstGates != 0 {
+			t.Fatalf("block %d: %d gates on constant operands", n, eval.stats.ConstGates)
+		}
+	}
+
+	count := func(f func(api frontend.API)) GateStats {
+		stats := &GateStats{}
+		f(&recordingAPI{field: gf2.ScalarField, stats: stats})
+		return *stats
+	}
+	var state [8]sha256Word
+	for i := range state {
+		copy(state[i][:], symbolicBits(32))
+	}
+	compress := count(func(api frontend.API) { sha256Compress(api, state, symbolicBits(512), nil) })
+	// everything but the adders: σ0 and σ1 of 48 schedule words, Σ0, Σ1, Ch and Maj of 64 rounds
+	rest := count(func(api frontend.API) {
+		for r := 0; r < 48; r++ {
+			sha256SmallSigma0(api, state[0])
+			sha256SmallSigma1(api, state[0])
+		}
+		for r := 0; r < 64; r++ {
+			sha256BigSigma0(api, state[0])
+			sha256BigSigma1(api, state[4])
+			sha256Ch(api, state[4], state[5], state[6])
+			sha256Maj(api, state[0], state[1], state[2])
+		}
+	})
+	adders := GateStats{Add: compress.Add - rest.Add, Sub: compress.Sub - rest.Sub, Mul: compress.Mul - rest.Mul}
+	add := count(func(api frontend.API) { sha256Add(api, state[0], state[1]) })
+	hash := count(func(api frontend.API) { Sha256(api, symbolicBits(64*8)) })
+	t.Logf("sha256Compress: %+v, of which adders %+v", compress, adders)
+	for _, c := range []struct {
+		name      string
+		got, want GateStats
+	}{
+		{"sha256Add", add, GateStats{Add: 123, Mul: 31}},
+		{"sha256Compress", compress, GateStats{Add: 93666, Sub: 1891, Mul: 22573}},
+		{"adders of sha256Compress", adders, GateStats{Add: 69714, Sub: 1891, Mul: 18477}},
+		{"Sha256 of 64 bytes", hash, GateStats{Add: 154572, Sub: 4298, Mul: 38444}},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s costs %+v, expected %+v", c.name, c.got, c.want)
+		}
+	}
+	keccak, err := gateStats(gf2.ScalarField, NewKeccak256Circuit(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("64-byte message: SHA-256 %d XOR/NOT and %d AND, Keccak-256 %d XOR/NOT and %d AND", hash.Add+hash.Sub, hash.Mul, keccak.Add+keccak.Sub, keccak.Mul)
+
+	const n = 2
+	cr, err := compileCircuit(gf2.ScalarField, NewSha256Circuit(n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, NewSha256Circuit(n))
+	a, err := sha256Assignment(randomMessages(1046, n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), a, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, bit := range []*frontend.Variable{&a.Out[n-1][255], &a.P[0][7]} {
+		*bit = 1 - (*bit).(int)
+		if err := expectVerdict(is, cr.GetLayeredCircuit(), a, false); err != nil {
+			t.Fatalf("flipped bit: %v", err)
+		}
+		*bit = 1 - (*bit).(int)
+	}
+}
+
+// TestSha256Blocks checks multi-block SHA-256: the padding and block count around the length-field spill
+// (55 bytes pad to one block, 56 to two), Sha256 against crypto/sha256 for 0, 55, 56, 64, 119 and 1000
+// bytes, linear gate growth (a block of message bits between the first block and the padding costs exactly
+// one non-constant compression), and compiled circuits for 56 and 1000 bytes, the latter with its 14
+// middle blocks in the memoized compression.
+func TestSha256Blocks(t *testing.T) {
+	for n, blocks := range map[int]int{0: 1, 55: 1, 56: 2, 63: 2, 64: 2, 119: 2, 120: 3, 1000: 16} {
+		pad := sha256Padding(n)
+		if sha256Blocks(n) != blocks || (n+len(pad))%64 != 0 || pad[0] != 0x80 || binary.BigEndian.Uint64(pad[len(pad)-8:]) != uint64(8*n) {
+			t.Fatalf("%d bytes: padding %x in %d blocks, expected %d blocks", n, pad, sha256Blocks(n), blocks)
+		}
+	}
+	rnd := rand.New(rand.NewSource(1047))
+	for _, n := range []int{0, 55, 56, 64, 119, 1000} {
+		msg := make([]byte, n)
+		rnd.Read(msg)
+		if got, want := evalBytes(func(api frontend.API) []frontend.Variable { return Sha256(api, bitsOf(msg)) }), sha256.Sum256(msg); !bytes.Equal(got, want[:]) {
+			t.Fatalf("Sha256 of %d bytes is %x, crypto/sha256 says %x", n, got, want)
+		}
+	}
+
+	count := func(msgBytes int) GateStats {
+		stats := &GateStats{}
+		Sha256(&recordingAPI{field: gf2.ScalarField, stats: stats}, symbolicBits(8*msgBytes))
+		return *stats
+	}
+	var state [8]sha256Word
+	for i := range state {
+		copy(state[i][:], symbolicBits(32))
+	}
+	block := &GateStats{}
+	sha256Compress(&recordingAPI{field: gf2.ScalarField, stats: block}, state, symbolicBits(512), nil)
+	for _, n := range []int{128, 192, 960} {
+		short, long := count(n), count(n+64)
+		if grown := (GateStats{Add: long.Add - short.Add, Sub: long.Sub - short.Sub, Mul: long.Mul - short.Mul}); grown != *block {
+			t.Fatalf("%d bytes cost %+v more than %d bytes, expected one compression %+v", n+64, grown, n, *block)
+		}
+	}
+
+	for _, n := range []int{56, 1000} {
+		cr, err := compileCircuit(gf2.ScalarField, NewSha256CircuitOfLength(1, n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		is := newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, NewSha256CircuitOfLength(1, n))
+		msg := make([]byte, n)
+		rnd.Read(msg)
+		a, err := sha256Assignment([][]byte{msg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := expectVerdict(is, cr.GetLayeredCircuit(), a, true); err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		a.P[0][8*n-1] = 1 - a.P[0][8*n-1].(int)
+		if err := expectVerdict(is, cr.GetLayeredCircuit(), a, false); err != nil {
+			t.Fatalf("%d bytes, last message bit flipped: %v", n, err)
+		}
+	}
+}
+
+// TestDigestLimbs checks that DigestToFieldLimbs, evaluated over BN254, and DigestToLimbs give the same
+// limbs at every limb width that divides the digest and fits the field, and that the limbs, written back
+// big-endian, are the digest. Widths that are not whole bytes, do not divide the digest or do not fit the
+// field panic.
+func TestDigestLimbs(t *testing.T) {
+	bn254 := ecc.BN254.ScalarField()
+	digest := crypto.Keccak256(randomMessages(978, 1)[0])
+	for _, limbBits := range []int{8, 16, 32, 64, 128} {
+		limbs := DigestToLimbs(digest, limbBits)
+		fieldLimbs := DigestToFieldLimbs(&fieldAPI{field: bn254}, bitsOf(digest), limbBits)
+		if len(limbs) != 256/limbBits || len(fieldLimbs) != len(limbs) {
+			t.Fatalf("%d-bit limbs: %d off circuit and %d in circuit", limbBits, len(limbs), len(fieldLimbs))
+		}
+		var joined []byte
+		for l := range limbs {
+			if limbs[l].Cmp(fieldLimbs[l].(*big.Int)) != 0 {
+				t.Fatalf("%d-bit limb %d is %x in circuit, %x off circuit", limbBits, l, fieldLimbs[l], limbs[l])
+			}
+			joined = append(joined, limbs[l].FillBytes(make([]byte, limbBits/8))...)
+		}
+		if !bytes.Equal(joined, digest) {
+			t.Fatalf("%d-bit limbs join to %x, want %x", limbBits, joined, digest)
+		}
+	}
+	for _, limbBits := range []int{0, 12, 24, 256} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%d-bit limbs over BN254 do not panic", limbBits)
+				}
+			}()
+			DigestToFieldLimbs(&fieldAPI{field: bn254}, bitsOf(digest), limbBits)
+		}()
+	}
+}
+
+// TestAbsorbU64Limbs evaluates AbsorbU64Limbs over BN254 on constant limbs against go-ethereum, across
+// block boundaries and after bytes absorbed with Absorb, checks that lane-aligned limbs never wait in the
+// pending buffer, proves a compiled limb circuit over BN254, and compares its cost with decomposing the
+// limbs first and absorbing the bits.
+func TestAbsorbU64Limbs(t *testing.T) {
+	field := ecc.BN254.ScalarField()
+	for _, n := range []int{0, 1, 16, 17, 18, 34, 40} {
+		for _, prefixBytes := range []int{0, 3, 16, 136} {
+			msg := make([]byte, prefixBytes+8*n)
+			rand.New(rand.NewSource(int64(993 + n))).Read(msg)
+			limbs := make([]frontend.Variable, n)
+			for i := range limbs {
+				limbs[i] = binary.LittleEndian.Uint64(msg[prefixBytes+8*i:])
+			}
+			eval := &recordingAPI{field: field, stats: &GateStats{}}
+			s := NewSponge(Keccak256Config.RateBits, Keccak256Config.DomainSep)
+			s.Absorb(eval, bitsOf(msg[:prefixBytes]))
+			AbsorbU64Limbs(eval, s, limbs)
+			if aligned := prefixBytes%8 == 0; aligned && n > 0 && len(s.pending) != 0 {
+				t.Fatalf("%d limbs after %d bytes: %d bits pending", n, prefixBytes, len(s.pending))
+			}
+			got, err := assignedBytes(s.Squeeze(eval, 256))
+			if err != nil {
+				t.Fatalf("%d limbs after %d bytes: %v", n, prefixBytes, err)
+			}
+			if want := crypto.Keccak256(msg); !bytes.Equal(got, want) {
+				t.Fatalf("%d limbs after %d bytes: digest %x, go-ethereum %x", n, prefixBytes, got, want)
+			}
+		}
+	}
+
+	cr, err := ecgo.Compile(field, newKeccakLimbCircuit(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, 8*20)
+	rand.New(rand.NewSource(993)).Read(msg)
+	a := newKeccakLimbCircuit(20)
+	for i := range a.Limbs {
+		a.Limbs[i] = new(big.Int).SetUint64(binary.LittleEndian.Uint64(msg[8*i:]))
+	}
+	putBits(a.Out[:], crypto.Keccak256(msg))
+	is := newCheckedSolver(cr.GetInputSolver(), field, newKeccakLimbCircuit(20))
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), a, true); err != nil {
+		t.Fatal(err)
+	}
+	a.Out[0] = 1 - a.Out[0].(int)
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), a, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Against the bit path over BN254: the same 20-limb hash with the limbs decomposed into a message buffer
+	// and absorbed with s.Absorb. Both decompose each limb once and XOR the same bits into the same lanes,
+	// so the limb absorb saves the buffer and no constraint.
+	limbStats, err := gateStats(field, newKeccakLimbCircuit(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bitsStats, err := gateStats(field, (*keccakLimbBitsCircuit)(newKeccakLimbCircuit(20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *limbStats != *bitsStats {
+		t.Fatalf("limb absorb costs %+v, the bit path %+v", *limbStats, *bitsStats)
+	}
+	if limbStats.Boolean != 64*20 {
+		t.Fatalf("limb absorb asserts %d bits boolean, want the %d of its decompositions", limbStats.Boolean, 64*20)
+	}
+	bcr, err := ecgo.Compile(field, (*keccakLimbBitsCircuit)(newKeccakLimbCircuit(20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	limbGates, bitsGates := 0, 0
+	for _, w := range LayerWidths(cr.GetLayeredCircuit()) {
+		limbGates += w.Mul + w.Add
+	}
+	for _, w := range LayerWidths(bcr.GetLayeredCircuit()) {
+		bitsGates += w.Mul + w.Add
+	}
+	if limbGates > bitsGates {
+		t.Fatalf("compiled over BN254, the limb absorb has %d gates, the bit path %d", limbGates, bitsGates)
+	}
+	t.Logf("BN254, 20 limbs: %d layered gates with the limb absorb, %d with the bit path", limbGates, bitsGates)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("AbsorbU64Limbs over GF(2) should panic")
+		}
+	}()
+	AbsorbU64Limbs(&recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}, NewSponge(Keccak256Config.RateBits, DomainKeccak), []frontend.Variable{0})
+}
+
+// TestContainsBytes proves "the message contains abab in its first 32 bytes" for markers at offset 0, at
+// the window edge and behind overlapping partial matches, and rejects a marker crossing the window edge,
+// an absent one and one present only as overlapping partial matches. It also pins SubstringCost.
+func TestContainsBytes(t *testing.T) {
+	const window = 32
+	marker := []byte("abab")
+	cr, err := compileCircuit(gf2.ScalarField, newSubstringCircuit(window, len(marker)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, newSubstringCircuit(window, len(marker)))
+	for _, c := range []struct {
+		name   string
+		at     int
+		text   string
+		accept bool
+	}{
+		{"offset 0", 0, "abab", true},
+		{"window edge", window - len(marker), "abab", true},
+		{"across the window edge", window - len(marker) + 1, "abab", false},
+		{"absent", 0, "", false},
+		{"after partial matches", 5, "abaababab", true},
+		{"partial matches only", 5, "abaabaXbab", false},
+	} {
+		msg := make([]byte, 64)
+		copy(msg[c.at:], c.text)
+		a, err := substringAssignment(msg, marker, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := expectVerdict(is, cr.GetLayeredCircuit(), a, c.accept); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+	}
+
+	for _, size := range [][2]int{{32, 4}, {64, 4}, {64, 8}, {64, 64}} {
+		stats := GateStats{}
+		msg := make([]frontend.Variable, 8*64)
+		for i := range msg {
+			msg[i] = &symbolicWire{}
+		}
+		ContainsBytes(&recordingAPI{field: gf2.ScalarField, stats: &stats}, msg, msg[:8*size[1]], size[0])
+		if want := SubstringCost(size[0], size[1]); stats != want {
+			t.Errorf("window %d, %d-byte marker: %+v, SubstringCost says %+v", size[0], size[1], stats, want)
+		}
+		t.Logf("window %d, %d-byte marker: %d add, %d sub, %d mul", size[0], size[1], stats.Add, stats.Sub, stats.Mul)
+	}
+}
+
+// fakeClock is a clock that only moves when advanced.
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+// slowSolver stands in for a large configuration: it forwards to is and then advances clock by the next
+// of delays (cycling) per assignment, so that solving dominates the run the way it does for wide circuits.
+type slowSolver struct {
+	is     batchSolver
+	clock  *fakeClock
+	delays []time.Duration
+	solved int
+}
+
+func (s *slowSolver) SolveInputs(assignments []frontend.Circuit) (*irwg.Witness, error) {
+	for range assignments {
+		s.clock.now = s.clock.now.Add(s.delays[s.solved%len(s.delays)])
+		s.solved++
+	}
+	return s.is.SolveInputs(assignments)
+}
+
+// TestSolveEstimate runs a chunked solve of 8 assignments with a solver that costs 40ms and 60ms of a fake
+// clock in turn, and checks that every assignment's solve time is recorded, that every progress report
+// extrapolates Elapsed·Total/Done, and that with chunks of equal cost the estimate after the first chunk
+// is the actual total, in the progress reports and in stats.json.
+func TestSolveEstimate(t *testing.T) {
+	const assignments, perFile = 8, 2
+	dir := t.TempDir()
+	var reports []SolveProgress
+	progress := func(p SolveProgress) { reports = append(reports, p) }
+	clock := &fakeClock{now: time.Unix(1022, 0)}
+	delays := []time.Duration{40 * time.Millisecond, 60 * time.Millisecond}
+	is := &slowSolver{is: solver, clock: clock, delays: delays}
+	if _, err := hashMessages(is, NHashes, randomMessages(1022, assignments*NHashes), nil, dir, perFile, progress, clock.Now); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != assignments/perFile || reports[len(reports)-1].Done != assignments {
+		t.Fatalf("%d progress reports, expected %d up to %d assignments: %+v", len(reports), assignments/perFile, assignments, reports)
+	}
+	for i, p := range reports {
+		chunk := time.Duration(i+1) * (delays[0] + delays[1])
+		if p.Elapsed != chunk || p.PerAssignment != (delays[0]+delays[1])/2 || p.Estimate != p.Elapsed*time.Duration(p.Total)/time.Duration(p.Done) {
+			t.Fatalf("progress report %d: %+v, expected %v elapsed at %v per assignment", i, p, chunk, (delays[0]+delays[1])/2)
+		}
+	}
+	stats, err := LoadSolveStats(filepath.Join(dir, "stats.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Chunks) != len(reports) {
+		t.Fatalf("stats.json has %d chunks, expected %d", len(stats.Chunks), len(reports))
+	}
+	for i, c := range stats.Chunks {
+		if fmt.Sprint(c.BuildNs) != "[0 0]" || fmt.Sprint(c.SolveNs) != fmt.Sprint([]int64{int64(delays[0]), int64(delays[1])}) {
+			t.Fatalf("chunk %d of stats.json: %+v, expected the solve times %v", i, c, delays)
+		}
+	}
+	if stats.FirstEstimateNs != int64(reports[0].Estimate) || stats.FirstEstimateNs != stats.TotalNs {
+		t.Errorf("stats.json has a first estimate of %v and a total of %v, the first progress report %v", time.Duration(stats.FirstEstimateNs), time.Duration(stats.TotalNs), reports[0].Estimate)
+	}
+}
+
+// TestSha3_512 checks Sha3_512 against x/crypto's sha3.Sum512 for random 64-byte messages and around the
+// 72-byte block, proves a batch of SHA3-512 digests, and logs its gate counts next to SHA3-256's.
+func TestSha3_512(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1022))
+	lengths := []int{0, 70, 71, 72, 143, 144}
+	for i := 0; i < 16; i++ {
+		lengths = append(lengths, 64)
+	}
+	for _, n := range lengths {
+		msg := make([]byte, n)
+		rnd.Read(msg)
+		if got, want := evalBytes(func(api frontend.API) []frontend.Variable { return Sha3_512(api, bitsOf(msg)) }), sha3.Sum512(msg); !bytes.Equal(got, want[:]) {
+			t.Fatalf("Sha3_512 of %d bytes is %x, x/crypto says %x", n, got, want)
+		}
+	}
+
+	const n = 2
+	cr, err := compileCircuit(gf2.ScalarField, NewSha3_512Circuit(n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, NewSha3_512Circuit(n))
+	a, err := sha3BatchAssignment(Sha3_512Config, randomMessages(1022, n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), a, true); err != nil {
+		t.Fatal(err)
+	}
+	a.Out[1][511] = 1 - a.Out[1][511].(int)
+	if err := expectVerdict(is, cr.GetLayeredCircuit(), a, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// symbolic messages: the counts are those of one instance, without the digest assertions
+	count := func(hash func(frontend.API, []frontend.Variable) []frontend.Variable, size int) GateStats {
+		stats := GateStats{}
+		msg := make([]frontend.Variable, 8*size)
+		for i := range msg {
+			msg[i] = &symbolicWire{}
+		}
+		hash(&recordingAPI{field: gf2.ScalarField, stats: &stats}, msg)
+		return stats
+	}
+	perm := count(Sha3_256, 64)
+	for _, size := range []int{64, 136, 256} {
+		s256, s512 := count(Sha3_256, size), count(Sha3_512, size)
+		t.Logf("%3d-byte message: SHA3-256 %d add %d mul, SHA3-512 %d add %d mul", size, s256.Add, s256.Mul, s512.Add, s512.Mul)
+		if blocks := size/72 + 1; s512.Mul != blocks*perm.Mul {
+			t.Errorf("%d-byte SHA3-512: %d mul gates, expected %d keccakF of %d", size, s512.Mul, blocks, perm.Mul)
+		}
+	}
+	if s256, s512 := count(Sha3_256, 64), count(Sha3_512, 64); s256 != s512 {
+		t.Errorf("64-byte message: SHA3-512 costs %+v, SHA3-256 %+v; both are one keccakF", s512, s256)
+	}
+}
+
+// testdata/storage_proof.json is an eth_getProof response for a 48-slot storage trie: storage proof 0
+// (slot 5) runs through two branch nodes to an even-path leaf, proof 1 (slot 0) through one branch node
+// to an odd-path leaf holding a single-byte value.
+func TestStorageProof(t *testing.T) {
+	p, err := LoadEthGetProof(filepath.Join("testdata", "storage_proof.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range p.StorageProof {
+		a, err := storageProofAssignment(p, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := newStorageProofCircuit(a.Shape)
+		cr, err := compileCircuit(gf2.ScalarField, template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		is := newCheckedSolver(cr.GetInputSolver(), gf2.ScalarField, template)
+		if err := expectVerdict(is, cr.GetLayeredCircuit(), a, true); err != nil {
+			t.Fatalf("storage proof %d: %v", k, err)
+		}
+		if k > 0 {
+			continue
+		}
+
+		// a sibling reference of the middle branch node: free in the shape, but it changes the node's hash
+		n := a.Shape.Nodes[1]
+		raw, err := hex.DecodeString(strings.TrimPrefix(p.StorageProof[k].Proof[1], "0x"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sibling := -1
+		for off := 2 + int(raw[0]-0xf8); sibling < 0 && off < n.Len; off++ {
+			if raw[off] == 0xa0 && off+1 != n.Child {
+				sibling = 8 * (off + 1)
+			} else if raw[off] == 0xa0 {
+				off += 32
+			}
+		}
+		tampered, _ := storageProofAssignment(p, k)
+		tampered.Nodes[1][sibling] = 1 - tampered.Nodes[1][sibling].(int)
+		if err := expectVerdict(is, cr.GetLayeredCircuit(), tampered, false); err != nil {
+			t.Fatalf("tampered node: %v", err)
+		}
+		claimed, _ := storageProofAssignment(p, k)
+		claimed.Value[0] = 1 - claimed.Value[0].(int)
+		if err := expectVerdict(is, cr.GetLayeredCircuit(), claimed, false); err != nil {
+			t.Fatalf("claimed value: %v", err)
+		}
+	}
+
+	// the last byte of the leaf is a value byte: the builder must notice that the leaf no longer hashes to
+	// the reference in its parent
+	leaf, err := hex.DecodeString(strings.TrimPrefix(p.StorageProof[0].Proof[2], "0x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf[len(leaf)-1] ^= 1
+	p.StorageProof[0].Proof[2] = "0x" + hex.EncodeToString(leaf)
+	if _, err := storageProofAssignment(p, 0); err == nil {
+		t.Fatal("the assignment builder accepts a tampered proof node")
+	}
+}
+
+// TestThresholdCircuit checks the soft comparison mode: popCount and subBorrow over every input of the
+// threshold circuit's widths, CompareDigest on an equal digest and on single flipped bits, and
+// keccakThresholdCircuit with exactly MinMatches matching instances, evaluated and compiled, accepting a
+// threshold one below or equal to the count and rejecting one above it.
+func TestThresholdCircuit(t *testing.T) {
+	api := &fieldAPI{field: gf2.ScalarField}
+	bit := func(v frontend.Variable) uint { return api.value(v).Bit(0) }
+	number := func(v []frontend.Variable) int {
+		n := 0
+		for i := range v {
+			n |= int(bit(v[i])) << i
+		}
+		return n
+	}
+	constBits := func(x, width int) []frontend.Variable {
+		v := make([]frontend.Variable, width)
+		for i := range v {
+			v[i] = x >> i & 1
+		}
+		return v
+	}
+	for x := 0; x < 1<<NHashes; x++ {
+		if got := number(popCount(api, constBits(x, NHashes), CountBits)); got != bits.OnesCount(uint(x)) {
+			t.Fatalf("popCount(%08b) = %d", x, got)
+		}
+	}
+	for a := 0; a < 1<<CountBits; a++ {
+		for b := 0; b < 1<<CountBits; b++ {
+			if got := bit(subBorrow(api, constBits(a, CountBits), constBits(b, CountBits))); (got == 1) != (a < b) {
+				t.Fatalf("subBorrow(%d, %d) = %d", a, b, got)
+			}
+		}
+	}
+
+	msgs := randomMessages(971, NHashes)
+	digest := crypto.Keccak256(msgs[0])
+	if got := bit(CompareDigest(api, bitsOf(digest), bitsOf(digest))); got != 1 {
+		t.Fatal("CompareDigest does not match a digest with itself")
+	}
+	for _, i := range []int{0, 7, 8, 128, 255} {
+		flipped := bitsOf(digest)
+		flipped[i] = 1 - flipped[i].(int)
+		if got := bit(CompareDigest(api, bitsOf(digest), flipped)); got != 0 {
+			t.Fatalf("CompareDigest matches a digest with bit %d flipped", i)
+		}
+	}
+
+	const matches = NHashes - 3
+	assignment := func(minMatches int) *keccakThresholdCircuit {
+		c := newKeccakThresholdCircuit()
+		for k, msg := range msgs {
+			digest := crypto.Keccak256(msg)
+			if k >= matches {
+				digest[k] ^= 1
+			}
+			putBits(c.P[k][:], msg)
+			putBits(c.Out[k][:], digest)
+		}
+		copy(c.MinMatches, constBits(minMatches, CountBits))
+		return c
+	}
+	cr, err := compileCircuit(gf2.ScalarField, newKeccakThresholdCircuit())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, minMatches := range []int{matches - 1, matches, matches + 1} {
+		accept := minMatches <= matches
+		api := &fieldAPI{field: gf2.ScalarField}
+		if err := assignment(minMatches).Define(api); err != nil || (len(api.failed) == 0) != accept {
+			t.Fatalf("%d matches against a threshold of %d: evaluated Define fails %v (%v)", matches, minMatches, api.failed, err)
+		}
+		if err := expectVerdict(cr.GetInputSolver(), cr.GetLayeredCircuit(), assignment(minMatches), accept); err != nil {
+			t.Fatalf("%d matches against a threshold of %d: %v", matches, minMatches, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package keccakgf2
+
+import (
+	"fmt"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// Keyed Keccak MAC:
+// KeccakMAC is the key-prefix MAC of Ethereum-style systems, Keccak-256(key || msg), absorbed block by
+// block, so a key and message of more than 136 bytes together span several keccakF. Unlike KMAC it does not
+// encode the key length into the hash input: the boundary between key and message is fixed by the circuit
+// instead. keccakMACCircuit takes the key as a private witness and the message and MAC as public inputs,
+// with the key length a compile-time constant (KeyBytes, which Define checks against the Key slice), so a
+// prover cannot move bytes between key and message.
+
+// Function Purpose:
+	// Keccak-256(key || msg) of a byte-aligned key and message, both of compile-time length.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `key`: key bits, LSB first within each byte, len(key) % 8 == 0
+	// - `msg`: message bits, LSB first within each byte, len(msg) % 8 == 0
+// Outputs:
+	// - the 256 MAC bits, LSB first within each byte
+// Gate Count:
+	// SpongePermutations(1088, len(key)/8+len(msg)/8, 256) keccakF: one per 136-byte block of key || msg
+	// and its padding
+func KeccakMAC(api frontend.API, key, msg []frontend.Variable) []frontend.Variable {
+	if len(key)%8 != 0 || len(msg)%8 != 0 {
+		panic("KeccakMAC: key and message must be byte aligned")
+	}
+	in := append(append(make([]frontend.Variable, 0, len(key)+len(msg)), key...), msg...)
+	return KeccakWithConfig(api, in, Keccak256Config)
+}
+
+// keccakMACCircuit proves knowledge of a KeyBytes-byte Key with KeccakMAC(Key, Msg) = MAC; build it with
+// newKeccakMACCircuit. The key and message bits are asserted boolean off GF(2) only (assertInputBits).
+type keccakMACCircuit struct {
+	Key []frontend.Variable
+	Msg []frontend.Variable    `gnark:",public"`
+	MAC [256]frontend.Variable `gnark:",public"`
+	// KeyBytes is the key length; build-time only.
+	KeyBytes int `gnark:"-"`
+}
+
+// newKeccakMACCircuit returns an unassigned keccakMACCircuit for keyBytes-byte keys and msgBytes-byte
+// messages.
+func newKeccakMACCircuit(keyBytes, msgBytes int) *keccakMACCircuit {
+	if keyBytes < 1 || msgBytes < 0 {
+		panic("newKeccakMACCircuit: need a non-empty key")
+	}
+	return &keccakMACCircuit{Key: make([]frontend.Variable, 8*keyBytes), Msg: make([]frontend.Variable, 8*msgBytes), KeyBytes: keyBytes}
+}
+
+func (t *keccakMACCircuit) Define(api frontend.API) error {
+	if len(t.Key) != 8*t.KeyBytes {
+		return fmt.Errorf("keccakMACCircuit: %d key bits for a %d-byte key", len(t.Key), t.KeyBytes)
+	}
+	assertInputBits(api, t.Key)
+	assertInputBits(api, t.Msg)
+	mac := KeccakMAC(api, t.Key, t.Msg)
+	for j := range mac {
+		api.AssertIsEqual(mac[j], t.MAC[j])
+	}
+	return nil
+}
+
+// keccakMACAssignment assigns key, msg and mac to a circuit of their sizes; it does not check the MAC.
+func keccakMACAssignment(key, msg, mac []byte) (*keccakMACCircuit, error) {
+	if len(key) == 0 || len(mac) != 32 {
+		return nil, fmt.Errorf("keccak MAC assignment: %d-byte key, %d-byte MAC: need a key and 32 bytes", len(key), len(mac))
+	}
+	c := newKeccakMACCircuit(len(key), len(msg))
+	putBits(c.Key, key)
+	putBits(c.Msg, msg)
+	putBits(c.MAC[:], mac)
+	return c, nil
+}
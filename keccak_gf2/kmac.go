@@ -0,0 +1,120 @@
+package keccakgf2
+
+import (
+	"fmt"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// KMAC (NIST SP 800-185, section 4):
+// KMAC128(K, X, L, S) = cSHAKE128(bytepad(encode_string(K), 168) || X || right_encode(L), L, "KMAC", S), and
+// KMAC256 the same over cSHAKE256 with a 136-byte bytepad. The key length, message length, L and S are
+// fixed at circuit-build time, so every encoding around the key and message bits is constant; only the key
+// and message bits are wires. kmacCircuit proves knowledge of a key: the key is private, the message and
+// the tag are public, and the circuit asserts KMAC128(Key, Msg, len(Tag), S) = Tag. KMAC256Circuit is the
+// same circuit over KMAC256. Both pad the key into a block of its own; the narrower rate then needs one
+// more block for a message of 133..164 bytes (with the 3 bytes of right_encode(L) and the padding), e.g.
+// two for a 140-byte message where KMAC128 absorbs one.
+
+// Function Purpose:
+	// KMAC128 of a byte-aligned key and message with output length outputBits and customization string s.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `key`: key bits, LSB first within each byte, len(key) % 8 == 0
+	// - `msg`: message bits, LSB first within each byte, len(msg) % 8 == 0
+	// - `outputBits`: L, the output length in bits
+	// - `s`: customization string
+// Outputs:
+	// - `outputBits` tag bits
+// Gate Count:
+	// one keccakF per 168-byte block of bytepad(encode_string(key), 168) || msg || right_encode(L) and its
+	// padding; the constant "KMAC" prefix block costs nothing
+func KMAC128(api frontend.API, key, msg []frontend.Variable, outputBits int, s []byte) []frontend.Variable {
+	return kmac(api, key, msg, Shake128Rate, outputBits, s)
+}
+
+// KMAC256 is KMAC128 over cSHAKE256 (rate 1088).
+func KMAC256(api frontend.API, key, msg []frontend.Variable, outputBits int, s []byte) []frontend.Variable {
+	return kmac(api, key, msg, Shake256Rate, outputBits, s)
+}
+
+func kmac(api frontend.API, key, msg []frontend.Variable, rate int, outputBits int, s []byte) []frontend.Variable {
+	if len(key)%8 != 0 || len(msg)%8 != 0 {
+		panic("KMAC: key and message must be byte aligned")
+	}
+	// bytepad(encode_string(K), rate/8): only the key bits in the middle are wires
+	w := rateOf(rate).Bytes
+	head := append(leftEncode(uint64(w)), leftEncode(uint64(len(key)))...)
+	padded := len(head) + len(key)/8
+	newX := append(constBits(head), key...)
+	newX = append(newX, zeroBits(8*((w-padded%w)%w))...)
+	newX = append(newX, msg...)
+	newX = append(newX, constBits(rightEncode(uint64(outputBits)))...)
+	return cShake(api, newX, rate, []byte("KMAC"), s, outputBits)
+}
+
+// kmacCircuit proves knowledge of a Key with KMAC128(Key, Msg, len(Tag), S) = Tag; build it with
+// newKmacCircuit. The key and message bits are asserted boolean off GF(2) only (assertInputBits).
+type kmacCircuit struct {
+	Key []frontend.Variable
+	Msg []frontend.Variable `gnark:",public"`
+	Tag []frontend.Variable `gnark:",public"`
+	// S is the customization string; build-time only.
+	S []byte `gnark:"-"`
+}
+
+// newKmacCircuit returns an unassigned kmacCircuit for keyBytes-byte keys (32 is the usual size),
+// msgBytes-byte messages and outputBits-bit tags.
+func newKmacCircuit(keyBytes, msgBytes, outputBits int, s []byte) *kmacCircuit {
+	if keyBytes < 1 || msgBytes < 0 || outputBits < 8 || outputBits%8 != 0 {
+		panic("newKmacCircuit: need a non-empty key and a whole number of tag bytes")
+	}
+	return &kmacCircuit{
+		Key: make([]frontend.Variable, 8*keyBytes),
+		Msg: make([]frontend.Variable, 8*msgBytes),
+		Tag: make([]frontend.Variable, outputBits),
+		S:   s,
+	}
+}
+
+func (t *kmacCircuit) Define(api frontend.API) error {
+	return t.define(api, KMAC128)
+}
+
+// define asserts that kmac of the key and message is the tag.
+func (t *kmacCircuit) define(api frontend.API, kmac func(frontend.API, []frontend.Variable, []frontend.Variable, int, []byte) []frontend.Variable) error {
+	assertInputBits(api, t.Key)
+	assertInputBits(api, t.Msg)
+	tag := kmac(api, t.Key, t.Msg, len(t.Tag), t.S)
+	for j := range tag {
+		api.AssertIsEqual(tag[j], t.Tag[j])
+	}
+	return nil
+}
+
+// KMAC256Circuit proves knowledge of a Key with KMAC256(Key, Msg, len(Tag), S) = Tag. The slice lengths
+// fix the key, message and tag sizes at compile time: build it with NewKMAC256Circuit, or allocate Key,
+// Msg and Tag directly, and compile it with ecgo.Compile(gf2.ScalarField, c).
+type KMAC256Circuit kmacCircuit
+
+// NewKMAC256Circuit returns an unassigned KMAC256Circuit for keyBytes-byte keys, msgBytes-byte messages
+// and outputBits-bit tags under the customization string s.
+func NewKMAC256Circuit(keyBytes, msgBytes, outputBits int, s []byte) *KMAC256Circuit {
+	return (*KMAC256Circuit)(newKmacCircuit(keyBytes, msgBytes, outputBits, s))
+}
+
+func (t *KMAC256Circuit) Define(api frontend.API) error {
+	return (*kmacCircuit)(t).define(api, KMAC256)
+}
+
+// kmacAssignment assigns key, msg and tag to a circuit of their sizes under s; it does not check the tag.
+func kmacAssignment(key, msg, tag []byte, s []byte) (*kmacCircuit, error) {
+	if len(key) == 0 || len(tag) == 0 {
+		return nil, fmt.Errorf("kmac assignment: %d-byte key, %d-byte tag: both must be non-empty", len(key), len(tag))
+	}
+	c := newKmacCircuit(len(key), len(msg), 8*len(tag), s)
+	putBits(c.Key, key)
+	putBits(c.Msg, msg)
+	putBits(c.Tag, tag)
+	return c, nil
+}
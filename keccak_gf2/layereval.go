@@ -0,0 +1,163 @@
+package keccakgf2
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/irwg"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/layered"
+)
+
+// Bit-sliced layered evaluation:
+// TraceWitness reads round states off the wires of the compiled circuit, so it needs the value of every
+// wire, which the checker of ecgo/test does not hand out. evalLayers runs a GF(2) layered circuit on 64
+// assignments at once, bit j of every wire value belonging to assignment j: addition is XOR,
+// multiplication AND, and a coefficient is 0 or all ones (per assignment for a public-input coefficient).
+// Random coefficients only weigh the zero checks of the output layer and are taken as 1, unless the
+// evaluation draws them (injectGateErrors): then every assignment gets its own random bit per gate.
+
+// evalLayers returns the outputs of every layer of c, input side first, on the input wires in and the
+// public inputs pub, one 64-assignment mask per wire or input. Inputs beyond len(in) are 0.
+func evalLayers(c *layered.RootCircuit, in []uint64, pub []uint64) ([][]uint64, error) {
+	if c.Field.Cmp(gf2Modulus) != 0 {
+		return nil, fmt.Errorf("evalLayers: circuit over a field of %d bits, expected GF(2)", c.Field.BitLen())
+	}
+	return (&layerEval{c: c, pub: pub}).layers(0, in)
+}
+
+// witnessMasks returns the input wires and public inputs of assignment z of w as masks, every
+// assignment of the 64 set to it.
+func witnessMasks(w *irwg.Witness, z int) (in, pub []uint64) {
+	per := w.NumInputsPerWitness + w.NumPublicInputsPerWitness
+	in = make([]uint64, w.NumInputsPerWitness)
+	pub = make([]uint64, w.NumPublicInputsPerWitness)
+	for i, v := range w.Values[z*per : (z+1)*per] {
+		mask := -uint64(v.Bit(0))
+		if i < len(in) {
+			in[i] = mask
+		} else {
+			pub[i-len(in)] = mask
+		}
+	}
+	return in, pub
+}
+
+type layerEval struct {
+	c   *layered.RootCircuit
+	pub []uint64
+	rnd *rand.Rand // draws the Random coefficients if set
+}
+
+// layers returns the outputs of the layers of e.c from start on, in being the output of layer start-1
+// (the input wires for start 0).
+func (e *layerEval) layers(start int, in []uint64) ([][]uint64, error) {
+	outs := make([][]uint64, len(e.c.Layers)-start)
+	for i, id := range e.c.Layers[start:] {
+		sc, ok := e.c.Circuits[id]
+		if !ok {
+			return nil, fmt.Errorf("evalLayers: layer %d is the unknown circuit %d", start+i, id)
+		}
+		layerIn := make([]uint64, sc.InputLen)
+		copy(layerIn, in)
+		outs[i] = make([]uint64, sc.OutputLen)
+		if err := e.run(id, layerIn, outs[i]); err != nil {
+			return nil, fmt.Errorf("evalLayers: layer %d: %w", start+i, err)
+		}
+		in = outs[i]
+	}
+	return outs, nil
+}
+
+// run adds the outputs of circuit id on in to out.
+func (e *layerEval) run(id uint64, in, out []uint64) error {
+	sc, ok := e.c.Circuits[id]
+	if !ok {
+		return fmt.Errorf("unknown circuit %d", id)
+	}
+	if uint64(len(in)) != sc.InputLen || uint64(len(out)) != sc.OutputLen {
+		return fmt.Errorf("circuit %d has %d inputs and %d outputs, placed on %d and %d", id, sc.InputLen, sc.OutputLen, len(in), len(out))
+	}
+	wire := func(v []uint64, i uint64) (uint64, error) {
+		if i >= uint64(len(v)) {
+			return 0, fmt.Errorf("circuit %d: wire %d of %d", id, i, len(v))
+		}
+		return v[i], nil
+	}
+	for _, g := range sc.Mul {
+		a, err := wire(in, g.In0)
+		if err != nil {
+			return err
+		}
+		b, err := wire(in, g.In1)
+		if err != nil {
+			return err
+		}
+		k, err := e.coef(g.Coef)
+		if err != nil {
+			return err
+		}
+		if _, err := wire(out, g.Out); err != nil {
+			return err
+		}
+		out[g.Out] ^= a & b & k
+	}
+	for _, g := range sc.Add {
+		a, err := wire(in, g.In)
+		if err != nil {
+			return err
+		}
+		k, err := e.coef(g.Coef)
+		if err != nil {
+			return err
+		}
+		if _, err := wire(out, g.Out); err != nil {
+			return err
+		}
+		out[g.Out] ^= a & k
+	}
+	for _, g := range sc.Cst {
+		k, err := e.coef(g.Coef)
+		if err != nil {
+			return err
+		}
+		if _, err := wire(out, g.Out); err != nil {
+			return err
+		}
+		out[g.Out] ^= k
+	}
+	for _, sub := range sc.SubCircuits {
+		s, ok := e.c.Circuits[sub.Id]
+		if !ok {
+			return fmt.Errorf("circuit %d: unknown sub-circuit %d", id, sub.Id)
+		}
+		for _, a := range sub.Allocations {
+			if a.InputOffset+s.InputLen > uint64(len(in)) || a.OutputOffset+s.OutputLen > uint64(len(out)) {
+				return fmt.Errorf("circuit %d: sub-circuit %d placed outside its wires", id, sub.Id)
+			}
+			if err := e.run(sub.Id, in[a.InputOffset:a.InputOffset+s.InputLen], out[a.OutputOffset:a.OutputOffset+s.OutputLen]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// coef is the mask of a gate coefficient: all ones where it is 1.
+func (e *layerEval) coef(c layered.Coef) (uint64, error) {
+	switch c.Type {
+	case layered.Constant:
+		if c.Constant.Bit(0) == 0 {
+			return 0, nil
+		}
+		return ^uint64(0), nil
+	case layered.PublicInput:
+		if c.PublicInputId >= uint64(len(e.pub)) {
+			return 0, fmt.Errorf("public input %d of %d", c.PublicInputId, len(e.pub))
+		}
+		return e.pub[c.PublicInputId], nil
+	}
+	if e.rnd != nil {
+		return e.rnd.Uint64(), nil
+	}
+	return ^uint64(0), nil
+}
@@ -0,0 +1,105 @@
+package keccakgf2
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/consensys/gnark/frontend"
+	"golang.org/x/crypto/sha3"
+)
+
+// Legacy Keccak-512 and Keccak-384:
+// The pre-NIST Keccak-512 and Keccak-384 that some older systems use are SHA3-512 and SHA3-384 with the
+// legacy padding: the same rates (576 and 832 bits) and output lengths, and domain byte 0x01
+// (DomainKeccak) instead of 0x06. Keccak512 and Keccak384 are the same sponge as Sha3_512 and Sha3_384
+// with Keccak512Config and Keccak384Config, so they differ in the padding constants only.
+// keccak512PairCircuit hashes one message both ways, which is how the tests show the domain byte actually
+// reaches the circuit. Both are digest variants of the -variants batch (variants.go).
+
+// Function Purpose:
+	// Legacy Keccak-512 of a compile-time-length, byte-aligned message: pad 0x01||10*1 to the 576-bit
+	// rate, absorb with keccakF, squeeze 512 bits.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: message bits, LSB first within each byte, len(msg) % 8 == 0
+// Outputs:
+	// - the 512 digest bits, LSB first within each byte
+// Gate Count:
+	// that of Sha3_512: one keccakF per 72-byte block
+func Keccak512(api frontend.API, msg []frontend.Variable) []frontend.Variable {
+	return keccakSponge(api, msg, Keccak512Config.RateBits, Keccak512Config.DomainSep, Keccak512Config.OutputBits)
+}
+
+// Function Purpose:
+	// Legacy Keccak-384 of a compile-time-length, byte-aligned message: pad 0x01||10*1 to the 832-bit
+	// rate, absorb with keccakF, squeeze 384 bits (from the first 6 of the 13 rate lanes).
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: message bits, LSB first within each byte, len(msg) % 8 == 0
+// Outputs:
+	// - the 384 digest bits, LSB first within each byte
+// Gate Count:
+	// that of Sha3_384: one keccakF per 104-byte block
+func Keccak384(api frontend.API, msg []frontend.Variable) []frontend.Variable {
+	return keccakSponge(api, msg, Keccak384Config.RateBits, Keccak384Config.DomainSep, Keccak384Config.OutputBits)
+}
+
+// legacyKeccak384Ref is legacy Keccak-384 off-circuit, on keccakP1600Ref: x/crypto ships only the
+// legacy Keccak-256 and Keccak-512.
+func legacyKeccak384Ref(msg []byte) []byte {
+	rate := Keccak384Config.RateBits / 8
+	padded := append(append([]byte{}, msg...), Keccak384Config.DomainSep)
+	for len(padded)%rate != 0 {
+		padded = append(padded, 0)
+	}
+	padded[len(padded)-1] |= 0x80
+	var a [25]uint64
+	for blk := 0; blk < len(padded); blk += rate {
+		for i := 0; i < rate/8; i++ {
+			a[i] ^= binary.LittleEndian.Uint64(padded[blk+8*i:])
+		}
+		a = keccakP1600Ref(a, 24)
+	}
+	var out []byte
+	for _, lane := range a[:Keccak384Config.OutputBits/64] {
+		out = binary.LittleEndian.AppendUint64(out, lane)
+	}
+	return out
+}
+
+// keccak512PairCircuit proves that Keccak is the Keccak-512 and SHA3 the SHA3-512 digest of P, asserting
+// the bits of P boolean off GF(2) only (assertInputBits).
+type keccak512PairCircuit struct {
+	P      [64 * 8]frontend.Variable
+	Keccak [512]frontend.Variable `gnark:",public"`
+	SHA3   [512]frontend.Variable `gnark:",public"`
+}
+
+func (t *keccak512PairCircuit) Define(api frontend.API) error {
+	assertInputBits(api, t.P[:])
+	for _, h := range []struct {
+		hash func(frontend.API, []frontend.Variable) []frontend.Variable
+		out  []frontend.Variable
+	}{{Keccak512, t.Keccak[:]}, {Sha3_512, t.SHA3[:]}} {
+		digest := h.hash(api, t.P[:])
+		for j := range digest {
+			api.AssertIsEqual(digest[j], h.out[j])
+		}
+	}
+	return nil
+}
+
+// keccak512PairAssignment assigns msg (64 bytes) and its x/crypto Keccak-512 and SHA3-512 digests.
+func keccak512PairAssignment(msg []byte) (*keccak512PairCircuit, error) {
+	if len(msg) != 64 {
+		return nil, fmt.Errorf("keccak-512 assignment: message of %d bytes, expected 64", len(msg))
+	}
+	c := &keccak512PairCircuit{}
+	h := sha3.NewLegacyKeccak512()
+	h.Write(msg)
+	digest := sha3.Sum512(msg)
+	putBits(c.P[:], msg)
+	putBits(c.Keccak[:], h.Sum(nil))
+	putBits(c.SHA3[:], digest[:])
+	return c, nil
+}
@@ -0,0 +1,75 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// Function Purpose:
+	// Absorb step for data held as 64-bit limbs (e.g. the outputs of other prime-field gadgets):
+	// each limb is decomposed once with api.ToBinary, which also constrains every bit to be boolean, and
+	// its 64 bits are XORed straight into the next rate lane of the state, least significant byte first,
+	// which is the byte order of a Keccak lane: no message-bit buffer, and no pass through Sponge.pending.
+	// Bits absorbed before with s.Absorb go in first; when they do not end on a lane, the limbs are
+	// buffered as Absorb would. The message bits need no further booleanity assertion: ToBinary already
+	// constrained them.
+	// A limb has to fit a field element, so this needs a field of more than 64 bits; the permutation then
+	// runs on the same field through the field-generic xor (see field.go).
+// Inputs:
+	// - `api`: the constraint system builder (field of more than 64 bits)
+	// - `s`: the sponge to absorb into, e.g. NewSponge(Keccak256Config.RateBits, DomainKeccak)
+	// - `limbs`: message limbs, limb i holding message bytes 8i..8i+7 as an unsigned 64-bit integer (bit j = message bit 64i+j)
+// Outputs:
+	// - none; the limbs are absorbed into s like the equivalent bits passed to s.Absorb
+// Gate Count:
+	// per limb: one 64-bit ToBinary decomposition with its 64 boolean constraints and 64 XORs into the
+	// state; one keccakF per completed block. That is what decomposing the limbs and passing the bits to
+	// s.Absorb costs too: the limb form saves the buffer, not constraints.
+func AbsorbU64Limbs(api frontend.API, s *Sponge, limbs []frontend.Variable) {
+	if api.Compiler().FieldBitLen() <= 64 {
+		panic("AbsorbU64Limbs: field too small for 64-bit limbs")
+	}
+	for _, limb := range limbs {
+		s.absorbLane(api, api.ToBinary(limb, 64))
+	}
+}
+
+// keccakLimbCircuit checks that the Keccak-256 digest of a message given as 64-bit limbs (absorbed with
+// AbsorbU64Limbs) equals the public Out, LSB first within each byte as everywhere else.
+type keccakLimbCircuit struct {
+	Limbs []frontend.Variable
+	Out   [256]frontend.Variable `gnark:",public"`
+}
+
+// newKeccakLimbCircuit allocates a keccakLimbCircuit for an n-limb (8n-byte) message.
+func newKeccakLimbCircuit(n int) *keccakLimbCircuit {
+	return &keccakLimbCircuit{Limbs: make([]frontend.Variable, n)}
+}
+
+func (t *keccakLimbCircuit) Define(api frontend.API) error {
+	s := NewSponge(Keccak256Config.RateBits, Keccak256Config.DomainSep)
+	AbsorbU64Limbs(api, s, t.Limbs)
+	out := s.Squeeze(api, Keccak256Config.OutputBits)
+	for i := range t.Out {
+		api.AssertIsEqual(out[i], t.Out[i])
+	}
+	return nil
+}
+
+// keccakLimbBitsCircuit is keccakLimbCircuit the way it is written without AbsorbU64Limbs: the limbs are
+// decomposed into a message-bit buffer, which is hashed through the bit input of the sponge, s.Absorb. It
+// is the baseline the limb absorb is measured against.
+type keccakLimbBitsCircuit keccakLimbCircuit
+
+func (t *keccakLimbBitsCircuit) Define(api frontend.API) error {
+	msg := make([]frontend.Variable, 0, 64*len(t.Limbs))
+	for _, limb := range t.Limbs {
+		msg = append(msg, api.ToBinary(limb, 64)...)
+	}
+	s := NewSponge(Keccak256Config.RateBits, Keccak256Config.DomainSep)
+	s.Absorb(api, msg)
+	out := s.Squeeze(api, Keccak256Config.OutputBits)
+	for i := range t.Out {
+		api.AssertIsEqual(out[i], t.Out[i])
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package keccakgf2
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Progress and diagnostics go through logger, which writes to stderr only.
+// stdout is reserved for machine-readable output, so the binary can be piped into other tools
+// without "test N passed" lines getting mixed into the data.
+
+type logLevel int
+
+const (
+	levelQuiet logLevel = iota // -q: nothing but panics
+	levelInfo                  // default: one line per completed step
+	levelDebug                 // -v: also sizes, seeds and artifact paths
+)
+
+type leveledLogger struct {
+	w     io.Writer
+	level logLevel
+}
+
+var logger = &leveledLogger{w: os.Stderr, level: levelInfo}
+
+func (l *leveledLogger) logf(level logLevel, format string, args ...interface{}) {
+	if level > l.level {
+		return
+	}
+	fmt.Fprintf(l.w, format+"\n", args...)
+}
+
+// Infof logs a progress line.
+func (l *leveledLogger) Infof(format string, args ...interface{}) { l.logf(levelInfo, format, args...) }
+
+// Debugf logs a diagnostic line, shown with -v only.
+func (l *leveledLogger) Debugf(format string, args ...interface{}) { l.logf(levelDebug, format, args...) }
@@ -0,0 +1,184 @@
+package keccakgf2
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/verifier"
+	"github.com/consensys/gnark/frontend"
+)
+
+// Low-memory compiles:
+// ecgo builds, optimizes and layers a circuit in one piece, so the memory of a compile grows with the
+// number of instances. In low-memory mode (-low-memory) compileBuilt compiles a batch whose instances are
+// independent one instance at a time instead and assembles the batch circuit from the pieces
+// (assemble.go): only one instance is ever in the compiler, each piece goes to disk as soon as it exists
+// and the compiler state is returned to the OS before the next one. The assembled circuit has the public
+// inputs and layout of the whole compile but not its fingerprint. Batches that cannot be split (distinct
+// digests, global aggregation, other circuits) are compiled whole, with what still saves memory there:
+//   - Define runs sequentially (the tapes of parallel Define hold every call of every instance),
+//   - the garbage collector runs at lowMemoryGCPercent instead of 100, trading compile time for peak heap,
+//   - the compile result is written to disk and everything but the layered circuit and the input solver
+//     is dropped and returned to the OS before the two are read back,
+//   - a build matrix (checkpointedBuild, BuildArtifacts) keeps no compiled target in memory: each one is
+//     on disk, only its fingerprint is kept, and memory is returned between targets.
+// Every compile through compileBuilt reports its peak heap and the process's peak resident set size, so
+// the trade-off is visible with -v; TestLowMemoryCompile compares the peaks of the two modes.
+
+// lowMemory is set by -low-memory.
+var lowMemory = false
+
+// lowMemoryGCPercent is the GOGC value of a low-memory compile.
+const lowMemoryGCPercent = 20
+
+// compileBuilt compiles circuit over field. In low-memory mode the result goes through dir: the pieces
+// and the assembled dir/name.circuit of a per-instance build, or dir/name.circuit and dir/name.solver of a
+// whole one, reloaded from there; otherwise nothing is written.
+func compileBuilt(field *big.Int, circuit frontend.Circuit, dir, name string) (*builtCircuit, error) {
+	var built *builtCircuit
+	start := time.Now()
+	peak, err := peakHeap(func() error {
+		if !lowMemory {
+			cr, err := compileCircuit(field, circuit)
+			if err != nil {
+				return err
+			}
+			c := cr.GetLayeredCircuit()
+			built = &builtCircuit{Circuit: c, Solver: cr.GetInputSolver(), Fingerprint: verifier.Fingerprint(c.Serialize())}
+			return nil
+		}
+		if batch, ok := circuit.(instancedCircuit); ok && batch.splittable() && batch.instanceCount() > 1 {
+			var err error
+			built, err = (&instanceBuild{Field: field, Dir: dir, Name: name}).run(batch)
+			if !errors.Is(err, errUnequalDepth) {
+				return err
+			}
+			logger.Infof("%s: %v; compiling the batch whole", name, err)
+		}
+		circuitPath, solverPath := filepath.Join(dir, name+".circuit"), filepath.Join(dir, name+".solver")
+		if _, err := compileToDisk(field, circuit, circuitPath, solverPath); err != nil {
+			return err
+		}
+		var err error
+		built, err = loadBuilt(circuitPath, solverPath, "")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	rss, _ := vmHWM()
+	logger.Debugf("compiled %s in %v, peak heap %d MiB, process peak RSS %d MiB (low-memory mode %v)", name, time.Since(start).Round(time.Millisecond), peak>>20, rss>>20, lowMemory)
+	return built, nil
+}
+
+// compileToDisk compiles circuit and writes its layered circuit and input solver. The result has both
+// in memory too, except in low-memory mode, where it only has the fingerprint: Define then runs
+// sequentially, the collector runs at lowMemoryGCPercent, and the compile result is returned to the OS
+// before compileToDisk returns.
+func compileToDisk(field *big.Int, circuit frontend.Circuit, circuitPath, solverPath string) (*builtCircuit, error) {
+	if lowMemory {
+		defer debug.SetGCPercent(debug.SetGCPercent(lowMemoryGCPercent))
+		defer func(w int) { defineWorkers = w }(defineWorkers)
+		defineWorkers = 1
+		// runs first, when the compile result is no longer referenced
+		defer debug.FreeOSMemory()
+	}
+	cr, err := compileCircuit(field, circuit)
+	if err != nil {
+		return nil, err
+	}
+	serialized := cr.GetLayeredCircuit().Serialize()
+	if err := os.WriteFile(circuitPath, serialized, 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(solverPath, cr.GetInputSolver().Serialize(), 0o644); err != nil {
+		return nil, err
+	}
+	built := &builtCircuit{Fingerprint: verifier.Fingerprint(serialized)}
+	if !lowMemory {
+		built.Circuit, built.Solver = cr.GetLayeredCircuit(), cr.GetInputSolver()
+	}
+	return built, nil
+}
+
+// loadBuilt reads a circuit and its input solver written by compileToDisk; the circuit must have the
+// given fingerprint, unless it is empty.
+func loadBuilt(circuitPath, solverPath, fingerprint string) (*builtCircuit, error) {
+	c, fp, err := verifier.LoadCircuit(circuitPath)
+	if err != nil {
+		return nil, err
+	}
+	if fingerprint != "" && fp != fingerprint {
+		return nil, fmt.Errorf("%s: circuit fingerprint %s, expected %s", circuitPath, fp, fingerprint)
+	}
+	raw, err := os.ReadFile(solverPath)
+	if err != nil {
+		return nil, err
+	}
+	return &builtCircuit{Circuit: c, Solver: ecgo.DeserializeInputSolver(raw), Fingerprint: fp}, nil
+}
+
+// peakHeap runs f and returns the largest Go heap seen by a sampler while it ran. The process-wide peak
+// resident set size is vmHWM; it only grows, so it is compared across processes (TestLowMemoryCompile).
+func peakHeap(f func() error) (uint64, error) {
+	var peak uint64
+	var mu sync.Mutex
+	sample := func() {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		mu.Lock()
+		if m.HeapInuse > peak {
+			peak = m.HeapInuse
+		}
+		mu.Unlock()
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tick := time.NewTicker(50 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				sample()
+			}
+		}
+	}()
+	err := f()
+	close(done)
+	wg.Wait()
+	sample()
+	return peak, err
+}
+
+// vmHWM reads the peak resident set size of the process from /proc/self/status.
+func vmHWM() (uint64, bool) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		if fields := strings.Fields(lines.Text()); len(fields) == 3 && fields[0] == "VmHWM:" && fields[2] == "kB" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			return kb << 10, err == nil
+		}
+	}
+	return 0, false
+}
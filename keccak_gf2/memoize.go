@@ -0,0 +1,20 @@
+package keccakgf2
+
+// Memoized instances:
+// Every instance of keccak256Circuit hashes a message of the same length with the same context length, so
+// the whole hash is one sub-circuit: defineInstance calls it through memorizedCall, and ecgo compiles it
+// once and instantiates it per instance instead of re-emitting the ~230k gates of every permutation
+// NHashes times. The booleanity and digest assertions stay outside the sub-circuit, in the caller, so
+// the public inputs and their order are those of the inlined build; the fingerprint is not.
+//
+// The inlined build (WithInlinedKeccak) is kept for comparison and for parallel Define, which traces
+// instances gate by gate and only applies to it (see parallel.go): with memoization there is nothing left
+// to trace in parallel, and Define builds sequentially whatever -define-workers says. Gate statistics
+// (gatestats.go) are the same either way, since the recording API inlines memorized calls.
+// TestMemoizedCompile logs compile time and serialized size of both builds.
+
+// WithInlinedKeccak emits the gates of every instance separately instead of instantiating one memoized
+// Keccak-256 sub-circuit.
+func WithInlinedKeccak() CircuitOption {
+	return func(t *keccak256Circuit) { t.Inlined = true }
+}
@@ -0,0 +1,142 @@
+package keccakgf2
+
+import (
+	"io"
+	"math/bits"
+
+	"github.com/consensys/gnark/frontend"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Merkle commitment of the instance digests:
+// keccakMerkleCircuit proves the same NHashes Keccak-256 computations as keccak256Circuit, but instead of
+// exposing every digest it folds them into a binary Merkle tree (node = Keccak-256(left || right)) and only
+// the root is public. Individual digests are opened later with merkleOpening / verifyMerkleOpening,
+// log2(NHashes) sibling hashes per opening.
+
+// MerkleDepth is the depth of the tree over the NHashes instance digests.
+var MerkleDepth = bits.Len(uint(NHashes)) - 1
+
+type keccakMerkleCircuit struct {
+	P    [NHashes][64 * 8]frontend.Variable
+	Root [256]frontend.Variable `gnark:",public"`
+}
+
+func (t *keccakMerkleCircuit) Define(api frontend.API) error {
+	leaves := make([][]frontend.Variable, NHashes)
+	for i := 0; i < NHashes; i++ {
+		leaves[i] = computeKeccak(api, t.P[i][:])
+	}
+	root := merkleRoot(api, leaves)
+	for j := 0; j < 256; j++ {
+		api.AssertIsEqual(root[j], t.Root[j])
+	}
+	return nil
+}
+
+// Function Purpose:
+	// Inner Merkle node: Keccak-256(left || right) of two 256-bit digests.
+	// The concatenation is exactly one 64-byte message, so this is a single computeKeccak (one keccakF).
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `left`, `right`: 256-bit digests, LSB first within each byte
+// Outputs:
+	// - the 256-bit parent digest
+func HashPair(api frontend.API, left []frontend.Variable, right []frontend.Variable) []frontend.Variable {
+	if len(left) != 256 || len(right) != 256 {
+		panic("HashPair: digests must be 256 bits")
+	}
+	msg := make([]frontend.Variable, 0, 512)
+	msg = append(msg, left...)
+	msg = append(msg, right...)
+	return computeKeccak(api, msg)
+}
+
+// Function Purpose:
+	// Folds a power-of-two number of 256-bit leaves into their Merkle root with HashPair.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `leaves`: the leaf digests, leaves[i] is leaf i
+// Outputs:
+	// - the 256-bit root
+// Gate Count:
+	// len(leaves) - 1 HashPair calls, i.e. 7 keccakF for the 8 instance digests
+func merkleRoot(api frontend.API, leaves [][]frontend.Variable) []frontend.Variable {
+	if len(leaves) == 0 || len(leaves)&(len(leaves)-1) != 0 {
+		panic("merkleRoot: number of leaves must be a power of two")
+	}
+	level := leaves
+	for len(level) > 1 {
+		next := make([][]frontend.Variable, len(level)/2)
+		for i := range next {
+			next[i] = HashPair(api, level[2*i], level[2*i+1])
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// merkleTree computes the off-circuit tree over the leaves: levels[0] are the leaves, levels[len-1] = {root}.
+func merkleTree(leaves [][32]byte) [][][32]byte {
+	if len(leaves) == 0 || len(leaves)&(len(leaves)-1) != 0 {
+		panic("merkleTree: number of leaves must be a power of two")
+	}
+	levels := [][][32]byte{leaves}
+	for level := leaves; len(level) > 1; {
+		next := make([][32]byte, len(level)/2)
+		for i := range next {
+			next[i] = crypto.Keccak256Hash(level[2*i][:], level[2*i+1][:])
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// merkleOpening returns the sibling hashes proving leaf idx, from the leaf level up to just below the root.
+func merkleOpening(levels [][][32]byte, idx int) [][32]byte {
+	if idx < 0 || idx >= len(levels[0]) {
+		panic("merkleOpening: leaf index out of range")
+	}
+	proof := make([][32]byte, 0, len(levels)-1)
+	for _, level := range levels[:len(levels)-1] {
+		proof = append(proof, level[idx^1])
+		idx >>= 1
+	}
+	return proof
+}
+
+// verifyMerkleOpening recomputes the root from a leaf, its index and its opening, and compares it with root.
+func verifyMerkleOpening(root [32]byte, leaf [32]byte, idx int, proof [][32]byte) bool {
+	if idx < 0 || idx>>len(proof) != 0 {
+		return false
+	}
+	node := leaf
+	for _, sib := range proof {
+		if idx&1 == 0 {
+			node = crypto.Keccak256Hash(node[:], sib[:])
+		} else {
+			node = crypto.Keccak256Hash(sib[:], node[:])
+		}
+		idx >>= 1
+	}
+	return node == root
+}
+
+// randomMerkleAssignment builds a keccakMerkleCircuit assignment from NHashes random 64-byte messages read
+// from rnd, and returns the off-circuit tree over their digests along with it.
+func randomMerkleAssignment(rnd io.Reader) (*keccakMerkleCircuit, [][][32]byte, error) {
+	circuit := &keccakMerkleCircuit{}
+	leaves := make([][32]byte, NHashes)
+	for k := 0; k < NHashes; k++ {
+		data := make([]byte, 64)
+		if _, err := io.ReadFull(rnd, data); err != nil {
+			return nil, nil, err
+		}
+		copy(circuit.P[k][:], bitsOf(data))
+		leaves[k] = crypto.Keccak256Hash(data)
+	}
+	levels := merkleTree(leaves)
+	copy(circuit.Root[:], bitsOf(levels[len(levels)-1][0][:]))
+	return circuit, levels, nil
+}
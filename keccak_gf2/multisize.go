@@ -0,0 +1,63 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// Multi-size builds:
+// Circuits for different message sizes differ only in padding and in how many blocks they absorb;
+// every block runs the same keccakF. keccakMultiSizeCircuit hashes one message of each requested size in
+// a single circuit, and with shared set every permutation goes through sharedKeccakF, so ecgo builds
+// the keccakF sub-circuit once and instantiates it for every block of every size.
+// ecgo memoizes within one Compile only, so the saving comes from compiling the size matrix as one
+// circuit rather than one circuit per size. TestMultiSizeBuild checks that the stored gates shrink, and
+// BenchmarkMultiSizeCompile times both builds.
+
+type keccakMultiSizeCircuit struct {
+	P      [][]frontend.Variable
+	Out    [][256]frontend.Variable `gnark:",public"`
+	shared bool
+}
+
+// newKeccakMultiSizeCircuit returns a circuit (or an empty assignment) hashing one message of each size in sizes (bytes).
+func newKeccakMultiSizeCircuit(sizes []int, shared bool) *keccakMultiSizeCircuit {
+	t := &keccakMultiSizeCircuit{P: make([][]frontend.Variable, len(sizes)), Out: make([][256]frontend.Variable, len(sizes)), shared: shared}
+	for i, n := range sizes {
+		t.P[i] = make([]frontend.Variable, 8*n)
+	}
+	return t
+}
+
+func (t *keccakMultiSizeCircuit) Define(api frontend.API) error {
+	perm := plainKeccakF
+	if t.shared {
+		perm = sharedKeccakF
+	}
+	for i := range t.P {
+		out := spongeWith(api, perm, t.P[i], Keccak256Config.RateBits, DomainKeccak, 256)
+		for j := 0; j < 256; j++ {
+			api.AssertIsEqual(out[j], t.Out[i][j])
+		}
+	}
+	return nil
+}
+
+// sharedKeccakF is keccakF as a memorized sub-circuit (see memorizedCall): the state is flattened to
+// 1600 wires, lane by lane in keccakF's a[5x+y] order, and split again on the way out.
+func sharedKeccakF(api frontend.API, a [][]frontend.Variable) [][]frontend.Variable {
+	out := memorizedCall(api, keccakFFlat, flattenBytes(a))
+	res := make([][]frontend.Variable, 25)
+	for i := range res {
+		res[i] = out[64*i : 64*i+64 : 64*i+64]
+	}
+	return res
+}
+
+func keccakFFlat(api frontend.API, in []frontend.Variable) []frontend.Variable {
+	// full slice expressions: rotateLeft appends to a lane's tail, which must not spill into the next lane
+	a := make([][]frontend.Variable, 25)
+	for i := range a {
+		a[i] = in[64*i : 64*i+64 : 64*i+64]
+	}
+	return flattenBytes(keccakF(api, a))
+}
@@ -0,0 +1,68 @@
+package keccakgf2
+
+import (
+	"io"
+)
+
+// Run-wide options:
+// The command line (cmd/keccak_gf2) maps its flags onto Options and calls Configure once before any entry
+// point. Verbosity, the Define worker count, the preflight switch and the low-memory mode are package-wide settings, because
+// they are read deep inside Define and solveBatch; the other fields are passed to the entry points, which
+// turn the circuit-shaping ones into CircuitOptions (circuitOptions).
+
+// Options are the settings of one run.
+type Options struct {
+	Seed          int64       // seed for reproducible messages (0: crypto/rand)
+	Context       []byte      // public context every witness is bound to
+	Instances     int         // Keccak-256 instances per assignment
+	DefineWorkers int         // goroutines tracing circuit instances during Define (1: sequential)
+	SkipPreflight bool        // skip re-deriving the digests of every assignment before solving
+	PublicInput   bool        // make the messages public inputs (BuildBatch, see publicinput.go)
+	SHA3          bool        // prove SHA3-256 instead of Keccak-256 digests (BuildBatch, see sha3.go)
+	Variants      []string    // digest variants cycled over the instances (BuildVariantBatch, see variants.go)
+	LowMemory     bool        // trade compile time for peak memory (see lowmem.go)
+	ChiSchedule   ChiSchedule // layer layout of the χ step (see chischedule.go)
+	Verbose       bool        // also log diagnostics
+	Quiet         bool        // log nothing
+}
+
+// DefaultOptions are the options of a run without flags.
+func DefaultOptions() Options {
+	return Options{Instances: NHashes, DefineWorkers: defineWorkers}
+}
+
+// Configure applies the package-wide settings of o.
+func Configure(o Options) {
+	logger.level = levelInfo
+	if o.Verbose {
+		logger.level = levelDebug
+	} else if o.Quiet {
+		logger.level = levelQuiet
+	}
+	defineWorkers = o.DefineWorkers
+	skipPreflight = o.SkipPreflight
+	lowMemory = o.LowMemory
+}
+
+// circuitOptions are the CircuitOptions of the batch circuit of o: SHA3-256 and the χ schedule.
+func (o Options) circuitOptions() []CircuitOption {
+	var opts []CircuitOption
+	if o.SHA3 {
+		opts = append(opts, WithSHA3())
+	}
+	if o.ChiSchedule != ChiSingleLayer {
+		opts = append(opts, WithChiSchedule(o.ChiSchedule))
+	}
+	return opts
+}
+
+// Reader is the message randomness of o: crypto/rand, or a seeded, reproducible stream if o.Seed is set.
+func (o Options) Reader() io.Reader {
+	if o.Seed != 0 {
+		return seededReader(o.Seed)
+	}
+	return defaultReader
+}
+
+// Infof logs a progress line to stderr, subject to the configured verbosity.
+func Infof(format string, args ...interface{}) { logger.Infof(format, args...) }
@@ -0,0 +1,252 @@
+package keccakgf2
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/irwg"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/verifier"
+	"github.com/consensys/gnark/frontend"
+)
+
+// Two-dimensional batching:
+// hashMessages takes any number of 64-byte messages and spreads them over ceil(N/n) assignments of a circuit
+// with n instances, n messages each, solving perFile assignments at a time into one witness envelope per chunk.
+// Message i goes to assignment i/n, slot i%n; the slots left over in the last assignment
+// are filled with the all-zero message and flagged as padding in the manifest.
+//
+// For capacity planning every assignment is timed: building it (assignMessages, which hashes its messages)
+// and solving it, each assignment in a SolveInputs call of its own whose witness is appended to the
+// chunk's envelope. After every chunk the total time is extrapolated from the mean time per assignment so
+// far (the first extrapolation comes after the first chunk) and handed to the progress callback; the
+// timings and the first and last estimates end up in stats.json next to the manifest. The times are read
+// from the clock passed in (time.Now outside tests).
+
+// SolveProgress is reported by hashMessages after every chunk: Done of Total assignments in Elapsed, at
+// PerAssignment each over the last chunk, and the extrapolated total time Estimate.
+type SolveProgress struct {
+	Done, Total   int
+	Elapsed       time.Duration
+	PerAssignment time.Duration
+	Estimate      time.Duration
+}
+
+// ChunkStats are the timings of one witness file: the build and the solve time of each assignment, in
+// nanoseconds.
+type ChunkStats struct {
+	File    string  `json:"file"`
+	BuildNs []int64 `json:"buildNs"`
+	SolveNs []int64 `json:"solveNs"`
+}
+
+// StatsVersion is bumped whenever the meaning of a SolveStats field changes. Version 1 had no version
+// field; version 2 added it (upgradeStatsV1); version 3 times the solve of every assignment instead of
+// every chunk (upgradeStatsV2).
+const StatsVersion = 3
+
+// SolveStats is stats.json: the shape of the run, its chunks, the estimate after the first chunk and the
+// actual total, in nanoseconds.
+type SolveStats struct {
+	Version         int          `json:"version"`
+	Assignments     int          `json:"assignments"`
+	Instances       int          `json:"instances"`
+	Messages        int          `json:"messages"`
+	ContextBytes    int          `json:"contextBytes"`
+	Chunks          []ChunkStats `json:"chunks"`
+	FirstEstimateNs int64        `json:"firstEstimateNs"`
+	TotalNs         int64        `json:"totalNs"`
+}
+
+// Serialize encodes the stats as JSON.
+func (s *SolveStats) Serialize() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// ParseSolveStats decodes stats.json of any version up to StatsVersion, upgrading older ones.
+func ParseSolveStats(b []byte) (*SolveStats, error) {
+	var probe struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return nil, fmt.Errorf("stats: %w", err)
+	}
+	if probe.Version == 0 {
+		probe.Version = 1
+	}
+	if probe.Version > StatsVersion {
+		return nil, fmt.Errorf("stats version %d, this build reads up to version %d", probe.Version, StatsVersion)
+	}
+	b, err := upgradeArtifact("stats", b, probe.Version, StatsVersion)
+	if err != nil {
+		return nil, err
+	}
+	s := &SolveStats{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("stats: %w", err)
+	}
+	return s, nil
+}
+
+// LoadSolveStats reads and parses a stats.json file.
+func LoadSolveStats(path string) (*SolveStats, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSolveStats(b)
+}
+
+// upgradeStatsV1 adds the version field that version 1 lacked.
+func upgradeStatsV1(b []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	fields["version"] = json.RawMessage("2")
+	return json.Marshal(fields)
+}
+
+// upgradeStatsV2 spreads the solve time of every chunk evenly over its assignments, the remainder on the
+// last one, since version 2 did not time them one by one.
+func upgradeStatsV2(b []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	var chunks []map[string]json.RawMessage
+	if err := json.Unmarshal(fields["chunks"], &chunks); err != nil {
+		return nil, err
+	}
+	for i, c := range chunks {
+		var build []int64
+		var solve int64
+		if err := json.Unmarshal(c["buildNs"], &build); err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", i, err)
+		}
+		if err := json.Unmarshal(c["solveNs"], &solve); err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", i, err)
+		}
+		if len(build) == 0 {
+			return nil, fmt.Errorf("chunk %d has no assignments", i)
+		}
+		spread := make([]int64, len(build))
+		for a := range spread {
+			spread[a] = solve / int64(len(build))
+		}
+		spread[len(spread)-1] += solve % int64(len(build))
+		raw, err := json.Marshal(spread)
+		if err != nil {
+			return nil, err
+		}
+		c["solveNs"] = raw
+	}
+	raw, err := json.Marshal(chunks)
+	if err != nil {
+		return nil, err
+	}
+	fields["chunks"] = raw
+	fields["version"] = json.RawMessage("3")
+	return json.Marshal(fields)
+}
+
+// estimateTotal extrapolates the time of total assignments from elapsed for the first done.
+func estimateTotal(elapsed time.Duration, done, total int) time.Duration {
+	if done <= 0 {
+		return 0
+	}
+	return time.Duration(int64(elapsed) * int64(total) / int64(done))
+}
+
+// hashMessages writes witness-NNNN.env files, manifest.json and stats.json into dir and returns the
+// manifest. is must solve newKeccak256Circuit(n, len(context)); progress, if not nil, is called after
+// every chunk; clock is the time source of the timings.
+func hashMessages(is batchSolver, n int, msgs [][]byte, context []byte, dir string, perFile int, progress func(SolveProgress), clock func() time.Time) (*verifier.Manifest, error) {
+	if len(msgs) == 0 || perFile <= 0 || n <= 0 {
+		return nil, fmt.Errorf("hashMessages: need at least one message, instance and a positive chunk size")
+	}
+	nAssignments := (len(msgs) + n - 1) / n
+	m := &verifier.Manifest{Layout: digestLayout(n, len(context)), Messages: len(msgs)}
+	stats := &SolveStats{Version: StatsVersion, Assignments: nAssignments, Instances: n, Messages: len(msgs), ContextBytes: len(context)}
+	start := clock()
+
+	for first := 0; first < nAssignments; first += perFile {
+		file := fmt.Sprintf("witness-%04d.env", first/perFile)
+		chunk := ChunkStats{File: file}
+		var batch []frontend.Circuit
+		var indices []int
+		for a := first; a < first+perFile && a < nAssignments; a++ {
+			slotMsgs := make([][]byte, n)
+			for k := range slotMsgs {
+				slot := verifier.Slot{Message: a*n + k, File: file, Assignment: a, Instance: k}
+				if slot.Message < len(msgs) {
+					slotMsgs[k] = msgs[slot.Message]
+				} else {
+					slotMsgs[k] = make([]byte, 64)
+					slot.Message, slot.Padding = -1, true
+				}
+				m.Slots = append(m.Slots, slot)
+			}
+			built := clock()
+			assignment, err := assignMessages(slotMsgs, context, CheckBits)
+			if err != nil {
+				return nil, err
+			}
+			chunk.BuildNs = append(chunk.BuildNs, int64(clock().Sub(built)))
+			batch = append(batch, assignment)
+			indices = append(indices, a)
+		}
+		env := &verifier.Envelope{Indices: indices, Witness: &irwg.Witness{}}
+		for z := range batch {
+			solved := clock()
+			one, err := solveBatch(is, batch[z:z+1], indices[z:z+1])
+			if err != nil {
+				return nil, err
+			}
+			chunk.SolveNs = append(chunk.SolveNs, int64(clock().Sub(solved)))
+			w := env.Witness
+			if z > 0 && (one.Witness.NumInputsPerWitness != w.NumInputsPerWitness || one.Witness.NumPublicInputsPerWitness != w.NumPublicInputsPerWitness) {
+				return nil, fmt.Errorf("hashMessages: assignment %d solved to a witness of another shape", indices[z])
+			}
+			w.NumWitnesses++
+			w.NumInputsPerWitness, w.NumPublicInputsPerWitness, w.Field = one.Witness.NumInputsPerWitness, one.Witness.NumPublicInputsPerWitness, one.Witness.Field
+			w.Values = append(w.Values, one.Witness.Values...)
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), env.Serialize(), 0o644); err != nil {
+			return nil, err
+		}
+		stats.Chunks = append(stats.Chunks, chunk)
+
+		done := first + len(batch)
+		p := SolveProgress{Done: done, Total: nAssignments, Elapsed: clock().Sub(start)}
+		for z := range batch {
+			p.PerAssignment += time.Duration((chunk.BuildNs[z] + chunk.SolveNs[z]) / int64(len(batch)))
+		}
+		p.Estimate = estimateTotal(p.Elapsed, done, nAssignments)
+		if first == 0 {
+			stats.FirstEstimateNs = int64(p.Estimate)
+		}
+		if progress != nil {
+			progress(p)
+		}
+	}
+	stats.TotalNs = int64(clock().Sub(start))
+	raw, err := stats.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "stats.json"), raw, 0o644); err != nil {
+		return nil, err
+	}
+
+	b, err := m.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "manifest.json"), b, 0o644); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
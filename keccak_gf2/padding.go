@@ -0,0 +1,41 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// padParams describes one pad10*1 instance.
+type padParams struct {
+	Rate     int  // sponge rate r in bits (1088 for Keccak-256); the block length and indices derive from it
+	Domain   byte // domain separation byte, merged with the first 1 of pad10*1 (DomainKeccak, DomainSHA3, ...)
+	MsgBytes int  // message length in bytes, fixed at circuit-build time
+}
+
+// Function Purpose:
+	// The single pad10*1 implementation shared by every sponge in this package.
+	// pad10*1 means: start with 1, add 0s, end with 1. At byte granularity, with the domain suffix bits in front, that is:
+	// - the first padding byte is the domain byte (0x01 for Keccak: binary 00000001)
+	// - the last byte of the block gets 0x80 (binary 10000000); read LSB first that is the final `1` at bit 7
+	// - everything in between is zero filler
+	// If the message ends one byte short of a block boundary, the domain byte and 0x80 share that byte (Domain | 0x80).
+	// If the message ends exactly on a block boundary, a whole extra block of padding is appended.
+// Inputs:
+	// - `msg`: message bits, LSB first within each byte, exactly 8*params.MsgBytes bits
+	// - `params`: block length, domain byte and message length
+// Outputs:
+	// - the padded message: msg followed by the constant padding bits, a multiple of Rate bits long
+// Gate Count:
+	// none: the padding is constant and msg is only copied
+func padMessage(msg []frontend.Variable, params padParams) []frontend.Variable {
+	r := rateOf(params.Rate)
+	if params.MsgBytes < 0 || len(msg) != 8*params.MsgBytes {
+		panic("padMessage: message length does not match params.MsgBytes")
+	}
+	paddedBytes, domainIndex, lastIndex := r.padIndices(params.MsgBytes)
+	pad := make([]byte, paddedBytes-params.MsgBytes)
+	pad[domainIndex-params.MsgBytes] ^= params.Domain
+	pad[lastIndex-params.MsgBytes] ^= 0x80
+	padded := make([]frontend.Variable, 0, 8*paddedBytes)
+	padded = append(padded, msg...)
+	return append(padded, constBits(pad)...)
+}
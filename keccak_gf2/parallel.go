@@ -0,0 +1,174 @@
+package keccakgf2
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// Parallel Define:
+// The ecgo builder is not safe for concurrent use: every API call appends to the builder's shared
+// instruction list without locking, so Define cannot simply call computeKeccak from several goroutines.
+// What can run concurrently is our own gadget code (slice allocation, layout conversion, constant folding).
+// defineParallel traces each instance against a private tapeAPI in its own goroutine, then replays the
+// tapes into the real builder one after the other, in instance order. Replay only issues the recorded
+// Add/Sub/Mul/AssertIsEqual/AssertIsBoolean calls in the sequential order, so the compiled circuit is identical to the
+// sequential build (TestParallelDefine compares the two builds, BenchmarkParallelDefine times them).
+//
+// Result: it does not pay. The gadget code is the cheap part: Define of 16 instances against the
+// recording API of gatestats.go takes about 65 ms, while every one of the ~230k builder calls per instance
+// still has to run sequentially on replay. A tape has to hold those calls until its turn, and that
+// retention alone (allocation plus GC scanning) made the traced build 6-8x slower on one core.
+// Parallel tracing is therefore opt-in (-define-workers); the builder's memoization (memorizedCall) is
+// the way to cut the compile time of repeated instances, and keccak256Circuit uses it unless built with
+// WithInlinedKeccak, the only build parallel Define applies to (see memoize.go).
+
+// defineWorkers is the number of goroutines Define may use to trace instances (-define-workers);
+// 1, the default, builds sequentially.
+var defineWorkers = 1
+
+// tapeWire is a value computed on a tape: the result of op id of that tape.
+type tapeWire struct {
+	tape *tapeAPI
+	id   int
+}
+
+type tapeOp struct {
+	kind  byte // '+', '-', '*', '=' (AssertIsEqual) or 'b' (AssertIsBoolean)
+	a, b  frontend.Variable
+	extra []frontend.Variable
+}
+
+// tapeAPI records the arithmetic calls of a gadget for later replay. Operands that are not tape wires
+// (builder variables, constants) are kept as they are and passed through on replay.
+// A keccakF records a few hundred thousand calls, so ops and wires are kept in slabs rather than
+// allocated one by one.
+type tapeAPI struct {
+	frontend.API // nil: any call not recorded below panics, and defineParallel reports it as an error
+	field        *big.Int
+	ops          [][]tapeOp // chunks of tapeChunk ops; op id is ops[id/tapeChunk][id%tapeChunk]
+	n            int
+	wires        []tapeWire
+}
+
+const tapeChunk = 4096
+
+func (t *tapeAPI) Compiler() frontend.Compiler { return recordingCompiler{field: t.field} }
+
+func (t *tapeAPI) record(kind byte, a, b frontend.Variable, extra []frontend.Variable) frontend.Variable {
+	if t.n%tapeChunk == 0 {
+		t.ops = append(t.ops, make([]tapeOp, 0, tapeChunk))
+		t.wires = make([]tapeWire, 0, tapeChunk)
+	}
+	last := len(t.ops) - 1
+	t.ops[last] = append(t.ops[last], tapeOp{kind, a, b, extra})
+	t.wires = append(t.wires, tapeWire{t, t.n})
+	t.n++
+	return &t.wires[len(t.wires)-1]
+}
+
+func (t *tapeAPI) Add(a, b frontend.Variable, in ...frontend.Variable) frontend.Variable {
+	return t.record('+', a, b, in)
+}
+
+func (t *tapeAPI) Sub(a, b frontend.Variable, in ...frontend.Variable) frontend.Variable {
+	return t.record('-', a, b, in)
+}
+
+func (t *tapeAPI) Mul(a, b frontend.Variable, in ...frontend.Variable) frontend.Variable {
+	return t.record('*', a, b, in)
+}
+
+func (t *tapeAPI) AssertIsEqual(a, b frontend.Variable) {
+	t.record('=', a, b, nil)
+}
+
+func (t *tapeAPI) AssertIsBoolean(a frontend.Variable) {
+	t.record('b', a, nil, nil)
+}
+
+// replay issues the recorded calls on api in order.
+func (t *tapeAPI) replay(api frontend.API) error {
+	res := make([]frontend.Variable, t.n)
+	var err error
+	in := func(v frontend.Variable) frontend.Variable {
+		if w, ok := v.(*tapeWire); ok {
+			if w.tape != t {
+				err = fmt.Errorf("tape wire used outside its own instance")
+				return nil
+			}
+			return res[w.id]
+		}
+		return v
+	}
+	for id := 0; id < t.n; id++ {
+		op := &t.ops[id/tapeChunk][id%tapeChunk]
+		a, b := in(op.a), in(op.b)
+		var extra []frontend.Variable
+		if len(op.extra) > 0 {
+			extra = make([]frontend.Variable, len(op.extra))
+			for k, v := range op.extra {
+				extra[k] = in(v)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		switch op.kind {
+		case '+':
+			res[id] = api.Add(a, b, extra...)
+		case '-':
+			res[id] = api.Sub(a, b, extra...)
+		case '*':
+			res[id] = api.Mul(a, b, extra...)
+		case '=':
+			api.AssertIsEqual(a, b)
+		case 'b':
+			api.AssertIsBoolean(a)
+		}
+	}
+	return nil
+}
+
+// defineParallel runs build(api, i) for i in [0, n): traced on up to workers goroutines, replayed into api
+// in order of i. build must not share tape values between instances.
+func defineParallel(api frontend.API, n int, workers int, build func(api frontend.API, i int)) error {
+	field := api.Compiler().Field()
+	tapes := make([]*tapeAPI, n)
+	errs := make([]error, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				tapes[i] = &tapeAPI{field: field}
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							errs[i] = fmt.Errorf("instance %d: %v", i, r)
+						}
+					}()
+					build(tapes[i], i)
+				}()
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	for i, tape := range tapes {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		if err := tape.replay(api); err != nil {
+			return fmt.Errorf("instance %d: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package keccakgf2
+
+import (
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo"
+	"github.com/consensys/gnark/frontend"
+)
+
+// Function Purpose:
+	// ParallelHash128 (NIST SP 800-185, section 6):
+	//   z = left_encode(B) || cSHAKE128(X[0..B)) || cSHAKE128(X[B..2B)) || ... || right_encode(n) || right_encode(L)
+	//   ParallelHash128(X, B, L, S) = cSHAKE128(z, L, "ParallelHash", S)
+	// where every inner cSHAKE128 has empty N and S (i.e. SHAKE128) and a 256-bit output, and the last block may be short.
+	// The inner block hash is a memoized sub-circuit, so every full block reuses one compiled copy of the sponge.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: message bits, LSB first within each byte
+	// - `blockSize`: B, the block size in bytes
+	// - `outputBits`: L, the output length in bits
+	// - `s`: customization string
+// Outputs:
+	// - `outputBits` digest bits
+// Gate Count:
+	// ParallelHashPermutations(1344, len(msg)/8, B, L) keccakF: n = ⌈len(msg)/B⌉ inner sponges of ⌈(B+1)/168⌉
+	// permutations each, plus the outer cSHAKE over 32n+~10 bytes; the constant "ParallelHash" block is free
+func ParallelHash128(api frontend.API, msg []frontend.Variable, blockSize int, outputBits int, s []byte) []frontend.Variable {
+	return parallelHash(api, msg, blockSize, Shake128Rate, parallelHashBlock128, outputBits, s)
+}
+
+// ParallelHash256 is ParallelHash128 over cSHAKE256, with 512-bit chaining values.
+func ParallelHash256(api frontend.API, msg []frontend.Variable, blockSize int, outputBits int, s []byte) []frontend.Variable {
+	return parallelHash(api, msg, blockSize, Shake256Rate, parallelHashBlock256, outputBits, s)
+}
+
+// ParallelHashPermutations is the number of keccakF calls of ParallelHash128 (rate 1344) or ParallelHash256
+// (rate 1088) over a msgBytes-byte message with blockSize-byte blocks and an outputBits-bit digest, counting
+// every inner sponge although the circuit compiles one copy per block length. Hashing the same message
+// serially with SHAKE takes SpongePermutations(rate, msgBytes, outputBits): fewer, since every inner sponge
+// pays for its own padding and the chaining values are absorbed again.
+func ParallelHashPermutations(rate, msgBytes, blockSize, outputBits int) int {
+	cvBytes := (1600 - rate) / 8 // the chaining values: 256 bits for ParallelHash128, 512 for ParallelHash256
+	n := (msgBytes + blockSize - 1) / blockSize
+	perms := 0
+	for i := 0; i < n; i++ {
+		size := blockSize
+		if rest := msgBytes - i*blockSize; rest < size {
+			size = rest
+		}
+		perms += SpongePermutations(rate, size, 8*cvBytes)
+	}
+	z := len(leftEncode(uint64(blockSize))) + n*cvBytes + len(rightEncode(uint64(n))) + len(rightEncode(uint64(outputBits)))
+	return perms + SpongePermutations(rate, z, outputBits)
+}
+
+// The block functions are top-level (not closures) so the memoization key is just (function, input length).
+func parallelHashBlock128(api frontend.API, block []frontend.Variable) []frontend.Variable {
+	return keccakSponge(api, block, Shake128Rate, DomainSHAKE, 256)
+}
+
+func parallelHashBlock256(api frontend.API, block []frontend.Variable) []frontend.Variable {
+	return keccakSponge(api, block, Shake256Rate, DomainSHAKE, 512)
+}
+
+func parallelHash(api frontend.API, msg []frontend.Variable, blockSize int, rate int, block func(frontend.API, []frontend.Variable) []frontend.Variable, outputBits int, s []byte) []frontend.Variable {
+	if blockSize <= 0 {
+		panic("ParallelHash: block size must be positive")
+	}
+	if len(msg)%8 != 0 {
+		panic("ParallelHash: message must be byte aligned")
+	}
+	n := (len(msg)/8 + blockSize - 1) / blockSize
+	z := constBits(leftEncode(uint64(blockSize)))
+	for i := 0; i < n; i++ {
+		end := (i + 1) * blockSize * 8
+		if end > len(msg) {
+			end = len(msg)
+		}
+		z = append(z, memorizedCall(api, block, msg[i*blockSize*8:end])...)
+	}
+	z = append(z, constBits(rightEncode(uint64(n)))...)
+	z = append(z, constBits(rightEncode(uint64(outputBits)))...)
+	return cShake(api, z, rate, []byte("ParallelHash"), s, outputBits)
+}
+
+// memorizedCall instantiates f as an ecgo sub-circuit when the builder supports it, so repeated calls with
+// the same input length are compiled once. Other APIs (e.g. plain gnark builders) just inline the call.
+func memorizedCall(api frontend.API, f func(frontend.API, []frontend.Variable) []frontend.Variable, in []frontend.Variable) []frontend.Variable {
+	if eapi, ok := api.(ecgo.API); ok {
+		return eapi.MemorizedSimpleCall(f, in)
+	}
+	return f(api, in)
+}
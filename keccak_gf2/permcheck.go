@@ -0,0 +1,156 @@
+package keccakgf2
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/spec"
+	"github.com/consensys/gnark/frontend"
+)
+
+// Permutation self-check:
+// Digest tests with random messages exercise every lane only statistically, so a miswired lane can hide
+// behind them for a long time. keccakPermCircuit runs the permutation alone on a full 1600-bit state and
+// compares all 25 lanes with the published Keccak-f[1600] test vector and with an off-circuit reference.
+// Lanes are indexed x+5y as in the Keccak reference (LayoutSpec: lane 0 = A[0,0], lane 1 = A[1,0], ...),
+// the layout of the exported KeccakF1600 gadget (permutation.go).
+
+// KeccakFZeroState is Keccak-f[1600] applied once to the all-zero state
+// (KeccakF-1600-IntermediateValues.txt from the Keccak team), lane x+5y at index x+5y.
+var KeccakFZeroState = [25]uint64{
+	0xF1258F7940E1DDE7, 0x84D5CCF933C0478A, 0xD598261EA65AA9EE, 0xBD1547306F80494D, 0x8B284E056253D057,
+	0xFF97A42D7F8E6FD4, 0x90FEE5A0A44647C4, 0x8C5BDA0CD6192E76, 0xAD30A6F71B19059C, 0x30935AB7D08FFC64,
+	0xEB5AA93F2317D635, 0xA9A6E6260D712103, 0x81A57C16DBCF555F, 0x43B831CD0347C826, 0x01F22F1A11A5569F,
+	0x05E5635A21D9AE61, 0x64BEFEF28CC970F2, 0x613670957BC46611, 0xB87C5A554FD00ECB, 0x8C3EE88A1CCF32C8,
+	0x940C7922AE3A2614, 0x1841F924A2C509E4, 0x16F53526E70465C2, 0x75F644E97F30A13B, 0xEAF1FF7B5CECA249,
+}
+
+// keccakPermCircuit proves Out = KeccakF1600^iterations(In), both as 25 lanes of 64 bits (LSB first).
+type keccakPermCircuit struct {
+	In         [25][64]frontend.Variable
+	Out        [25][64]frontend.Variable `gnark:",public"`
+	iterations int
+}
+
+func (t *keccakPermCircuit) Define(api frontend.API) error {
+	s := t.In
+	for n := 0; n < t.iterations; n++ {
+		s = KeccakF1600(api, s)
+	}
+	for i := 0; i < 25; i++ {
+		for j := 0; j < 64; j++ {
+			api.AssertIsEqual(s[i][j], t.Out[i][j])
+		}
+	}
+	return nil
+}
+
+// permState converts lanes indexed x+5y into the keccakF layout a[5x+y].
+func permState(lanes [25][64]frontend.Variable) [][]frontend.Variable {
+	ss := make([][]frontend.Variable, 25)
+	for i := 0; i < 25; i++ {
+		ss[i] = append([]frontend.Variable{}, lanes[i][:]...)
+	}
+	return ConvertState(ss, LayoutSpec, LayoutInternal)
+}
+
+// permLanes is the assignment side: 25 uint64 lanes into the circuit's bit layout.
+func permLanes(a [25]uint64) [25][64]frontend.Variable {
+	var res [25][64]frontend.Variable
+	for i := 0; i < 25; i++ {
+		copy(res[i][:], bitsOf(binary.LittleEndian.AppendUint64(nil, a[i])))
+	}
+	return res
+}
+
+// roundConstants are the ι constants of Keccak-f[1600]; keccakF reads them as bits through rcs.
+var roundConstants = spec.RoundConstants(64, 24)
+
+// keccakF1600Ref is a plain off-circuit Keccak-f[1600] (FIPS 202 section 3.3), lanes indexed x+5y,
+// used only to cross-check the circuit.
+func keccakF1600Ref(a [25]uint64) [25]uint64 {
+	return keccakP1600Ref(a, 24)
+}
+
+// keccakP1600Ref is keccakF1600Ref reduced to its last `rounds` rounds (Keccak-p[1600, rounds]).
+func keccakP1600Ref(a [25]uint64, rounds int) [25]uint64 {
+	return keccakP1600RefTrace(a, rounds, nil)
+}
+
+// keccakP1600RefTrace is keccakP1600Ref calling observe, if set, with the state after every round
+// (see trace.go).
+func keccakP1600RefTrace(a [25]uint64, rounds int, observe func(round int, a [25]uint64)) [25]uint64 {
+	for r := 24 - rounds; r < 24; r++ {
+		b := thetaRhoPiRef(a)
+		// χ
+		for y := 0; y < 5; y++ {
+			for x := 0; x < 5; x++ {
+				a[x+5*y] = b[x+5*y] ^ (^b[(x+1)%5+5*y] & b[(x+2)%5+5*y])
+			}
+		}
+		// ι
+		a[0] ^= roundConstants[r]
+		if observe != nil {
+			observe(r, a)
+		}
+	}
+	return a
+}
+
+// thetaRhoPiRef applies the linear steps θ, ρ and π of a round to a, returning the lanes B that χ reads.
+func thetaRhoPiRef(a [25]uint64) [25]uint64 {
+	// θ
+	var c [5]uint64
+	for x := 0; x < 5; x++ {
+		c[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+	}
+	for x := 0; x < 5; x++ {
+		d := c[(x+4)%5] ^ bits.RotateLeft64(c[(x+1)%5], 1)
+		for y := 0; y < 5; y++ {
+			a[x+5*y] ^= d
+		}
+	}
+	// ρ and π: B[y, 2x+3y] = rot(A[x, y], r[x, y])
+	var b [25]uint64
+	for i := 0; i < 25; i++ {
+		b[spec.PiPermutation[i]] = bits.RotateLeft64(a[i], spec.RotationOffsets[i])
+	}
+	return b
+}
+
+// keccakFLanesRef is a plain off-circuit Keccak-f[25w] (w-bit lanes held in the low bits of a uint64,
+// 12+2·log2(w) rounds), lanes indexed x+5y, written from the spec tables for w rather than from
+// keccakP1600Ref: keccakFLanesRef(32, ·) is Keccak-f[800], keccakFLanesRef(16, ·) Keccak-f[400].
+func keccakFLanesRef(w int, a [25]uint64) [25]uint64 {
+	mask := uint64(1)<<w - 1
+	rotl := func(v uint64, r int) uint64 {
+		if r == 0 {
+			return v
+		}
+		return (v<<r | v>>(w-r)) & mask
+	}
+	offsets := spec.RotationOffsetsFor(w)
+	for _, rc := range spec.RoundConstants(w, 12+2*bits.TrailingZeros(uint(w))) {
+		var c [5]uint64
+		for x := 0; x < 5; x++ {
+			c[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+		}
+		for x := 0; x < 5; x++ {
+			d := c[(x+4)%5] ^ rotl(c[(x+1)%5], 1)
+			for y := 0; y < 5; y++ {
+				a[x+5*y] ^= d
+			}
+		}
+		var b [25]uint64
+		for i := 0; i < 25; i++ {
+			b[spec.PiPermutation[i]] = rotl(a[i], offsets[i])
+		}
+		for y := 0; y < 5; y++ {
+			for x := 0; x < 5; x++ {
+				a[x+5*y] = b[x+5*y] ^ (^b[(x+1)%5+5*y] & b[(x+2)%5+5*y] & mask)
+			}
+		}
+		a[0] ^= rc
+	}
+	return a
+}
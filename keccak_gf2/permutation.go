@@ -0,0 +1,111 @@
+package keccakgf2
+
+import (
+	"math/bits"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// Function Purpose:
+	// The raw Keccak-f[1600] permutation as a standalone gadget, for sponge and duplex constructions built
+	// outside this package. It wraps keccakF, converting between the FIPS 202 lane order and keccakF's own.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `state`: 25 lanes of 64 bits, lane x+5y at index x+5y (LayoutSpec), LSB first within each lane
+// Outputs:
+	// - the permuted state in the same layout; state itself is passed by value and never modified
+// Gate Count:
+	// the same as keccakF: 24 rounds of θ, χ and ι, with ρ and π pure wiring
+func KeccakF1600(api frontend.API, state [25][64]frontend.Variable) [25][64]frontend.Variable {
+	out := ConvertState(keccakF(api, permState(state)), LayoutInternal, LayoutSpec)
+	var res [25][64]frontend.Variable
+	for i := range res {
+		copy(res[i][:], out[i])
+	}
+	return res
+}
+
+// copyState returns a copy of a keccakF state with fresh lane slices.
+func copyState(a [][]frontend.Variable) [][]frontend.Variable {
+	res := make([][]frontend.Variable, len(a))
+	for i := range a {
+		res[i] = append([]frontend.Variable{}, a[i]...)
+	}
+	return res
+}
+
+// Function Purpose:
+	// The Keccak-f[800] permutation: 25 lanes of 32 bits, 22 rounds, the ρ offsets mod 32 and the round
+	// constants RC[0..21] cut to 32 bits, through keccakP on 32-bit lanes.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `state`: 25 lanes of 32 bits, lane x+5y at index x+5y (LayoutSpec), LSB first within each lane
+// Outputs:
+	// - the permuted state in the same layout; state itself is passed by value and never modified
+// Gate Count:
+	// 22 rounds of 800 AND, 800 NOT (plus ι) and 3200 XOR: 17600 AND against keccakF's 38400, 11/24 of it
+func KeccakF800(api frontend.API, state [25][32]frontend.Variable) [25][32]frontend.Variable {
+	lanes := make([][]frontend.Variable, 25)
+	for i := range lanes {
+		lanes[i] = state[i][:]
+	}
+	out := keccakFLanes(api, lanes)
+	var res [25][32]frontend.Variable
+	for i := range res {
+		copy(res[i][:], out[i])
+	}
+	return res
+}
+
+// Function Purpose:
+	// The Keccak-f[400] permutation: 25 lanes of 16 bits, 20 rounds, the ρ offsets mod 16 and the round
+	// constants RC[0..19] cut to 16 bits, for very small sponge and duplex instances.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `state`: 25 lanes of 16 bits, lane x+5y at index x+5y (LayoutSpec), LSB first within each lane
+// Outputs:
+	// - the permuted state in the same layout; state itself is passed by value and never modified
+// Gate Count:
+	// 20 rounds of 400 AND, 400 NOT (plus ι) and 1600 XOR: 8000 AND, 5/24 of keccakF's 38400
+func KeccakF400(api frontend.API, state [25][16]frontend.Variable) [25][16]frontend.Variable {
+	lanes := make([][]frontend.Variable, 25)
+	for i := range lanes {
+		lanes[i] = state[i][:]
+	}
+	out := keccakFLanes(api, lanes)
+	var res [25][16]frontend.Variable
+	for i := range res {
+		copy(res[i][:], out[i])
+	}
+	return res
+}
+
+// Function Purpose:
+	// The Keccak-f[200] permutation: 25 lanes of 8 bits, 18 rounds, the ρ offsets mod 8 and the round
+	// constants RC[0..17] cut to 8 bits. Small enough for fast differential tests and fuzzing of the rounds.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `state`: 25 lanes of 8 bits, lane x+5y at index x+5y (LayoutSpec), LSB first within each lane
+// Outputs:
+	// - the permuted state in the same layout; state itself is passed by value and never modified
+// Gate Count:
+	// 18 rounds of 200 AND, 200 NOT (plus ι) and 800 XOR: 3600 AND, 3/32 of keccakF's 38400
+func KeccakF200(api frontend.API, state [25][8]frontend.Variable) [25][8]frontend.Variable {
+	lanes := make([][]frontend.Variable, 25)
+	for i := range lanes {
+		lanes[i] = state[i][:]
+	}
+	out := keccakFLanes(api, lanes)
+	var res [25][8]frontend.Variable
+	for i := range res {
+		copy(res[i][:], out[i])
+	}
+	return res
+}
+
+// keccakFLanes runs all 12+2·log2(w) rounds of keccakP on a LayoutSpec state of w-bit lanes (lanes is
+// not modified) and returns the result in LayoutSpec.
+func keccakFLanes(api frontend.API, lanes [][]frontend.Variable) [][]frontend.Variable {
+	a := copyState(ConvertState(lanes, LayoutSpec, LayoutInternal))
+	return ConvertState(keccakP(api, a, 0, 12+2*bits.TrailingZeros(uint(len(lanes[0])))), LayoutInternal, LayoutSpec)
+}
@@ -0,0 +1,61 @@
+package keccakgf2
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// Preflight:
+// A wrong Out (byte-swapped, bit-reversed, hashed without the context, ...) is only discovered after the
+// solver and the checker have run. Preflight re-derives every digest from the assignment's own P (and
+// Context) bits with CircuitKeccak256 (Sha3_256 through evalBytes for SHA3-256), i.e. exactly as the
+// circuit will, and compares it with Out first, which takes milliseconds.
+// checkedSolver.SolveInput runs it on every keccak256Circuit assignment, and solveBatch on every one of a
+// batch, unless skipPreflight is set (-no-preflight).
+
+var skipPreflight = false
+
+// Preflight returns nil if every Out[k] is (the first len(Out[k]) bits of) the Keccak-256 (SHA3-256 for a
+// WithSHA3 assignment) of Context || P[k] (PublicP[k]), and otherwise an error listing
+// each mismatching instance with the expected and the assigned digest in hex.
+func Preflight(assignment *keccak256Circuit) error {
+	context, err := assignedBytes(assignment.Context)
+	if err != nil {
+		return fmt.Errorf("preflight: Context: %w", err)
+	}
+	checkBits, err := assignment.checkBits()
+	if err != nil {
+		return fmt.Errorf("preflight: %w", err)
+	}
+	var mismatches []string
+	for k, bits := range assignment.messages() {
+		msg, err := assignedBytes(bits[:])
+		if err != nil {
+			return fmt.Errorf("preflight: %s[%d]: %w", assignment.messageField(), k, err)
+		}
+		// Out holds the first checkBits bits only; compare those, padding the tail with zeros on both sides
+		hashed := append(append([]byte{}, context...), msg...)
+		digest := CircuitKeccak256(hashed)
+		want := digest[:]
+		if assignment.SHA3 {
+			want = evalBytes(func(api frontend.API) []frontend.Variable { return Sha3_256(api, bitsOf(hashed)) })
+		}
+		got, err := assignedBytes(append(append([]frontend.Variable{}, assignment.Out[k]...), zeroBits(256-checkBits)...))
+		if err != nil {
+			return fmt.Errorf("preflight: Out[%d]: %w", k, err)
+		}
+		for i := checkBits; i < 256; i++ {
+			want[i/8] &^= 1 << (i % 8)
+		}
+		if !bytes.Equal(want, got) {
+			mismatches = append(mismatches, fmt.Sprintf("instance %d: expected %x, assigned %x", k, want, got))
+		}
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("preflight: %d digest(s) do not match their messages:\n  %s", len(mismatches), strings.Join(mismatches, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package keccakgf2
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/field/gf2"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/verifier"
+	"github.com/consensys/gnark/frontend"
+)
+
+// Public-input messages (transparent-hash mode):
+// When the verifier knows the messages, the circuit only certifies the Keccak relation between public
+// messages and public digests (e.g. to offload the recomputation). gnark reads the visibility of an input
+// from its struct tag, so it cannot be switched on P itself: keccak256Circuit carries the messages either
+// in P (private) or in PublicP (tagged public), and exactly one of the two is allocated. The public inputs
+// of an assignment are then the digests, the context and the messages, in this order (verifier.Layout
+// with PublicMessages). Everything that reads the messages goes through messages().
+
+// NewPublicInputKeccak256Circuit returns a circuit (or an empty assignment) with n Keccak-256 instances
+// whose messages are public inputs.
+func NewPublicInputKeccak256Circuit(n int, opts ...CircuitOption) *keccak256Circuit {
+	return NewKeccak256Circuit(n, opts...).withPublicMessages()
+}
+
+// withPublicMessages moves the messages of t, a template or a filled assignment, from P to PublicP and
+// returns t.
+func (t *keccak256Circuit) withPublicMessages() *keccak256Circuit {
+	if t.PublicP == nil {
+		t.PublicP, t.P = t.P, nil
+	}
+	return t
+}
+
+// messages returns the message bits of every instance, from P or PublicP.
+func (t *keccak256Circuit) messages() [][64 * 8]frontend.Variable {
+	if t.PublicP != nil {
+		return t.PublicP
+	}
+	return t.P
+}
+
+// messageField is the name of the field messages() reads, for error messages.
+func (t *keccak256Circuit) messageField() string {
+	if t.PublicP != nil {
+		return "PublicP"
+	}
+	return "P"
+}
+
+// layout is the public-input layout of t as a template.
+func (t *keccak256Circuit) layout() verifier.Layout {
+	return verifier.Layout{Instances: len(t.Out), CheckBits: len(t.Out[0]), ContextBytes: len(t.Context) / 8, PublicMessages: t.PublicP != nil}
+}
+
+// BuildBatch compiles the batch circuit of o, with public messages if o.PublicInput is set and SHA3-256
+// instead of Keccak-256 if o.SHA3 is, writes it to
+// circuit.txt with its layout.json, solves 16 random assignments into witness.env and checks that batch
+// through the verifier package, the way a prover hands a batch to a verifier. In low-memory mode the
+// compile also leaves batch.circuit and batch.solver behind (see lowmem.go).
+func BuildBatch(o Options) error {
+	template := newKeccak256Circuit(o.Instances, len(o.Context))
+	if o.PublicInput {
+		template.withPublicMessages()
+	}
+	opts := o.circuitOptions()
+	for _, opt := range opts {
+		opt(template)
+	}
+	built, err := compileBuilt(gf2.ScalarField, template, ".", "batch")
+	if err != nil {
+		return err
+	}
+	c := built.Circuit
+	if err := os.WriteFile("circuit.txt", c.Serialize(), 0o644); err != nil {
+		return err
+	}
+	if err := template.layout().Describe(built.Fingerprint).WriteFile("layout.json"); err != nil {
+		return err
+	}
+	rnd := o.Reader()
+	assignments := make([]frontend.Circuit, 16)
+	indices := make([]int, len(assignments))
+	for z := range assignments {
+		a, err := randomAssignment(rnd, o.Instances, o.Context, opts...)
+		if err != nil {
+			return err
+		}
+		if o.PublicInput {
+			a.withPublicMessages()
+		}
+		assignments[z], indices[z] = a, z
+	}
+	env, err := solveBatch(newCheckedSolver(built.Solver, gf2.ScalarField, template), assignments, indices)
+	if err != nil {
+		return fmt.Errorf("solving: %w", err)
+	}
+	if err := os.WriteFile("witness.env", env.Serialize(), 0o644); err != nil {
+		return err
+	}
+	vc, _, err := verifier.LoadCircuit("circuit.txt")
+	if err != nil {
+		return err
+	}
+	venv, err := verifier.LoadEnvelope("witness.env")
+	if err != nil {
+		return err
+	}
+	if err := expectBatch(vc, venv); err != nil {
+		return err
+	}
+	first, err := DigestHex(assignments[0].(*keccak256Circuit).Out[0])
+	if err != nil {
+		return err
+	}
+	logger.Debugf("digest of message 0 of assignment 0: %s", first)
+	logger.Infof("built and checked a batch of %d assignments (%d public inputs each) into circuit.txt, layout.json and witness.env",
+		len(assignments), env.Witness.NumPublicInputsPerWitness)
+	return nil
+}
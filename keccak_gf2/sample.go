@@ -0,0 +1,86 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Hash-to-index sampling:
+// k indices in [0, 2^m) are read off the Keccak-256 sponge output of a seed, squeezed for as many bits
+// as needed (k*m, continuing past the first 256 bits with further permutations). Index i is output
+// bits [i*m, (i+1)*m), LSB first, where output bit 8*b+j is bit j of output byte b.
+// sampleIndices is the off-circuit sampler with exactly the same slicing, for witness generation.
+
+// Function Purpose:
+	// Derives k m-bit indices from Keccak(seed) and optionally asserts that they are pairwise distinct.
+	// With distinct set, a seed whose indices collide has no valid witness; callers that need a result
+	// for every seed must resample off-circuit (e.g. with a counter in the seed).
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `seed`: seed bits, LSB first within each byte, byte aligned
+	// - `k`, `m`: number of indices and their width in bits
+	// - `distinct`: whether to assert pairwise distinctness
+// Outputs:
+	// - k bit vectors of m bits each, LSB first
+// Gate Count:
+	// the sponge: one keccakF per absorbed block plus one per extra 1088 squeezed bits;
+	// distinctness: k(k-1)/2 CompareDigest calls of m XOR + m-1 AND gates each
+func HashToIndices(api frontend.API, seed []frontend.Variable, k int, m int, distinct bool) [][]frontend.Variable {
+	if k <= 0 || m <= 0 || m > 63 {
+		panic("HashToIndices: need k > 0 and 0 < m < 64")
+	}
+	stream := keccakSponge(api, seed, Keccak256Config.RateBits, DomainKeccak, k*m)
+	indices := make([][]frontend.Variable, k)
+	for i := range indices {
+		indices[i] = stream[i*m : (i+1)*m]
+	}
+	if distinct {
+		for i := 0; i < k; i++ {
+			for j := i + 1; j < k; j++ {
+				api.AssertIsEqual(CompareDigest(api, indices[i], indices[j]), 0)
+			}
+		}
+	}
+	return indices
+}
+
+// sampleIndices is HashToIndices off-circuit.
+func sampleIndices(seed []byte, k int, m int) []uint64 {
+	if k <= 0 || m <= 0 || m > 63 {
+		panic("sampleIndices: need k > 0 and 0 < m < 64")
+	}
+	h := crypto.NewKeccakState()
+	h.Write(seed)
+	stream := make([]byte, (k*m+7)/8)
+	h.Read(stream)
+	indices := make([]uint64, k)
+	for i := range indices {
+		for j := 0; j < m; j++ {
+			bit := i*m + j
+			indices[i] |= uint64((stream[bit/8]>>(bit%8))&1) << j
+		}
+	}
+	return indices
+}
+
+// sampleK and sampleM size keccakSampleCircuit: 16 distinct indices into a 2^20-element domain.
+const (
+	sampleK = 16
+	sampleM = 20
+)
+
+// keccakSampleCircuit proves that the public Indices are the distinct indices sampled from a private 32-byte seed.
+type keccakSampleCircuit struct {
+	Seed    [32 * 8]frontend.Variable
+	Indices [sampleK][sampleM]frontend.Variable `gnark:",public"`
+}
+
+func (t *keccakSampleCircuit) Define(api frontend.API) error {
+	indices := HashToIndices(api, t.Seed[:], sampleK, sampleM, true)
+	for i := 0; i < sampleK; i++ {
+		for j := 0; j < sampleM; j++ {
+			api.AssertIsEqual(indices[i][j], t.Indices[i][j])
+		}
+	}
+	return nil
+}
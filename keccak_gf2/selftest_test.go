@@ -0,0 +1,2035 @@
+package keccakgf2
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"math/bits"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/field/gf2"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/irwg"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/layered"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/test"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/spec"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/verifier"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/sha3"
+)
+
+// End-to-end tests:
+// These were the numbered self-test of the command line. They share one batch, compiled with a context
+// and solved once per test binary: circuit.txt, layout.json and witness.env are written to a temporary
+// directory and loaded back through the verifier package, the way a prover hands a batch to a verifier.
+// The command-line exit statuses are tested in cmd/keccak_gf2.
+
+// selfTestContext is the public context of the shared batch; a context keeps TestContextBinding honest.
+var selfTestContext = []byte("keccak_gf2 end-to-end")
+
+// sharedBatch is the batch the end-to-end tests share (see selfTestBatch).
+type sharedBatch struct {
+	nHashes     int
+	ctx         []byte
+	c           *layered.RootCircuit
+	is          *checkedSolver
+	assignments []frontend.Circuit
+	identity    []int
+	dir         string // holds circuit.txt, layout.json and witness.env
+	vc          *layered.RootCircuit
+	fingerprint string
+	venv        *verifier.Envelope
+	circuit     frontend.Circuit // a valid assignment outside the batch
+}
+
+var (
+	sharedBatchOnce sync.Once
+	sharedBatchVal  *sharedBatch
+	sharedBatchErr  error
+)
+
+// selfTestBatch builds the shared batch on first use: it compiles the circuit, writes it with its layout,
+// solves 16 seeded assignments in one batch into witness.env and checks that batch through the verifier
+// package. TestMain removes its directory.
+func selfTestBatch(t *testing.T) *sharedBatch {
+	t.Helper()
+	sharedBatchOnce.Do(func() { sharedBatchVal, sharedBatchErr = buildSharedBatch() })
+	if sharedBatchErr != nil {
+		t.Fatalf("shared batch: %v", sharedBatchErr)
+	}
+	return sharedBatchVal
+}
+
+func buildSharedBatch() (_ *sharedBatch, err error) {
+	b := &sharedBatch{nHashes: NHashes, ctx: selfTestContext}
+	dir, err := os.MkdirTemp("", "keccak-selftest")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+	b.dir = dir
+	rnd := seededReader(975)
+	template := newKeccak256Circuit(b.nHashes, len(b.ctx))
+	cr, err := compileCircuit(gf2.ScalarField, template)
+	if err != nil {
+		return nil, err
+	}
+	b.c = cr.GetLayeredCircuit()
+	if err := os.WriteFile(filepath.Join(dir, "circuit.txt"), b.c.Serialize(), 0o644); err != nil {
+		return nil, err
+	}
+	if err := digestLayout(b.nHashes, len(b.ctx)).Describe(verifier.Fingerprint(b.c.Serialize())).WriteFile(filepath.Join(dir, "layout.json")); err != nil {
+		return nil, err
+	}
+	b.is = newCheckedSolver(ecgo.DeserializeInputSolver(cr.GetInputSolver().Serialize()), gf2.ScalarField, template)
+	if b.circuit, err = randomAssignment(rnd, b.nHashes, b.ctx); err != nil {
+		return nil, err
+	}
+	b.assignments = make([]frontend.Circuit, 16)
+	b.identity = make([]int, len(b.assignments))
+	for z := range b.assignments {
+		if b.assignments[z], err = randomAssignment(rnd, b.nHashes, b.ctx); err != nil {
+			return nil, err
+		}
+		b.identity[z] = z
+	}
+	env, err := solveBatch(b.is, b.assignments, b.identity)
+	if err != nil {
+		return nil, fmt.Errorf("solving: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "witness.env"), env.Serialize(), 0o644); err != nil {
+		return nil, err
+	}
+	if b.vc, b.fingerprint, err = verifier.LoadCircuit(filepath.Join(dir, "circuit.txt")); err != nil {
+		return nil, err
+	}
+	if b.venv, err = verifier.LoadEnvelope(filepath.Join(dir, "witness.env")); err != nil {
+		return nil, err
+	}
+	if err := expectBatch(b.vc, b.venv); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// removeSharedBatch deletes the directory of the shared batch, if it was built.
+func removeSharedBatch() {
+	if sharedBatchVal != nil {
+		os.RemoveAll(sharedBatchVal.dir)
+	}
+}
+
+// TestBatchOrderIndependence checks batch order independence: solve the same 16 assignments in a (seeded)
+// shuffled order. Every assignment must still verify, and the public digests read back from the witness must
+// belong to the right message once mapped through the envelope indices instead of the batch position.
+func TestBatchOrderIndependence(t *testing.T) {
+	b := selfTestBatch(t)
+	nHashes, ctx, c, is, assignments, identity := b.nHashes, b.ctx, b.c, b.is, b.assignments, b.identity
+	perm := rand.New(rand.NewSource(975)).Perm(len(assignments))
+	shuffled := make([]frontend.Circuit, len(assignments))
+	for z, idx := range perm {
+		shuffled[z] = assignments[idx]
+	}
+	for _, e := range []struct {
+		batch   []frontend.Circuit
+		indices []int
+	}{{assignments, identity}, {shuffled, perm}} {
+		env, err := solveBatch(is, e.batch, e.indices)
+		if err != nil {
+			t.Fatalf("solving: %v", err)
+		}
+		if err := expectBatch(c, env); err != nil {
+			t.Fatal(err)
+		}
+		digests, err := verifier.PublicDigests(env.Witness, digestLayout(nHashes, len(ctx)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for z, idx := range env.Indices {
+			want := assignments[idx].(*keccak256Circuit)
+			for k := 0; k < nHashes; k++ {
+				for i := 0; i < CheckBits; i++ {
+					if digests[z][k][i] != want.Out[k][i].(int) {
+						t.Fatal("public digest does not map back to its message")
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestReproducibleArtifacts checks reproducible artifacts: two batches built from the same seeded reader must
+// serialize to exactly the same witness envelope.
+func TestReproducibleArtifacts(t *testing.T) {
+	b := selfTestBatch(t)
+	nHashes, ctx, is, identity := b.nHashes, b.ctx, b.is, b.identity
+	var envs [2][]byte
+	for r := range envs {
+		seeded := seededReader(977)
+		batch := make([]frontend.Circuit, 4)
+		for z := range batch {
+			a, err := randomAssignment(seeded, nHashes, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			batch[z] = a
+		}
+		env, err := solveBatch(is, batch, identity[:len(batch)])
+		if err != nil {
+			t.Fatalf("solving: %v", err)
+		}
+		envs[r] = env.Serialize()
+	}
+	if !bytes.Equal(envs[0], envs[1]) {
+		t.Fatal("seeded artifacts are not byte-stable")
+	}
+}
+
+// TestContextBinding checks context binding: re-label a valid assignment with a different context, keeping P
+// and Out: the public inputs change and the witness must no longer verify.
+func TestContextBinding(t *testing.T) {
+	b := selfTestBatch(t)
+	nHashes, ctx, c, is, assignments, venv := b.nHashes, b.ctx, b.c, b.is, b.assignments, b.venv
+	contexts, err := verifier.PublicContexts(venv.Witness, digestLayout(nHashes, len(ctx)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, got := range contexts {
+		if !bytes.Equal(got, ctx) {
+			t.Fatal("public context does not match")
+		}
+	}
+	other := make([]byte, len(ctx))
+	copy(other, ctx)
+	other[0] ^= 1
+	relabeled := *assignments[0].(*keccak256Circuit)
+	relabeled.Context = bitsOf(other)
+	if err := expectVerdict(is, c, &relabeled, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMerkleRoot checks the Merkle root commitment: the Merkle variant exposes only the root of the tree over
+// the instance digests. The root computed in the circuit must equal the off-circuit one, a flipped root bit
+// must fail, and every instance digest must open against the root.
+func TestMerkleRoot(t *testing.T) {
+	rnd := seededReader(7)
+	mcr, err := compileCircuit(gf2.ScalarField, &keccakMerkleCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc := mcr.GetLayeredCircuit()
+	mis := mcr.GetInputSolver()
+	massignment, levels, err := randomMerkleAssignment(rnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expectVerdict(mis, mc, massignment, true); err != nil {
+		t.Fatal(err)
+	}
+	massignment.Root[0] = 1 - massignment.Root[0].(int)
+	if err := expectVerdict(mis, mc, massignment, false); err != nil {
+		t.Fatal(err)
+	}
+	root := levels[MerkleDepth][0]
+	for k := 0; k < NHashes; k++ {
+		proof := merkleOpening(levels, k)
+		if !verifyMerkleOpening(root, levels[0][k], k, proof) {
+			t.Fatal("opening should verify")
+		}
+		if verifyMerkleOpening(root, levels[0][k], k^1, proof) {
+			t.Fatal("opening at the wrong index should not verify")
+		}
+	}
+}
+
+// TestDigestHexConstant checks digests against a hex constant: the all-zero message must match KeccakZero64;
+// the same constant with its bytes reversed must not, which pins down the byte/bit order AssertDigestEqualsHex
+// uses. Malformed hex is a build-time error.
+func TestDigestHexConstant(t *testing.T) {
+	zero := &keccakKATCircuit{}
+	for i := range zero.P {
+		zero.P[i] = 0
+	}
+	swapped := []byte(strings.TrimPrefix(KeccakZero64, "0x"))
+	for i, j := 0, len(swapped)-2; i < j; i, j = i+2, j-2 {
+		swapped[i], swapped[i+1], swapped[j], swapped[j+1] = swapped[j], swapped[j+1], swapped[i], swapped[i+1]
+	}
+	for _, e := range []struct {
+		expected string
+		ok       bool
+	}{{KeccakZero64, true}, {"0x" + string(swapped), false}} {
+		kcr, err := compileCircuit(gf2.ScalarField, &keccakKATCircuit{expected: e.expected})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := expectVerdict(kcr.GetInputSolver(), kcr.GetLayeredCircuit(), zero, e.ok); err != nil {
+			t.Fatalf("known-answer check: %v", err)
+		}
+	}
+	for _, bad := range []string{"0xzz", KeccakZero64[:len(KeccakZero64)-2]} {
+		if _, err := compileCircuit(gf2.ScalarField, &keccakKATCircuit{expected: bad}); err == nil {
+			t.Fatal("malformed hex should not compile")
+		}
+	}
+}
+
+// TestPermutationFixedStates checks the permutation on fixed states: keccakF on the zero state must give the
+// published constant in all 25 lanes, and three iterations must match the off-circuit reference. The reference
+// iterates must not cycle back early either.
+func TestPermutationFixedStates(t *testing.T) {
+	var zeroState [25]uint64
+	if keccakF1600Ref(zeroState) != KeccakFZeroState {
+		t.Fatal("reference permutation disagrees with the published zero-state vector")
+	}
+	seen := map[[25]uint64]bool{zeroState: true}
+	want := zeroState
+	for n := 1; n <= 3; n++ {
+		want = keccakF1600Ref(want)
+		if seen[want] {
+			t.Fatal("short cycle in the permutation")
+		}
+		seen[want] = true
+	}
+	for _, e := range []struct {
+		iterations int
+		want       [25]uint64
+	}{{1, KeccakFZeroState}, {3, want}} {
+		pcr, err := compileCircuit(gf2.ScalarField, &keccakPermCircuit{iterations: e.iterations})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := expectVerdict(pcr.GetInputSolver(), pcr.GetLayeredCircuit(), &keccakPermCircuit{In: permLanes(zeroState), Out: permLanes(e.want)}, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestWrongField checks the wrong-field guard: the circuits are built from GF(2)-only helpers; asking for
+// BN254 must fail with a descriptive error instead of producing a circuit that computes the wrong digests.
+func TestWrongField(t *testing.T) {
+	b := selfTestBatch(t)
+	nHashes := b.nHashes
+	if _, err := compileCircuit(ecc.BN254.ScalarField(), NewKeccak256Circuit(nHashes)); err == nil || !strings.Contains(err.Error(), "GF(2)") {
+		t.Fatal("compiling over BN254 should be refused")
+	}
+}
+
+// TestByteBitReversal checks byte and bit reversal: label every wire with its index and check each output
+// position for the widths used in practice (1-byte selector parts, 8-byte lanes, 20-byte addresses, 32-byte
+// words); both are involutions.
+func TestByteBitReversal(t *testing.T) {
+	for _, n := range []int{1, 8, 20, 32} {
+		label := make([]frontend.Variable, 8*n)
+		for i := range label {
+			label[i] = i
+		}
+		rb, rbits := ReverseBytes(label), ReverseBitsInBytes(label)
+		for i := 0; i < n; i++ {
+			for j := 0; j < 8; j++ {
+				if rb[8*i+j].(int) != 8*(n-1-i)+j || rbits[8*i+j].(int) != 8*i+7-j {
+					t.Fatal("reversal moved a wire to the wrong position")
+				}
+			}
+		}
+		rb, rbits = ReverseBytes(rb), ReverseBitsInBytes(rbits)
+		for i := range label {
+			if rb[i].(int) != i || rbits[i].(int) != i {
+				t.Fatal("reversal is not an involution")
+			}
+		}
+	}
+}
+
+// TestToyExample checks the toy example: the documentation example must run end to end and reproduce the
+// reference digest, and a message of the wrong length must be refused.
+func TestToyExample(t *testing.T) {
+	if digest, err := ToyExample(); err != nil || digest != "3caf4a49a24c961ab4a65db344fde10e57b3f36225d6db3fe1ab2133bc727f0b" {
+		t.Fatal("toy example failed")
+	}
+	if _, err := Keccak256(make([]byte, 31)); err == nil {
+		t.Fatal("the toy circuit accepted a 31-byte message")
+	}
+}
+
+func ExampleKeccak256() {
+	digest, err := Keccak256([]byte("hello, expander! keccak over gf2"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(digest)
+	// Output: 3caf4a49a24c961ab4a65db344fde10e57b3f36225d6db3fe1ab2133bc727f0b
+}
+
+// TestSampleIndices checks hash-to-index sampling: the off-circuit sampler must agree with the circuit for a
+// seed with distinct indices, and a changed index must be rejected.
+func TestSampleIndices(t *testing.T) {
+	rnd := seededReader(13)
+	scr, err := compileCircuit(gf2.ScalarField, &keccakSampleCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seedBytes []byte
+	var indices []uint64
+	for distinct := false; !distinct; {
+		seedBytes = make([]byte, 32)
+		if _, err := io.ReadFull(rnd, seedBytes); err != nil {
+			t.Fatal(err)
+		}
+		indices = sampleIndices(seedBytes, sampleK, sampleM)
+		seenIdx := map[uint64]bool{}
+		for _, idx := range indices {
+			seenIdx[idx] = true
+		}
+		distinct = len(seenIdx) == sampleK
+	}
+	sample := &keccakSampleCircuit{}
+	copy(sample.Seed[:], bitsOf(seedBytes))
+	for _, flip := range []bool{false, true} {
+		for i, idx := range indices {
+			copy(sample.Indices[i][:], bitsOf(binary.LittleEndian.AppendUint64(nil, idx))[:sampleM])
+		}
+		if flip {
+			sample.Indices[sampleK-1][sampleM-1] = 1 - sample.Indices[sampleK-1][sampleM-1].(int)
+		}
+		if err := expectVerdict(scr.GetInputSolver(), scr.GetLayeredCircuit(), sample, !flip); err != nil {
+			t.Fatalf("sampled indices: %v", err)
+		}
+	}
+}
+
+// TestManifestMessages checks many messages per instance slot: 21 messages need 3 assignments at the default
+// -n 8 (3 padding slots), solved 2 assignments per witness file. Every witness file must verify, and every
+// message's digest must be found through the manifest with nothing but the verifier package.
+func TestManifestMessages(t *testing.T) {
+	b := selfTestBatch(t)
+	nHashes, ctx, is, vc := b.nHashes, b.ctx, b.is, b.vc
+	rnd := seededReader(14)
+	dir := t.TempDir()
+	msgs := make([][]byte, 21)
+	for i := range msgs {
+		msgs[i] = make([]byte, 64)
+		if _, err := io.ReadFull(rnd, msgs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	progress := func(p SolveProgress) {
+		t.Logf("%d/%d assignments in %v, estimated total %v", p.Done, p.Total, p.Elapsed, p.Estimate)
+	}
+	if _, err := hashMessages(is, nHashes, msgs, ctx, dir, 2, progress, time.Now); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := verifier.LoadManifest(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	padding := 0
+	for _, slot := range manifest.Slots {
+		if slot.Padding {
+			padding++
+		}
+	}
+	nAssignments := (len(msgs) + nHashes - 1) / nHashes
+	if len(manifest.Slots) != nAssignments*nHashes || padding != nAssignments*nHashes-len(msgs) {
+		t.Fatal("manifest does not flag the padding slots")
+	}
+	for f := 0; f < (nAssignments+1)/2; f++ {
+		file := fmt.Sprintf("witness-%04d.env", f)
+		env, err := verifier.LoadEnvelope(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := expectBatch(vc, env); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+	}
+	for i, msg := range msgs {
+		digest, err := manifest.MessageDigest(dir, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, b := range bitsOf(crypto.Keccak256(ctx, msg)) {
+			if j < CheckBits && digest[j] != b.(int) {
+				t.Fatal("manifest points at the wrong digest")
+			}
+		}
+	}
+}
+
+// TestManifestFiles checks that a manifest can only point into its own directory: LoadManifest refuses a
+// slot file that is empty, absolute or climbs out through "..", MessageDigest refuses one in a manifest built
+// in memory, and a local file in a subdirectory loads.
+func TestManifestFiles(t *testing.T) {
+	dir := t.TempDir()
+	// the first two are local
+	for i, file := range []string{"witness-0000.env", "sub/witness-0000.env", "", "../witness-0000.env", "sub/../../witness-0000.env", "/tmp/witness-0000.env"} {
+		m := &verifier.Manifest{Messages: 1, Slots: []verifier.Slot{{Message: 0, File: file}}}
+		raw, err := m.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "manifest.json")
+		if err := os.WriteFile(path, raw, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		local := i < 2
+		if _, err := verifier.LoadManifest(path); (err == nil) != local {
+			t.Fatalf("LoadManifest with slot file %q: %v", file, err)
+		}
+		if _, err := m.MessageDigest(dir, 0); !local && (err == nil || !strings.Contains(err.Error(), "outside the manifest's directory")) {
+			t.Fatalf("MessageDigest with slot file %q: %v", file, err)
+		}
+	}
+}
+
+// TestMultiSizeBuild checks the multi-size build: one shared-permutation circuit for 32-, 64- and 96-byte
+// messages against one circuit per size: every variant must accept the reference digests, and the shared
+// circuit must store less than half the gates of the three separate ones, since it stores one keccakF for
+// their three. Compile times are logged; BenchmarkMultiSizeCompile measures them.
+func TestMultiSizeBuild(t *testing.T) {
+	rnd := seededReader(15)
+	sizes := []int{32, 64, 96}
+	sized := make([][]byte, len(sizes))
+	for i, n := range sizes {
+		sized[i] = make([]byte, n)
+		if _, err := io.ReadFull(rnd, sized[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkSizes := func(idx []int, shared bool) (time.Duration, int, error) {
+		sub := make([]int, len(idx))
+		for i, k := range idx {
+			sub[i] = sizes[k]
+		}
+		start := time.Now()
+		mcr, err := compileCircuit(gf2.ScalarField, newKeccakMultiSizeCircuit(sub, shared))
+		if err != nil {
+			return 0, 0, err
+		}
+		elapsed := time.Since(start)
+		assignment := newKeccakMultiSizeCircuit(sub, shared)
+		for i, k := range idx {
+			copy(assignment.P[i], bitsOf(sized[k]))
+			copy(assignment.Out[i][:], bitsOf(crypto.Keccak256(sized[k])))
+		}
+		if err := expectVerdict(mcr.GetInputSolver(), mcr.GetLayeredCircuit(), assignment, true); err != nil {
+			return 0, 0, fmt.Errorf("sizes %v: %w", sub, err)
+		}
+		return elapsed, storedGates(mcr.GetLayeredCircuit()), nil
+	}
+	var separate time.Duration
+	var separateGates int
+	for k := range sizes {
+		elapsed, gates, err := checkSizes([]int{k}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		separate += elapsed
+		separateGates += gates
+	}
+	together, togetherGates, err := checkSizes([]int{0, 1, 2}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("compile %v: %v as separate circuits, %v with a shared permutation", sizes, separate, together)
+	if 2*togetherGates >= separateGates {
+		t.Fatalf("the shared circuit stores %d gates, the separate circuits %d", togetherGates, separateGates)
+	}
+}
+
+// storedGates counts the gates c stores: those of every circuit once, however often it is instantiated.
+func storedGates(c *layered.RootCircuit) int {
+	n := 0
+	for _, sc := range c.Circuits {
+		n += len(sc.Mul) + len(sc.Add) + len(sc.Cst)
+	}
+	return n
+}
+
+// TestForeignVerifier checks a foreign verifier: rebuild every digest of the shared batch from layout.json and
+// the raw public-input vector alone, the way a verifier in another language would.
+func TestForeignVerifier(t *testing.T) {
+	b := selfTestBatch(t)
+	nHashes, assignments, venv, fingerprint := b.nHashes, b.assignments, b.venv, b.fingerprint
+	layoutJSON, err := os.ReadFile("layout.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var desc struct {
+		Version      int    `json:"version"`
+		Circuit      string `json:"circuit"`
+		PublicInputs int    `json:"public_inputs"`
+		Inputs       []struct {
+			Position, Instance, Byte, Bit int
+			Kind                          string
+		} `json:"inputs"`
+	}
+	if err := json.Unmarshal(layoutJSON, &desc); err != nil {
+		t.Fatal(err)
+	}
+	if desc.Version != verifier.DescriptorVersion || desc.Circuit != fingerprint || desc.PublicInputs != venv.Witness.NumPublicInputsPerWitness {
+		t.Fatal("layout.json does not describe circuit.txt")
+	}
+	per := venv.Witness.NumInputsPerWitness + venv.Witness.NumPublicInputsPerWitness
+	for z, idx := range venv.Indices {
+		public := venv.Witness.Values[z*per+venv.Witness.NumInputsPerWitness : (z+1)*per]
+		digests := make([][32]byte, nHashes)
+		for _, in := range desc.Inputs {
+			if in.Kind == "digest" {
+				digests[in.Instance][in.Byte] |= byte(public[in.Position].Bit(0)) << in.Bit
+			}
+		}
+		want := assignments[idx].(*keccak256Circuit)
+		for k := range digests {
+			for i := 0; i < CheckBits; i++ {
+				if int(digests[k][i/8]>>(i%8)&1) != want.Out[k][i].(int) {
+					t.Fatal("foreign verifier rebuilt the wrong digest")
+				}
+			}
+		}
+	}
+}
+
+// TestAbsorbLimbsBN254 checks absorbing 64-bit limbs: over BN254 (the limb form needs a field wider than 64
+// bits), a 20-limb message absorbed with AbsorbU64Limbs must hash to the go-ethereum digest, and a digest off
+// by one bit must be rejected. The checked solver refuses a limb equal to the modulus.
+func TestAbsorbLimbsBN254(t *testing.T) {
+	rnd := seededReader(17)
+	lcr, err := ecgo.Compile(ecc.BN254.ScalarField(), newKeccakLimbCircuit(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	limbMsg := make([]byte, 8*20)
+	if _, err := io.ReadFull(rnd, limbMsg); err != nil {
+		t.Fatal(err)
+	}
+	limbAssignment := newKeccakLimbCircuit(20)
+	for i := range limbAssignment.Limbs {
+		limbAssignment.Limbs[i] = new(big.Int).SetUint64(binary.LittleEndian.Uint64(limbMsg[8*i:]))
+	}
+	putBits(limbAssignment.Out[:], crypto.Keccak256(limbMsg))
+	for _, tamper := range []bool{false, true} {
+		if tamper {
+			limbAssignment.Out[255] = 1 - limbAssignment.Out[255].(int)
+		}
+		if err := expectVerdict(lcr.GetInputSolver(), lcr.GetLayeredCircuit(), limbAssignment, !tamper); err != nil {
+			t.Fatalf("limb absorb: %v", err)
+		}
+	}
+	limbAssignment.Out[255] = 1 - limbAssignment.Out[255].(int)
+	// Over BN254 the range is the prime modulus: a limb equal to it is rejected, one below it is not.
+	lis := newCheckedSolver(lcr.GetInputSolver(), ecc.BN254.ScalarField(), newKeccakLimbCircuit(20))
+	limbAssignment.Limbs[3] = ecc.BN254.ScalarField()
+	if _, err := lis.SolveInput(limbAssignment, 0); err == nil || !strings.Contains(err.Error(), "Limbs[3] = ") {
+		t.Fatal("a limb equal to the modulus should be rejected")
+	}
+	limbAssignment.Limbs[3] = new(big.Int).Sub(ecc.BN254.ScalarField(), big.NewInt(1))
+	if _, err := lis.SolveInput(limbAssignment, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestErrorInjection checks error injection: perturb a seeded sample of witness values of a valid assignment,
+// across the private message bits, the public digests and the context, and flip a seeded sample of gate
+// outputs in the layers above the input; the checker must reject every single one.
+func TestErrorInjection(t *testing.T) {
+	b := selfTestBatch(t)
+	c, is, assignments := b.c, b.is, b.assignments
+	wit, err := is.SolveInput(assignments[0], 0)
+	if err != nil {
+		t.Fatalf("solving: %v", err)
+	}
+	names := inputNames(assignments[0])
+	if len(names) != wit.NumInputsPerWitness+wit.NumPublicInputsPerWitness {
+		t.Fatal("annotation table does not match the witness layout")
+	}
+	rnd := rand.New(rand.NewSource(994))
+	injectAt := rnd.Perm(len(names))[:32]
+	if accepted := injectErrors(c, wit, injectAt); len(accepted) != 0 {
+		for _, pos := range accepted {
+			t.Errorf("perturbing witness position %d (%s) was accepted", pos, names[pos])
+		}
+		t.Fatal("checker accepted perturbed witnesses")
+	}
+
+	gates := make([]gateWire, 128)
+	for i := range gates {
+		layer := rnd.Intn(len(c.Layers) - 1)
+		gates[i] = gateWire{layer, rnd.Intn(int(c.Circuits[c.Layers[layer]].OutputLen))}
+	}
+	accepted, checked, err := injectGateErrors(c, wit, gates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked == 0 {
+		t.Fatal("no sampled gate output reaches the next layer")
+	}
+	for _, g := range accepted {
+		t.Errorf("flipping wire %d of layer %d was accepted", g.Wire, g.Layer)
+	}
+}
+
+// TestReproducibleCorpus checks the reproducible corpus: regenerating the corpus must be byte-identical, both
+// run to run and against the checked-in testdata/.
+func TestReproducibleCorpus(t *testing.T) {
+	dir := t.TempDir()
+	corpusDirs := [2]string{filepath.Join(dir, "corpus-a"), filepath.Join(dir, "corpus-b")}
+	for _, d := range corpusDirs {
+		if err := GenerateCorpus(d, CorpusSeed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if same, err := sameCorpus(corpusDirs[0], corpusDirs[1]); err != nil || !same {
+		t.Fatal("corpus generation is not deterministic")
+	}
+	if same, err := sameCorpus(corpusDirs[0], "testdata"); err != nil || !same {
+		t.Fatal("testdata/ is stale: regenerate it with -gencorpus testdata")
+	}
+}
+
+// TestNoConstantGates checks constant gates: after the gadget-level folding, the default build must not
+// compute anything from constants alone.
+func TestNoConstantGates(t *testing.T) {
+	stats, err := gateStats(gf2.ScalarField, newKeccak256Circuit(NHashes, len(selfTestContext)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("gate stats: %+v", *stats)
+	if stats.ConstGates != 0 {
+		t.Fatalf("%d gates compute constants", stats.ConstGates)
+	}
+}
+
+// TestSelectorDispatch checks selector dispatch: 196 bytes of swapExactTokensForTokens calldata (two sponge
+// blocks) against four allowed ERC-20 and router selectors must verify; balanceOf calldata of the same length,
+// with its own correct digest, must not, since its selector is not allowed.
+func TestSelectorDispatch(t *testing.T) {
+	rnd := seededReader(21)
+	signatures := []string{
+		"transfer(address,uint256)",
+		"approve(address,uint256)",
+		"transferFrom(address,address,uint256)",
+		"swapExactTokensForTokens(uint256,uint256,address[],address,uint256)",
+	}
+	if hex.EncodeToString(functionSelector(signatures[0])) != "a9059cbb" {
+		t.Fatal("wrong selector for transfer(address,uint256)")
+	}
+	calldataLen := 4 + 6*32
+	dcr, err := compileCircuit(gf2.ScalarField, newKeccakDispatchCircuit(calldataLen, len(signatures)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []struct {
+		signature string
+		ok        bool
+	}{{signatures[3], true}, {"balanceOf(address)", false}} {
+		calldata := make([]byte, calldataLen)
+		copy(calldata, functionSelector(e.signature))
+		if _, err := io.ReadFull(rnd, calldata[4:]); err != nil {
+			t.Fatal(err)
+		}
+		dispatch := newKeccakDispatchCircuit(calldataLen, len(signatures))
+		copy(dispatch.Calldata, bitsOf(calldata))
+		for i, sig := range signatures {
+			copy(dispatch.Selectors[i][:], bitsOf(functionSelector(sig)))
+		}
+		copy(dispatch.Digest[:], bitsOf(crypto.Keccak256(calldata)))
+		if err := expectVerdict(dcr.GetInputSolver(), dcr.GetLayeredCircuit(), dispatch, e.ok); err != nil {
+			t.Fatalf("dispatch check for %s: %v", e.signature, err)
+		}
+	}
+}
+
+// TestPreflightBeforeSolve checks preflight: an assignment whose Out has the bytes of one digest reversed must
+// be rejected by preflight, before the solver is ever called, in a batch and by SolveInput alike.
+func TestPreflightBeforeSolve(t *testing.T) {
+	b := selfTestBatch(t)
+	nHashes, ctx, is := b.nHashes, b.ctx, b.is
+	rnd := seededReader(22)
+	swappedOut, err := randomAssignment(rnd, nHashes, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Preflight(swappedOut); err != nil {
+		t.Fatal(err)
+	}
+	last := nHashes - 1
+	copy(swappedOut.Out[last][:], ReverseBytes(swappedOut.Out[last][:]))
+	solver := &countingSolver{is: is}
+	if _, err := solveBatch(solver, []frontend.Circuit{swappedOut}, []int{0}); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("instance %d", last)) {
+		t.Fatal("preflight should reject the byte-swapped digest")
+	}
+	if solver.calls != 0 {
+		t.Fatal("solver ran before preflight")
+	}
+	if _, err := is.SolveInput(swappedOut, 0); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("instance %d", last)) {
+		t.Fatalf("SolveInput should preflight the byte-swapped digest: %v", err)
+	}
+}
+
+// TestStateLayouts checks state layouts: converting to the spec layout must put lane (x, y) at x+5y,
+// converting back must be the identity, and the published zero-state vector read back into the internal layout
+// must come out unchanged.
+func TestStateLayouts(t *testing.T) {
+	labels := make([][]frontend.Variable, 25)
+	for i := range labels {
+		labels[i] = []frontend.Variable{i}
+	}
+	specOrder := ConvertState(labels, LayoutInternal, LayoutSpec)
+	back := ConvertState(specOrder, LayoutSpec, LayoutInternal)
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 5; y++ {
+			if specOrder[x+5*y][0].(int) != 5*x+y || back[5*x+y][0].(int) != 5*x+y {
+				t.Fatal("state layout conversion moved a lane to the wrong place")
+			}
+		}
+	}
+	internal := convertLanes(KeccakFZeroState, LayoutSpec, LayoutInternal)
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 5; y++ {
+			if internal[5*x+y] != KeccakFZeroState[x+5*y] {
+				t.Fatalf("lane (%d, %d) of the published zero-state vector lands at the wrong internal index", x, y)
+			}
+		}
+	}
+	if convertLanes(internal, LayoutInternal, LayoutSpec) != KeccakFZeroState {
+		t.Fatal("state layout conversion is not invertible")
+	}
+}
+
+// TestMidstate exports the midstate of a Keccak-256 sponge after two blocks, compares it lane by lane with
+// the off-circuit reference absorbing the same blocks, and resumes from both the exported and the
+// reference midstate: absorbing the rest of the message must give go-ethereum's digest of all of it.
+func TestMidstate(t *testing.T) {
+	eval := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+	r := rateOf(Keccak256Config.RateBits)
+	msg := make([]byte, 2*r.Bytes+50)
+	if _, err := io.ReadFull(seededReader(999), msg); err != nil {
+		t.Fatal(err)
+	}
+	s := NewSponge(r.Bits, DomainKeccak)
+	s.Absorb(eval, bitsOf(msg[:r.Bytes+8]))
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("Midstate accepted a partial block")
+			}
+		}()
+		s.Midstate()
+	}()
+	s.Absorb(eval, bitsOf(msg[r.Bytes+8:2*r.Bytes]))
+	exported := s.Midstate()
+
+	var ref bitState
+	for blk := 0; blk < 2*r.Bytes; blk += r.Bytes {
+		lanes := ref.lanes()
+		for i := 0; i < r.Lanes; i++ {
+			lanes[i] ^= binary.LittleEndian.Uint64(msg[blk+8*i:])
+		}
+		ref = bitKeccakF(bitStateFromLanes(lanes), 64)
+	}
+	imported := make([][]frontend.Variable, 25)
+	for i, lane := range ref.lanes() {
+		imported[i] = bitsOf(binary.LittleEndian.AppendUint64(nil, lane))
+		got, err := assignedBytes(exported[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if binary.LittleEndian.Uint64(got) != lane {
+			t.Fatalf("midstate lane %d is %x, the reference has %016x", i, got, lane)
+		}
+	}
+
+	want := crypto.Keccak256(msg)
+	for name, mid := range map[string][][]frontend.Variable{"exported": exported, "reference": imported} {
+		resumed := ResumeSponge(r.Bits, DomainKeccak, mid)
+		resumed.Absorb(eval, bitsOf(msg[2*r.Bytes:]))
+		got, err := assignedBytes(resumed.Squeeze(eval, 256))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("resumed from the %s midstate: %x, go-ethereum gives %x", name, got, want)
+		}
+	}
+}
+
+// TestCheckpointedBuild checks the checkpointed build of a 4-size multi-size target, which is compiled one
+// instance at a time: stop it after 2 of the 4 instances, resume it, and compare with an uninterrupted
+// build: the interrupted run leaves exactly its 2 pieces in state.json, the resumed run compiles only the
+// missing 2, and the assembled fingerprints match.
+func TestCheckpointedBuild(t *testing.T) {
+	dir := t.TempDir()
+	targets := func() []buildTarget {
+		return []buildTarget{{Name: "multisize", Circuit: newKeccakMultiSizeCircuit([]int{32, 64, 96, 128}, false)}}
+	}
+	full, err := (&checkpointedBuild{Dir: filepath.Join(dir, "build-full"), Field: gf2.ScalarField}).Run(context.Background(), targets())
+	if err != nil {
+		t.Fatal(err)
+	}
+	interrupted, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var compiled []string
+	resumable := &checkpointedBuild{Dir: filepath.Join(dir, "build-resumed"), Field: gf2.ScalarField, OnBuilt: func(piece string) {
+		compiled = append(compiled, piece)
+		if len(compiled) == 2 {
+			cancel()
+		}
+	}}
+	if _, err := resumable.Run(interrupted, targets()); !errors.Is(err, context.Canceled) || len(compiled) != 2 {
+		t.Fatalf("build should stop after 2 instances, compiled %v: %v", compiled, err)
+	}
+	raw, err := os.ReadFile(filepath.Join(resumable.Dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state buildState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Done) != 2 || state.Done["multisize.instance-0"] == "" || state.Done["multisize.instance-1"] == "" {
+		t.Fatalf("interrupted build saved %s", raw)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(resumable.Dir, ".state.json.*")); len(leftovers) > 0 {
+		t.Fatalf("state.json was written in place: %v", leftovers)
+	}
+	resumed, err := resumable.Run(context.Background(), targets())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(compiled) != "[multisize.instance-0 multisize.instance-1 multisize.instance-2 multisize.instance-3]" {
+		t.Fatalf("resumed build compiled %v, expected the 2 missing instances", compiled)
+	}
+	if resumed["multisize"].Fingerprint != full["multisize"].Fingerprint {
+		t.Fatal("resumed build differs from the uninterrupted one")
+	}
+}
+
+// TestDualDigest checks Keccak-256 and SHA3-256 of one message: both reference digests must verify; keeping
+// either one correct while breaking the other must not.
+func TestDualDigest(t *testing.T) {
+	rnd := seededReader(25)
+	dualcr, err := compileCircuit(gf2.ScalarField, &keccakDualCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dualMsg := make([]byte, 64)
+	if _, err := io.ReadFull(rnd, dualMsg); err != nil {
+		t.Fatal(err)
+	}
+	sha3Digest := sha3.Sum256(dualMsg)
+	for _, e := range []struct {
+		breakKeccak, breakSHA3 bool
+	}{{false, false}, {true, false}, {false, true}} {
+		dual := &keccakDualCircuit{}
+		copy(dual.P[:], bitsOf(dualMsg))
+		copy(dual.Keccak[:], bitsOf(crypto.Keccak256(dualMsg)))
+		copy(dual.SHA3[:], bitsOf(sha3Digest[:]))
+		if e.breakKeccak {
+			dual.Keccak[0] = 1 - dual.Keccak[0].(int)
+		}
+		if e.breakSHA3 {
+			dual.SHA3[0] = 1 - dual.SHA3[0].(int)
+		}
+		if err := expectVerdict(dualcr.GetInputSolver(), dualcr.GetLayeredCircuit(), dual, !e.breakKeccak && !e.breakSHA3); err != nil {
+			t.Fatalf("dual digest check: %v", err)
+		}
+	}
+}
+
+// TestKeccakPTables checks the Keccak-p tables: the spec tables are generated, so pin them to the published
+// values (FIPS 202 tables 2 and the RC list of the Keccak reference), and check that keccakF's rcs and
+// keccakF1600Ref read the same round constants.
+func TestKeccakPTables(t *testing.T) {
+	publishedOffsets := [25]int{
+		0, 1, 62, 28, 27,
+		36, 44, 6, 55, 20,
+		3, 10, 43, 25, 39,
+		41, 45, 15, 21, 8,
+		18, 2, 61, 56, 14,
+	}
+	publishedRC := []uint64{
+		0x0000000000000001, 0x0000000000008082, 0x800000000000808A, 0x8000000080008000,
+		0x000000000000808B, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+		0x000000000000008A, 0x0000000000000088, 0x0000000080008009, 0x000000008000000A,
+		0x000000008000808B, 0x800000000000008B, 0x8000000000008089, 0x8000000000008003,
+		0x8000000000008002, 0x8000000000000080, 0x000000000000800A, 0x800000008000000A,
+		0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+	}
+	if spec.RotationOffsets != publishedOffsets {
+		t.Fatal("rotation offsets differ from the published table")
+	}
+	for i := 0; i < 25; i++ {
+		x, y := i%5, i/5
+		if spec.PiPermutation[i] != y+5*((2*x+3*y)%5) {
+			t.Fatal("pi permutation differs from B[y, 2x+3y] = A[x, y]")
+		}
+	}
+	for r := 0; r < 24; r++ {
+		if roundConstants[r] != publishedRC[r] {
+			t.Fatalf("round constant %d is %016x, published %016x", r, roundConstants[r], publishedRC[r])
+		}
+		for j := 0; j < 64; j++ {
+			if uint64(rcs[r][j]) != (roundConstants[r]>>j)&1 {
+				t.Fatal("keccakF round constants differ from the reference ones")
+			}
+		}
+	}
+	// Reduced instances: Keccak-p[1600, 12] runs the last 12 rounds, Keccak-f[200] keeps the low 8 bits.
+	for r, v := range spec.RoundConstants(64, 12) {
+		if v != publishedRC[12+r] {
+			t.Fatal("Keccak-p[1600, 12] round constants are not the last 12 of Keccak-f[1600]")
+		}
+	}
+	for r, v := range spec.RoundConstants(8, 18) {
+		if v != publishedRC[r]&0xff {
+			t.Fatal("Keccak-f[200] round constants are not the truncated Keccak-f[1600] ones")
+		}
+	}
+}
+
+// TestInstanceCount checks the instance count: NewKeccak256Circuit(n) must cost exactly n times one instance,
+// and assignments built with the same constructor must go through both SolveInput and SolveInputs.
+func TestInstanceCount(t *testing.T) {
+	rnd := seededReader(27)
+	one, err := gateStats(gf2.ScalarField, NewKeccak256Circuit(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{1, 8, 32} {
+		stats, err := gateStats(gf2.ScalarField, NewKeccak256Circuit(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := GateStats{Add: n * one.Add, Sub: n * one.Sub, Mul: n * one.Mul, Assert: n * one.Assert, Boolean: n * one.Boolean, ConstGates: n * one.ConstGates, PublicInputs: n * one.PublicInputs}
+		if *stats != want {
+			t.Fatalf("%d instances cost %+v, expected %+v", n, *stats, want)
+		}
+		t.Logf("%d instances: %+v", n, *stats)
+		ncr, err := compileCircuit(gf2.ScalarField, NewKeccak256Circuit(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nis := ncr.GetInputSolver()
+		nbatch := make([]frontend.Circuit, 2)
+		for z := range nbatch {
+			if nbatch[z], err = randomAssignment(rnd, n, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := expectVerdict(nis, ncr.GetLayeredCircuit(), nbatch[0], true); err != nil {
+			t.Fatalf("%d instances: %v", n, err)
+		}
+		env, err := solveBatch(nis, nbatch, []int{0, 1})
+		if err != nil {
+			t.Fatalf("%d instances: solving: %v", n, err)
+		}
+		if err := expectBatch(ncr.GetLayeredCircuit(), env); err != nil {
+			t.Fatalf("%d instances: %v", n, err)
+		}
+	}
+	if _, err := assignMessages(nil, nil, CheckBits); err == nil {
+		t.Fatal("an assignment without messages should be rejected")
+	}
+}
+
+// TestMalformedAssignments checks malformed assignments: every structurally broken assignment must come back
+// from the checked solver as an error naming the offending field, and a panicking solver must not take the
+// process down.
+func TestMalformedAssignments(t *testing.T) {
+	b := selfTestBatch(t)
+	nHashes, ctx, is, circuit := b.nHashes, b.ctx, b.is, b.circuit
+	rnd := seededReader(28)
+	malformed := func(edit func(a *keccak256Circuit) frontend.Circuit, want string) error {
+		a, err := randomAssignment(rnd, nHashes, ctx)
+		if err != nil {
+			return err
+		}
+		_, err = is.SolveInput(edit(a), 0)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			return fmt.Errorf("expected an error mentioning %q, got %v", want, err)
+		}
+		t.Logf("%v", err)
+		if _, err := is.SolveInputs([]frontend.Circuit{circuit, edit(a)}); err == nil || !strings.Contains(err.Error(), "assignment 1: "+want) {
+			return fmt.Errorf("expected a batch error mentioning %q, got %v", want, err)
+		}
+		return nil
+	}
+	last := nHashes - 1
+	for _, m := range []struct {
+		edit func(a *keccak256Circuit) frontend.Circuit
+		want string
+	}{
+		{func(a *keccak256Circuit) frontend.Circuit { a.P[last][17] = nil; return a }, fmt.Sprintf("P[%d][17] is nil", last)},
+		{func(a *keccak256Circuit) frontend.Circuit { a.Out[0][5] = (*big.Int)(nil); return a }, "Out[0][5] is nil"},
+		{func(a *keccak256Circuit) frontend.Circuit { a.P[0][0] = 2; return a }, "P[0][0] = 2 is out of range"},
+		{func(a *keccak256Circuit) frontend.Circuit { a.P[0][1] = -1; return a }, "P[0][1] = -1 is out of range"},
+		{func(a *keccak256Circuit) frontend.Circuit { a.P[0][2] = 0.5; return a }, "P[0][2] has type float64"},
+		{func(a *keccak256Circuit) frontend.Circuit { a.P[0][3] = "one"; return a }, "P[0][3] is \"one\""},
+		{func(a *keccak256Circuit) frontend.Circuit { a.P = a.P[:last]; return a }, fmt.Sprintf("P[%d][0] is missing", last)},
+		{func(a *keccak256Circuit) frontend.Circuit {
+			a.Context = make([]frontend.Variable, len(a.Context)+8)
+			return a
+		}, fmt.Sprintf("Context[%d] is not an input", len(ctx)*8)},
+		{func(a *keccak256Circuit) frontend.Circuit { return &keccakMerkleCircuit{} }, "assignment is a *keccakgf2.keccakMerkleCircuit"},
+		{func(a *keccak256Circuit) frontend.Circuit { return (*keccak256Circuit)(nil) }, "assignment is nil"},
+	} {
+		if err := malformed(m.edit, m.want); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A solver that was never loaded panics inside ecgo; the wrapper must return that as an error.
+	unloaded := newCheckedSolver((*ecgo.InputSolver)(nil), gf2.ScalarField, circuit)
+	if _, err := unloaded.SolveInput(circuit, 0); err == nil || !strings.Contains(err.Error(), "input solver failed") {
+		t.Fatal("a solver panic should come back as an error")
+	}
+}
+
+// TestTruncatedDigests checks truncated digests: a circuit checking only the first 160 digest bits must accept
+// correct prefixes, reject a flipped bit 159, and expose exactly 160 public inputs per instance.
+func TestTruncatedDigests(t *testing.T) {
+	rnd := seededReader(29)
+	tcircuit := NewTruncatedKeccak256Circuit(2, 160)
+	tcr, err := compileCircuit(gf2.ScalarField, tcircuit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tis := newCheckedSolver(tcr.GetInputSolver(), gf2.ScalarField, tcircuit)
+	tmsgs := make([][]byte, 2)
+	for k := range tmsgs {
+		tmsgs[k] = make([]byte, 64)
+		if _, err := io.ReadFull(rnd, tmsgs[k]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tassignment, err := assignMessages(tmsgs, nil, 160)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, flip := range []bool{false, true} {
+		if flip {
+			tassignment.Out[1][159] = 1 - tassignment.Out[1][159].(int)
+		}
+		if err := Preflight(tassignment); (err != nil) != flip {
+			t.Fatal("preflight gave the wrong verdict on a truncated digest")
+		}
+		if _, err := tis.SolveInput(tassignment, 0); (err != nil) != flip {
+			t.Fatalf("solving a truncated digest flipped %v: %v", flip, err)
+		}
+		// the flipped digest must also be rejected by the circuit itself
+		wit, err := tis.withoutPreflight().SolveInput(tassignment, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wit.NumPublicInputsPerWitness != 2*160 {
+			t.Fatalf("truncated circuit has %d public inputs, expected %d", wit.NumPublicInputsPerWitness, 2*160)
+		}
+		if test.CheckCircuit(tcr.GetLayeredCircuit(), wit) == flip {
+			t.Fatal("truncated digest check gave the wrong verdict")
+		}
+	}
+	fullDigests, err := assignMessages(tmsgs, nil, CheckBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tis.SolveInput(fullDigests, 0); err == nil || !strings.Contains(err.Error(), "Out[0][160] is not an input") {
+		t.Fatal("a full-digest assignment should not fit the truncated circuit")
+	}
+}
+
+// TestKeccakF1600Gadget checks the KeccakF1600 gadget: on constant inputs every gate folds, so the gadget can
+// be run directly against the recording API: a random state must permute to keccakF1600Ref's output, the zero
+// state to KeccakFZeroState, and neither the gadget nor keccakF may touch the caller's state.
+func TestKeccakF1600Gadget(t *testing.T) {
+	rnd := seededReader(30)
+	var refState [25]uint64
+	for i := range refState {
+		lane := make([]byte, 8)
+		if _, err := io.ReadFull(rnd, lane); err != nil {
+			t.Fatal(err)
+		}
+		refState[i] = binary.LittleEndian.Uint64(lane)
+	}
+	constAPI := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+	for _, e := range []struct{ in, want [25]uint64 }{{refState, keccakF1600Ref(refState)}, {[25]uint64{}, KeccakFZeroState}} {
+		in := permLanes(e.in)
+		if KeccakF1600(constAPI, in) != permLanes(e.want) || in != permLanes(e.in) {
+			t.Fatal("KeccakF1600 disagrees with the reference permutation")
+		}
+		ss := permState(in)
+		before := copyState(ss)
+		keccakF(constAPI, ss)
+		for i := range ss {
+			for j := range ss[i] {
+				if ss[i][j] != before[i][j] {
+					t.Fatal("keccakF modified the caller's state")
+				}
+			}
+		}
+	}
+	if *constAPI.stats != (GateStats{}) {
+		t.Fatal("a constant permutation should not emit gates")
+	}
+}
+
+// TestParallelDefine checks parallel Define: building 16 inlined instances traced on several goroutines must
+// give the same circuit as the sequential build (same gate counts, same fingerprint) and still prove correct
+// digests. Both build times are logged.
+func TestParallelDefine(t *testing.T) {
+	rnd := seededReader(31)
+	configuredWorkers, parallel := defineWorkers, defineWorkers
+	t.Cleanup(func() { defineWorkers = configuredWorkers })
+	if parallel < 4 {
+		parallel = 4
+	}
+	builds := map[int]*ecgo.CompileResult{}
+	buildStats := map[int]*GateStats{}
+	for _, workers := range []int{1, parallel} {
+		defineWorkers = workers
+		start := time.Now()
+		pcr, err := compileCircuit(gf2.ScalarField, NewKeccak256Circuit(16, WithInlinedKeccak()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("Define with %d worker(s) for 16 instances: %v", workers, time.Since(start))
+		if buildStats[workers], err = gateStats(gf2.ScalarField, NewKeccak256Circuit(16, WithInlinedKeccak())); err != nil {
+			t.Fatal(err)
+		}
+		builds[workers] = pcr
+	}
+	if *buildStats[1] != *buildStats[parallel] || verifier.Fingerprint(builds[1].GetLayeredCircuit().Serialize()) != verifier.Fingerprint(builds[parallel].GetLayeredCircuit().Serialize()) {
+		t.Fatal("parallel Define built a different circuit")
+	}
+	passignment, err := randomAssignment(rnd, 16, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, flip := range []bool{false, true} {
+		if flip {
+			passignment.Out[15][0] = 1 - passignment.Out[15][0].(int)
+		}
+		if err := expectVerdict(builds[parallel].GetInputSolver(), builds[parallel].GetLayeredCircuit(), passignment, !flip); err != nil {
+			t.Fatalf("parallel build: %v", err)
+		}
+	}
+}
+
+// TestBitConversions checks bit conversions: every helper of bitconv.go round-trips LSB first, and reading
+// back anything that is not a constant 0/1 fails with the position of the offending bit.
+func TestBitConversions(t *testing.T) {
+	convBytes := []byte{0x01, 0x80, 0xa5}
+	convBits := []int{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 0, 1, 0, 0, 1, 0, 1}
+	if plain, err := VariablesToBits(bitsOf(convBytes)); err != nil || fmt.Sprint(plain) != fmt.Sprint(convBits) {
+		t.Fatal("bitsOf is not LSB first")
+	}
+	if fmt.Sprint(UintBitsFromBytes(convBytes)) != fmt.Sprint(convBits) {
+		t.Fatal("UintBitsFromBytes is not LSB first")
+	}
+	if vs, err := BitsToVariables(convBits); err != nil {
+		t.Fatal(err)
+	} else if back, err := assignedBytes(vs); err != nil || !bytes.Equal(back, convBytes) {
+		t.Fatal("bits do not pack back into their bytes")
+	}
+	if _, err := BitsToVariables([]int{0, 1, 2}); err == nil || !strings.Contains(err.Error(), "bit 2") {
+		t.Fatal("BitsToVariables should reject 2")
+	}
+	for _, bad := range []frontend.Variable{nil, 2, -1, big.NewInt(2), &symbolicWire{}} {
+		if _, err := VariablesToBits([]frontend.Variable{0, 1, bad}); err == nil || !strings.Contains(err.Error(), "bit 2") {
+			t.Fatalf("VariablesToBits should reject %v", bad)
+		}
+	}
+	if _, err := assignedBytes(zeroBits(7)); err == nil {
+		t.Fatal("7 bits should not pack into bytes")
+	}
+}
+
+// TestSpongePrimitives checks the sponge primitives: on constant bits every gate folds, so the primitives can
+// be checked directly against go-ethereum and x/crypto: Pad101 at the block-boundary lengths, computeKeccak
+// (now Pad101 + Absorb + Squeeze) on random messages, multi-block absorbs, and squeezes longer than the rate.
+// computeKeccak must also build exactly the pinned gates: those of the hand-unrolled version it replaced, less
+// the XORs with constant 0 bits that xor folds, with the XORs with constant 1 bits as NOTs.
+func TestSpongePrimitives(t *testing.T) {
+	rnd := seededReader(33)
+	for _, e := range []struct {
+		msgBytes, padBytes int
+		last               byte
+	}{{0, 136, 0x80}, {134, 2, 0x80}, {135, 1, 0x81}, {136, 136, 0x80}} {
+		padded, err := assignedBytes(Pad101(bitsOf(make([]byte, e.msgBytes)), Keccak256Config.RateBits, DomainKeccak))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(padded) != e.msgBytes+e.padBytes || padded[e.msgBytes]&0x01 != 1 || padded[len(padded)-1] != e.last {
+			t.Fatalf("Pad101 of %d bytes is wrong", e.msgBytes)
+		}
+	}
+	spongeAPI := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+	for n := 0; n < 4; n++ {
+		msg := make([]byte, 64)
+		if _, err := io.ReadFull(rnd, msg); err != nil {
+			t.Fatal(err)
+		}
+		digest, err := assignedBytes(computeKeccak(spongeAPI, bitsOf(msg)))
+		if err != nil || !bytes.Equal(digest, crypto.Keccak256(msg)) {
+			t.Fatal("computeKeccak disagrees with go-ethereum")
+		}
+	}
+	for _, msgLen := range []int{0, 135, 136, 300} {
+		msg := make([]byte, msgLen)
+		if _, err := io.ReadFull(rnd, msg); err != nil {
+			t.Fatal(err)
+		}
+		padded := Pad101(bitsOf(msg), 1344, DomainSHAKE)
+		ss := NewState()
+		for blk := 0; blk < len(padded); blk += 1344 {
+			before := copyState(ss)
+			ss2 := Absorb(spongeAPI, ss, padded[blk:blk+1344])
+			if fmt.Sprint(ss) != fmt.Sprint(before) {
+				t.Fatal("Absorb modified the caller's state")
+			}
+			ss = ss2
+		}
+		want := make([]byte, 400)
+		sha3.ShakeSum128(want, msg)
+		got, err := assignedBytes(Squeeze(spongeAPI, ss, 1344, 8*len(want)))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("Pad101/Absorb/Squeeze disagree with SHAKE128 on %d bytes", msgLen)
+		}
+	}
+	if *spongeAPI.stats != (GateStats{}) {
+		t.Fatal("constant sponge inputs should not emit gates")
+	}
+	composed, err := gateStats(gf2.ScalarField, NewKeccak256Circuit(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *composed != (GateStats{Add: 151296, Sub: 38491, Mul: 38400, Assert: 256, PublicInputs: 256}) {
+		t.Fatalf("computeKeccak builds %+v, the unrolled version built Add:153536 Sub:38486 Mul:38400 Assert:256, less the 2240 XORs with constant bits that fold (5 of them into NOTs), and no booleanity assertions over GF(2)", *composed)
+	}
+}
+
+// TestIncrementalSponge checks the incremental sponge: a 300-byte message absorbed in three uneven chunks (one
+// straddling the block boundary) must give the single-shot digest, and squeezing SHAKE128 output in pieces
+// across rate boundaries must match x/crypto.
+func TestIncrementalSponge(t *testing.T) {
+	rnd := seededReader(34)
+	spongeAPI := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+	chunked := make([]byte, 300)
+	if _, err := io.ReadFull(rnd, chunked); err != nil {
+		t.Fatal(err)
+	}
+	incremental := NewSponge(Keccak256Config.RateBits, DomainKeccak)
+	for _, part := range [][]byte{chunked[:7], chunked[7:207], chunked[207:]} {
+		incremental.Absorb(spongeAPI, bitsOf(part))
+	}
+	oneShot, err := assignedBytes(keccakSponge(spongeAPI, bitsOf(chunked), Keccak256Config.RateBits, DomainKeccak, 256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := assignedBytes(incremental.Squeeze(spongeAPI, 256)); err != nil || !bytes.Equal(got, oneShot) || !bytes.Equal(got, crypto.Keccak256(chunked)) {
+		t.Fatal("chunked absorb disagrees with the single-shot digest")
+	}
+	shake := NewSponge(1344, DomainSHAKE)
+	shake.Absorb(spongeAPI, bitsOf(chunked[:168]))
+	shake.Absorb(spongeAPI, nil)
+	var squeezed []frontend.Variable
+	for _, n := range []int{8, 1336, 8, 2000} {
+		squeezed = append(squeezed, shake.Squeeze(spongeAPI, n)...)
+	}
+	wantShake := make([]byte, len(squeezed)/8)
+	sha3.ShakeSum128(wantShake, chunked[:168])
+	if got, err := assignedBytes(squeezed); err != nil || !bytes.Equal(got, wantShake) {
+		t.Fatal("piecewise squeeze disagrees with SHAKE128")
+	}
+}
+
+// TestExactSqueezeLengths checks exact squeeze lengths: copyOutUnaligned must return exactly 8*outputLen bits,
+// cutting the last lane, and they must be the first outputLen bytes of the sponge output (SHAKE256 has
+// Keccak-256's rate).
+func TestExactSqueezeLengths(t *testing.T) {
+	rnd := seededReader(35)
+	spongeAPI := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+	shakeMsg := make([]byte, 64)
+	if _, err := io.ReadFull(rnd, shakeMsg); err != nil {
+		t.Fatal(err)
+	}
+	shakeState := Absorb(spongeAPI, NewState(), Pad101(bitsOf(shakeMsg), Shake256Rate, DomainSHAKE))
+	shakeOut := make([]byte, 136)
+	sha3.ShakeSum256(shakeOut, shakeMsg)
+	for _, outputLen := range []int{20, 28, 32, 48, 136} {
+		outBits := copyOutUnaligned(spongeAPI, shakeState, Shake256Rate, outputLen)
+		if len(outBits) != 8*outputLen {
+			t.Fatalf("copyOutUnaligned returned %d bits for %d bytes", len(outBits), outputLen)
+		}
+		if got, err := assignedBytes(outBits); err != nil || !bytes.Equal(got, shakeOut[:outputLen]) {
+			t.Fatalf("copyOutUnaligned read the wrong %d bytes", outputLen)
+		}
+	}
+}
+
+// TestByteGroupedDigest checks the byte-grouped digest: ComputeKeccakBytes must give crypto.Keccak256Hash byte
+// for byte. The message is picked so that the hash is not a palindrome and has a byte whose bit reversal
+// differs, so reversed bytes or bits cannot pass.
+func TestByteGroupedDigest(t *testing.T) {
+	rnd := seededReader(36)
+	spongeAPI := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+	var asymMsg []byte
+	var asymHash [32]byte
+	for asymHash == ([32]byte{}) {
+		asymMsg = make([]byte, 64)
+		if _, err := io.ReadFull(rnd, asymMsg); err != nil {
+			t.Fatal(err)
+		}
+		h := crypto.Keccak256Hash(asymMsg)
+		if h[0] != h[31] && h[0] != bits.Reverse8(h[0]) {
+			asymHash = h
+		}
+	}
+	byteDigest := ComputeKeccakBytes(spongeAPI, bitsOf(asymMsg))
+	for i := range byteDigest {
+		got, err := assignedBytes(byteDigest[i][:])
+		if err != nil || got[0] != asymHash[i] {
+			t.Fatalf("digest byte %d is %x, want %02x", i, got, asymHash[i])
+		}
+	}
+	flatDigest := BytesToDigestBits(byteDigest[:])
+	if got, err := assignedBytes(flatDigest); err != nil || !bytes.Equal(got, asymHash[:]) {
+		t.Fatal("BytesToDigestBits is not the circuit.Out layout")
+	}
+	for i, b := range DigestBitsToBytes(bitsOf(asymHash[:])) {
+		if b != byteDigest[i] {
+			t.Fatal("DigestBitsToBytes does not invert BytesToDigestBits")
+		}
+	}
+}
+
+// TestDigestTruncation checks DigestPrefixBits and DigestSuffixBits against byte slices of a reference
+// digest for every nBytes from 1 to 32, and that they panic for 0, 33, a negative count and a digest
+// that is not whole bytes.
+func TestDigestTruncation(t *testing.T) {
+	msg := make([]byte, 64)
+	if _, err := io.ReadFull(seededReader(970), msg); err != nil {
+		t.Fatal(err)
+	}
+	hash := crypto.Keccak256(msg)
+	digest := bitsOf(hash)
+	for n := 1; n <= 32; n++ {
+		prefix, suffix := DigestPrefixBits(digest, n), DigestSuffixBits(digest, n)
+		if got, err := assignedBytes(prefix); err != nil || !bytes.Equal(got, hash[:n]) {
+			t.Fatalf("DigestPrefixBits(digest, %d) is %x, want %x", n, got, hash[:n])
+		}
+		if got, err := assignedBytes(suffix); err != nil || !bytes.Equal(got, hash[32-n:]) {
+			t.Fatalf("DigestSuffixBits(digest, %d) is %x, want %x", n, got, hash[32-n:])
+		}
+		if &prefix[0] != &digest[0] || &suffix[len(suffix)-1] != &digest[255] {
+			t.Fatalf("the %d-byte truncations are not views of the digest", n)
+		}
+	}
+	for _, e := range []struct {
+		bits   int
+		nBytes int
+	}{{256, 0}, {256, 33}, {256, -1}, {255, 1}} {
+		for _, truncate := range []func([]frontend.Variable, int) []frontend.Variable{DigestPrefixBits, DigestSuffixBits} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("truncating a %d-bit digest to %d bytes does not panic", e.bits, e.nBytes)
+					}
+				}()
+				truncate(digest[:e.bits], e.nBytes)
+			}()
+		}
+	}
+}
+
+// TestAnonymizedFailures checks anonymized failing witnesses: a digest mismatch and a length mismatch must
+// survive anonymization: the artifact, after a JSON round trip, rebuilds an assignment with none of the
+// original messages that fails in the same way. A non-boolean input keeps its position and value, and a value-
+// dependent case is redacted.
+func TestAnonymizedFailures(t *testing.T) {
+	b := selfTestBatch(t)
+	nHashes, ctx, c, is := b.nHashes, b.ctx, b.c, b.is
+	rnd := seededReader(37)
+	failing, err := randomAssignment(rnd, nHashes, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	private := newWitnessCase(failing).Messages
+	roundTrip := func(ac *anonymizedCase) (*anonymizedCase, error) {
+		raw, err := json.Marshal(ac)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range private {
+			if strings.Contains(string(raw), m) {
+				return nil, fmt.Errorf("anonymized artifact contains a private message")
+			}
+		}
+		back := &anonymizedCase{}
+		if err := json.Unmarshal(raw, back); err != nil {
+			return nil, err
+		}
+		return back, nil
+	}
+	failing.Out[nHashes-1][3] = 1 - failing.Out[nHashes-1][3].(int)
+	failing.Out[nHashes-1][200] = 1 - failing.Out[nHashes-1][200].(int)
+	ac, err := AnonymizeFailure(failing, seededReader(1007))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ac, err = roundTrip(ac); err != nil {
+		t.Fatal(err)
+	}
+	reproduced, err := ac.Case.assignment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if class, _ := classifyFailure(reproduced); ac.Class != failureDigest || class != failureDigest || ac.Redacted {
+		t.Fatalf("digest mismatch anonymized as %s, reproduced as %s", ac.Class, class)
+	}
+	if diff, err := digestDiff(reproduced, nHashes-1); err != nil || fmt.Sprint(diff) != "[3 200]" || Preflight(reproduced) == nil || reproduced.P[0] == failing.P[0] {
+		t.Fatalf("anonymized digest mismatch does not flip the same bits of new messages (%v)", err)
+	}
+	if err := expectVerdict(is, c, reproduced, false); err != nil {
+		t.Fatalf("anonymized digest mismatch: %v", err)
+	}
+	for _, i := range []int{3, 200} {
+		reproduced.Out[nHashes-1][i] = 1 - reproduced.Out[nHashes-1][i].(int)
+	}
+	if err := expectVerdict(is, c, reproduced, true); err != nil {
+		t.Fatalf("anonymized digest mismatch fails for another reason: %v", err)
+	}
+
+	failing.Out[nHashes-1][3] = 1 - failing.Out[nHashes-1][3].(int)
+	failing.Out[nHashes-1][200] = 1 - failing.Out[nHashes-1][200].(int)
+	fullOut := failing.Out[0]
+	failing.Out[0] = fullOut[:255]
+	ac, err = AnonymizeFailure(failing, seededReader(1007))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ac, err = roundTrip(ac); err != nil {
+		t.Fatal(err)
+	}
+	if reproduced, err = ac.Case.assignment(); err != nil {
+		t.Fatal(err)
+	}
+	class, failures := classifyFailure(reproduced)
+	if ac.Class != failureLength || class != failureLength || fmt.Sprint(failures) != fmt.Sprint(ac.Failures) || len(reproduced.Out[0]) != 255 {
+		t.Fatalf("length mismatch anonymized as %s %v, reproduced as %s %v", ac.Class, ac.Failures, class, failures)
+	}
+	if _, err := is.SolveInput(reproduced, 0); err == nil || !strings.Contains(err.Error(), "Out[0][255] is missing") {
+		t.Fatalf("anonymized length mismatch solved: %v", err)
+	}
+
+	failing.Out[0] = fullOut
+	failing.P[2][5] = 2
+	if ac, err = AnonymizeFailure(failing, seededReader(1007)); err != nil {
+		t.Fatal(err)
+	}
+	if ac, err = roundTrip(ac); err != nil {
+		t.Fatal(err)
+	}
+	if reproduced, err = ac.Case.assignment(); err != nil {
+		t.Fatal(err)
+	}
+	if class, _ := classifyFailure(reproduced); ac.Class != failureNonBoolean || class != failureNonBoolean || reproduced.P[2][5] != 2 {
+		t.Fatalf("non-boolean input anonymized as %s", ac.Class)
+	}
+	failing.P[2][5] = 0
+	msg2, err := assignedBytes(failing.P[2][:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(failing.Out[2], bitsOf(crypto.Keccak256(ctx, msg2))[:CheckBits])
+	if ac, err = AnonymizeFailure(failing, seededReader(1007)); err != nil {
+		t.Fatal(err)
+	}
+	if ac.Class != failureValueDependent || !ac.Redacted || len(ac.Case.Messages) != 0 || ac.Case.Out[1] != bitString(failing.Out[1]) {
+		t.Fatal("value-dependent case is not redacted to its public inputs")
+	}
+	if ac, err = roundTrip(ac); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ac.Case.assignment(); err == nil {
+		t.Fatal("a redacted case rebuilt an assignment")
+	}
+}
+
+// TestDigestDiffContext checks that a context that is not whole bytes is reported, not a panic: digestDiff
+// returns an error, classifyFailure calls it a length failure, and AnonymizeFailure still anonymizes it.
+func TestDigestDiffContext(t *testing.T) {
+	a, err := randomAssignment(seededReader(1007), 2, []byte("ctx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Context = a.Context[:21]
+	if _, err := digestDiff(a, 0); err == nil || !strings.Contains(err.Error(), "not a whole number of bytes") {
+		t.Fatalf("digestDiff on a 21-bit context: %v", err)
+	}
+	if class, failures := classifyFailure(a); class != failureLength || len(failures) != 1 {
+		t.Fatalf("21-bit context classified as %s %v", class, failures)
+	}
+	ac, err := AnonymizeFailure(a, seededReader(1008))
+	if err != nil || ac.Class != failureLength || ac.ContextBytes != 2 {
+		t.Fatalf("21-bit context anonymized as %+v (%v)", ac, err)
+	}
+}
+
+// TestDistinctDigests checks distinct digests: with Distinct set, distinct messages must still pass while two
+// equal messages (equal digests) fail, with the sequential and the parallel Define alike; the plain circuit
+// accepts both. The pairwise check costs one assertion per pair, and more than distinctWarnInstances instances
+// log a warning.
+func TestDistinctDigests(t *testing.T) {
+	rnd := seededReader(38)
+	dn := 3
+	plain, err := gateStats(gf2.ScalarField, NewKeccak256Circuit(dn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dcircuit := NewKeccak256Circuit(dn)
+	dcircuit.Distinct = true
+	distinct, err := gateStats(gf2.ScalarField, dcircuit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if distinct.Assert != plain.Assert+dn*(dn-1)/2 {
+		t.Fatalf("distinctness of %d digests costs %d assertions", dn, distinct.Assert-plain.Assert)
+	}
+	unique, err := randomAssignment(rnd, dn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	duplicate, err := randomAssignment(rnd, dn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	duplicate.P[2], duplicate.Out[2] = duplicate.P[0], duplicate.Out[0]
+	if err := Preflight(duplicate); err != nil {
+		t.Fatal(err)
+	}
+	sequential := defineWorkers
+	t.Cleanup(func() { defineWorkers = sequential })
+	for _, workers := range []int{1, 2} {
+		defineWorkers = workers
+		dcircuit := NewKeccak256Circuit(dn)
+		for _, on := range []bool{false, true} {
+			dcircuit.Distinct = on
+			dcr, err := compileCircuit(gf2.ScalarField, dcircuit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dis := newCheckedSolver(dcr.GetInputSolver(), gf2.ScalarField, dcircuit)
+			for _, a := range []*keccak256Circuit{unique, duplicate} {
+				if err := expectVerdict(dis, dcr.GetLayeredCircuit(), a, !on || a == unique); err != nil {
+					t.Fatalf("distinct=%v, %d workers: %v", on, workers, err)
+				}
+			}
+		}
+	}
+	var warning bytes.Buffer
+	w, level := logger.w, logger.level
+	t.Cleanup(func() { logger.w, logger.level = w, level })
+	logger.w, logger.level = &warning, levelInfo
+	wide := NewKeccak256Circuit(distinctWarnInstances + 1)
+	wide.Distinct = true
+	if _, err := gateStats(gf2.ScalarField, wide); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(warning.String(), fmt.Sprintf("%d digest comparisons", (distinctWarnInstances+1)*distinctWarnInstances/2)) {
+		t.Fatal("no warning for a large distinctness check")
+	}
+}
+
+// TestFillAssignment checks FillAssignment: assignments filled from plain messages (under the run's context)
+// must pass the checker, alone and as a batch; a wrong-length message or a wrong message count is rejected
+// before solving, leaving the assignment untouched.
+func TestFillAssignment(t *testing.T) {
+	b := selfTestBatch(t)
+	nHashes, ctx, c, is := b.nHashes, b.ctx, b.c, b.is
+	rnd := seededReader(39)
+	fillBatch := make([]frontend.Circuit, 2)
+	for z := range fillBatch {
+		msgs := make([][]byte, nHashes)
+		for k := range msgs {
+			msgs[k] = make([]byte, 64)
+			if _, err := io.ReadFull(rnd, msgs[k]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		filled := newKeccak256Circuit(nHashes, len(ctx))
+		copy(filled.Context, bitsOf(ctx))
+		if err := FillAssignment(filled, msgs); err != nil {
+			t.Fatal(err)
+		}
+		if err := Preflight(filled); err != nil {
+			t.Fatal(err)
+		}
+		fillBatch[z] = filled
+	}
+	if err := expectVerdict(is, c, fillBatch[0], true); err != nil {
+		t.Fatalf("filled assignment: %v", err)
+	}
+	fillEnv, err := solveBatch(is, fillBatch, []int{0, 1})
+	if err != nil {
+		t.Fatalf("solving: %v", err)
+	}
+	if err := expectBatch(c, fillEnv); err != nil {
+		t.Fatalf("filled batch: %v", err)
+	}
+	unfilled := newKeccak256Circuit(nHashes, len(ctx))
+	copy(unfilled.Context, bitsOf(ctx))
+	short := make([][]byte, nHashes)
+	for k := range short {
+		short[k] = make([]byte, 64)
+	}
+	short[nHashes-1] = short[nHashes-1][:63]
+	if err := FillAssignment(unfilled, short); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("message %d is 63 bytes, expected 64", nHashes-1)) {
+		t.Fatalf("short message: %v", err)
+	}
+	if err := FillAssignment(unfilled, short[:nHashes-1]); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("%d messages for %d instances", nHashes-1, nHashes)) {
+		t.Fatalf("missing message: %v", err)
+	}
+	if unfilled.P[0][0] != nil || unfilled.Out[0][0] != nil {
+		t.Fatal("a rejected FillAssignment wrote to the assignment")
+	}
+}
+
+// TestInstanceBundles checks per-instance bundles: instance 3 of an assignment in the shared batch must be
+// extractable from witness.env into a bundle that, after a round trip through instance.json in the test's
+// temporary directory (never the working directory), verifies against the single-instance circuit, and its
+// witness must be the one solving that instance on its own. The bundle must not verify against another
+// circuit or with a digest that is not its own.
+func TestInstanceBundles(t *testing.T) {
+	b := selfTestBatch(t)
+	nHashes, ctx, c, assignments, venv, fingerprint := b.nHashes, b.ctx, b.c, b.assignments, b.venv, b.fingerprint
+	bk := 3
+	if bk >= nHashes {
+		bk = nHashes - 1
+	}
+	icr, err := compileCircuit(gf2.ScalarField, newKeccak256Circuit(1, len(ctx)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	singleFingerprint := verifier.Fingerprint(icr.GetLayeredCircuit().Serialize())
+	bundle, err := verifier.ExtractInstance(venv, digestLayout(nHashes, len(ctx)), 5, bk, singleFingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundlePath := filepath.Join(t.TempDir(), "instance.json")
+	if err := bundle.WriteFile(bundlePath); err != nil {
+		t.Fatal(err)
+	}
+	if bundle, err = verifier.LoadInstanceBundle(bundlePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := bundle.Verify(icr.GetLayeredCircuit(), singleFingerprint); err != nil {
+		t.Fatal(err)
+	}
+	source := assignments[5].(*keccak256Circuit)
+	msg, err := assignedBytes(source.P[bk][:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	single := newKeccak256Circuit(1, len(ctx))
+	copy(single.Context, bitsOf(ctx))
+	if err := FillAssignment(single, [][]byte{msg}); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(single.Out[0]) != fmt.Sprint(source.Out[bk]) || fmt.Sprint(bundle.Digest) != fmt.Sprint(source.Out[bk]) || string(bundle.Context) != string(ctx) {
+		t.Fatal("bundle digest is not the digest of the instance")
+	}
+	wit, err := newCheckedSolver(icr.GetInputSolver(), gf2.ScalarField, newKeccak256Circuit(1, len(ctx))).SolveInput(single, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	benv, err := verifier.ParseEnvelope(bundle.Witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(benv.Witness.Values) != fmt.Sprint(wit.Values) {
+		t.Fatal("bundle witness differs from the single-instance witness")
+	}
+	if err := bundle.Verify(c, fingerprint); err == nil {
+		t.Fatal("bundle verified against the batch circuit")
+	}
+	bundle.Digest[0] = 1 - bundle.Digest[0]
+	if err := bundle.Verify(icr.GetLayeredCircuit(), singleFingerprint); err == nil || !strings.Contains(err.Error(), "digest does not match") {
+		t.Fatalf("tampered bundle digest: %v", err)
+	}
+}
+
+// TestKeccakConfig checks KeccakConfig: KeccakWithConfig(Keccak256Config) must be computeKeccak gate for gate
+// and bit for bit; Keccak512Config must match the legacy Keccak-512, and a reduced-round config the off-
+// circuit Keccak-p reference. Nonsensical configs are rejected.
+func TestKeccakConfig(t *testing.T) {
+	rnd := seededReader(41)
+	configAPI := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+	symbolic := make([]frontend.Variable, 64*8)
+	for i := range symbolic {
+		symbolic[i] = &symbolicWire{}
+	}
+	fixed, configured := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}, &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+	computeKeccak(fixed, symbolic)
+	KeccakWithConfig(configured, symbolic, Keccak256Config)
+	if *fixed.stats != *configured.stats {
+		t.Fatalf("Keccak256Config costs %+v, computeKeccak %+v", *configured.stats, *fixed.stats)
+	}
+	for _, msgLen := range []int{0, 64, 71, 72, 200} {
+		msg := make([]byte, msgLen)
+		if _, err := io.ReadFull(rnd, msg); err != nil {
+			t.Fatal(err)
+		}
+		digest, err := assignedBytes(KeccakWithConfig(configAPI, bitsOf(msg), Keccak256Config))
+		if err != nil || !bytes.Equal(digest, crypto.Keccak256(msg)) {
+			t.Fatalf("Keccak256Config disagrees with go-ethereum on %d bytes", msgLen)
+		}
+		if msgLen == 64 {
+			if fixedDigest, err := assignedBytes(computeKeccak(configAPI, bitsOf(msg))); err != nil || !bytes.Equal(fixedDigest, digest) {
+				t.Fatal("Keccak256Config disagrees with computeKeccak")
+			}
+		}
+		h := sha3.NewLegacyKeccak512()
+		h.Write(msg)
+		if digest, err := assignedBytes(KeccakWithConfig(configAPI, bitsOf(msg), Keccak512Config)); err != nil || !bytes.Equal(digest, h.Sum(nil)) {
+			t.Fatalf("Keccak512Config disagrees with x/crypto on %d bytes", msgLen)
+		}
+	}
+	reduced := KeccakConfig{RateBits: 1088, OutputBits: 256, Rounds: 12, DomainSep: DomainKeccak}
+	reducedMsg := make([]byte, 64)
+	if _, err := io.ReadFull(rnd, reducedMsg); err != nil {
+		t.Fatal(err)
+	}
+	block, err := assignedBytes(Pad101(bitsOf(reducedMsg), reduced.RateBits, reduced.DomainSep))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lanes [25]uint64
+	for i := 0; i < len(block)/8; i++ {
+		lanes[i] = binary.LittleEndian.Uint64(block[8*i:])
+	}
+	lanes = keccakP1600Ref(lanes, reduced.Rounds)
+	var reducedWant []byte
+	for i := 0; i < 4; i++ {
+		reducedWant = binary.LittleEndian.AppendUint64(reducedWant, lanes[i])
+	}
+	if digest, err := assignedBytes(KeccakWithConfig(configAPI, bitsOf(reducedMsg), reduced)); err != nil || !bytes.Equal(digest, reducedWant) {
+		t.Fatal("12-round Keccak disagrees with the reference")
+	}
+	if bytes.Equal(reducedWant, crypto.Keccak256(reducedMsg)) {
+		t.Fatal("12-round Keccak equals Keccak-256")
+	}
+	for _, bad := range []KeccakConfig{
+		{RateBits: 1000, OutputBits: 256, Rounds: 24, DomainSep: DomainKeccak},
+		{RateBits: 1600, OutputBits: 256, Rounds: 24, DomainSep: DomainKeccak},
+		{RateBits: 1088, OutputBits: 0, Rounds: 24, DomainSep: DomainKeccak},
+		{RateBits: 1088, OutputBits: 256, Rounds: 0, DomainSep: DomainKeccak},
+		{RateBits: 1088, OutputBits: 256, Rounds: 25, DomainSep: DomainKeccak},
+		{RateBits: 1088, OutputBits: 256, Rounds: 24, DomainSep: 0},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Fatalf("config %+v accepted", bad)
+		}
+	}
+	if err := Keccak256Config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCircuitKeccak256 checks CircuitKeccak256 against go-ethereum: the gadget-evaluated hash must agree with
+// crypto.Keccak256 on thousands of random messages of every length up to two blocks, including the 64-byte
+// computeKeccak path; a divergence names the message.
+func TestCircuitKeccak256(t *testing.T) {
+	rnd := seededReader(42)
+	lengths := seededReader(1010)
+	for n := 0; n < 2000; n++ {
+		var l [1]byte
+		if _, err := io.ReadFull(lengths, l[:]); err != nil {
+			t.Fatal(err)
+		}
+		msgLen := int(l[0]) + n%2*17
+		if n%5 == 0 {
+			msgLen = 64
+		}
+		msg := make([]byte, msgLen)
+		if _, err := io.ReadFull(rnd, msg); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := CircuitKeccak256(msg), crypto.Keccak256Hash(msg); got != want {
+			t.Fatalf("CircuitKeccak256(%x) = %x, go-ethereum gives %x", msg, got, want)
+		}
+	}
+}
+
+// TestVerdictFiles checks verdict files: checking a batch with one corrupted assignment must report it in
+// the verdict (15 of 16 passed, the corrupted caller index failed, status ExitFailed); the intact batch
+// passes and a missing envelope is an ExitError. The exit status of -check itself is tested in cmd/keccak_gf2.
+func TestVerdictFiles(t *testing.T) {
+	b := selfTestBatch(t)
+	venv, fingerprint := b.venv, b.fingerprint
+	dir := t.TempDir()
+	corrupt := &verifier.Envelope{Indices: venv.Indices, Witness: &irwg.Witness{}}
+	*corrupt.Witness = *venv.Witness
+	corrupt.Witness.Values = append([]*big.Int{}, venv.Witness.Values...)
+	perAssignment := venv.Witness.NumInputsPerWitness + venv.Witness.NumPublicInputsPerWitness
+	flipped := 4*perAssignment + venv.Witness.NumInputsPerWitness
+	corrupt.Witness.Values[flipped] = new(big.Int).Sub(big.NewInt(1), corrupt.Witness.Values[flipped])
+	corruptPath := filepath.Join(dir, "corrupt.env")
+	if err := os.WriteFile(corruptPath, corrupt.Serialize(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	circuitPath := filepath.Join(b.dir, "circuit.txt")
+	for _, e := range []struct {
+		envelope string
+		code     int
+		failed   []int
+	}{{filepath.Join(b.dir, "witness.env"), verifier.ExitPassed, []int{}}, {corruptPath, verifier.ExitFailed, []int{venv.Indices[4]}}, {filepath.Join(dir, "missing.env"), verifier.ExitError, []int{}}} {
+		verdictPath := filepath.Join(dir, "verdict.json")
+		if err := verifier.CheckFiles(circuitPath, e.envelope).WriteFile(verdictPath); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := os.ReadFile(verdictPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var verdict verifier.Verdict
+		if err := json.Unmarshal(raw, &verdict); err != nil {
+			t.Fatal(err)
+		}
+		if verdict.ExitCode() != e.code || fmt.Sprint(verdict.Failed) != fmt.Sprint(e.failed) || verdict.Version != verifier.VerdictVersion || verdict.Envelope.Path != e.envelope {
+			t.Fatalf("%s: verdict %s", e.envelope, raw)
+		}
+		if e.code == verifier.ExitError {
+			if verdict.Error == "" || verdict.Total != 0 {
+				t.Fatalf("%s: verdict %s", e.envelope, raw)
+			}
+			continue
+		}
+		if verdict.Circuit != fingerprint || verdict.Total != len(venv.Indices) || verdict.Passed != verdict.Total-len(e.failed) || verdict.Error != "" ||
+			verdict.Envelope.Assignments != len(venv.Indices) || verdict.Envelope.Inputs != venv.Witness.NumInputsPerWitness ||
+			verdict.Envelope.PublicInputs != venv.Witness.NumPublicInputsPerWitness || verdict.WallTimeMs < 0 {
+			t.Fatalf("%s: verdict %s", e.envelope, raw)
+		}
+		for _, field := range []string{`"version"`, `"circuit"`, `"envelope"`, `"total"`, `"passed"`, `"failed"`, `"wall_time_ms"`, `"inputs_per_assignment"`, `"public_inputs_per_assignment"`} {
+			if !strings.Contains(string(raw), field) {
+				t.Fatalf("verdict schema lost %s", field)
+			}
+		}
+	}
+}
+
+// TestFailureReporting checks failure reporting: a rejected assignment must come back as an error naming the
+// phase and the mismatching instance. That a failing run prints its error and exits non-zero is tested in
+// cmd/keccak_gf2.
+func TestFailureReporting(t *testing.T) {
+	b := selfTestBatch(t)
+	nHashes, ctx, c, is := b.nHashes, b.ctx, b.c, b.is
+	rnd := seededReader(44)
+	bad, err := randomAssignment(rnd, nHashes, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad.Out[nHashes-1][7] = 1 - bad.Out[nHashes-1][7].(int)
+	err = expectVerdict(is, c, bad, true)
+	if err == nil || !strings.Contains(err.Error(), "checking: the circuit rejects the assignment: preflight: 1 digest(s)") ||
+		!strings.Contains(err.Error(), fmt.Sprintf("instance %d: expected", nHashes-1)) {
+		t.Fatalf("expected a rejection naming instance %d, got %v", nHashes-1, err)
+	}
+	if err := expectVerdict(is, c, bad, false); err != nil {
+		t.Fatal(err)
+	}
+	bad.P[0][0] = 2
+	if err := expectVerdict(is, c, bad, false); err == nil || !strings.HasPrefix(err.Error(), "solving: ") || !strings.Contains(err.Error(), "P[0][0] = 2 is out of range") {
+		t.Fatalf("expected a solving error, got %v", err)
+	}
+}
+
+// TestGadgetCalls checks the gadget benchmarks: on a symbolic state every round makes the same calls but for
+// the ι flips (one Sub per set bit of its round constant), so keccakF makes 24 times the Adds and Muls of one
+// round, and xorIn one Add per rate bit; none of the benchmarked gadgets may emit a constant gate, and a
+// benchmark run must report the counted calls as its per-op metric and Sha256 its linear/nonlinear split.
+// A sponge gadget builds the AND gates of its Perms keccakF, and Shake128Squeeze reports its two.
+func TestGadgetCalls(t *testing.T) {
+	calls := map[string]*GateStats{}
+	for _, g := range gadgetBenchmarks() {
+		calls[g.Name] = gadgetCalls(g)
+		if calls[g.Name].ConstGates != 0 {
+			t.Fatalf("%s emits %d constant gates", g.Name, calls[g.Name].ConstGates)
+		}
+		t.Logf("%s makes %d api calls", g.Name, calls[g.Name].calls())
+	}
+	iotaFlips := 0
+	for _, rc := range roundConstants {
+		iotaFlips += bits.OnesCount64(rc)
+	}
+	round, f := calls["keccakRound"], calls["keccakF"]
+	if f.Add != 24*round.Add || f.Mul != 24*round.Mul || f.Sub != 24*(round.Sub-bits.OnesCount64(roundConstants[23]))+iotaFlips {
+		t.Fatalf("keccakF makes %+v calls, one round %+v", *f, *round)
+	}
+	if x := calls["xorIn"]; x.Add != Keccak256Config.RateBits || x.calls() != Keccak256Config.RateBits {
+		t.Fatalf("xorIn makes %+v calls, expected one Add per rate bit", *x)
+	}
+	if calls["computeKeccak"].calls() > calls["keccakF"].calls()+calls["xorIn"].calls() {
+		t.Fatal("computeKeccak makes more calls than one absorbed block and keccakF")
+	}
+	r := testing.Benchmark(gadgetBenchmarks()[0].benchmark)
+	if rate := float64(Keccak256Config.RateBits); r.N == 0 || r.Extra["api-calls/op"] != rate || r.Extra["add/op"] != rate {
+		t.Fatalf("xorIn benchmark reported %v", r.Extra)
+	}
+	for _, g := range gadgetBenchmarks() {
+		if g.Perms > 0 && calls[g.Name].Mul != g.Perms*f.Mul {
+			t.Fatalf("%s builds %d AND gates, expected those of %d keccakF", g.Name, calls[g.Name].Mul, g.Perms)
+		}
+		switch g.Name {
+		case "Sha256":
+			// the split TestSha256 pins: 154572 XOR and 4298 NOT, 38444 AND
+			if r := testing.Benchmark(g.benchmark); r.Extra["linear/op"] != 158870 || r.Extra["nonlinear/op"] != 38444 {
+				t.Fatalf("Sha256 benchmark reported %v", r.Extra)
+			}
+		case "Shake128Squeeze":
+			if r := testing.Benchmark(g.benchmark); g.Perms != 2 || r.Extra["permutations/op"] != 2 {
+				t.Fatalf("Shake128Squeeze benchmark reported %v", r.Extra)
+			}
+		}
+	}
+}
+
+// TestLogLevels checks the level filter of the logger as Configure sets it from -q, the default and -v:
+// nothing, the progress lines, and the progress and diagnostic lines.
+func TestLogLevels(t *testing.T) {
+	w, level := logger.w, logger.level
+	t.Cleanup(func() { logger.w, logger.level = w, level })
+	for _, e := range []struct {
+		verbose, quiet bool
+		want           string
+	}{{false, true, ""}, {false, false, "info 1\n"}, {true, false, "info 1\ndebug 2\n"}} {
+		o := DefaultOptions()
+		o.Verbose, o.Quiet = e.verbose, e.quiet
+		Configure(o)
+		var out strings.Builder
+		logger.w = &out
+		Infof("info %d", 1)
+		logger.Debugf("debug %d", 2)
+		if out.String() != e.want {
+			t.Errorf("verbose %v, quiet %v: logged %q, want %q", e.verbose, e.quiet, out.String(), e.want)
+		}
+	}
+}
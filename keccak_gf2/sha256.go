@@ -0,0 +1,385 @@
+package keccakgf2
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// SHA-256:
+// FIPS 180-4 SHA-256 as a boolean circuit over GF(2), in the style of the Keccak gadgets: messages and
+// digests are bits LSB first within each byte, every XOR is an Add, every NOT a Sub and every AND a Mul,
+// and constant bits are folded instead of emitting gates (xorBit and andBit of keccak.go). Inside the
+// compression function a 32-bit word is a sha256Word, 32 wires with bit j of weight 2^j; the big-endian
+// load of the message words and the store of the digest words are wire permutations. ROTR and SHR are wire
+// permutations too (SHR shifts in constant zeros), so σ0, σ1, Σ0 and Σ1 are two XORs per bit, fewer where
+// a zero is shifted in. Ch and Maj are AND/XOR networks of one AND per bit: Ch(e, f, g) = g ⊕ e∧(f ⊕ g)
+// and Maj(a, b, c) = c ⊕ (a ⊕ c)∧(b ⊕ c). Every addition mod 2^32 is a ripple-carry adder: bit j is
+// a ⊕ b ⊕ c_j and the carry c_{j+1} = Maj(a_j, b_j, c_j), 4 XOR and 1 AND per bit, 123 XOR and 31 AND
+// per word (no carry into bit 0, none out of bit 31).
+//
+// The adders dominate: a compression over non-constant state and block makes 600 additions (3 per
+// scheduled word, 7 per round, 8 for the feed-forward), and they account for 18477 of its 22573 AND gates
+// and 71605 of its 95557 linear gates (93666 XOR, 1891 NOT from the constant bits of K). Sha256 of a
+// 64-byte message compresses two blocks, the first from the constant IV and the second entirely constant
+// but for the chaining value; it costs 38444 AND (30380 in the adders) and 158870 linear gates (154572
+// XOR, 4298 NOT), against 38400 AND and 189787 linear gates for computeKeccak. Longer messages add one
+// compression per 64-byte block, the blocks of message bits between the first and the padding going
+// through one memoized sub-circuit (sha256SharedCompress). sha256Circuit proves a batch of SHA-256
+// digests of messages of one compile-time length. bitSha256Round and bitSha256Schedule (bitref.go) are
+// the plain-Go reference the gadget is checked against round by round.
+
+// sha256Word is a 32-bit word of the compression function, bit j (weight 2^j) at index j.
+type sha256Word [32]frontend.Variable
+
+// sha256IV is the initial hash value H(0) of FIPS 180-4 section 5.3.3.
+var sha256IV = [8]uint32{
+	0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a, 0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
+}
+
+// sha256K holds the round constants K0..K63 of FIPS 180-4 section 4.2.2.
+var sha256K = [64]uint32{
+	0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1, 0x923f82a4, 0xab1c5ed5,
+	0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3, 0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174,
+	0xe49b69c1, 0xefbe4786, 0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+	0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147, 0x06ca6351, 0x14292967,
+	0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13, 0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85,
+	0xa2bfe8a1, 0xa81a664b, 0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+	0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a, 0x5b9cca4f, 0x682e6ff3,
+	0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208, 0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2,
+}
+
+// sha256Const is the constant word x.
+func sha256Const(x uint32) sha256Word {
+	var w sha256Word
+	for j := range w {
+		w[j] = int(x>>j) & 1
+	}
+	return w
+}
+
+// sha256Rotr is ROTR^n(x): wiring only.
+func sha256Rotr(x sha256Word, n int) sha256Word {
+	var r sha256Word
+	for j := range r {
+		r[j] = x[(j+n)%32]
+	}
+	return r
+}
+
+// sha256Shr is SHR^n(x): wiring only, the top n bits are constant zeros.
+func sha256Shr(x sha256Word, n int) sha256Word {
+	var r sha256Word
+	for j := range r {
+		if j+n < 32 {
+			r[j] = x[j+n]
+		} else {
+			r[j] = 0
+		}
+	}
+	return r
+}
+
+// sha256Xor3 is a ⊕ b ⊕ c, 2 XOR per bit (1 where one operand is a constant zero).
+func sha256Xor3(api frontend.API, a, b, c sha256Word) sha256Word {
+	var r sha256Word
+	for j := range r {
+		r[j] = xorBit(api, xorBit(api, a[j], b[j]), c[j])
+	}
+	return r
+}
+
+// sha256SmallSigma0 is σ0(x) = ROTR^7(x) ⊕ ROTR^18(x) ⊕ SHR^3(x): 61 XOR.
+func sha256SmallSigma0(api frontend.API, x sha256Word) sha256Word {
+	return sha256Xor3(api, sha256Rotr(x, 7), sha256Rotr(x, 18), sha256Shr(x, 3))
+}
+
+// sha256SmallSigma1 is σ1(x) = ROTR^17(x) ⊕ ROTR^19(x) ⊕ SHR^10(x): 54 XOR.
+func sha256SmallSigma1(api frontend.API, x sha256Word) sha256Word {
+	return sha256Xor3(api, sha256Rotr(x, 17), sha256Rotr(x, 19), sha256Shr(x, 10))
+}
+
+// sha256BigSigma0 is Σ0(x) = ROTR^2(x) ⊕ ROTR^13(x) ⊕ ROTR^22(x): 64 XOR.
+func sha256BigSigma0(api frontend.API, x sha256Word) sha256Word {
+	return sha256Xor3(api, sha256Rotr(x, 2), sha256Rotr(x, 13), sha256Rotr(x, 22))
+}
+
+// sha256BigSigma1 is Σ1(x) = ROTR^6(x) ⊕ ROTR^11(x) ⊕ ROTR^25(x): 64 XOR.
+func sha256BigSigma1(api frontend.API, x sha256Word) sha256Word {
+	return sha256Xor3(api, sha256Rotr(x, 6), sha256Rotr(x, 11), sha256Rotr(x, 25))
+}
+
+// sha256Ch is Ch(e, f, g) = (e ∧ f) ⊕ (¬e ∧ g), computed as g ⊕ e ∧ (f ⊕ g): 2 XOR and 1 AND per bit.
+func sha256Ch(api frontend.API, e, f, g sha256Word) sha256Word {
+	var r sha256Word
+	for j := range r {
+		r[j] = xorBit(api, g[j], andBit(api, e[j], xorBit(api, f[j], g[j])))
+	}
+	return r
+}
+
+// sha256Maj is Maj(a, b, c) = (a ∧ b) ⊕ (a ∧ c) ⊕ (b ∧ c), computed as c ⊕ (a ⊕ c) ∧ (b ⊕ c): 3 XOR and
+// 1 AND per bit.
+func sha256Maj(api frontend.API, a, b, c sha256Word) sha256Word {
+	var r sha256Word
+	for j := range r {
+		r[j] = xorBit(api, c[j], andBit(api, xorBit(api, a[j], c[j]), xorBit(api, b[j], c[j])))
+	}
+	return r
+}
+
+// Function Purpose:
+	// a + b mod 2^32 with a ripple-carry adder over GF(2): s_j = a_j ⊕ b_j ⊕ c_j and the carry
+	// c_{j+1} = Maj(a_j, b_j, c_j) = c_j ⊕ (a_j ⊕ c_j) ∧ (b_j ⊕ c_j), sharing a_j ⊕ c_j with the sum bit.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `a`, `b`: the addends, either may hold constant bits (a round constant, the IV)
+// Outputs:
+	// - the 32-bit sum; the carry out of bit 31 is dropped
+// Gate Count:
+	// 123 XOR and 31 AND for non-constant addends: c_0 = 0 folds bit 0 into 1 XOR and 1 AND, bits 1..30
+	// take 4 XOR and 1 AND, bit 31 only its 2 sum XORs; constant bits fold further
+func sha256Add(api frontend.API, a, b sha256Word) sha256Word {
+	var s sha256Word
+	var carry frontend.Variable = 0
+	for j := range s {
+		u := xorBit(api, a[j], carry)
+		s[j] = xorBit(api, u, b[j])
+		if j < 31 {
+			carry = xorBit(api, carry, andBit(api, u, xorBit(api, b[j], carry)))
+		}
+	}
+	return s
+}
+
+// Function Purpose:
+	// The message schedule W0..W63 of one 512-bit block: W0..W15 are the block's big-endian words and
+	// W_t = σ1(W_{t-2}) + W_{t-7} + σ0(W_{t-15}) + W_{t-16} for t = 16..63.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `block`: 512 block bits, LSB first within each byte
+// Outputs:
+	// - the 64 schedule words
+// Gate Count:
+	// 48 × (3 additions + σ0 + σ1) = 23232 XOR and 4464 AND for a non-constant block; none for a constant
+	// one (the padding block of a 64-byte message)
+func sha256Schedule(api frontend.API, block []frontend.Variable) [64]sha256Word {
+	if len(block) != 512 {
+		panic(fmt.Sprintf("sha256Schedule: block of %d bits, expected 512", len(block)))
+	}
+	var w [64]sha256Word
+	for t := 0; t < 16; t++ {
+		w[t] = sha256LoadWord(block[32*t : 32*t+32])
+	}
+	for t := 16; t < 64; t++ {
+		w[t] = sha256Add(api, sha256Add(api, sha256Add(api, sha256SmallSigma1(api, w[t-2]), w[t-7]), sha256SmallSigma0(api, w[t-15])), w[t-16])
+	}
+	return w
+}
+
+// sha256RoundObserver receives the working variables a..h after each round of sha256Compress.
+type sha256RoundObserver func(round int, state [8]sha256Word)
+
+// Function Purpose:
+	// The SHA-256 compression function: 64 rounds over the working variables a..h started from h, then
+	// the feed-forward H_i + a..h.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `h`: the chaining value H0..H7
+	// - `block`: 512 block bits, LSB first within each byte
+	// - `observe`: called with a..h after every round, or nil
+// Outputs:
+	// - the next chaining value
+// Gate Count:
+	// the schedule, then per round 7 additions (T1 = h + Σ1(e) + Ch(e, f, g) + (W_t + K_t), e' = d + T1,
+	// a' = T1 + T2), Σ0, Σ1, Ch and Maj, then 8 additions: 93666 XOR, 1891 NOT and 22573 AND when neither
+	// h nor block is constant
+func sha256Compress(api frontend.API, h [8]sha256Word, block []frontend.Variable, observe sha256RoundObserver) [8]sha256Word {
+	w := sha256Schedule(api, block)
+	s := h
+	for t := 0; t < 64; t++ {
+		a, b, c, d, e, f, g, hh := s[0], s[1], s[2], s[3], s[4], s[5], s[6], s[7]
+		kw := sha256Add(api, w[t], sha256Const(sha256K[t]))
+		t1 := sha256Add(api, sha256Add(api, sha256Add(api, hh, sha256BigSigma1(api, e)), sha256Ch(api, e, f, g)), kw)
+		t2 := sha256Add(api, sha256BigSigma0(api, a), sha256Maj(api, a, b, c))
+		s = [8]sha256Word{sha256Add(api, t1, t2), a, b, c, sha256Add(api, d, t1), e, f, g}
+		if observe != nil {
+			observe(t, s)
+		}
+	}
+	for i := range s {
+		s[i] = sha256Add(api, h[i], s[i])
+	}
+	return s
+}
+
+// sha256LoadWord reads the big-endian word of 4 message bytes (32 bits, LSB first within each byte).
+func sha256LoadWord(bits []frontend.Variable) sha256Word {
+	var w sha256Word
+	for j := range w {
+		w[j] = bits[8*(3-j/8)+j%8]
+	}
+	return w
+}
+
+// sha256StoreWord is the inverse of sha256LoadWord: the 4 big-endian bytes of w, LSB first within each byte.
+func sha256StoreWord(w sha256Word) []frontend.Variable {
+	bits := make([]frontend.Variable, 32)
+	for j := range w {
+		bits[8*(3-j/8)+j%8] = w[j]
+	}
+	return bits
+}
+
+// sha256Padding is the constant FIPS 180-4 padding of a msgBytes-byte message: 0x80, zeros up to 56 mod 64
+// bytes and the 64-bit big-endian message length in bits, 9..72 bytes. A message of 56..63 bytes mod 64
+// leaves no room for the length field in its last block, so the padding spills into one more block:
+// 55 bytes pad to one block, 56 to two.
+func sha256Padding(msgBytes int) []byte {
+	pad := make([]byte, 1+(55-msgBytes%64+64)%64+8)
+	pad[0] = 0x80
+	bitLen := uint64(8 * msgBytes)
+	for i := 0; i < 8; i++ {
+		pad[len(pad)-1-i] = byte(bitLen >> (8 * i))
+	}
+	return pad
+}
+
+// sha256Blocks is the number of 512-bit blocks SHA-256 compresses for a msgBytes-byte message.
+func sha256Blocks(msgBytes int) int {
+	return (msgBytes + len(sha256Padding(msgBytes))) / 64
+}
+
+// sha256SharedCompress is sha256Compress as a memorized sub-circuit (see memorizedCall) when every bit of
+// h and block is a wire: the blocks in the middle of a long message then instantiate one compression
+// sub-circuit, so the circuit grows by one call per block and ecgo compiles the compression once. A block
+// with constant bits, the first (constant IV) and those holding the padding, is built inline instead,
+// where the constants fold; a sub-circuit would take them as inputs and fold nothing.
+func sha256SharedCompress(api frontend.API, h [8]sha256Word, block []frontend.Variable) [8]sha256Word {
+	in := make([]frontend.Variable, 0, 256+512)
+	for _, w := range h {
+		in = append(in, w[:]...)
+	}
+	in = append(in, block...)
+	for _, v := range in {
+		if _, ok := constBitValue(v); ok {
+			return sha256Compress(api, h, block, nil)
+		}
+	}
+	out := memorizedCall(api, sha256CompressFlat, in)
+	for i := range h {
+		copy(h[i][:], out[32*i:32*i+32])
+	}
+	return h
+}
+
+// sha256CompressFlat is sha256Compress on the flat wires of sha256SharedCompress: H0..H7 (32 bits each,
+// LSB first) then the 512 block bits in, the next H0..H7 out.
+func sha256CompressFlat(api frontend.API, in []frontend.Variable) []frontend.Variable {
+	var h [8]sha256Word
+	for i := range h {
+		copy(h[i][:], in[32*i:32*i+32])
+	}
+	out := make([]frontend.Variable, 0, 256)
+	for _, w := range sha256Compress(api, h, in[256:], nil) {
+		out = append(out, w[:]...)
+	}
+	return out
+}
+
+// Function Purpose:
+	// SHA-256 of a compile-time-length, byte-aligned message: pad with sha256Padding and compress the
+	// 512-bit blocks from the IV, threading the chaining value from block to block (sha256SharedCompress).
+// Inputs:
+	// - `api`: the constraint system builder, over GF(2)
+	// - `msg`: message bits, LSB first within each byte, len(msg) % 8 == 0
+// Outputs:
+	// - the 256 digest bits, LSB first within each byte
+// Gate Count:
+	// one sha256Compress per 64-byte block (the message and 9..72 padding bytes): 154572 XOR, 4298 NOT and
+	// 38444 AND for a 64-byte message, whose constant second block needs no schedule; every further block
+	// of message bits adds one compression, memoized
+func Sha256(api frontend.API, msg []frontend.Variable) []frontend.Variable {
+	requireGF2(api, "Sha256")
+	if len(msg)%8 != 0 {
+		panic("Sha256: message must be byte aligned")
+	}
+	padded := append(append([]frontend.Variable{}, msg...), constBits(sha256Padding(len(msg)/8))...)
+	var h [8]sha256Word
+	for i, x := range sha256IV {
+		h[i] = sha256Const(x)
+	}
+	for off := 0; off < len(padded); off += 512 {
+		h = sha256SharedCompress(api, h, padded[off:off+512])
+	}
+	var out []frontend.Variable
+	for _, w := range h {
+		out = append(out, sha256StoreWord(w)...)
+	}
+	return out
+}
+
+// sha256Circuit proves that Out[i] is SHA-256(P[i]) for len(P) instances of equal, compile-time-length
+// messages, the counterpart of keccak256Circuit; build it with NewSha256Circuit (64-byte messages) or
+// NewSha256CircuitOfLength. Sha256 is GF(2)-only, where every input is a bit, so P is not asserted boolean.
+type sha256Circuit struct {
+	P   [][]frontend.Variable
+	Out [][256]frontend.Variable `gnark:",public"`
+}
+
+// NewSha256Circuit returns an unassigned SHA-256 circuit of n instances of 64-byte messages.
+func NewSha256Circuit(n int) *sha256Circuit {
+	return NewSha256CircuitOfLength(n, 64)
+}
+
+// NewSha256CircuitOfLength returns an unassigned SHA-256 circuit of n instances of msgBytes-byte messages,
+// sha256Blocks(msgBytes) compressions each.
+func NewSha256CircuitOfLength(n, msgBytes int) *sha256Circuit {
+	if n < 1 {
+		panic("NewSha256Circuit: need at least one instance")
+	}
+	if msgBytes < 0 {
+		panic(fmt.Sprintf("NewSha256CircuitOfLength: %d-byte messages", msgBytes))
+	}
+	t := &sha256Circuit{P: make([][]frontend.Variable, n), Out: make([][256]frontend.Variable, n)}
+	msgs := make([]frontend.Variable, n*8*msgBytes)
+	for i := range t.P {
+		t.P[i] = msgs[i*8*msgBytes : (i+1)*8*msgBytes : (i+1)*8*msgBytes]
+	}
+	return t
+}
+
+func (t *sha256Circuit) Define(api frontend.API) error {
+	if len(t.Out) != len(t.P) {
+		return fmt.Errorf("sha256Circuit: %d digests for %d messages", len(t.Out), len(t.P))
+	}
+	for i := range t.P {
+		if len(t.P[i]) != len(t.P[0]) || len(t.P[i])%8 != 0 {
+			return fmt.Errorf("sha256Circuit: message %d has %d bits, expected %d", i, len(t.P[i]), len(t.P[0]))
+		}
+		out := memorizedCall(api, Sha256, t.P[i])
+		for j := range out {
+			api.AssertIsEqual(out[j], t.Out[i][j])
+		}
+	}
+	return nil
+}
+
+// sha256Assignment assigns msgs (all of one length) and their crypto/sha256 digests to a circuit of their
+// count and length.
+func sha256Assignment(msgs [][]byte) (*sha256Circuit, error) {
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("sha256 assignment: no messages")
+	}
+	c := NewSha256CircuitOfLength(len(msgs), len(msgs[0]))
+	for k, msg := range msgs {
+		if len(msg) != len(msgs[0]) {
+			return nil, fmt.Errorf("sha256 assignment: message %d has %d bytes, expected %d", k, len(msg), len(msgs[0]))
+		}
+		digest := sha256.Sum256(msg)
+		putBits(c.P[k], msg)
+		putBits(c.Out[k][:], digest[:])
+	}
+	return c, nil
+}
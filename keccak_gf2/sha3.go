@@ -0,0 +1,193 @@
+package keccakgf2
+
+import (
+	"fmt"
+
+	"github.com/consensys/gnark/frontend"
+	"golang.org/x/crypto/sha3"
+)
+
+// SHA3-256:
+// FIPS 202 SHA3-256 is Keccak-256 with another domain suffix: the first padding byte after the message is
+// 0x06 instead of 0x01 (DomainSHA3), merged with the final 0x80 into 0x86 when the message is one byte
+// short of a block. Everything else, keccakF, xorIn (Absorb) and the squeeze, is shared. A
+// keccak256Circuit built with WithSHA3 proves SHA3-256 digests instead: Define hashes with Sha3_256 (the
+// context, if any, goes through the same sponge), FillAssignment assigns x/crypto's SHA3-256 digests and
+// Preflight re-derives them with Sha3_256 (through evalBytes). The public inputs are laid out exactly as for
+// Keccak-256; nothing in the witness tells the two apart, so a verifier must know which circuit it holds
+// (its fingerprint does). The -sha3 flag builds and checks a batch of that circuit.
+
+// Sha3_256Config is FIPS 202 SHA3-256.
+var Sha3_256Config = KeccakConfig{RateBits: 1088, OutputBits: 256, Rounds: 24, DomainSep: DomainSHA3}
+
+// Function Purpose:
+	// SHA3-256 of a compile-time-length, byte-aligned message: pad 0x06||10*1 to the 1088-bit rate,
+	// absorb with keccakF, squeeze 256 bits.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: message bits, LSB first within each byte, len(msg) % 8 == 0
+// Outputs:
+	// - the 256 digest bits, LSB first within each byte
+// Gate Count:
+	// that of computeKeccak for a 64-byte message (one keccakF); one keccakF per 136-byte block in general
+func Sha3_256(api frontend.API, msg []frontend.Variable) []frontend.Variable {
+	return keccakSponge(api, msg, Sha3_256Config.RateBits, Sha3_256Config.DomainSep, Sha3_256Config.OutputBits)
+}
+
+// WithSHA3 makes the circuit prove SHA3-256 digests instead of Keccak-256 ones.
+func WithSHA3() CircuitOption {
+	return func(t *keccak256Circuit) { t.SHA3 = true }
+}
+
+// SHA3-512:
+// The sponge code takes the rate and the output length as parameters, so SHA3-512 is the same sponge at
+// rate 576 (9 lanes absorbed per block, xorIn bounded by the block) squeezing 512 bits. The squeeze reads
+// 64 of the 72 rate bytes, lanes 0..7, in one copyOutUnaligned pass, so no permutation follows the last
+// absorb. A 64-byte message still fits one block with its padding (64 + 1 <= 72) and costs one keccakF,
+// exactly what SHA3-256 costs for it; from 72 bytes on SHA3-512 needs a keccakF per 72 bytes where
+// SHA3-256 needs one per 136. NewSha3_512Circuit proves a batch of SHA3-512 digests of 64-byte messages.
+
+// Sha3_512Config is FIPS 202 SHA3-512.
+var Sha3_512Config = KeccakConfig{RateBits: 576, OutputBits: 512, Rounds: 24, DomainSep: DomainSHA3}
+
+// Function Purpose:
+	// SHA3-512 of a compile-time-length, byte-aligned message: pad 0x06||10*1 to the 576-bit rate,
+	// absorb with keccakF, squeeze 512 bits.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: message bits, LSB first within each byte, len(msg) % 8 == 0
+// Outputs:
+	// - the 512 digest bits, LSB first within each byte
+// Gate Count:
+	// one keccakF per 72-byte block: one for a 64-byte message, like Sha3_256
+func Sha3_512(api frontend.API, msg []frontend.Variable) []frontend.Variable {
+	return keccakSponge(api, msg, Sha3_512Config.RateBits, Sha3_512Config.DomainSep, Sha3_512Config.OutputBits)
+}
+
+// SHA3-384:
+// Rate 832 bits (13 lanes), capacity 768, 384-bit output. A 64-byte message again fits one block with its
+// padding, which now runs to the 104-byte block; Pad101 pads to whatever rate it is given and absorbWith
+// splits the block into len(block)/64 lanes, so neither assumes 17 lanes. The squeeze is lanes 0..5 of
+// one copyOutUnaligned pass, exactly 48 bytes.
+
+// Sha3_384Config is FIPS 202 SHA3-384.
+var Sha3_384Config = KeccakConfig{RateBits: 832, OutputBits: 384, Rounds: 24, DomainSep: DomainSHA3}
+
+// Function Purpose:
+	// SHA3-384 of a compile-time-length, byte-aligned message: pad 0x06||10*1 to the 832-bit rate,
+	// absorb with keccakF, squeeze 384 bits.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: message bits, LSB first within each byte, len(msg) % 8 == 0
+// Outputs:
+	// - the 384 digest bits, LSB first within each byte
+// Gate Count:
+	// one keccakF per 104-byte block: one for a 64-byte message
+func Sha3_384(api frontend.API, msg []frontend.Variable) []frontend.Variable {
+	return keccakSponge(api, msg, Sha3_384Config.RateBits, Sha3_384Config.DomainSep, Sha3_384Config.OutputBits)
+}
+
+// SHA3-224:
+// Rate 1152 bits, the widest of the family: 18 lanes per block, one more than Keccak-256, which is the
+// lane (x, y) = (2, 3) that xorIn's bound LayoutSpec.Index(x, y) < len(buf) lets in only now. The 224-bit
+// digest is 28 bytes, three and a half lanes: copyOutUnaligned takes lanes 0..2 whole and the low 32 bits
+// of lane 3.
+
+// Sha3_224Config is FIPS 202 SHA3-224.
+var Sha3_224Config = KeccakConfig{RateBits: 1152, OutputBits: 224, Rounds: 24, DomainSep: DomainSHA3}
+
+// Function Purpose:
+	// SHA3-224 of a compile-time-length, byte-aligned message: pad 0x06||10*1 to the 1152-bit rate,
+	// absorb with keccakF, squeeze 224 bits.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: message bits, LSB first within each byte, len(msg) % 8 == 0
+// Outputs:
+	// - the 224 digest bits, LSB first within each byte
+// Gate Count:
+	// one keccakF per 144-byte block: one for a 64-byte message
+func Sha3_224(api frontend.API, msg []frontend.Variable) []frontend.Variable {
+	return keccakSponge(api, msg, Sha3_224Config.RateBits, Sha3_224Config.DomainSep, Sha3_224Config.OutputBits)
+}
+
+// sha3BatchCircuit proves that Out[i] is the SHA3 digest (SHA3-224, SHA3-384 or SHA3-512, by Config) of
+// P[i] for len(P) instances; build it with NewSha3_224Circuit, NewSha3_384Circuit or NewSha3_512Circuit.
+// The message bits are asserted boolean off GF(2) only (assertInputBits).
+type sha3BatchCircuit struct {
+	P   [][64 * 8]frontend.Variable
+	Out [][]frontend.Variable `gnark:",public"`
+	// Config is Sha3_224Config, Sha3_384Config or Sha3_512Config; build-time only.
+	Config KeccakConfig `gnark:"-"`
+}
+
+func newSha3BatchCircuit(n int, cfg KeccakConfig) *sha3BatchCircuit {
+	if n < 1 {
+		panic("sha3 batch circuit: at least one instance")
+	}
+	c := &sha3BatchCircuit{P: make([][64 * 8]frontend.Variable, n), Out: make([][]frontend.Variable, n), Config: cfg}
+	for i := range c.Out {
+		c.Out[i] = make([]frontend.Variable, cfg.OutputBits)
+	}
+	return c
+}
+
+// NewSha3_224Circuit returns an unassigned SHA3-224 circuit of n instances.
+func NewSha3_224Circuit(n int) *sha3BatchCircuit {
+	return newSha3BatchCircuit(n, Sha3_224Config)
+}
+
+// NewSha3_384Circuit returns an unassigned SHA3-384 circuit of n instances.
+func NewSha3_384Circuit(n int) *sha3BatchCircuit {
+	return newSha3BatchCircuit(n, Sha3_384Config)
+}
+
+// NewSha3_512Circuit returns an unassigned SHA3-512 circuit of n instances.
+func NewSha3_512Circuit(n int) *sha3BatchCircuit {
+	return newSha3BatchCircuit(n, Sha3_512Config)
+}
+
+func (t *sha3BatchCircuit) Define(api frontend.API) error {
+	var hash func(frontend.API, []frontend.Variable) []frontend.Variable
+	switch t.Config {
+	case Sha3_224Config:
+		hash = Sha3_224
+	case Sha3_384Config:
+		hash = Sha3_384
+	case Sha3_512Config:
+		hash = Sha3_512
+	default:
+		return fmt.Errorf("sha3BatchCircuit: unsupported configuration %+v", t.Config)
+	}
+	for i := range t.P {
+		assertInputBits(api, t.P[i][:])
+		out := memorizedCall(api, hash, t.P[i][:])
+		for j := range out {
+			api.AssertIsEqual(out[j], t.Out[i][j])
+		}
+	}
+	return nil
+}
+
+// sha3BatchAssignment assigns msgs (64 bytes each) and their x/crypto digests to a circuit of cfg.
+func sha3BatchAssignment(cfg KeccakConfig, msgs [][]byte) (*sha3BatchCircuit, error) {
+	var sum func([]byte) []byte
+	switch cfg {
+	case Sha3_224Config:
+		sum = func(msg []byte) []byte { d := sha3.Sum224(msg); return d[:] }
+	case Sha3_384Config:
+		sum = func(msg []byte) []byte { d := sha3.Sum384(msg); return d[:] }
+	case Sha3_512Config:
+		sum = func(msg []byte) []byte { d := sha3.Sum512(msg); return d[:] }
+	default:
+		return nil, fmt.Errorf("sha3 assignment: unsupported configuration %+v", cfg)
+	}
+	c := newSha3BatchCircuit(len(msgs), cfg)
+	for k, msg := range msgs {
+		if len(msg) != 64 {
+			return nil, fmt.Errorf("sha3 assignment: message %d has %d bytes, expected 64", k, len(msg))
+		}
+		putBits(c.P[k][:], msg)
+		putBits(c.Out[k], sum(msg))
+	}
+	return c, nil
+}
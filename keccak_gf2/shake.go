@@ -0,0 +1,105 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// SHAKE128 and SHAKE256:
+// FIPS 202 SHAKE128 and SHAKE256 are the Keccak[256] and Keccak[512] sponges (rates 1344 and 1088) with
+// domain suffix 1111 (DomainSHAKE) and an output length chosen by the caller, e.g. for mask generation.
+// Outputs longer than the rate are squeezed by squeezeWith, which runs one more keccakF between
+// rate-sized extractions (copyOutUnaligned itself only reads one rate's worth of lanes), so the cost
+// depends on both lengths: SpongePermutations counts the keccakF calls, ⌈(msgBytes+1)/(rate/8)⌉ to absorb
+// plus ⌈outputBits/rate⌉-1 to squeeze, which the gadget benchmarks report as permutations/op. SHAKE256
+// shares its rate with Keccak-256: the two differ in the domain byte only, and build the same gates for
+// the same lengths. CShake128 and CShake256 add the SP 800-185 function name and customization string of
+// cShake, a constant prefix that folds away.
+
+// Shake128Rate and Shake256Rate are the rates of SHAKE128 and SHAKE256 in bits.
+const (
+	Shake128Rate = 1344
+	Shake256Rate = 1088
+)
+
+// Function Purpose:
+	// SHAKE128 of a compile-time-length, byte-aligned message with a compile-time output length: pad
+	// 0x1F||10*1 to the 1344-bit rate, absorb with keccakF, squeeze outputBits bits, permuting again
+	// after every full 1344-bit extraction.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: message bits, LSB first within each byte, len(msg) % 8 == 0
+	// - `outputBits`: number of output bits, positive
+// Outputs:
+	// - outputBits bits, LSB first within each byte
+// Gate Count:
+	// SpongePermutations(Shake128Rate, len(msg)/8, outputBits) keccakF (one for a 64-byte message and
+	// up to 1344 output bits, two for 1345..2688), plus 1344 XOR per absorbed block after the first
+func Shake128(api frontend.API, msg []frontend.Variable, outputBits int) []frontend.Variable {
+	return keccakSponge(api, msg, Shake128Rate, DomainSHAKE, outputBits)
+}
+
+// Function Purpose:
+	// SHAKE256 of a compile-time-length, byte-aligned message with a compile-time output length: pad
+	// 0x1F||10*1 to the 1088-bit rate, absorb with keccakF, squeeze outputBits bits, permuting again
+	// after every full 1088-bit extraction.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: message bits, LSB first within each byte, len(msg) % 8 == 0
+	// - `outputBits`: number of output bits, positive
+// Outputs:
+	// - outputBits bits, LSB first within each byte
+// Gate Count:
+	// SpongePermutations(Shake256Rate, len(msg)/8, outputBits) keccakF (one for a 64-byte message and
+	// up to 1088 output bits, two for 1089..2176), plus 1088 XOR per absorbed block after the first
+func Shake256(api frontend.API, msg []frontend.Variable, outputBits int) []frontend.Variable {
+	return keccakSponge(api, msg, Shake256Rate, DomainSHAKE, outputBits)
+}
+
+// Function Purpose:
+	// cSHAKE128 with function name n and customization string s fixed at circuit-build time: Shake128 if
+	// both are empty, otherwise Shake128's sponge over bytepad(encode_string(n) || encode_string(s), 168)
+	// || msg with domain bits 00 (DomainCSHAKE).
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: message bits, LSB first within each byte, len(msg) % 8 == 0
+	// - `n`, `s`: function name and customization string
+	// - `outputBits`: number of output bits, positive
+// Outputs:
+	// - outputBits bits, LSB first within each byte
+// Gate Count:
+	// that of Shake128 over msg alone: the prefix blocks are constant and their keccakF folds
+func CShake128(api frontend.API, msg []frontend.Variable, n, s []byte, outputBits int) []frontend.Variable {
+	if len(n) == 0 && len(s) == 0 {
+		return Shake128(api, msg, outputBits)
+	}
+	return cShake(api, msg, Shake128Rate, n, s, outputBits)
+}
+
+// Function Purpose:
+	// cSHAKE256 with function name n and customization string s fixed at circuit-build time: Shake256 if
+	// both are empty, otherwise Shake256's sponge over bytepad(encode_string(n) || encode_string(s), 136)
+	// || msg with domain bits 00 (DomainCSHAKE).
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: message bits, LSB first within each byte, len(msg) % 8 == 0
+	// - `n`, `s`: function name and customization string
+	// - `outputBits`: number of output bits, positive
+// Outputs:
+	// - outputBits bits, LSB first within each byte
+// Gate Count:
+	// that of Shake256 over msg alone: the prefix blocks are constant and their keccakF folds
+func CShake256(api frontend.API, msg []frontend.Variable, n, s []byte, outputBits int) []frontend.Variable {
+	if len(n) == 0 && len(s) == 0 {
+		return Shake256(api, msg, outputBits)
+	}
+	return cShake(api, msg, Shake256Rate, n, s, outputBits)
+}
+
+// SpongePermutations is the number of keccakF calls of keccakSponge with the given rate over a msgBytes-byte
+// message squeezed to outputBits bits: one per padded block, and one more per rate-sized output chunk
+// after the first.
+func SpongePermutations(rate, msgBytes, outputBits int) int {
+	r := rateOf(rate)
+	paddedBytes, _, _ := r.padIndices(msgBytes)
+	return paddedBytes/r.Bytes + (outputBits+rate-1)/rate - 1
+}
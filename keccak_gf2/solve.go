@@ -0,0 +1,200 @@
+package keccakgf2
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/irwg"
+	"github.com/consensys/gnark/frontend"
+)
+
+// Checked solving:
+// The ecgo input solver expects an assignment shaped exactly like the circuit it was compiled from, with
+// every input set to an element of the field. Anything else ends in a panic somewhere inside the solver,
+// or worse, in a value silently reduced modulo the field. checkedSolver validates every assignment against
+// the compiled circuit first and reports the offending field by its path ("P[3][17] is nil"); a panic that
+// still escapes the solver is recovered and returned as an error. Over GF(2), SolveInput also preflights
+// a keccak256Circuit assignment (see preflight.go) unless skipPreflight is set; batches are preflighted
+// by solveBatch, which knows their caller indices.
+
+type inputSolver interface {
+	SolveInput(assignment frontend.Circuit, nbThreads int) (*irwg.Witness, error)
+	SolveInputs(assignments []frontend.Circuit) (*irwg.Witness, error)
+}
+
+type checkedSolver struct {
+	is    inputSolver
+	field *big.Int
+	typ   reflect.Type
+	names map[string]bool
+	order []string
+	// noPreflight skips the preflight of SolveInput (withoutPreflight).
+	noPreflight bool
+}
+
+// newCheckedSolver wraps is, the input solver of circuit compiled over field.
+// circuit must be allocated the way it was compiled (slices sized), e.g. NewKeccak256Circuit(n).
+func newCheckedSolver(is inputSolver, field *big.Int, circuit frontend.Circuit) *checkedSolver {
+	s := &checkedSolver{is: is, field: field, typ: reflect.TypeOf(circuit), names: map[string]bool{}}
+	for _, name := range inputNames(circuit) {
+		s.names[name] = true
+		s.order = append(s.order, name)
+	}
+	return s
+}
+
+func (s *checkedSolver) SolveInput(assignment frontend.Circuit, nbThreads int) (w *irwg.Witness, err error) {
+	assignment, err = s.check(assignment)
+	if err != nil {
+		return nil, err
+	}
+	// preflight derives the digests over GF(2), the field the circuits are built for
+	if a, ok := assignment.(*keccak256Circuit); ok && !skipPreflight && !s.noPreflight && s.field.Cmp(gf2Modulus) == 0 {
+		if err := Preflight(a); err != nil {
+			return nil, err
+		}
+	}
+	defer recoverSolver(&err)
+	return s.is.SolveInput(assignment, nbThreads)
+}
+
+// withoutPreflight returns s with the preflight of SolveInput off, for assignments whose wrong digests are
+// meant to reach the circuit.
+func (s *checkedSolver) withoutPreflight() *checkedSolver {
+	c := *s
+	c.noPreflight = true
+	return &c
+}
+
+func (s *checkedSolver) SolveInputs(assignments []frontend.Circuit) (w *irwg.Witness, err error) {
+	checked := make([]frontend.Circuit, len(assignments))
+	for z, a := range assignments {
+		if checked[z], err = s.check(a); err != nil {
+			return nil, fmt.Errorf("assignment %d: %w", z, err)
+		}
+	}
+	defer recoverSolver(&err)
+	return s.is.SolveInputs(checked)
+}
+
+// recoverSolver turns a solver panic into the error returned through err.
+func recoverSolver(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("input solver failed: %v", r)
+	}
+}
+
+// check returns an error naming the first input of assignment that the solver cannot take, or the
+// assignment to solve: assignment itself, or a copy of it with its bool inputs turned into the ints 0/1.
+func (s *checkedSolver) check(assignment frontend.Circuit) (frontend.Circuit, error) {
+	if assignment == nil {
+		return nil, errors.New("assignment is nil")
+	}
+	if v := reflect.ValueOf(assignment); v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil, errors.New("assignment is nil")
+	}
+	if t := reflect.TypeOf(assignment); t != s.typ {
+		return nil, fmt.Errorf("assignment is a %v, the circuit was compiled from a %v", t, s.typ)
+	}
+	leaves := inputLeaves(assignment)
+	got := make(map[string]bool, len(leaves))
+	for _, l := range leaves {
+		if !s.names[l.name] {
+			return nil, fmt.Errorf("%s is not an input of the circuit", l.name)
+		}
+		got[l.name] = true
+	}
+	for _, name := range s.order {
+		if !got[name] {
+			return nil, fmt.Errorf("%s is missing", name)
+		}
+	}
+	copied := false
+	for i := range leaves {
+		// a bool bit is the one assigned form the solver cannot take: it becomes the int 0/1 in a copy
+		if _, ok := leaves[i].value.Interface().(bool); ok && !copied {
+			assignment, copied = copyInputs(assignment), true
+			leaves = inputLeaves(assignment)
+		}
+		l := leaves[i]
+		if b, ok := l.value.Interface().(bool); ok {
+			bit, _ := assignedBit(b)
+			l.value.Set(reflect.ValueOf(frontend.Variable(bit)))
+		}
+		if err := checkFieldElement(l.value.Interface(), s.field); err != nil {
+			return nil, fmt.Errorf("%s %w", l.name, err)
+		}
+	}
+	return assignment, nil
+}
+
+// copyInputs returns a copy of circuit, a pointer to a struct, whose slices and arrays of inputs are its
+// own: setting an input of the copy leaves circuit unchanged. The values themselves are shared.
+func copyInputs(circuit frontend.Circuit) frontend.Circuit {
+	v := reflect.ValueOf(circuit)
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+	var own func(v reflect.Value)
+	own = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				if v.Type().Field(i).PkgPath == "" {
+					own(v.Field(i))
+				}
+			}
+		case reflect.Slice:
+			if v.IsNil() {
+				return
+			}
+			s := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+			reflect.Copy(s, v)
+			v.Set(s)
+			fallthrough
+		case reflect.Array:
+			for i := 0; i < v.Len(); i++ {
+				own(v.Index(i))
+			}
+		}
+	}
+	own(c.Elem())
+	return c.Interface().(frontend.Circuit)
+}
+
+// checkFieldElement accepts the constant types gnark assigns (integers, big.Int, numeric strings) with a
+// value in [0, field).
+func checkFieldElement(x frontend.Variable, field *big.Int) error {
+	var v *big.Int
+	switch x := x.(type) {
+	case nil:
+		return errors.New("is nil")
+	case *big.Int:
+		if x == nil {
+			return errors.New("is nil")
+		}
+		v = x
+	case big.Int:
+		v = &x
+	case string:
+		var ok bool
+		if v, ok = new(big.Int).SetString(x, 0); !ok {
+			return fmt.Errorf("is %q, not a number", x)
+		}
+	default:
+		rv := reflect.ValueOf(x)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v = big.NewInt(rv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			v = new(big.Int).SetUint64(rv.Uint())
+		default:
+			return fmt.Errorf("has type %T, not a field element", x)
+		}
+	}
+	if v.Sign() < 0 || v.Cmp(field) >= 0 {
+		return fmt.Errorf("= %s is out of range for the field (modulus %s)", v, field)
+	}
+	return nil
+}
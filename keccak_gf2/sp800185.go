@@ -0,0 +1,271 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// NIST SP 800-185 string encodings (left_encode, right_encode, encode_string, bytepad).
+// cSHAKE, KMAC, TupleHash and ParallelHash all frame their inputs with these.
+// When the encoded value is known at circuit-build time it folds to constant bits and costs no gates;
+// leftEncodeVar encodes a length that is only known to the prover, for TupleHash128Var over strings of
+// witness length. No gadget right-encodes a witness: right_encode only frames L (TupleHash, KMAC) and the
+// block count of ParallelHash, both fixed at circuit-build time.
+
+// leftEncode(x) = n || x as n big-endian bytes, where n is the smallest value >= 1 with 2^(8n) > x.
+func leftEncode(x uint64) []byte {
+	b := bigEndianMinimal(x)
+	return append([]byte{byte(len(b))}, b...)
+}
+
+// rightEncode(x) = x as n big-endian bytes || n, with the same n as leftEncode.
+func rightEncode(x uint64) []byte {
+	b := bigEndianMinimal(x)
+	return append(b, byte(len(b)))
+}
+
+// encodeString(S) = left_encode(len(S) in bits) || S
+func encodeString(s []byte) []byte {
+	return append(leftEncode(uint64(len(s))*8), s...)
+}
+
+// bytepad(X, w) = left_encode(w) || X, then zero bytes up to a multiple of w bytes
+func bytepad(x []byte, w int) []byte {
+	res := append(leftEncode(uint64(w)), x...)
+	for len(res)%w != 0 {
+		res = append(res, 0)
+	}
+	return res
+}
+
+// bigEndianMinimal returns x as big-endian bytes without leading zeros, but at least one byte (so 0 → {0x00}).
+func bigEndianMinimal(x uint64) []byte {
+	n := 1
+	for n < 8 && x>>(8*n) != 0 {
+		n++
+	}
+	b := make([]byte, n)
+	for i := 0; i < n; i++ {
+		b[n-1-i] = byte(x >> (8 * i))
+	}
+	return b
+}
+
+// constBits expands constant bytes into circuit bits, LSB first within each byte (the same order as circuit.P).
+func constBits(b []byte) []frontend.Variable {
+	return bitsOf(b)
+}
+
+// Function Purpose:
+	// In-circuit left_encode of a length that is a witness (e.g. the bit length of a private variable-length string).
+	// The encoded length depends on the value, so the result is returned in a fixed-width buffer of maxBytes+1 bytes,
+	// left-aligned, together with a one-hot selector telling which encoded length is in use.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `x`: the value, 8*maxBytes bits, LSB first (bit 8*i+j is bit j of the i-th least significant byte)
+	// - `maxBytes`: the largest n the encoding may use, 1 <= maxBytes <= 255
+// Outputs:
+	// - `enc`: 8*(maxBytes+1) bits: n || x[n-1] .. x[0] || zero filler
+	// - `size`: size[n-1] = 1 iff the encoding uses n value bytes (so len(left_encode(x)) = n+1)
+// Gate Count:
+	// maxBytes × 7 OR gates for the per-byte nonzero flags, maxBytes OR gates for the suffix-OR,
+	// and at most maxBytes × (maxBytes+1) × 8 AND/XOR gates for the one-hot byte muxes.
+func leftEncodeVar(api frontend.API, x []frontend.Variable, maxBytes int) ([]frontend.Variable, []frontend.Variable) {
+	size, xBytes := encodedLengthSelector(api, x, maxBytes)
+	enc := make([][]frontend.Variable, maxBytes+1)
+	for pos := 0; pos <= maxBytes; pos++ {
+		enc[pos] = zeroBits(8)
+		for n := 1; n <= maxBytes; n++ {
+			var src []frontend.Variable
+			if pos == 0 {
+				src = constBits([]byte{byte(n)})
+			} else if pos <= n {
+				src = xBytes[n-pos]
+			} else {
+				continue
+			}
+			enc[pos] = xor(api, enc[pos], selectBits(api, size[n-1], src))
+		}
+	}
+	return flattenBytes(enc), size
+}
+
+// varBytes is a byte string whose length is a witness: a buffer of the largest length the circuit allows,
+// zero past the actual length, and a one-hot selector of that length. A constant string is a varBytes whose
+// selector is a constant, so the gadgets below fold on it exactly as on constant bits.
+type varBytes struct {
+	bytes [][]frontend.Variable // 8 bits per byte, LSB first; zero from the actual length on
+	size  []frontend.Variable   // len(bytes)+1 entries: size[l] = 1 iff the length is l
+}
+
+// constVarBytes is b as a varBytes of fixed length.
+func constVarBytes(b []byte) varBytes {
+	v := varBytes{bytes: splitBytes(constBits(b)), size: zeroBits(len(b) + 1)}
+	v.size[len(b)] = 1
+	return v
+}
+
+// Function Purpose:
+	// Truncate a buffer to a witness length: the bytes from the length on are zeroed, and the length becomes a
+	// one-hot selector. A length above the buffer fails an assertion.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `x`: the buffer, a whole number of bytes (LSB first within each byte)
+	// - `length`: the length in bytes, LSB first
+// Outputs:
+	// - the varBytes of the first length bytes of x
+// Gate Count:
+	// (len(x)/8+1) × (len(length)-1) AND gates for the selector, len(x)/8 XOR gates for the masks and one
+	// AND gate per bit of x
+func truncateVarBytes(api frontend.API, x []frontend.Variable, length []frontend.Variable) varBytes {
+	if len(x)%8 != 0 || len(length) == 0 {
+		panic("truncateVarBytes: x must be byte aligned and the length at least one bit")
+	}
+	maxLen := len(x) / 8
+	size := zeroBits(maxLen + 1)
+	inRange := frontend.Variable(0)
+	for l := 0; l <= maxLen; l++ {
+		if len(length) < 64 && l>>len(length) != 0 {
+			break
+		}
+		eq := frontend.Variable(1)
+		for j, b := range length {
+			if (l>>j)&1 == 0 {
+				b = xorBit(api, b, 1)
+			}
+			eq = andBit(api, eq, b)
+		}
+		size[l] = eq
+		// at most one l equals the length, so the XOR of the selector is 1 iff the length is at most maxLen
+		inRange = xorBit(api, inRange, eq)
+	}
+	api.AssertIsEqual(inRange, 1)
+	v := varBytes{bytes: splitBytes(x), size: size}
+	// keep[p] = 1 iff p < length, the suffix XOR of the selector
+	keep := frontend.Variable(0)
+	for p := maxLen - 1; p >= 0; p-- {
+		keep = xorBit(api, keep, size[p+1])
+		v.bytes[p] = selectBits(api, keep, v.bytes[p])
+	}
+	return v
+}
+
+// Function Purpose:
+	// Concatenate two varBytes: a || b, where b starts wherever a ends.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `a`, `b`: the strings
+// Outputs:
+	// - a || b, with a buffer of len(a.bytes)+len(b.bytes) bytes
+// Gate Count:
+	// one AND and one XOR gate per bit of b for every length a may take (none when a has a constant length),
+	// and as many for the product of the two selectors
+func appendVarBytes(api frontend.API, a, b varBytes) varBytes {
+	res := varBytes{bytes: make([][]frontend.Variable, len(a.bytes)+len(b.bytes)), size: zeroBits(len(a.bytes) + len(b.bytes) + 1)}
+	for p := range res.bytes {
+		if p < len(a.bytes) {
+			res.bytes[p] = a.bytes[p]
+		} else {
+			res.bytes[p] = zeroBits(8)
+		}
+	}
+	// a is zero from its length on, so each byte of b lands on zeros: XOR it in at every offset a may end at
+	for la, sel := range a.size {
+		for q := range b.bytes {
+			res.bytes[la+q] = xorBits(api, res.bytes[la+q], b.bytes[q], sel)
+		}
+		for lb, selB := range b.size {
+			res.size[la+lb] = xorBit(api, res.size[la+lb], andBit(api, sel, selB))
+		}
+	}
+	return res
+}
+
+// encodeStringVar is encode_string(X) = left_encode(bit length of X) || X for a varBytes X whose length in
+// bytes is given by the bits length (as for truncateVarBytes). The bit length is the byte length shifted by
+// three, left-encoded through leftEncodeVar.
+func encodeStringVar(api frontend.API, x varBytes, length []frontend.Variable) varBytes {
+	maxBytes := (len(length) + 3 + 7) / 8
+	bitLength := append(zeroBits(3), length...)
+	bitLength = append(bitLength, zeroBits(8*maxBytes-len(bitLength))...)
+	enc, size := leftEncodeVar(api, bitLength, maxBytes)
+	// left_encode uses n+1 bytes for n value bytes, and at least two
+	prefix := varBytes{bytes: splitBytes(enc), size: append(zeroBits(2), size...)}
+	return appendVarBytes(api, prefix, x)
+}
+
+// xorBits is a ⊕ (sel ∧ b) bit by bit, folded through xorBit and andBit.
+func xorBits(api frontend.API, a, b []frontend.Variable, sel frontend.Variable) []frontend.Variable {
+	res := make([]frontend.Variable, len(a))
+	for i := range a {
+		res[i] = xorBit(api, a[i], andBit(api, sel, b[i]))
+	}
+	return res
+}
+
+// encodedLengthSelector splits x into bytes and computes the one-hot selector of n = max(1, index of the top nonzero byte + 1).
+// hi[k] = 1 iff some byte at index >= k is nonzero; hi is monotone, so n is where it drops to 0.
+func encodedLengthSelector(api frontend.API, x []frontend.Variable, maxBytes int) ([]frontend.Variable, [][]frontend.Variable) {
+	if maxBytes < 1 || maxBytes > 255 || len(x) != 8*maxBytes {
+		panic("encodeLength: x must have 8*maxBytes bits with 1 <= maxBytes <= 255")
+	}
+	xBytes := make([][]frontend.Variable, maxBytes)
+	for i := 0; i < maxBytes; i++ {
+		xBytes[i] = x[8*i : 8*i+8]
+	}
+	hi := make([]frontend.Variable, maxBytes+1)
+	hi[maxBytes] = 0
+	for k := maxBytes - 1; k >= 0; k-- {
+		nz := xBytes[k][0]
+		for j := 1; j < 8; j++ {
+			nz = orBit(api, nz, xBytes[k][j])
+		}
+		hi[k] = orBit(api, hi[k+1], nz)
+	}
+	size := make([]frontend.Variable, maxBytes)
+	// n = 1 covers both x = 0 and x < 256
+	size[0] = api.Sub(1, hi[1])
+	for n := 2; n <= maxBytes; n++ {
+		size[n-1] = api.Mul(hi[n-1], api.Sub(1, hi[n]))
+	}
+	return size, xBytes
+}
+
+// orBit: a ∨ b = a ⊕ b ⊕ (a ∧ b) over GF(2), 2 XOR + 1 AND
+func orBit(api frontend.API, a frontend.Variable, b frontend.Variable) frontend.Variable {
+	requireGF2(api, "orBit")
+	return api.Add(a, b, api.Mul(a, b))
+}
+
+// selectBits returns bits if sel = 1 and all zeros if sel = 0 (one AND gate per bit).
+func selectBits(api frontend.API, sel frontend.Variable, bits []frontend.Variable) []frontend.Variable {
+	res := make([]frontend.Variable, len(bits))
+	for i := range bits {
+		res[i] = api.Mul(sel, bits[i])
+	}
+	return res
+}
+
+func zeroBits(n int) []frontend.Variable {
+	res := make([]frontend.Variable, n)
+	for i := range res {
+		res[i] = 0
+	}
+	return res
+}
+
+// splitBytes is the inverse of flattenBytes: len(b)/8 bytes of 8 bits each.
+func splitBytes(b []frontend.Variable) [][]frontend.Variable {
+	res := make([][]frontend.Variable, len(b)/8)
+	for i := range res {
+		res[i] = b[8*i : 8*i+8 : 8*i+8]
+	}
+	return res
+}
+
+func flattenBytes(b [][]frontend.Variable) []frontend.Variable {
+	res := make([]frontend.Variable, 0, 8*len(b))
+	for _, x := range b {
+		res = append(res, x...)
+	}
+	return res
+}
@@ -0,0 +1,79 @@
+// Package spec holds the Keccak-p tables (FIPS 202, section 3.2), generated from their definitions
+// instead of copied from a table: the ρ rotation offsets, the π lane permutation and the ι round constants.
+// Every Keccak implementation in this repository, circuit or reference, takes its tables from here.
+// Lanes are indexed x+5y (the FIPS 202 / Keccak reference layout).
+package spec
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// RotationOffsets are the ρ offsets for 64-bit lanes: lane x+5y is rotated left by RotationOffsets[x+5y].
+var RotationOffsets = RotationOffsetsFor(64)
+
+// PiPermutation is π: lane x+5y moves to position PiPermutation[x+5y] = y + 5*((2x+3y) mod 5).
+var PiPermutation = piPermutation()
+
+// RotationOffsetsFor returns the ρ offsets for lanes of w bits: starting from (x, y) = (1, 0), step t
+// (0 <= t < 24) rotates lane (x, y) by (t+1)(t+2)/2 mod w and moves on to (y, (2x+3y) mod 5).
+// Lane (0, 0) is not rotated.
+func RotationOffsetsFor(w int) [25]int {
+	checkWidth(w)
+	var r [25]int
+	x, y := 1, 0
+	for t := 0; t < 24; t++ {
+		r[x+5*y] = ((t + 1) * (t + 2) / 2) % w
+		x, y = y, (2*x+3*y)%5
+	}
+	return r
+}
+
+func piPermutation() [25]int {
+	var p [25]int
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 5; y++ {
+			p[x+5*y] = y + 5*((2*x+3*y)%5)
+		}
+	}
+	return p
+}
+
+// RoundConstants returns the ι constants of Keccak-p[25w, rounds], one per round in order, masked to w bits.
+// Keccak-p with nr rounds runs the last nr rounds of the 12+2l round indices (l = log2 w), so for
+// rounds = 12+2l this is Keccak-f[25w] (24 rounds for w = 64); fewer rounds are the tail of that list.
+func RoundConstants(w int, rounds int) []uint64 {
+	checkWidth(w)
+	if rounds < 1 {
+		panic("spec: rounds must be positive")
+	}
+	l := bits.TrailingZeros(uint(w))
+	rc := make([]uint64, rounds)
+	for n := range rc {
+		ir := 12 + 2*l - rounds + n
+		for j := 0; j <= l; j++ {
+			rc[n] |= lfsrBit(j+7*ir) << ((1 << j) - 1)
+		}
+	}
+	return rc
+}
+
+// lfsrBit is rc(t) of FIPS 202 Algorithm 5: the output of the LFSR with polynomial x^8+x^6+x^5+x^4+1
+// after t mod 255 steps (t may be negative for Keccak-p with more rounds than Keccak-f).
+func lfsrBit(t int) uint64 {
+	t = ((t % 255) + 255) % 255
+	r := uint16(1)
+	for i := 0; i < t; i++ {
+		r <<= 1
+		if r&0x100 != 0 {
+			r ^= 0x171
+		}
+	}
+	return uint64(r & 1)
+}
+
+func checkWidth(w int) {
+	if w < 1 || w > 64 || w&(w-1) != 0 {
+		panic(fmt.Sprintf("spec: lane width %d is not a power of two in [1, 64]", w))
+	}
+}
@@ -0,0 +1,261 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// Domain separation bytes: the suffix bits appended to the message, merged with the first 1 of pad10*1.
+const (
+	DomainKeccak = 0x01 // legacy Keccak (Ethereum)
+	DomainSHA3   = 0x06 // SHA3-224/256/384/512: suffix 01
+	DomainSHAKE  = 0x1F // SHAKE128/256: suffix 1111
+	DomainCSHAKE = 0x04 // cSHAKE, KMAC, TupleHash, ParallelHash: suffix 00
+)
+
+// Function Purpose:
+	// General Keccak[c] sponge over a compile-time-length, byte-aligned message:
+	// pad → absorb block by block (xorIn + keccakF) → squeeze as many rate-sized chunks as needed.
+	// computeKeccak is the hand-unrolled special case rate = 1088, one block, 256-bit output.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: message bits, LSB first within each byte, len(msg) % 8 == 0
+	// - `rate`: sponge rate in bits, a multiple of 64 below 1600 (1344 for SHAKE128, 1088 for SHAKE256/Keccak-256, ...)
+	// - `domainSep`: domain separation byte (DomainKeccak, DomainSHA3, DomainSHAKE, DomainCSHAKE)
+	// - `outputBits`: number of output bits to squeeze; outputs longer than the rate run extra permutations
+// Outputs:
+	// - `outputBits` digest bits, LSB first within each byte
+// Gate Count:
+	// one keccakF per absorbed block plus one per additional squeezed rate chunk;
+	// the padding and the zero initial state are constants and cost nothing.
+func keccakSponge(api frontend.API, msg []frontend.Variable, rate int, domainSep byte, outputBits int) []frontend.Variable {
+	return spongeWith(api, plainKeccakF, msg, rate, domainSep, outputBits)
+}
+
+// plainKeccakF is keccakF without options, as the permutation argument of spongeWith and friends.
+func plainKeccakF(api frontend.API, a [][]frontend.Variable) [][]frontend.Variable {
+	return keccakF(api, a)
+}
+
+// spongeWith is keccakSponge with the permutation passed in, e.g. sharedKeccakF to compile it only once.
+func spongeWith(api frontend.API, perm func(frontend.API, [][]frontend.Variable) [][]frontend.Variable, msg []frontend.Variable, rate int, domainSep byte, outputBits int) []frontend.Variable {
+	if len(msg)%8 != 0 {
+		panic("keccakSponge: message must be byte aligned")
+	}
+	ss := NewState()
+	for _, block := range Pad101Blocks(msg, rate, domainSep) {
+		ss = absorbWith(api, perm, ss, block)
+	}
+	return squeezeWith(api, perm, ss, rate, outputBits)
+}
+
+// Sponge primitives:
+// Pad101, Absorb and Squeeze are the three steps of every sponge in this package, exported so that other
+// members of the family (different rate, domain byte or output length) can be assembled from them:
+//
+//	ss := NewState()
+//	for _, block := range Pad101Blocks(msg, rate, domainSep) {
+//		ss = Absorb(api, ss, block)
+//	}
+//	digest := Squeeze(api, ss, rate, outputBits)
+//
+// The state is keccakF's [][]frontend.Variable of 25 lanes (LayoutInternal); blocks and outputs are plain
+// bit strings, LSB first within each byte. None of them modifies the caller's state.
+
+// NewState returns the all-zero sponge state.
+func NewState() [][]frontend.Variable {
+	ss := make([][]frontend.Variable, 25)
+	for i := 0; i < 25; i++ {
+		ss[i] = zeroBits(64)
+	}
+	return ss
+}
+
+// Function Purpose:
+	// pad10*1 with the domain suffix merged in, as computeKeccak and every sponge apply it (see padMessage).
+// Inputs:
+	// - `msgBits`: message bits, LSB first within each byte, len(msgBits) % 8 == 0
+	// - `rate`: sponge rate in bits, a positive multiple of 64 below 1600
+	// - `domainSep`: domain separation byte (DomainKeccak, DomainSHA3, DomainSHAKE, DomainCSHAKE)
+// Outputs:
+	// - msgBits followed by the constant padding, a non-empty multiple of rate bits
+// Gate Count:
+	// none
+func Pad101(msgBits []frontend.Variable, rate int, domainSep byte) []frontend.Variable {
+	checkRate(rate)
+	if len(msgBits)%8 != 0 {
+		panic("Pad101: message must be byte aligned")
+	}
+	return padMessage(msgBits, padParams{Rate: rate, Domain: domainSep, MsgBytes: len(msgBits) / 8})
+}
+
+// Function Purpose:
+	// Pad101 cut into the blocks a sponge absorbs one by one. A message that ends one byte short of a block
+	// gets Domain|0x80 in that byte; one that ends on a block boundary (including the empty message) gets a
+	// whole block of padding.
+// Inputs:
+	// - `msgBits`: message bits, LSB first within each byte, len(msgBits) % 8 == 0
+	// - `rate`: sponge rate in bits, a positive multiple of 64 below 1600
+	// - `domainSep`: domain separation byte (DomainKeccak, DomainSHA3, DomainSHAKE, DomainCSHAKE)
+// Outputs:
+	// - len(msgBits)/rate + 1 blocks of rate bits each
+// Gate Count:
+	// none
+func Pad101Blocks(msgBits []frontend.Variable, rate int, domainSep byte) [][]frontend.Variable {
+	padded := Pad101(msgBits, rate, domainSep)
+	blocks := make([][]frontend.Variable, len(padded)/rate)
+	for i := range blocks {
+		blocks[i] = padded[i*rate : (i+1)*rate : (i+1)*rate]
+	}
+	return blocks
+}
+
+// Function Purpose:
+	// One absorb step: XOR a rate-sized block into the first rate/64 lanes of the state, then apply keccakF.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `state`: the sponge state (NewState, or the result of a previous Absorb)
+	// - `block`: len(block) = rate bits of padded message, LSB first within each byte
+// Outputs:
+	// - the new state
+// Gate Count:
+	// rate XOR gates (none against constant lanes, e.g. the first block into the zero state) plus one keccakF
+func Absorb(api frontend.API, state [][]frontend.Variable, block []frontend.Variable) [][]frontend.Variable {
+	return absorbWith(api, plainKeccakF, state, block)
+}
+
+func absorbWith(api frontend.API, perm func(frontend.API, [][]frontend.Variable) [][]frontend.Variable, state [][]frontend.Variable, block []frontend.Variable) [][]frontend.Variable {
+	return perm(api, xorIn(api, copyState(state), block, len(block)))
+}
+
+// Function Purpose:
+	// The squeeze phase: read outBits bits from the first rate bits of the state, running keccakF between
+	// rate-sized chunks when outBits exceeds the rate.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `state`: the state after the last Absorb
+	// - `rate`: sponge rate in bits, the same as for Absorb
+	// - `outBits`: number of output bits, positive
+// Outputs:
+	// - outBits bits, LSB first within each byte
+// Gate Count:
+	// one keccakF per rate-sized chunk after the first; reading the state is wiring only
+func Squeeze(api frontend.API, state [][]frontend.Variable, rate int, outBits int) []frontend.Variable {
+	return squeezeWith(api, plainKeccakF, state, rate, outBits)
+}
+
+func squeezeWith(api frontend.API, perm func(frontend.API, [][]frontend.Variable) [][]frontend.Variable, state [][]frontend.Variable, rate int, outBits int) []frontend.Variable {
+	r := rateOf(rate)
+	if outBits <= 0 {
+		panic("keccakSponge: outputBits must be positive")
+	}
+	out := []frontend.Variable{}
+	for {
+		// only the bytes still needed are read: computeKeccak's 256 bits are the first 4 lanes
+		need := (outBits - len(out) + 7) / 8
+		if need > r.Bytes {
+			need = r.Bytes
+		}
+		out = append(out, copyOutUnaligned(api, state, rate, need)...)
+		if len(out) >= outBits {
+			break
+		}
+		state = perm(api, state)
+	}
+	return out[:outBits]
+}
+
+func checkRate(rate int) {
+	if rate <= 0 || rate%64 != 0 || rate >= 1600 {
+		panic("keccakSponge: rate must be a positive multiple of 64 below 1600")
+	}
+}
+
+// spongeRate is a sponge rate with every quantity derived from it: the block length, the lanes a block
+// covers and the padding positions. Keccak-256 is rateOf(Keccak256Config.RateBits) = {1088, 136, 17}: the
+// gadgets take their rate from a configuration (Keccak256Config, Sha3_256Config, Shake128Rate, ...) and
+// derive the rest through rateOf instead of hard-coding those numbers.
+type spongeRate struct {
+	Bits  int // r, a positive multiple of 64 below 1600
+	Bytes int // r/8, the block length
+	Lanes int // r/64, the lanes xorIn writes and a squeeze pass may read
+}
+
+// rateOf checks rate (bits) and derives its spongeRate.
+func rateOf(rate int) spongeRate {
+	checkRate(rate)
+	return spongeRate{Bits: rate, Bytes: rate / 8, Lanes: rate / 64}
+}
+
+// padIndices returns the length in bytes of a msgBytes-byte message padded with pad10*1, the index of its
+// domain byte (right after the message) and that of the byte holding the final 1 (the last of the padded
+// message). Both are the same byte when the message ends one byte short of a block boundary, e.g. 135 for
+// a 135-byte message at rate 1088; a message of 64 bytes has its domain byte at 64 and the 0x80 at 135.
+func (r spongeRate) padIndices(msgBytes int) (paddedBytes, domainIndex, lastIndex int) {
+	paddedBytes = (msgBytes/r.Bytes + 1) * r.Bytes
+	return paddedBytes, msgBytes, paddedBytes - 1
+}
+
+// Function Purpose:
+	// cSHAKE (NIST SP 800-185) with function name N and customization string S fixed at circuit-build time.
+	// With N = S = "" it is plain SHAKE; otherwise the message is prefixed with bytepad(encode_string(N) || encode_string(S), rate)
+	// and the domain bits change to 00 (DomainCSHAKE). The prefix is constant and fills whole blocks, so it costs no gates:
+	// its keccakF runs on a constant state and folds (see gatestats.go). The message then meets that state rather than
+	// a zero one, and an XOR with one of its 1 bits is a NOT where one with a 0 bit folds away.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: message bits, LSB first within each byte
+	// - `rate`: 1344 for cSHAKE128, 1088 for cSHAKE256
+	// - `n`, `s`: function name and customization string
+	// - `outputBits`: number of output bits
+func cShake(api frontend.API, msg []frontend.Variable, rate int, n, s []byte, outputBits int) []frontend.Variable {
+	if len(n) == 0 && len(s) == 0 {
+		return keccakSponge(api, msg, rate, DomainSHAKE, outputBits)
+	}
+	prefix := bytepad(append(encodeString(n), encodeString(s)...), rateOf(rate).Bytes)
+	full := append(constBits(prefix), msg...)
+	return keccakSponge(api, full, rate, DomainCSHAKE, outputBits)
+}
+
+// Function Purpose:
+	// cShake over a message of witness length (see varBytes). The sponge absorbs every block the longest message
+	// needs, with the domain byte and the final 0x80 XORed in at each position a length may put them, and keeps
+	// the state after the block the message actually ends in.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: the message, zero from its length on
+	// - `rate`, `n`, `s`, `outputBits`: as for cShake
+// Outputs:
+	// - `outputBits` bits, those of cShake over the first length bytes of msg
+// Gate Count:
+	// that of cShake over the longest message, plus 8 XOR gates per padding position a length may select and
+	// one AND and one XOR gate per state bit for each block the message may end in
+func cShakeVar(api frontend.API, msg varBytes, rate int, n, s []byte, outputBits int) []frontend.Variable {
+	domainSep := byte(DomainSHAKE)
+	if len(n) != 0 || len(s) != 0 {
+		domainSep = DomainCSHAKE
+		msg = appendVarBytes(api, constVarBytes(bytepad(append(encodeString(n), encodeString(s)...), rateOf(rate).Bytes)), msg)
+	}
+	r := rateOf(rate)
+	blocks := len(msg.bytes)/r.Bytes + 1
+	padded := make([][]frontend.Variable, blocks*r.Bytes)
+	copy(padded, msg.bytes)
+	for p := len(msg.bytes); p < len(padded); p++ {
+		padded[p] = zeroBits(8)
+	}
+	last := zeroBits(blocks)
+	for l, sel := range msg.size {
+		// a message of length l has its domain byte at l and the 0x80 at the end of block l/r.Bytes
+		padded[l] = xorBits(api, padded[l], constBits([]byte{domainSep}), sel)
+		last[l/r.Bytes] = xorBit(api, last[l/r.Bytes], sel)
+	}
+	ss, out := NewState(), NewState()
+	for b := 0; b < blocks; b++ {
+		end := (b+1)*r.Bytes - 1
+		padded[end] = xorBits(api, padded[end], constBits([]byte{0x80}), last[b])
+		ss = Absorb(api, ss, flattenBytes(padded[b*r.Bytes:end+1]))
+		for i := range out {
+			out[i] = xorBits(api, out[i], ss[i], last[b])
+		}
+	}
+	return Squeeze(api, out, rate, outputBits)
+}
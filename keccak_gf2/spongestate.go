@@ -0,0 +1,140 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// Sponge is an incremental Keccak sponge for data that arrives in chunks: Absorb buffers bits until a
+// whole rate-sized block is available and absorbs it (AbsorbU64Limbs instead XORs whole lanes into the
+// state as they come), the first Squeeze pads whatever is left with
+// pad10*1 (exactly once) and switches to squeezing, and Squeeze hands out the rate part of the state,
+// permuting again whenever a rate's worth has been read. Absorbing after squeezing is not supported.
+type Sponge struct {
+	state     [][]frontend.Variable
+	rate      int
+	domainSep byte
+	lanes     int                 // rate lanes of the current block already XORed into the state
+	pending   []frontend.Variable // absorbed bits after those lanes, not yet forming a whole block
+	squeezing bool
+	chunk     []frontend.Variable // rate part of the current state while squeezing
+	pos       int                 // bits of chunk already handed out
+}
+
+// NewSponge returns an empty sponge with the given rate in bits (a positive multiple of 64 below 1600)
+// and domain separation byte, e.g. NewSponge(1088, DomainKeccak) for Keccak-256.
+func NewSponge(rate int, domainSep byte) *Sponge {
+	checkRate(rate)
+	return &Sponge{state: NewState(), rate: rate, domainSep: domainSep}
+}
+
+// Absorb appends message bits (a whole number of bytes, LSB first within each byte). Every block that is
+// completed by them is absorbed right away; the rest is kept for the next call.
+func (s *Sponge) Absorb(api frontend.API, bits []frontend.Variable) {
+	if s.squeezing {
+		panic("Sponge: Absorb after Squeeze")
+	}
+	if len(bits)%8 != 0 {
+		panic("Sponge: absorbed data must be byte aligned")
+	}
+	s.pending = append(s.pending, bits...)
+	for 64*s.lanes+len(s.pending) >= s.rate {
+		// the lanes already in the state are constant zeros of the block, which xorIn folds away
+		n := s.rate - 64*s.lanes
+		s.state = Absorb(api, s.state, append(zeroBits(64*s.lanes), s.pending[:n]...))
+		s.pending = append([]frontend.Variable{}, s.pending[n:]...)
+		s.lanes = 0
+	}
+}
+
+// absorbLane XORs 64 message bits into the next rate lane of the state and permutes when that lane ends
+// the block. Pending bits go in first; when they are not a whole number of lanes, lane is absorbed
+// through Absorb instead.
+func (s *Sponge) absorbLane(api frontend.API, lane []frontend.Variable) {
+	if s.squeezing {
+		panic("Sponge: Absorb after Squeeze")
+	}
+	if len(s.pending)%64 != 0 {
+		s.Absorb(api, lane)
+		return
+	}
+	for len(s.pending) > 0 {
+		s.xorLane(api, s.pending[:64])
+		s.pending = s.pending[64:]
+	}
+	s.xorLane(api, lane)
+}
+
+// xorLane XORs lane into the rate lane at index s.lanes of the block (LayoutSpec order, as xorIn) and
+// runs keccakF once the block is complete.
+func (s *Sponge) xorLane(api frontend.API, lane []frontend.Variable) {
+	i := LayoutInternal.Index(s.lanes%5, s.lanes/5)
+	// xor returns a fresh lane, so a copy of the lane list leaves a midstate handed out earlier intact
+	s.state = append([][]frontend.Variable(nil), s.state...)
+	s.state[i] = xor(api, s.state[i], lane)
+	if s.lanes++; s.lanes == rateOf(s.rate).Lanes {
+		s.state = keccakF(api, s.state)
+		s.lanes = 0
+	}
+}
+
+// Squeeze returns the next n output bits. The first call finalizes the message: the pending bits are
+// padded with pad10*1 and absorbed as the last block.
+func (s *Sponge) Squeeze(api frontend.API, n int) []frontend.Variable {
+	if n < 0 {
+		panic("Sponge: negative output length")
+	}
+	if !s.squeezing {
+		s.state = Absorb(api, s.state, Pad101(append(zeroBits(64*s.lanes), s.pending...), s.rate, s.domainSep))
+		s.pending, s.lanes = nil, 0
+		s.squeezing = true
+		s.chunk = copyOutUnaligned(api, s.state, s.rate, rateOf(s.rate).Bytes)
+	}
+	out := make([]frontend.Variable, 0, n)
+	for len(out) < n {
+		if s.pos == s.rate {
+			s.state = keccakF(api, s.state)
+			s.chunk = copyOutUnaligned(api, s.state, s.rate, rateOf(s.rate).Bytes)
+			s.pos = 0
+		}
+		take := n - len(out)
+		if take > s.rate-s.pos {
+			take = s.rate - s.pos
+		}
+		out = append(out, s.chunk[s.pos:s.pos+take]...)
+		s.pos += take
+	}
+	return out
+}
+
+// Midstates:
+// A midstate is the sponge state between whole absorbed blocks, before any padding: what a prover hands
+// over when a long message is hashed partly outside the circuit, or partly in another circuit. Midstate
+// exports it and ResumeSponge imports it as 25 lanes of 64 bits in LayoutSpec, the lane order of FIPS 202
+// and of other implementations, so neither side needs to know the internal order.
+
+// Midstate returns the state after the blocks absorbed so far: 25 lanes in LayoutSpec, LSB first within
+// each lane. It panics when absorbed bits are still pending (the message so far is not a whole number of
+// blocks) or after Squeeze.
+func (s *Sponge) Midstate() [][]frontend.Variable {
+	if s.squeezing || s.lanes > 0 || len(s.pending) > 0 {
+		panic("Sponge: a midstate exists only between whole absorbed blocks")
+	}
+	return copyState(ConvertState(s.state, LayoutInternal, LayoutSpec))
+}
+
+// ResumeSponge returns a sponge with the given rate and domain separation byte that continues from
+// midstate, as exported by Midstate: absorbing the rest of the message and squeezing gives the digest of
+// the whole message.
+func ResumeSponge(rate int, domainSep byte, midstate [][]frontend.Variable) *Sponge {
+	s := NewSponge(rate, domainSep)
+	if len(midstate) != 25 {
+		panic("ResumeSponge: a midstate has 25 lanes")
+	}
+	for _, lane := range midstate {
+		if len(lane) != 64 {
+			panic("ResumeSponge: a midstate lane has 64 bits")
+		}
+	}
+	s.state = copyState(ConvertState(midstate, LayoutSpec, LayoutInternal))
+	return s
+}
@@ -0,0 +1,51 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// StateLayout names an ordering of the 25 lanes of a Keccak state.
+// The gadgets in this package keep lane (x, y) at a[5x+y] (LayoutInternal, column-major);
+// FIPS 202, the Keccak team's intermediate-value files and most other implementations keep it at
+// a[x+5y] (LayoutSpec, row-major). Anything that imports or exports a state converts with
+// ConvertState rather than hand-writing the index map.
+type StateLayout int
+
+const (
+	LayoutInternal StateLayout = iota // a[5x+y]
+	LayoutSpec                        // a[x+5y]
+)
+
+// Index returns the position of lane (x, y) in the layout.
+func (l StateLayout) Index(x int, y int) int {
+	if l == LayoutInternal {
+		return 5*x + y
+	}
+	return x + 5*y
+}
+
+// ConvertState reorders the 25 lanes of a state from one layout to another. Lanes are moved, not
+// copied, so this is free in the circuit.
+func ConvertState(a [][]frontend.Variable, from StateLayout, to StateLayout) [][]frontend.Variable {
+	if len(a) != 25 {
+		panic("ConvertState: a Keccak state has 25 lanes")
+	}
+	res := make([][]frontend.Variable, 25)
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 5; y++ {
+			res[to.Index(x, y)] = a[from.Index(x, y)]
+		}
+	}
+	return res
+}
+
+// convertLanes is ConvertState for off-circuit uint64 lanes.
+func convertLanes(a [25]uint64, from StateLayout, to StateLayout) [25]uint64 {
+	var res [25]uint64
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 5; y++ {
+			res[to.Index(x, y)] = a[from.Index(x, y)]
+		}
+	}
+	return res
+}
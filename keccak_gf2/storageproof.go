@@ -0,0 +1,337 @@
+package keccakgf2
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/consensys/gnark/frontend"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Storage proofs:
+// storageProofCircuit proves that a storage slot of a contract holds a value, from one entry of an
+// eth_getProof response: the storage root (storageHash) and the value are public, the slot key and the
+// Merkle-Patricia proof nodes are private. The circuit hashes every node with the general sponge (a
+// 17-child branch node is 532 bytes, four keccakF) and chains them: the first node hashes to the root,
+// every further node hashes to the 32-byte child reference its parent holds on the key's path, and the
+// leaf holds the rest of the path Keccak-256(key) and the value. RLP is not parsed in the circuit: the
+// circuit is compiled for a fixed shape (mptShape), taken from the proof by storageProofShape, in which
+// the byte length of every node, every RLP header, the empty children and the offsets of the child
+// reference, the leaf path and the value are constants. The shape thereby also fixes the path nibbles the
+// branch nodes select (asserted on Keccak-256(key)), so a compiled circuit proves slots of one trie
+// position only; the other sibling references are free private bytes. Extension nodes, embedded (under
+// 32 bytes) nodes and exclusion proofs (a zero value) are outside the supported shapes and are rejected
+// when the shape is taken. The key and node bits are asserted boolean off GF(2) only (assertInputBits).
+
+// EthGetProof is the part of an eth_getProof response a storage proof uses; keys and values are hex
+// quantities, proof nodes hex bytes.
+type EthGetProof struct {
+	StorageHash  string `json:"storageHash"`
+	StorageProof []struct {
+		Key   string   `json:"key"`
+		Value string   `json:"value"`
+		Proof []string `json:"proof"`
+	} `json:"storageProof"`
+}
+
+// LoadEthGetProof reads an eth_getProof response (its "result" object) from path.
+func LoadEthGetProof(path string) (*EthGetProof, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &EthGetProof{}
+	if err := json.Unmarshal(raw, p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// bitConst is a bit of a node or of the key path that the shape fixes.
+type bitConst struct{ Bit, Value int }
+
+// mptNodeShape is the shape of one proof node: its length in bytes, its constant bits, the pairs (node
+// bit, path bit) of the leaf path, the byte offset of the next node's reference (-1 in the leaf) and, in
+// the leaf, the byte offset of the value.
+type mptNodeShape struct {
+	Len   int
+	Fixed []bitConst
+	Path  [][2]int
+	Child int
+	Value int
+}
+
+// mptShape is the fixed shape a storageProofCircuit is compiled for: the nodes from the root down to the
+// leaf, the path nibbles the branch nodes select, as bits of Keccak-256(key), and the value length.
+type mptShape struct {
+	Nodes    []mptNodeShape
+	KeyPath  []bitConst
+	ValueLen int
+}
+
+type storageProofCircuit struct {
+	Root  [256]frontend.Variable `gnark:",public"`
+	Value []frontend.Variable    `gnark:",public"`
+	Key   [256]frontend.Variable
+	Nodes [][]frontend.Variable
+	// Shape is the proof shape the circuit is built for; build-time only.
+	Shape *mptShape `gnark:"-"`
+}
+
+// newStorageProofCircuit returns an unassigned circuit of the given shape.
+func newStorageProofCircuit(shape *mptShape) *storageProofCircuit {
+	c := &storageProofCircuit{Value: make([]frontend.Variable, 8*shape.ValueLen), Shape: shape}
+	c.Nodes = make([][]frontend.Variable, len(shape.Nodes))
+	for i, n := range shape.Nodes {
+		c.Nodes[i] = make([]frontend.Variable, 8*n.Len)
+	}
+	return c
+}
+
+func (t *storageProofCircuit) Define(api frontend.API) error {
+	if t.Shape == nil || len(t.Nodes) != len(t.Shape.Nodes) || len(t.Nodes) == 0 {
+		return errors.New("storageProofCircuit: no proof shape")
+	}
+	assertInputBits(api, t.Key[:])
+	path := keccakSponge(api, t.Key[:], Keccak256Config.RateBits, DomainKeccak, 256)
+	for _, c := range t.Shape.KeyPath {
+		api.AssertIsEqual(path[c.Bit], c.Value)
+	}
+	parent := t.Root[:]
+	for i, n := range t.Shape.Nodes {
+		node := t.Nodes[i]
+		if len(node) != 8*n.Len {
+			return fmt.Errorf("storageProofCircuit: node %d has %d bits, the shape %d", i, len(node), 8*n.Len)
+		}
+		assertInputBits(api, node)
+		digest := keccakSponge(api, node, Keccak256Config.RateBits, DomainKeccak, 256)
+		for j := range digest {
+			api.AssertIsEqual(digest[j], parent[j])
+		}
+		for _, c := range n.Fixed {
+			api.AssertIsEqual(node[c.Bit], c.Value)
+		}
+		for _, p := range n.Path {
+			api.AssertIsEqual(node[p[0]], path[p[1]])
+		}
+		if n.Child >= 0 {
+			parent = node[8*n.Child : 8*n.Child+256]
+			continue
+		}
+		for j := range t.Value {
+			api.AssertIsEqual(node[8*n.Value+j], t.Value[j])
+		}
+	}
+	return nil
+}
+
+// nibbleBit is the first bit (LSB first) of nibble d of a byte string: nibble d is the high nibble of
+// byte d/2 for even d.
+func nibbleBit(d int) int {
+	return 8*(d/2) + 4*(1-d%2)
+}
+
+// nibble returns nibble d of b, high nibble first.
+func nibble(b []byte, d int) int {
+	return int(b[d/2]>>(4*(1-d%2))) & 15
+}
+
+// fixBytes marks bytes [from, to) of node as constants of the shape.
+func (n *mptNodeShape) fixBytes(node []byte, from, to int) {
+	for i := from; i < to; i++ {
+		for j := 0; j < 8; j++ {
+			n.Fixed = append(n.Fixed, bitConst{8*i + j, int(node[i]>>j) & 1})
+		}
+	}
+}
+
+// fixNibble marks nibble d of node as a constant of the shape.
+func (n *mptNodeShape) fixNibble(node []byte, d int) {
+	for j := 0; j < 4; j++ {
+		n.Fixed = append(n.Fixed, bitConst{nibbleBit(d) + j, nibble(node, d) >> j & 1})
+	}
+}
+
+// linkNibble ties nibble d of the node to nibble k of the key path.
+func (n *mptNodeShape) linkNibble(d, k int) {
+	for j := 0; j < 4; j++ {
+		n.Path = append(n.Path, [2]int{nibbleBit(d) + j, nibbleBit(k) + j})
+	}
+}
+
+// storageProofShape takes the shape of proof, the nodes of a storage proof of key (32 bytes), checking
+// the proof off-circuit on the way: it must be a chain of branch nodes ending in the leaf of key, every
+// node referenced by the hash its parent holds. It returns the shape and the value bytes of the leaf.
+func storageProofShape(key []byte, proof [][]byte) (*mptShape, []byte, error) {
+	if len(proof) == 0 {
+		return nil, nil, errors.New("storage proof: no nodes")
+	}
+	path := crypto.Keccak256(key)
+	shape := &mptShape{}
+	var value []byte
+	depth := 0
+	for i, node := range proof {
+		content, rest, err := rlp.SplitList(node)
+		if err != nil || len(rest) != 0 {
+			return nil, nil, fmt.Errorf("storage proof: node %d is not an RLP list", i)
+		}
+		items, err := rlp.CountValues(content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("storage proof: node %d: %w", i, err)
+		}
+		n := mptNodeShape{Len: len(node), Child: -1}
+		n.fixBytes(node, 0, len(node)-len(content))
+		// item returns the next item of the node: its offset, its payload and its end
+		next := content
+		item := func() (int, []byte, int, error) {
+			start := len(node) - len(next)
+			kind, val, r, err := rlp.Split(next)
+			if err == nil && kind == rlp.List {
+				err = errors.New("list item")
+			}
+			next = r
+			return start, val, len(node) - len(r), err
+		}
+		switch {
+		case items == 17 && i < len(proof)-1:
+			if depth == 64 {
+				return nil, nil, fmt.Errorf("storage proof: node %d: branch node below the full path", i)
+			}
+			sel := nibble(path, depth)
+			for c := 0; c < 17; c++ {
+				start, val, end, err := item()
+				switch {
+				case err != nil:
+					return nil, nil, fmt.Errorf("storage proof: node %d child %d: %w", i, c, err)
+				case len(val) == 0:
+					n.fixBytes(node, start, end)
+				case len(val) == 32 && c < 16:
+					n.fixBytes(node, start, end-32)
+					if c == sel {
+						n.Child = end - 32
+					}
+				default:
+					return nil, nil, fmt.Errorf("storage proof: node %d child %d: %d-byte reference (embedded nodes and branch values are not supported)", i, c, len(val))
+				}
+			}
+			if n.Child < 0 {
+				return nil, nil, fmt.Errorf("storage proof: node %d: no child at nibble %x of the key path", i, sel)
+			}
+			if !bytes.Equal(crypto.Keccak256(proof[i+1]), node[n.Child:n.Child+32]) {
+				return nil, nil, fmt.Errorf("storage proof: node %d does not hash to the reference of node %d", i+1, i)
+			}
+			for j := 0; j < 4; j++ {
+				shape.KeyPath = append(shape.KeyPath, bitConst{nibbleBit(depth) + j, sel >> j & 1})
+			}
+			depth++
+		case items == 2 && i == len(proof)-1:
+			start, hp, end, err := item()
+			if err != nil || len(hp) == 0 || hp[0]>>4 != 2 && hp[0]>>4 != 3 {
+				return nil, nil, fmt.Errorf("storage proof: node %d is not a leaf (extension nodes are not supported)", i)
+			}
+			n.fixBytes(node, start, end-len(hp))
+			off := 2 * (end - len(hp))
+			n.fixNibble(node, off)
+			d := 2
+			if hp[0]>>4 == 3 {
+				d = 1
+			} else {
+				n.fixNibble(node, off+1)
+			}
+			for ; d < 2*len(hp); d++ {
+				if depth == 64 || nibble(hp, d) != nibble(path, depth) {
+					return nil, nil, fmt.Errorf("storage proof: node %d is the leaf of another key", i)
+				}
+				n.linkNibble(off+d, depth)
+				depth++
+			}
+			if depth != 64 {
+				return nil, nil, fmt.Errorf("storage proof: node %d is the leaf of another key", i)
+			}
+			start, enc, end, err := item()
+			if err != nil {
+				return nil, nil, fmt.Errorf("storage proof: node %d value: %w", i, err)
+			}
+			_, val, r, err := rlp.Split(enc)
+			if err != nil || len(val) == 0 || len(r) != 0 {
+				return nil, nil, fmt.Errorf("storage proof: node %d: leaf value is not an RLP string", i)
+			}
+			n.Value = end - len(val)
+			n.fixBytes(node, start, n.Value)
+			value = val
+			shape.ValueLen = len(val)
+		default:
+			return nil, nil, fmt.Errorf("storage proof: node %d of %d has %d items (supported: branch nodes, then a leaf)", i, len(proof), items)
+		}
+		shape.Nodes = append(shape.Nodes, n)
+	}
+	return shape, value, nil
+}
+
+// hexQuantity decodes a 0x-prefixed hex quantity into n big-endian bytes, or into its minimal bytes for
+// n = 0.
+func hexQuantity(s string, n int) ([]byte, error) {
+	x, ok := new(big.Int).SetString(strings.TrimPrefix(s, "0x"), 16)
+	if !ok || x.Sign() < 0 || n > 0 && x.BitLen() > 8*n {
+		return nil, fmt.Errorf("%q is not a hex quantity of at most %d bytes", s, n)
+	}
+	if n == 0 {
+		return x.Bytes(), nil
+	}
+	return x.FillBytes(make([]byte, n)), nil
+}
+
+// storageProofAssignment builds the assignment of storage proof k of p; its Shape is the shape of the
+// proof, from which newStorageProofCircuit builds the circuit to compile.
+func storageProofAssignment(p *EthGetProof, k int) (*storageProofCircuit, error) {
+	if k < 0 || k >= len(p.StorageProof) {
+		return nil, fmt.Errorf("storage proof %d: the response has %d", k, len(p.StorageProof))
+	}
+	sp := p.StorageProof[k]
+	root, err := hex.DecodeString(strings.TrimPrefix(p.StorageHash, "0x"))
+	if err != nil || len(root) != 32 {
+		return nil, fmt.Errorf("storage proof %d: storageHash %q is not 32 hex bytes", k, p.StorageHash)
+	}
+	key, err := hexQuantity(sp.Key, 32)
+	if err != nil {
+		return nil, fmt.Errorf("storage proof %d: key: %w", k, err)
+	}
+	want, err := hexQuantity(sp.Value, 0)
+	if err != nil {
+		return nil, fmt.Errorf("storage proof %d: value: %w", k, err)
+	}
+	if len(want) == 0 {
+		return nil, fmt.Errorf("storage proof %d: zero value (exclusion proofs are not supported)", k)
+	}
+	proof := make([][]byte, len(sp.Proof))
+	for i, s := range sp.Proof {
+		if proof[i], err = hex.DecodeString(strings.TrimPrefix(s, "0x")); err != nil {
+			return nil, fmt.Errorf("storage proof %d: node %d: %w", k, i, err)
+		}
+	}
+	shape, value, err := storageProofShape(key, proof)
+	if err != nil {
+		return nil, fmt.Errorf("storage proof %d: %w", k, err)
+	}
+	if !bytes.Equal(crypto.Keccak256(proof[0]), root) {
+		return nil, fmt.Errorf("storage proof %d: the first node does not hash to storageHash", k)
+	}
+	if !bytes.Equal(value, want) {
+		return nil, fmt.Errorf("storage proof %d: the leaf holds %x, the response says %x", k, value, want)
+	}
+	a := newStorageProofCircuit(shape)
+	putBits(a.Root[:], root)
+	putBits(a.Key[:], key)
+	putBits(a.Value, value)
+	for i, node := range proof {
+		putBits(a.Nodes[i], node)
+	}
+	return a, nil
+}
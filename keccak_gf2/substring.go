@@ -0,0 +1,134 @@
+package keccakgf2
+
+import (
+	"fmt"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// Bounded substring search:
+// ContainsBytes proves that a marker occurs somewhere in the first window bytes of a message without
+// revealing where: every candidate offset o (the marker lying entirely inside the window) gets a match bit,
+// the AND of the byte-equality bits of msg[o+i] and marker[i], and the result is the OR of the match bits.
+// Window and marker length are compile-time parameters; the offsets are all evaluated, so the cost is
+// (window - markerLen + 1) × markerLen × 8 of each comparison gate whatever the data (SubstringCost).
+// substringCircuit binds the search to a message by its public Keccak-256 digest: "the message hashing to
+// Out contains Marker in its first Window bytes".
+
+// Function Purpose:
+	// Equality of two bytes as a single bit, with CompareDigest on their 8 bits.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `a`, `b`: 8 bits each, LSB first
+// Outputs:
+	// - 1 iff a = b
+// Gate Count:
+	// 8 XOR + 8 NOT + 7 AND
+func ByteEqual(api frontend.API, a []frontend.Variable, b []frontend.Variable) frontend.Variable {
+	if len(a) != 8 || len(b) != 8 {
+		panic("ByteEqual: operands must be 8 bits")
+	}
+	return CompareDigest(api, a, b)
+}
+
+// Function Purpose:
+	// Whether marker occurs at some byte offset o with o + len(marker) <= window in msg.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `msg`: message bits, LSB first within each byte, at least window bytes
+	// - `marker`: marker bits, LSB first within each byte, 1..window bytes
+	// - `window`: number of leading message bytes searched
+// Outputs:
+	// - 1 iff the marker occurs inside the window
+// Gate Count:
+	// see SubstringCost: per offset markerLen ByteEqual and markerLen-1 AND, then offsets-1 OR (orBit)
+func ContainsBytes(api frontend.API, msg []frontend.Variable, marker []frontend.Variable, window int) frontend.Variable {
+	requireGF2(api, "ContainsBytes")
+	m := len(marker) / 8
+	if len(marker)%8 != 0 || m < 1 || m > window || len(msg) < 8*window {
+		panic(fmt.Sprintf("ContainsBytes: %d marker bits, %d message bits, window %d: need 1 <= marker bytes <= window <= message bytes", len(marker), len(msg), window))
+	}
+	matches := make([]frontend.Variable, window-m+1)
+	for o := range matches {
+		eq := make([]frontend.Variable, m)
+		for i := range eq {
+			eq[i] = ByteEqual(api, msg[8*(o+i):8*(o+i+1)], marker[8*i:8*(i+1)])
+		}
+		matches[o] = andTree(api, eq)
+	}
+	return orTree(api, matches)
+}
+
+// AssertContainsBytes asserts ContainsBytes(api, msg, marker, window).
+func AssertContainsBytes(api frontend.API, msg []frontend.Variable, marker []frontend.Variable, window int) {
+	api.AssertIsEqual(ContainsBytes(api, msg, marker, window), 1)
+}
+
+// orTree returns the OR of all bits in a, as a balanced binary tree of orBit.
+func orTree(api frontend.API, a []frontend.Variable) frontend.Variable {
+	for len(a) > 1 {
+		next := make([]frontend.Variable, 0, (len(a)+1)/2)
+		for i := 0; i+1 < len(a); i += 2 {
+			next = append(next, orBit(api, a[i], a[i+1]))
+		}
+		if len(a)%2 == 1 {
+			next = append(next, a[len(a)-1])
+		}
+		a = next
+	}
+	return a[0]
+}
+
+// SubstringCost is the gate count of ContainsBytes over non-constant inputs, as gateStats counts it: per
+// offset 8 XOR (Add), 8 NOT (Sub) and 8·markerLen-1 AND (Mul), then one Add and one Mul per orBit.
+func SubstringCost(window, markerLen int) GateStats {
+	offsets := window - markerLen + 1
+	return GateStats{
+		Add: offsets*8*markerLen + offsets - 1,
+		Sub: offsets * 8 * markerLen,
+		Mul: offsets*(8*markerLen-1) + offsets - 1,
+	}
+}
+
+// substringCircuit proves that the message P with Keccak-256 digest Out contains Marker within its first
+// Window bytes; build it with newSubstringCircuit. The message bits are asserted boolean off GF(2) only
+// (assertInputBits).
+type substringCircuit struct {
+	P      [64 * 8]frontend.Variable
+	Out    [256]frontend.Variable `gnark:",public"`
+	Marker []frontend.Variable    `gnark:",public"`
+	// Window is the number of leading message bytes searched; build-time only.
+	Window int `gnark:"-"`
+}
+
+// newSubstringCircuit returns an unassigned substringCircuit for a markerLen-byte marker.
+func newSubstringCircuit(window, markerLen int) *substringCircuit {
+	if markerLen < 1 || markerLen > window || window > 64 {
+		panic("newSubstringCircuit: need 1 <= markerLen <= window <= 64")
+	}
+	return &substringCircuit{Marker: make([]frontend.Variable, 8*markerLen), Window: window}
+}
+
+func (t *substringCircuit) Define(api frontend.API) error {
+	assertInputBits(api, t.P[:])
+	out := computeKeccak(api, t.P[:])
+	for j := range out {
+		api.AssertIsEqual(out[j], t.Out[j])
+	}
+	AssertContainsBytes(api, t.P[:], t.Marker, t.Window)
+	return nil
+}
+
+// substringAssignment assigns msg (64 bytes), its digest and marker to a circuit of the given window;
+// it does not check that the marker occurs.
+func substringAssignment(msg, marker []byte, window int) (*substringCircuit, error) {
+	if len(msg) != 64 {
+		return nil, fmt.Errorf("substring assignment: message of %d bytes, expected 64", len(msg))
+	}
+	c := newSubstringCircuit(window, len(marker))
+	putBits(c.P[:], msg)
+	digest := CircuitKeccak256(msg)
+	putBits(c.Out[:], digest[:])
+	putBits(c.Marker, marker)
+	return c, nil
+}
@@ -0,0 +1,88 @@
+package keccakgf2
+
+import (
+	"math/bits"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// CountBits is the number of bits needed to write any match count in [0, NHashes].
+var CountBits = bits.Len(uint(NHashes))
+
+// keccakThresholdCircuit is a demo of the soft comparison mode:
+// every instance's digest is compared with CompareDigest, and instead of requiring all of them to match
+// the circuit only asserts that at least MinMatches of the NHashes instances matched.
+// MinMatches is a public CountBits-bit unsigned integer, LSB first.
+type keccakThresholdCircuit struct {
+	P          [NHashes][64 * 8]frontend.Variable
+	Out        [NHashes][CheckBits]frontend.Variable `gnark:",public"`
+	MinMatches []frontend.Variable                   `gnark:",public"`
+}
+
+func newKeccakThresholdCircuit() *keccakThresholdCircuit {
+	return &keccakThresholdCircuit{MinMatches: make([]frontend.Variable, CountBits)}
+}
+
+func (t *keccakThresholdCircuit) Define(api frontend.API) error {
+	matched := make([]frontend.Variable, NHashes)
+	for i := 0; i < NHashes; i++ {
+		out := computeKeccak(api, t.P[i][:])
+		matched[i] = CompareDigest(api, out[:CheckBits], t.Out[i][:])
+	}
+	count := popCount(api, matched, CountBits)
+	// count >= MinMatches  <=>  count - MinMatches does not borrow
+	api.AssertIsEqual(subBorrow(api, count, t.MinMatches), 0)
+	return nil
+}
+
+// Function Purpose:
+	// Counts how many of the input bits are 1 and returns the count as a width-bit binary number (LSB first).
+	// Over GF(2) api.Add is XOR, so the count cannot be a field sum; it is accumulated with a ripple of half adders instead.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `a`: the bits to count
+	// - `width`: bit width of the result, must satisfy 2^width > len(a)
+// Outputs:
+	// - `acc`: width bits of popcount(a)
+// Gate Count:
+	// len(a) × width half adders = len(a) × width XOR gates + len(a) × width AND gates
+func popCount(api frontend.API, a []frontend.Variable, width int) []frontend.Variable {
+	requireGF2(api, "popCount")
+	acc := make([]frontend.Variable, width)
+	for j := 0; j < width; j++ {
+		acc[j] = 0
+	}
+	for _, x := range a {
+		carry := x
+		for j := 0; j < width; j++ {
+			// half adder: sum = acc ⊕ carry, carry' = acc ∧ carry
+			acc[j], carry = api.Add(acc[j], carry), api.Mul(acc[j], carry)
+		}
+	}
+	return acc
+}
+
+// Function Purpose:
+	// Returns the borrow out of the unsigned subtraction a - b, i.e. 1 iff a < b.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `a`, `b`: unsigned integers of the same bit width, LSB first
+// Outputs:
+	// - the final borrow bit
+// Gate Count:
+	// per bit: borrow' = (¬a ∧ b) ∨ (¬(a ⊕ b) ∧ borrow).
+	// The two terms are mutually exclusive (the first needs a ≠ b, the second a = b), so the OR is a plain XOR:
+	// 3 XOR gates + 2 AND gates per bit.
+func subBorrow(api frontend.API, a []frontend.Variable, b []frontend.Variable) frontend.Variable {
+	if len(a) != len(b) {
+		panic("subBorrow: width mismatch")
+	}
+	requireGF2(api, "subBorrow")
+	var borrow frontend.Variable = 0
+	for i := range a {
+		notA := api.Sub(1, a[i])
+		eq := api.Sub(1, api.Add(a[i], b[i]))
+		borrow = api.Add(api.Mul(notA, b[i]), api.Mul(eq, borrow))
+	}
+	return borrow
+}
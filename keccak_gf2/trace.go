@@ -0,0 +1,373 @@
+package keccakgf2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"math/rand"
+	"strings"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/field/gf2"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/irwg"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/layered"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/spec"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/verifier"
+	"github.com/consensys/gnark/frontend"
+)
+
+// Round traces:
+// A digest mismatch says that some round went wrong, not which. keccakF takes an optional round observer
+// (WithRoundObserver) that is handed the state after each of the 24 rounds; without it keccakF emits the
+// same gates and makes no extra allocations. The observed wires carry no values at build time:
+// circuitRoundTrace runs the gadgets on constants through the recording API of gatestats.go, where every
+// observed wire folds to a bit, which traces the gadgets but not a compiled circuit.
+//
+// TraceWitness traces the compiled circuit: it evaluates the layered circuit on a solved witness
+// (layereval.go) and reads the round states off its wires. ecgo does not record which wire carries which
+// state bit, so the wires are told apart by value: 63 assignments with random messages and contexts run
+// alongside the witness, and a wire whose 63 values are those of a state bit in the reference (or their
+// complement) carries that bit; its value on the witness is the traced one. Where the circuit keeps no
+// wire for the state after a round but does for the lanes B that the next χ reads, the state is
+// recovered by inverting π, ρ and θ. After the last round of the sponge only the squeezed bits are left
+// in the circuit; the others are marked Missing.
+//
+// RefRoundTrace is the same trace from the plain Go keccakP1600RefTrace, and WriteRoundTrace prints
+// either one in the same text form, so the two can be diffed round by round.
+
+// RoundObserver is called by keccakF with the round index (0..23) and the state after that round's ι,
+// lanes in keccakF's LayoutInternal order. The lanes are not written to afterwards.
+type RoundObserver func(round int, state [][]frontend.Variable)
+
+// StepObserver is called by keccakF with the round index, the step just applied ("theta", "rhoPi", "chi" or
+// "iota") and a copy of the state after it, lanes in LayoutInternal order; after "rhoPi" the state is the
+// permuted lanes B of the round. It is meant for step-by-step differential tests (see bitref.go).
+type StepObserver func(round int, step string, state [][]frontend.Variable)
+
+// PermOption is an option of keccakF and keccakP.
+type PermOption struct {
+	observer RoundObserver
+	step     StepObserver
+	chi      ChiSchedule
+}
+
+// WithRoundObserver makes keccakF call f after every round.
+func WithRoundObserver(f RoundObserver) PermOption {
+	return PermOption{observer: f}
+}
+
+// WithStepObserver makes keccakF call f after every step of every round.
+func WithStepObserver(f StepObserver) PermOption {
+	return PermOption{step: f}
+}
+
+// RoundState is the state after one round, lane x+5y at index x+5y (LayoutSpec), the layout of the
+// Keccak team's intermediate-value files.
+type RoundState struct {
+	Round int
+	Lanes [25]uint64
+	// Missing marks the bits of Lanes that no wire of the circuit carries (TraceWitness only); they are 0.
+	Missing [25]uint64
+}
+
+// circuitRoundTrace runs keccakF on state (LayoutSpec lanes) with opts through the recording API and
+// returns the observed state after every round.
+func circuitRoundTrace(state [25]uint64, opts ...PermOption) ([]RoundState, error) {
+	eval := &recordingAPI{field: gf2.ScalarField, stats: &GateStats{}}
+	var trace []RoundState
+	var err error
+	observe := WithRoundObserver(func(round int, s [][]frontend.Variable) {
+		rs := RoundState{Round: round}
+		for i, lane := range ConvertState(s, LayoutInternal, LayoutSpec) {
+			b, e := assignedBytes(lane)
+			if e != nil && err == nil {
+				err = fmt.Errorf("round %d lane %d: %w", round, i, e)
+			}
+			if e == nil {
+				rs.Lanes[i] = binary.LittleEndian.Uint64(b)
+			}
+		}
+		trace = append(trace, rs)
+	})
+	keccakF(eval, permState(permLanes(state)), append([]PermOption{observe}, opts...)...)
+	return trace, err
+}
+
+// RefRoundTrace is the round trace of Keccak-f[1600] on state (LayoutSpec lanes) from the plain Go reference.
+func RefRoundTrace(state [25]uint64) []RoundState {
+	var trace []RoundState
+	keccakP1600RefTrace(state, 24, func(round int, a [25]uint64) {
+		trace = append(trace, RoundState{Round: round, Lanes: a})
+	})
+	return trace
+}
+
+// TraceWitness returns the round traces of instance k of assignment z in w, a witness of the layered
+// keccak256Circuit c laid out as l, read off the wires of c: one trace per permutation of the sponge (the
+// context, if any, and the message span one or more blocks), in order. A round whose state the circuit
+// does not carry is an error, except for the bits of the last one that are not squeezed (see Missing).
+func TraceWitness(c *layered.RootCircuit, w *irwg.Witness, l verifier.Layout, z, k int) ([][]RoundState, error) {
+	if z < 0 || z >= w.NumWitnesses || k < 0 || k >= l.Instances {
+		return nil, fmt.Errorf("trace: no instance %d in assignment %d (%d assignments of %d instances)", k, z, w.NumWitnesses, l.Instances)
+	}
+	if !l.PublicMessages && w.NumInputsPerWitness != l.Instances*8*verifier.MessageBytes {
+		return nil, fmt.Errorf("trace: witness has %d private inputs per assignment, expected %d", w.NumInputsPerWitness, l.Instances*8*verifier.MessageBytes)
+	}
+	if _, err := verifier.PublicContexts(w, l); err != nil {
+		return nil, fmt.Errorf("trace: %w", err)
+	}
+	in, pub := witnessMasks(w, z)
+	// Bit 0 of every mask is the witness; bits 1..63 are the probe assignments, with random bits for the
+	// context and message k. The lanes of the sponge input are read back from the masks.
+	rnd := rand.New(rand.NewSource(1016))
+	probe := func(v *uint64) { *v = *v&1 | rnd.Uint64()&^1 }
+	input := make([]*uint64, 0, 8*(l.ContextBytes+verifier.MessageBytes))
+	for i := 0; i < 8*l.ContextBytes; i++ {
+		input = append(input, &pub[l.ContextPosition(i)])
+	}
+	for i := 0; i < 8*verifier.MessageBytes; i++ {
+		if l.PublicMessages {
+			input = append(input, &pub[l.MessagePosition(k, i)])
+		} else {
+			input = append(input, &in[k*8*verifier.MessageBytes+i])
+		}
+	}
+	for _, v := range input {
+		probe(v)
+	}
+	blocks, err := probeTraces(input)
+	if err != nil {
+		return nil, fmt.Errorf("trace: %w", err)
+	}
+
+	layers, err := evalLayers(c, in, pub)
+	if err != nil {
+		return nil, fmt.Errorf("trace: %w", err)
+	}
+	// wires maps the probe values of a wire (bit 0 cleared) to its value on the witness.
+	wires := map[uint64]uint64{}
+	for _, layer := range layers {
+		for _, v := range layer {
+			if _, ok := wires[v&^1]; !ok {
+				wires[v&^1] = v & 1
+			}
+		}
+	}
+	read := func(sig *[1600]uint64) (lanes, missing [25]uint64) {
+		for i, s := range sig {
+			v, ok := wires[s&^1]
+			if !ok {
+				v, ok = wires[^s&^1]
+				v ^= 1
+			}
+			if !ok {
+				missing[i/64] |= 1 << (i % 64)
+				continue
+			}
+			lanes[i/64] |= v << (i % 64)
+		}
+		return lanes, missing
+	}
+
+	traces := make([][]RoundState, len(blocks))
+	for b, blk := range blocks {
+		for r := 0; r < 24; r++ {
+			rs := RoundState{Round: r}
+			rs.Lanes, rs.Missing = read(&blk.after[r])
+			if rs.Missing != ([25]uint64{}) && blk.next[r] != nil {
+				next, missing := read(blk.next[r])
+				if missing == ([25]uint64{}) {
+					rs.Lanes, rs.Missing = thetaInverseRef(rhoPiInverseRef(next)), missing
+					if r == 23 {
+						// B of the next block's first round: undo the absorption of that block
+						for i, m := range blocks[b+1].block {
+							rs.Lanes[i] ^= m
+						}
+					}
+				}
+			}
+			if rs.Missing != ([25]uint64{}) && (b < len(blocks)-1 || r < 23) {
+				return nil, fmt.Errorf("trace: block %d round %d: %d state bits are not wires of the circuit", b, r, onesCount(rs.Missing))
+			}
+			traces[b] = append(traces[b], rs)
+		}
+	}
+	return traces, nil
+}
+
+// probeBlock holds, for one permutation of the sponge, the state bits of the 64 assignments of the
+// masks: after[r][64i+j] has bit a of the lane-i bit j after round r of assignment a, and next[r] that of
+// the lanes B the following χ reads (nil after the last round of the sponge). block is the witness's
+// absorbed block, LayoutSpec lanes.
+type probeBlock struct {
+	block [25]uint64
+	after [24][1600]uint64
+	next  [24]*[1600]uint64
+}
+
+// probeTraces runs the reference sponge of Keccak-256 on the 64 assignments of input, the masks of the
+// context and message bits, and returns the state bits of every permutation.
+func probeTraces(input []*uint64) ([]*probeBlock, error) {
+	var blocks []*probeBlock
+	for a := 0; a < 64; a++ {
+		bitsIn := make([]frontend.Variable, len(input))
+		for i, v := range input {
+			bitsIn[i] = int(*v >> a & 1)
+		}
+		padded, err := assignedBytes(Pad101(bitsIn, Keccak256Config.RateBits, DomainKeccak))
+		if err != nil {
+			return nil, err
+		}
+		rate := rateOf(Keccak256Config.RateBits)
+		var state [25]uint64
+		for n := 0; n*rate.Bytes < len(padded); n++ {
+			if a == 0 {
+				blocks = append(blocks, &probeBlock{})
+			}
+			blk := blocks[n]
+			var block [25]uint64
+			for i := 0; i < rate.Lanes; i++ {
+				block[i] = binary.LittleEndian.Uint64(padded[n*rate.Bytes+8*i:])
+				state[i] ^= block[i]
+			}
+			if a == 0 {
+				blk.block = block
+			}
+			if n > 0 {
+				// the previous block's last round is followed by this block's first χ
+				if blocks[n-1].next[23] == nil {
+					blocks[n-1].next[23] = new([1600]uint64)
+				}
+				spreadState(blocks[n-1].next[23], thetaRhoPiRef(state), a)
+			}
+			state = keccakP1600RefTrace(state, 24, func(r int, s [25]uint64) {
+				spreadState(&blk.after[r], s, a)
+				if r < 23 {
+					if blk.next[r] == nil {
+						blk.next[r] = new([1600]uint64)
+					}
+					spreadState(blk.next[r], thetaRhoPiRef(s), a)
+				}
+			})
+		}
+	}
+	return blocks, nil
+}
+
+// spreadState sets bit a of sig[64i+j] to bit j of lane i of s.
+func spreadState(sig *[1600]uint64, s [25]uint64, a int) {
+	for i, lane := range s {
+		for j := 0; j < 64; j++ {
+			sig[64*i+j] |= (lane >> j & 1) << a
+		}
+	}
+}
+
+// rhoPiInverseRef undoes ρ and π: the lanes A whose rotated, permuted lanes are b.
+func rhoPiInverseRef(b [25]uint64) [25]uint64 {
+	var a [25]uint64
+	for i := 0; i < 25; i++ {
+		a[i] = bits.RotateLeft64(b[spec.PiPermutation[i]], -spec.RotationOffsets[i])
+	}
+	return a
+}
+
+// thetaParity is the effect of θ on the column parities: every column gets D[x] five times, so C'[x] =
+// C[x] ⊕ C[x-1] ⊕ rot(C[x+1], 1).
+func thetaParity(c [5]uint64) [5]uint64 {
+	var res [5]uint64
+	for x := 0; x < 5; x++ {
+		res[x] = c[x] ^ c[(x+4)%5] ^ bits.RotateLeft64(c[(x+1)%5], 1)
+	}
+	return res
+}
+
+// thetaParityInverse is the inverse of thetaParity as a 320×320 bit matrix, row i (bit i%64 of column
+// i/64 of the result) as a mask of the input bits, by Gauss-Jordan elimination.
+var thetaParityInverse = func() [320][5]uint64 {
+	var m, inv [320][5]uint64
+	for j := 0; j < 320; j++ {
+		var e [5]uint64
+		e[j/64] = 1 << (j % 64)
+		img := thetaParity(e)
+		for i := 0; i < 320; i++ {
+			m[i][j/64] |= (img[i/64] >> (i % 64) & 1) << (j % 64)
+		}
+		inv[j][j/64] = 1 << (j % 64)
+	}
+	for col := 0; col < 320; col++ {
+		p := col
+		for m[p][col/64]>>(col%64)&1 == 0 {
+			p++
+		}
+		m[col], m[p] = m[p], m[col]
+		inv[col], inv[p] = inv[p], inv[col]
+		for i := range m {
+			if i != col && m[i][col/64]>>(col%64)&1 == 1 {
+				for x := 0; x < 5; x++ {
+					m[i][x] ^= m[col][x]
+					inv[i][x] ^= inv[col][x]
+				}
+			}
+		}
+	}
+	return inv
+}()
+
+// thetaInverseRef undoes θ: from the column parities of a it solves those of the state θ was applied
+// to, whose D is then added back.
+func thetaInverseRef(a [25]uint64) [25]uint64 {
+	var parity, c [5]uint64
+	for x := 0; x < 5; x++ {
+		parity[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+	}
+	for i, row := range thetaParityInverse {
+		n := 0
+		for x := 0; x < 5; x++ {
+			n += bits.OnesCount64(row[x] & parity[x])
+		}
+		c[i/64] |= uint64(n&1) << (i % 64)
+	}
+	for x := 0; x < 5; x++ {
+		d := c[(x+4)%5] ^ bits.RotateLeft64(c[(x+1)%5], 1)
+		for y := 0; y < 5; y++ {
+			a[x+5*y] ^= d
+		}
+	}
+	return a
+}
+
+// onesCount is the number of bits set in s.
+func onesCount(s [25]uint64) int {
+	n := 0
+	for _, lane := range s {
+		n += bits.OnesCount64(lane)
+	}
+	return n
+}
+
+// WriteRoundTrace prints a round trace to w: a "round N" line per round followed by the 25 lanes in hex,
+// one row y of lanes (x = 0..4) per line, with "?" for a digit holding Missing bits.
+func WriteRoundTrace(w io.Writer, trace []RoundState) error {
+	for _, rs := range trace {
+		if _, err := fmt.Fprintf(w, "round %d\n", rs.Round); err != nil {
+			return err
+		}
+		for y := 0; y < 5; y++ {
+			var row strings.Builder
+			for x := 0; x < 5; x++ {
+				digits := []byte(fmt.Sprintf("%016x", rs.Lanes[x+5*y]))
+				for d := range digits {
+					if rs.Missing[x+5*y]>>(4*(15-d))&0xf != 0 {
+						digits[d] = '?'
+					}
+				}
+				row.WriteString(" " + string(digits))
+			}
+			if _, err := fmt.Fprintf(w, " %s\n", row.String()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package keccakgf2
+
+import (
+	"fmt"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// Fiat–Shamir transcript:
+// A Transcript derives challenges in-circuit from the public data appended to it, and NativeTranscript is
+// the same transcript in plain Go for the prover and for external implementations. Both run the Duplex
+// with rate TranscriptRate (1344 bits, 168 bytes) and domain byte TranscriptDomain (0x1F). Every operation
+// is encoded as a byte string, with the SP 800-185 encodings (left_encode of the length in bits, then the
+// bytes) and bits LSB first within each byte as everywhere in this package:
+//
+//	NewTranscript(protocol):  0x00 || encode_string(protocol)
+//	Append(label, bits):      0x01 || encode_string(label) || left_encode(len(bits)) || bits, zero-filled to a byte
+//	Challenge(label, n):      0x02 || encode_string(label) || left_encode(n)
+//
+// The encodings are appended to a pending byte string; nothing is permuted until a challenge. Challenge
+// then cuts the pending string (its own encoding last) into chunks of 167 bytes, the most a duplex call
+// takes, and duplexes them in order, the last chunk (1..167 bytes) returning the first min(n, 1344) bits
+// of the challenge; longer challenges continue with duplex calls on the empty input, 1344 bits each.
+// The pending string is then empty. Since the labels, the lengths and the protocol name are fixed when the
+// circuit is built, only the appended data bits are wires.
+
+// TranscriptRate and TranscriptDomain are the duplex rate (bits) and domain byte of Transcript.
+const (
+	TranscriptRate   = Shake128Rate
+	TranscriptDomain = DomainSHAKE
+)
+
+// Transcript operation bytes, the first byte of every encoded operation.
+const (
+	transcriptInit      = 0x00
+	transcriptAppend    = 0x01
+	transcriptChallenge = 0x02
+)
+
+// transcriptHeader is op || encode_string(label) || left_encode(n).
+func transcriptHeader(op byte, label string, n int) []byte {
+	return append(append([]byte{op}, encodeString([]byte(label))...), leftEncode(uint64(n))...)
+}
+
+// Transcript is an in-circuit Fiat–Shamir transcript; build it with NewTranscript.
+type Transcript struct {
+	duplex  *Duplex
+	pending []frontend.Variable // encoded operations since the last challenge, whole bytes
+}
+
+// NewTranscript returns a transcript for the given protocol name, fixed at circuit-build time.
+func NewTranscript(protocol string) *Transcript {
+	return &Transcript{
+		duplex:  NewDuplex(TranscriptRate, TranscriptDomain),
+		pending: constBits(append([]byte{transcriptInit}, encodeString([]byte(protocol))...)),
+	}
+}
+
+// Function Purpose:
+	// Append labelled data to the transcript: the encoding is only recorded, the next Challenge absorbs it.
+// Inputs:
+	// - `api`: the constraint system builder (unused until the next Challenge)
+	// - `label`: label of the data, fixed at circuit-build time
+	// - `bits`: the data, any number of bits
+// Outputs:
+	// - none
+// Gate Count:
+	// none here; the data bits take part in the keccakF calls of the next Challenge
+func (t *Transcript) Append(api frontend.API, label string, bits []frontend.Variable) {
+	t.pending = append(t.pending, constBits(transcriptHeader(transcriptAppend, label, len(bits)))...)
+	t.pending = append(append(t.pending, bits...), zeroBits((8-len(bits)%8)%8)...)
+}
+
+// Function Purpose:
+	// Derive an nBits-bit challenge from everything appended so far, labelled with label.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `label`: label of the challenge, fixed at circuit-build time
+	// - `nBits`: challenge length in bits, positive
+// Outputs:
+	// - nBits challenge bits
+// Gate Count:
+	// one keccakF per started 167 bytes of pending encoding and one per further 1344 challenge bits
+func (t *Transcript) Challenge(api frontend.API, label string, nBits int) []frontend.Variable {
+	if nBits < 1 {
+		panic(fmt.Sprintf("Transcript: %d-bit challenge", nBits))
+	}
+	pending := append(t.pending, constBits(transcriptHeader(transcriptChallenge, label, nBits))...)
+	t.pending = nil
+	chunk := TranscriptRate - 8
+	for len(pending) > chunk {
+		t.duplex.DuplexCall(api, pending[:chunk], 0)
+		pending = pending[chunk:]
+	}
+	var out []frontend.Variable
+	for {
+		take := nBits - len(out)
+		if take > TranscriptRate {
+			take = TranscriptRate
+		}
+		out = append(out, t.duplex.DuplexCall(api, pending, take)...)
+		if len(out) == nBits {
+			return out
+		}
+		pending = nil
+	}
+}
+
+// NativeTranscript is Transcript in plain Go, bit for bit; build it with NewNativeTranscript. Bits are
+// plain 0/1 ints.
+type NativeTranscript struct {
+	duplex  duplexRef
+	pending []byte
+}
+
+// NewNativeTranscript returns the plain-Go transcript of NewTranscript(protocol).
+func NewNativeTranscript(protocol string) *NativeTranscript {
+	return &NativeTranscript{
+		duplex:  duplexRef{rate: TranscriptRate / 8, domainSep: TranscriptDomain},
+		pending: append([]byte{transcriptInit}, encodeString([]byte(protocol))...),
+	}
+}
+
+// Append is Transcript.Append; every bit must be 0 or 1.
+func (t *NativeTranscript) Append(label string, bits []int) {
+	t.pending = append(t.pending, transcriptHeader(transcriptAppend, label, len(bits))...)
+	data := make([]byte, (len(bits)+7)/8)
+	for i, b := range bits {
+		if b != 0 && b != 1 {
+			panic(fmt.Sprintf("NativeTranscript: bit %d is %d", i, b))
+		}
+		data[i/8] |= byte(b) << (i % 8)
+	}
+	t.pending = append(t.pending, data...)
+}
+
+// Challenge is Transcript.Challenge.
+func (t *NativeTranscript) Challenge(label string, nBits int) []int {
+	if nBits < 1 {
+		panic(fmt.Sprintf("NativeTranscript: %d-bit challenge", nBits))
+	}
+	pending := append(t.pending, transcriptHeader(transcriptChallenge, label, nBits)...)
+	t.pending = nil
+	chunk := TranscriptRate/8 - 1
+	for len(pending) > chunk {
+		t.duplex.call(pending[:chunk], 0)
+		pending = pending[chunk:]
+	}
+	out := t.duplex.call(pending, TranscriptRate/8)
+	for len(out)*8 < nBits {
+		out = append(out, t.duplex.call(nil, TranscriptRate/8)...)
+	}
+	bits := make([]int, nBits)
+	for i := range bits {
+		bits[i] = int(out[i/8]>>(i%8)) & 1
+	}
+	return bits
+}
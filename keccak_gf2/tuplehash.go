@@ -0,0 +1,81 @@
+package keccakgf2
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// Function Purpose:
+	// TupleHash128 (NIST SP 800-185, section 5):
+	//   newX = encode_string(X[0]) || ... || encode_string(X[n-1]) || right_encode(L)
+	//   TupleHash128(X, L, S) = cSHAKE128(newX, L, "TupleHash", S)
+	// Element lengths are fixed at circuit-build time, so every length prefix is a constant;
+	// the element bits themselves may be private or public wires.
+// Inputs:
+	// - `api`: the constraint system builder
+	// - `elems`: tuple elements, each a byte-aligned bit slice (LSB first within each byte); empty elements are allowed
+	// - `outputBits`: L, the output length in bits
+	// - `s`: customization string
+// Outputs:
+	// - `outputBits` digest bits
+// Gate Count:
+	// that of CShake128 over newX: the length prefixes and right_encode(L) are constant bits, which cost
+	// gates only through the extra blocks they may add
+func TupleHash128(api frontend.API, elems [][]frontend.Variable, outputBits int, s []byte) []frontend.Variable {
+	return tupleHash(api, elems, Shake128Rate, outputBits, s)
+}
+
+// TupleHash256 is TupleHash128 over cSHAKE256 (rate 1088).
+func TupleHash256(api frontend.API, elems [][]frontend.Variable, outputBits int, s []byte) []frontend.Variable {
+	return tupleHash(api, elems, Shake256Rate, outputBits, s)
+}
+
+func tupleHash(api frontend.API, elems [][]frontend.Variable, rate int, outputBits int, s []byte) []frontend.Variable {
+	newX := []frontend.Variable{}
+	for _, e := range elems {
+		if len(e)%8 != 0 {
+			panic("TupleHash: tuple elements must be byte aligned")
+		}
+		// encode_string(e) = left_encode(bit length of e) || e
+		newX = append(newX, constBits(leftEncode(uint64(len(e))))...)
+		newX = append(newX, e...)
+	}
+	newX = append(newX, constBits(rightEncode(uint64(outputBits)))...)
+	return cShake(api, newX, rate, []byte("TupleHash"), s, outputBits)
+}
+
+// Function Purpose:
+	// TupleHash128 over elements whose lengths are witnesses: element i is the first lens[i] bytes of elems[i], so
+	// a circuit can hash private strings of any length up to its buffers. The length prefixes are encoded in
+	// circuit (encode_string through leftEncodeVar) and cSHAKE128 absorbs the variable-length newX.
+// Inputs:
+	// - `api`: the constraint system builder, over GF(2)
+	// - `elems`: element buffers, each byte aligned (LSB first within each byte); the bytes past the length are ignored
+	// - `lens`: the element lengths in bytes, LSB first; a length above its buffer fails an assertion
+	// - `outputBits`: L, the output length in bits
+	// - `s`: customization string
+// Outputs:
+	// - `outputBits` digest bits, those of TupleHash128 over the truncated elements
+// Gate Count:
+	// one keccakF per block the longest newX needs, plus the selectors, the length encodings, the
+	// concatenation of newX (quadratic in the element lengths) and the variable padding (see cShakeVar)
+func TupleHash128Var(api frontend.API, elems [][]frontend.Variable, lens [][]frontend.Variable, outputBits int, s []byte) []frontend.Variable {
+	return tupleHashVar(api, elems, lens, Shake128Rate, outputBits, s)
+}
+
+// TupleHash256Var is TupleHash128Var over cSHAKE256 (rate 1088).
+func TupleHash256Var(api frontend.API, elems [][]frontend.Variable, lens [][]frontend.Variable, outputBits int, s []byte) []frontend.Variable {
+	return tupleHashVar(api, elems, lens, Shake256Rate, outputBits, s)
+}
+
+func tupleHashVar(api frontend.API, elems [][]frontend.Variable, lens [][]frontend.Variable, rate int, outputBits int, s []byte) []frontend.Variable {
+	requireGF2(api, "TupleHashVar")
+	if len(lens) != len(elems) {
+		panic("TupleHashVar: one length per element")
+	}
+	newX := constVarBytes(nil)
+	for i, e := range elems {
+		newX = appendVarBytes(api, newX, encodeStringVar(api, truncateVarBytes(api, e, lens[i]), lens[i]))
+	}
+	newX = appendVarBytes(api, newX, constVarBytes(rightEncode(uint64(outputBits))))
+	return cShakeVar(api, newX, rate, []byte("TupleHash"), s, outputBits)
+}
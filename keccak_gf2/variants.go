@@ -0,0 +1,175 @@
+package keccakgf2
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/field/gf2"
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/verifier"
+	"github.com/consensys/gnark/frontend"
+	"golang.org/x/crypto/sha3"
+)
+
+// Digest variants:
+// The sponge gadgets of this package differ in rate, domain byte and output length only, so one batch
+// circuit can prove digests of several of them side by side. digestVariants names every variant the
+// -variants flag accepts, with its gadget and its x/crypto reference. A variantBatchCircuit hashes the
+// 64-byte message of instance i with the variant Variants[i]; its public inputs are the digests back to
+// back, each as long as its variant's output. BuildVariantBatch cycles the selected variants over the -n
+// instances, so "-variants keccak256,keccak384,sha3-512" batch-proves mixed 256-, 384- and 512-bit digests.
+
+// digestVariant is one hash the variant batch can prove.
+type digestVariant struct {
+	Config KeccakConfig
+	Hash   func(frontend.API, []frontend.Variable) []frontend.Variable
+	Sum    func([]byte) []byte // x/crypto reference
+}
+
+// digestVariants maps the names of -variants to their variants.
+var digestVariants = map[string]digestVariant{
+	"keccak256": {Keccak256Config, computeKeccak, func(msg []byte) []byte { h := sha3.NewLegacyKeccak256(); h.Write(msg); return h.Sum(nil) }},
+	"keccak384": {Keccak384Config, Keccak384, legacyKeccak384Ref},
+	"keccak512": {Keccak512Config, Keccak512, func(msg []byte) []byte { h := sha3.NewLegacyKeccak512(); h.Write(msg); return h.Sum(nil) }},
+	"sha3-224":  {Sha3_224Config, Sha3_224, func(msg []byte) []byte { d := sha3.Sum224(msg); return d[:] }},
+	"sha3-256":  {Sha3_256Config, Sha3_256, func(msg []byte) []byte { d := sha3.Sum256(msg); return d[:] }},
+	"sha3-384":  {Sha3_384Config, Sha3_384, func(msg []byte) []byte { d := sha3.Sum384(msg); return d[:] }},
+	"sha3-512":  {Sha3_512Config, Sha3_512, func(msg []byte) []byte { d := sha3.Sum512(msg); return d[:] }},
+}
+
+// ParseVariants splits the comma-separated variant names of -variants and checks each of them.
+func ParseVariants(s string) ([]string, error) {
+	names := strings.Split(s, ",")
+	for _, name := range names {
+		if _, ok := digestVariants[name]; !ok {
+			known := make([]string, 0, len(digestVariants))
+			for k := range digestVariants {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown digest variant %q (%s)", name, strings.Join(known, ", "))
+		}
+	}
+	return names, nil
+}
+
+// variantBatchCircuit proves that Out[i] is the Variants[i] digest of P[i]; build it with
+// newVariantBatchCircuit. The message bits are asserted boolean off GF(2) only (assertInputBits).
+type variantBatchCircuit struct {
+	P   [][64 * 8]frontend.Variable
+	Out [][]frontend.Variable `gnark:",public"`
+	// Variants names the hash of every instance (digestVariants); build-time only.
+	Variants []string `gnark:"-"`
+}
+
+// newVariantBatchCircuit returns an unassigned variantBatchCircuit with one instance per variant name.
+func newVariantBatchCircuit(variants []string) *variantBatchCircuit {
+	if len(variants) < 1 {
+		panic("variant batch circuit: at least one instance")
+	}
+	c := &variantBatchCircuit{P: make([][64 * 8]frontend.Variable, len(variants)), Out: make([][]frontend.Variable, len(variants)), Variants: variants}
+	for i, name := range variants {
+		v, ok := digestVariants[name]
+		if !ok {
+			panic(fmt.Sprintf("variant batch circuit: unknown digest variant %q", name))
+		}
+		c.Out[i] = make([]frontend.Variable, v.Config.OutputBits)
+	}
+	return c
+}
+
+func (t *variantBatchCircuit) Define(api frontend.API) error {
+	for i := range t.P {
+		v, ok := digestVariants[t.Variants[i]]
+		if !ok {
+			return fmt.Errorf("variantBatchCircuit: unknown digest variant %q", t.Variants[i])
+		}
+		assertInputBits(api, t.P[i][:])
+		out := memorizedCall(api, v.Hash, t.P[i][:])
+		for j := range out {
+			api.AssertIsEqual(out[j], t.Out[i][j])
+		}
+	}
+	return nil
+}
+
+// variantBatchAssignment assigns msgs (64 bytes each) and their x/crypto digests, msgs[k] hashed with
+// variants[k], to a circuit of variants.
+func variantBatchAssignment(variants []string, msgs [][]byte) (*variantBatchCircuit, error) {
+	if len(msgs) != len(variants) {
+		return nil, fmt.Errorf("variant assignment: %d messages for %d instances", len(msgs), len(variants))
+	}
+	c := newVariantBatchCircuit(variants)
+	for k, msg := range msgs {
+		if len(msg) != 64 {
+			return nil, fmt.Errorf("variant assignment: message %d has %d bytes, expected 64", k, len(msg))
+		}
+		putBits(c.P[k][:], msg)
+		putBits(c.Out[k], digestVariants[variants[k]].Sum(msg))
+	}
+	return c, nil
+}
+
+// instanceVariants cycles variants over n instances.
+func instanceVariants(variants []string, n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = variants[i%len(variants)]
+	}
+	return out
+}
+
+// BuildVariantBatch compiles the variant batch circuit of o (o.Variants cycled over o.Instances) into
+// circuit.txt, solves 16 random assignments into witness.env and checks that batch through the verifier
+// package, like BuildBatch. The circuit has no layout.json: its digests are not all CheckBits long.
+func BuildVariantBatch(o Options) error {
+	variants := instanceVariants(o.Variants, o.Instances)
+	template := newVariantBatchCircuit(variants)
+	built, err := compileBuilt(gf2.ScalarField, template, ".", "variants")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile("circuit.txt", built.Circuit.Serialize(), 0o644); err != nil {
+		return err
+	}
+	rnd := o.Reader()
+	assignments := make([]frontend.Circuit, 16)
+	indices := make([]int, len(assignments))
+	for z := range assignments {
+		msgs := make([][]byte, len(variants))
+		for k := range msgs {
+			msgs[k] = make([]byte, 64)
+			if _, err := io.ReadFull(rnd, msgs[k]); err != nil {
+				return err
+			}
+		}
+		a, err := variantBatchAssignment(variants, msgs)
+		if err != nil {
+			return err
+		}
+		assignments[z], indices[z] = a, z
+	}
+	env, err := solveBatch(newCheckedSolver(built.Solver, gf2.ScalarField, template), assignments, indices)
+	if err != nil {
+		return fmt.Errorf("solving: %w", err)
+	}
+	if err := os.WriteFile("witness.env", env.Serialize(), 0o644); err != nil {
+		return err
+	}
+	vc, _, err := verifier.LoadCircuit("circuit.txt")
+	if err != nil {
+		return err
+	}
+	venv, err := verifier.LoadEnvelope("witness.env")
+	if err != nil {
+		return err
+	}
+	if err := expectBatch(vc, venv); err != nil {
+		return err
+	}
+	logger.Infof("built and checked a batch of %d assignments (digests %s) into circuit.txt and witness.env",
+		len(assignments), strings.Join(variants, ","))
+	return nil
+}
@@ -0,0 +1,157 @@
+package verifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/irwg"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/layered"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/test"
+)
+
+// Instance bundles:
+// The instances of an assignment are independent and the input layer lists them in instance order:
+// the private inputs are the Instances messages back to back, the public inputs the Instances digests
+// followed by the context (see Layout). The inputs of instance k therefore are, as they stand, the whole
+// input layer of the single-instance circuit (Layout{Instances: 1}) over the same context, so a batch
+// statement can be narrowed to one instance by slicing, without the messages or a new solver run.
+// With public messages the message of instance k is cut out of the public inputs the same way.
+// An InstanceBundle is that slice with everything a verifier of the single-instance circuit needs.
+
+// InstanceBundle is the self-contained statement of one instance of a batch.
+type InstanceBundle struct {
+	// Assignment is the caller index (Envelope.Indices) of the source assignment, Instance the instance
+	// within it and Batch the layout of the source batch.
+	Assignment int    `json:"assignment"`
+	Instance   int    `json:"instance"`
+	Batch      Layout `json:"batch"`
+	// Digest and Context are the public inputs of the instance, as PublicDigests and PublicContexts read them.
+	Digest  []int  `json:"digest"`
+	Context []byte `json:"context"`
+	// Layout describes the public inputs of the single-instance circuit the bundle verifies against.
+	Layout *Descriptor `json:"layout"`
+	// Witness is the serialized single-assignment Envelope of the single-instance circuit.
+	Witness []byte `json:"witness"`
+}
+
+// ExtractInstance cuts instance k of the assignment with caller index assignment out of e, a batch laid
+// out as l. fingerprint is the Fingerprint of the single-instance circuit (l with Instances = 1).
+func ExtractInstance(e *Envelope, l Layout, assignment int, k int, fingerprint string) (*InstanceBundle, error) {
+	w := e.Witness
+	if k < 0 || k >= l.Instances {
+		return nil, fmt.Errorf("instance %d is outside the %d instances of the layout", k, l.Instances)
+	}
+	if w.NumPublicInputsPerWitness != l.publicInputs() {
+		return nil, fmt.Errorf("witness has %d public inputs per assignment, layout expects %d", w.NumPublicInputsPerWitness, l.publicInputs())
+	}
+	if w.NumInputsPerWitness%l.Instances != 0 {
+		return nil, fmt.Errorf("witness has %d private inputs per assignment, not a multiple of %d instances", w.NumInputsPerWitness, l.Instances)
+	}
+	z := -1
+	for i, idx := range e.Indices {
+		if idx == assignment {
+			z = i
+		}
+	}
+	if z < 0 {
+		return nil, fmt.Errorf("the batch does not hold assignment %d", assignment)
+	}
+	single := Layout{Instances: 1, CheckBits: l.CheckBits, ContextBytes: l.ContextBytes, PublicMessages: l.PublicMessages}
+	per := w.NumInputsPerWitness + w.NumPublicInputsPerWitness
+	private := w.Values[z*per : z*per+w.NumInputsPerWitness]
+	public := w.Values[z*per+w.NumInputsPerWitness : (z+1)*per]
+	message := w.NumInputsPerWitness / l.Instances
+
+	var values []*big.Int
+	values = append(values, private[k*message:(k+1)*message]...)
+	values = append(values, public[l.DigestPosition(k, 0):l.DigestPosition(k+1, 0)]...)
+	values = append(values, public[l.ContextPosition(0):l.ContextPosition(8*l.ContextBytes)]...)
+	if l.PublicMessages {
+		values = append(values, public[l.MessagePosition(k, 0):l.MessagePosition(k+1, 0)]...)
+	}
+	sw := &irwg.Witness{
+		NumWitnesses:              1,
+		NumInputsPerWitness:       message,
+		NumPublicInputsPerWitness: single.publicInputs(),
+		Field:                     w.Field,
+		Values:                    values,
+	}
+	digests, err := PublicDigests(sw, single)
+	if err != nil {
+		return nil, err
+	}
+	contexts, err := PublicContexts(sw, single)
+	if err != nil {
+		return nil, err
+	}
+	return &InstanceBundle{
+		Assignment: assignment,
+		Instance:   k,
+		Batch:      l,
+		Digest:     digests[0][0],
+		Context:    contexts[0],
+		Layout:     single.Describe(fingerprint),
+		Witness:    (&Envelope{Indices: []int{assignment}, Witness: sw}).Serialize(),
+	}, nil
+}
+
+// Verify checks b against c, the single-instance circuit with the given fingerprint: the bundle must
+// be meant for c, its witness must satisfy c, and its Digest and Context must be the witness's public inputs.
+func (b *InstanceBundle) Verify(c *layered.RootCircuit, fingerprint string) error {
+	if b.Layout == nil || b.Layout.Circuit != fingerprint {
+		return fmt.Errorf("instance bundle is not for circuit %s", fingerprint)
+	}
+	e, err := ParseEnvelope(b.Witness)
+	if err != nil {
+		return err
+	}
+	if e.Witness.NumWitnesses != 1 {
+		return fmt.Errorf("instance bundle holds %d assignments, expected 1", e.Witness.NumWitnesses)
+	}
+	if e.Witness.NumPublicInputsPerWitness != b.Layout.PublicInputs {
+		return fmt.Errorf("witness has %d public inputs, layout expects %d", e.Witness.NumPublicInputsPerWitness, b.Layout.PublicInputs)
+	}
+	single := Layout{Instances: 1, CheckBits: b.Batch.CheckBits, ContextBytes: b.Batch.ContextBytes, PublicMessages: b.Batch.PublicMessages}
+	digests, err := PublicDigests(e.Witness, single)
+	if err != nil {
+		return err
+	}
+	contexts, err := PublicContexts(e.Witness, single)
+	if err != nil {
+		return err
+	}
+	if fmt.Sprint(digests[0][0]) != fmt.Sprint(b.Digest) {
+		return fmt.Errorf("instance bundle digest does not match its witness")
+	}
+	if string(contexts[0]) != string(b.Context) {
+		return fmt.Errorf("instance bundle context does not match its witness")
+	}
+	if !test.CheckCircuit(c, e.Witness) {
+		return fmt.Errorf("instance %d of assignment %d does not satisfy the circuit", b.Instance, b.Assignment)
+	}
+	return nil
+}
+
+// WriteFile writes the bundle as JSON.
+func (b *InstanceBundle) WriteFile(path string) error {
+	raw, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, raw, 0o644)
+}
+
+// LoadInstanceBundle reads a bundle written with WriteFile.
+func LoadInstanceBundle(path string) (*InstanceBundle, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b := &InstanceBundle{}
+	if err := json.Unmarshal(raw, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
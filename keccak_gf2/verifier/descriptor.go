@@ -0,0 +1,60 @@
+package verifier
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// DescriptorVersion is bumped whenever the meaning of a Descriptor field changes.
+const DescriptorVersion = 1
+
+// Descriptor is the language-neutral form of Layout, written next to the circuit as layout.json so
+// verifiers in other languages can find every public input without reading the Go code.
+// It is generated from DigestPosition / ContextPosition / MessagePosition, the mapping PublicDigests uses.
+type Descriptor struct {
+	Version int `json:"version"`
+	// Circuit is the Fingerprint of the serialized circuit the layout belongs to.
+	Circuit string `json:"circuit"`
+	// PublicInputs is the number of public inputs per assignment; they follow the private inputs.
+	PublicInputs int           `json:"public_inputs"`
+	Inputs       []PublicInput `json:"inputs"`
+}
+
+// PublicInput says what one public input position holds: bit Bit (0 = least significant) of byte Byte
+// of either digest Instance ("digest"), of the context ("context", Instance = -1) or of message Instance
+// ("message", public-message layouts only).
+type PublicInput struct {
+	Position int    `json:"position"`
+	Kind     string `json:"kind"`
+	Instance int    `json:"instance"`
+	Byte     int    `json:"byte"`
+	Bit      int    `json:"bit"`
+}
+
+// Describe builds the descriptor of l for the circuit with the given fingerprint.
+func (l Layout) Describe(fingerprint string) *Descriptor {
+	d := &Descriptor{Version: DescriptorVersion, Circuit: fingerprint, PublicInputs: l.publicInputs()}
+	for k := 0; k < l.Instances; k++ {
+		for i := 0; i < l.CheckBits; i++ {
+			d.Inputs = append(d.Inputs, PublicInput{Position: l.DigestPosition(k, i), Kind: "digest", Instance: k, Byte: i / 8, Bit: i % 8})
+		}
+	}
+	for i := 0; i < 8*l.ContextBytes; i++ {
+		d.Inputs = append(d.Inputs, PublicInput{Position: l.ContextPosition(i), Kind: "context", Instance: -1, Byte: i / 8, Bit: i % 8})
+	}
+	for k := 0; l.PublicMessages && k < l.Instances; k++ {
+		for i := 0; i < 8*MessageBytes; i++ {
+			d.Inputs = append(d.Inputs, PublicInput{Position: l.MessagePosition(k, i), Kind: "message", Instance: k, Byte: i / 8, Bit: i % 8})
+		}
+	}
+	return d
+}
+
+// WriteFile writes the descriptor as JSON.
+func (d *Descriptor) WriteFile(path string) error {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
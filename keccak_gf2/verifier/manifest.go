@@ -0,0 +1,95 @@
+package verifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Manifest maps every message of a multi-file run to where its digest ended up.
+// Messages are packed Layout.Instances to an assignment and several assignments to a witness file;
+// the last assignment is filled up with padding slots, which carry no message.
+type Manifest struct {
+	Layout   Layout `json:"layout"`
+	Messages int    `json:"messages"`
+	Slots    []Slot `json:"slots"`
+}
+
+// Slot is one instance slot of one assignment.
+// File is the witness envelope holding it, relative to the manifest's directory, and Assignment is the
+// envelope index (Envelope.Indices) of its assignment. Message is -1 for padding slots.
+type Slot struct {
+	Message    int    `json:"message"`
+	File       string `json:"file"`
+	Assignment int    `json:"assignment"`
+	Instance   int    `json:"instance"`
+	Padding    bool   `json:"padding,omitempty"`
+}
+
+// Serialize encodes the manifest as JSON.
+func (m *Manifest) Serialize() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// LoadManifest reads a manifest written with Serialize. A slot whose file is not a local path (empty,
+// absolute, or leaving the manifest's directory through "..") is an error.
+func LoadManifest(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	for _, s := range m.Slots {
+		if err := s.checkFile(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return m, nil
+}
+
+// checkFile rejects a File that does not name a file under the manifest's directory.
+func (s Slot) checkFile() error {
+	if !filepath.IsLocal(s.File) {
+		return fmt.Errorf("slot %d of assignment %d names the file %q outside the manifest's directory", s.Instance, s.Assignment, s.File)
+	}
+	return nil
+}
+
+// Locate returns the slot holding message msg.
+func (m *Manifest) Locate(msg int) (Slot, error) {
+	for _, s := range m.Slots {
+		if !s.Padding && s.Message == msg {
+			return s, nil
+		}
+	}
+	return Slot{}, fmt.Errorf("message %d is not in the manifest", msg)
+}
+
+// MessageDigest loads the witness file holding message msg from dir and returns its public digest bits.
+func (m *Manifest) MessageDigest(dir string, msg int) ([]int, error) {
+	s, err := m.Locate(msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkFile(); err != nil {
+		return nil, err
+	}
+	e, err := LoadEnvelope(filepath.Join(dir, s.File))
+	if err != nil {
+		return nil, err
+	}
+	digests, err := PublicDigests(e.Witness, m.Layout)
+	if err != nil {
+		return nil, err
+	}
+	for z, idx := range e.Indices {
+		if idx == s.Assignment {
+			return digests[z][s.Instance], nil
+		}
+	}
+	return nil, fmt.Errorf("%s does not hold assignment %d", s.File, s.Assignment)
+}
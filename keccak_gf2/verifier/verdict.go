@@ -0,0 +1,98 @@
+package verifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// VerdictVersion is bumped whenever the meaning of a Verdict field changes.
+const VerdictVersion = 1
+
+// Exit statuses of a check run (Verdict.ExitCode), for pipelines that only look at the process status.
+const (
+	ExitPassed = 0 // every assignment of the batch verified
+	ExitFailed = 1 // the batch was checked and at least one assignment failed
+	ExitError  = 2 // the batch could not be checked (unreadable circuit or envelope, ...)
+)
+
+// Verdict is the machine-readable result of checking one batch, written as JSON by the -check mode.
+// Failed lists caller indices (Envelope.Indices), never batch positions, and is empty rather than absent
+// when everything passed. Error is set, and the counts are zero, when the batch could not be checked.
+type Verdict struct {
+	Version    int          `json:"version"`
+	Circuit    string       `json:"circuit"`
+	Envelope   EnvelopeInfo `json:"envelope"`
+	Total      int          `json:"total"`
+	Passed     int          `json:"passed"`
+	Failed     []int        `json:"failed"`
+	WallTimeMs int64        `json:"wall_time_ms"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// EnvelopeInfo describes the witness envelope a verdict was reached on.
+type EnvelopeInfo struct {
+	Path         string `json:"path"`
+	Assignments  int    `json:"assignments"`
+	Inputs       int    `json:"inputs_per_assignment"`
+	PublicInputs int    `json:"public_inputs_per_assignment"`
+}
+
+// CheckFiles loads the circuit and the envelope and checks every assignment of the batch.
+// It always returns a verdict; a failure to load either file, or a panic in the ecgo runtime on a
+// malformed one, is recorded in Verdict.Error.
+func CheckFiles(circuitPath string, envelopePath string) (v *Verdict) {
+	start := time.Now()
+	v = &Verdict{Version: VerdictVersion, Failed: []int{}, Envelope: EnvelopeInfo{Path: envelopePath}}
+	defer func() {
+		if r := recover(); r != nil {
+			v.Total, v.Passed, v.Failed = 0, 0, []int{}
+			v.Error = fmt.Sprintf("check failed: %v", r)
+		}
+		v.WallTimeMs = time.Since(start).Milliseconds()
+	}()
+	c, fingerprint, err := LoadCircuit(circuitPath)
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	v.Circuit = fingerprint
+	e, err := LoadEnvelope(envelopePath)
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	v.Envelope.Assignments = len(e.Indices)
+	v.Envelope.Inputs = e.Witness.NumInputsPerWitness
+	v.Envelope.PublicInputs = e.Witness.NumPublicInputsPerWitness
+	for z, ok := range Check(c, e) {
+		v.Total++
+		if ok {
+			v.Passed++
+		} else {
+			v.Failed = append(v.Failed, e.Indices[z])
+		}
+	}
+	return v
+}
+
+// ExitCode maps the verdict to ExitPassed, ExitFailed or ExitError.
+func (v *Verdict) ExitCode() int {
+	switch {
+	case v.Error != "":
+		return ExitError
+	case len(v.Failed) > 0:
+		return ExitFailed
+	}
+	return ExitPassed
+}
+
+// WriteFile writes the verdict as JSON.
+func (v *Verdict) WriteFile(path string) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
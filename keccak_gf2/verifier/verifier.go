@@ -0,0 +1,281 @@
+// Package verifier is the lean, verifier-side half of the keccak_gf2 demo.
+// It loads a compiled layered circuit and a batch witness envelope, checks every assignment in the batch,
+// and maps the public inputs back to digests. It deliberately depends only on the ecgo runtime
+// (no gadget construction code, no go-ethereum), so a verifying party can vendor it on its own.
+package verifier
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/irwg"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/layered"
+	"github.com/PolyhedraZK/ExpanderCompilerCollection/ecgo/test"
+)
+
+// Layout describes how the public inputs of one assignment are arranged:
+// Instances digests of CheckBits bits each, digest k occupying public inputs [k*CheckBits, (k+1)*CheckBits),
+// every digest LSB first within each byte, followed by ContextBytes bytes of public context (0 if the
+// circuit was built without one) and, with PublicMessages, by the Instances messages of MessageBytes
+// bytes each (the transparent-hash circuit, whose messages are public inputs).
+type Layout struct {
+	Instances      int
+	CheckBits      int
+	ContextBytes   int
+	PublicMessages bool
+}
+
+// MessageBytes is the length of every message of a keccak256Circuit.
+const MessageBytes = 64
+
+func (l Layout) publicInputs() int {
+	n := l.Instances*l.CheckBits + 8*l.ContextBytes
+	if l.PublicMessages {
+		n += l.Instances * 8 * MessageBytes
+	}
+	return n
+}
+
+// DigestPosition is the public input index (within one assignment) of bit i of digest k,
+// i.e. bit i%8 of byte i/8.
+func (l Layout) DigestPosition(k int, i int) int {
+	return k*l.CheckBits + i
+}
+
+// ContextPosition is the public input index of context bit i (bit i%8 of context byte i/8).
+func (l Layout) ContextPosition(i int) int {
+	return l.Instances*l.CheckBits + i
+}
+
+// MessagePosition is the public input index of bit i of message k (bit i%8 of byte i/8); only
+// meaningful with PublicMessages.
+func (l Layout) MessagePosition(k int, i int) int {
+	return l.Instances*l.CheckBits + 8*l.ContextBytes + k*8*MessageBytes + i
+}
+
+// Fingerprint identifies a compiled circuit by the SHA-256 of its serialized form.
+func Fingerprint(serializedCircuit []byte) string {
+	h := sha256.Sum256(serializedCircuit)
+	return hex.EncodeToString(h[:])
+}
+
+// LoadCircuit reads a serialized layered circuit (e.g. circuit.txt) and returns it with its fingerprint.
+func LoadCircuit(path string) (*layered.RootCircuit, string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return ecgo.DeserializeLayeredCircuit(b), Fingerprint(b), nil
+}
+
+// Envelope is a batch witness plus the ordering contract of the batch:
+// Indices[z] is the caller-side index of the z-th assignment in Witness.
+// Public inputs must always be mapped back to messages through Indices, never through the batch position.
+type Envelope struct {
+	Indices []int
+	Witness *irwg.Witness
+}
+
+// EnvelopeVersion is bumped whenever the serialized form of an Envelope changes; it follows the magic as a
+// u32. Version 1 wrote it as one ASCII digit instead ("KWE1"), which ParseEnvelope still reads: the rest of
+// the layout is the same.
+const EnvelopeVersion = 2
+
+const (
+	envelopeMagic   = "KWEV"
+	envelopeMagicV1 = "KWE1"
+)
+
+// Serialize writes the envelope as (all integers u32 little endian, big integers big endian):
+// magic | version | count | count × index | inputs per witness | public inputs per witness |
+// len(field) | field | number of values | for each value: len | value
+// The witness is stored field by field so that loading it needs nothing beyond this package.
+func (e *Envelope) Serialize() []byte {
+	w := e.Witness
+	buf := binary.LittleEndian.AppendUint32([]byte(envelopeMagic), EnvelopeVersion)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(e.Indices)))
+	for _, idx := range e.Indices {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(idx))
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(w.NumInputsPerWitness))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(w.NumPublicInputsPerWitness))
+	buf = appendBig(buf, w.Field)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(w.Values)))
+	for _, v := range w.Values {
+		buf = appendBig(buf, v)
+	}
+	return buf
+}
+
+func appendBig(buf []byte, v *big.Int) []byte {
+	b := v.Bytes()
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(b)))
+	return append(buf, b...)
+}
+
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) u32() int {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.b) < 4 {
+		r.err = errors.New("truncated witness envelope")
+		return 0
+	}
+	v := binary.LittleEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return int(v)
+}
+
+func (r *reader) big() *big.Int {
+	n := r.u32()
+	if r.err != nil {
+		return nil
+	}
+	if len(r.b) < n {
+		r.err = errors.New("truncated witness envelope")
+		return nil
+	}
+	v := new(big.Int).SetBytes(r.b[:n])
+	r.b = r.b[n:]
+	return v
+}
+
+// count reads the number of items that follow, each taking at least size bytes: a count that the rest of
+// the envelope cannot hold is an error before anything is allocated for it.
+func (r *reader) count(size int) int {
+	n := r.u32()
+	if r.err == nil && n > len(r.b)/size {
+		r.err = errors.New("truncated witness envelope")
+		return 0
+	}
+	return n
+}
+
+// ParseEnvelope is the inverse of Envelope.Serialize.
+func ParseEnvelope(b []byte) (*Envelope, error) {
+	if len(b) < 4 {
+		return nil, errors.New("not a witness envelope")
+	}
+	r := &reader{b: b[4:]}
+	switch string(b[:4]) {
+	case envelopeMagicV1:
+	case envelopeMagic:
+		if v := r.u32(); r.err == nil && v != EnvelopeVersion {
+			return nil, fmt.Errorf("witness envelope version %d, this verifier reads versions 1 to %d", v, EnvelopeVersion)
+		}
+	default:
+		return nil, errors.New("not a witness envelope")
+	}
+	e := &Envelope{Indices: make([]int, r.count(4))}
+	for i := range e.Indices {
+		e.Indices[i] = r.u32()
+	}
+	w := &irwg.Witness{NumWitnesses: len(e.Indices)}
+	w.NumInputsPerWitness = r.u32()
+	w.NumPublicInputsPerWitness = r.u32()
+	w.Field = r.big()
+	// every value has at least its 4-byte length
+	w.Values = make([]*big.Int, r.count(4))
+	for i := range w.Values {
+		w.Values[i] = r.big()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(w.Values) != w.NumWitnesses*(w.NumInputsPerWitness+w.NumPublicInputsPerWitness) {
+		return nil, fmt.Errorf("witness envelope holds %d values, expected %d", len(w.Values), w.NumWitnesses*(w.NumInputsPerWitness+w.NumPublicInputsPerWitness))
+	}
+	e.Witness = w
+	return e, nil
+}
+
+// LoadEnvelope reads and parses a witness envelope file.
+func LoadEnvelope(path string) (*Envelope, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseEnvelope(b)
+}
+
+// Check runs the layered circuit on every assignment of the batch; result z belongs to caller index Indices[z].
+// Check only reads c and e: a service may load a circuit once and check envelopes against it from any
+// number of goroutines (TestConcurrentCheck runs that under go test -race, and BenchmarkConcurrentCheck
+// measures it).
+func Check(c *layered.RootCircuit, e *Envelope) []bool {
+	return test.CheckCircuitMultiWitness(c, e.Witness)
+}
+
+// PublicDigests reads the public digests out of a batch witness.
+// Within one assignment the private inputs come first and the public inputs follow, so digest k of the
+// z-th assignment is public input [k*CheckBits, (k+1)*CheckBits) of block z.
+func PublicDigests(w *irwg.Witness, l Layout) ([][][]int, error) {
+	if w.NumPublicInputsPerWitness != l.publicInputs() {
+		return nil, fmt.Errorf("witness has %d public inputs per assignment, layout expects %d", w.NumPublicInputsPerWitness, l.publicInputs())
+	}
+	per := w.NumInputsPerWitness + w.NumPublicInputsPerWitness
+	digests := make([][][]int, w.NumWitnesses)
+	for z := 0; z < w.NumWitnesses; z++ {
+		pub := w.Values[z*per+w.NumInputsPerWitness : (z+1)*per]
+		digests[z] = make([][]int, l.Instances)
+		for k := 0; k < l.Instances; k++ {
+			digests[z][k] = make([]int, l.CheckBits)
+			for i := 0; i < l.CheckBits; i++ {
+				digests[z][k][i] = int(pub[l.DigestPosition(k, i)].Bit(0))
+			}
+		}
+	}
+	return digests, nil
+}
+
+// PublicContexts reads the context each assignment of a batch witness is bound to.
+// The context bits follow the digests in the public inputs, LSB first within each byte.
+func PublicContexts(w *irwg.Witness, l Layout) ([][]byte, error) {
+	if w.NumPublicInputsPerWitness != l.publicInputs() {
+		return nil, fmt.Errorf("witness has %d public inputs per assignment, layout expects %d", w.NumPublicInputsPerWitness, l.publicInputs())
+	}
+	per := w.NumInputsPerWitness + w.NumPublicInputsPerWitness
+	contexts := make([][]byte, w.NumWitnesses)
+	for z := 0; z < w.NumWitnesses; z++ {
+		pub := w.Values[z*per+w.NumInputsPerWitness : (z+1)*per]
+		contexts[z] = make([]byte, l.ContextBytes)
+		for i := 0; i < 8*l.ContextBytes; i++ {
+			contexts[z][i/8] |= byte(pub[l.ContextPosition(i)].Bit(0)) << (i % 8)
+		}
+	}
+	return contexts, nil
+}
+
+// PublicMessages reads the messages of every assignment of a batch witness of a PublicMessages layout.
+func PublicMessages(w *irwg.Witness, l Layout) ([][][]byte, error) {
+	if !l.PublicMessages {
+		return nil, errors.New("layout has no public messages")
+	}
+	if w.NumPublicInputsPerWitness != l.publicInputs() {
+		return nil, fmt.Errorf("witness has %d public inputs per assignment, layout expects %d", w.NumPublicInputsPerWitness, l.publicInputs())
+	}
+	per := w.NumInputsPerWitness + w.NumPublicInputsPerWitness
+	messages := make([][][]byte, w.NumWitnesses)
+	for z := 0; z < w.NumWitnesses; z++ {
+		pub := w.Values[z*per+w.NumInputsPerWitness : (z+1)*per]
+		messages[z] = make([][]byte, l.Instances)
+		for k := range messages[z] {
+			messages[z][k] = make([]byte, MessageBytes)
+			for i := 0; i < 8*MessageBytes; i++ {
+				messages[z][k][i/8] |= byte(pub[l.MessagePosition(k, i)].Bit(0)) << (i % 8)
+			}
+		}
+	}
+	return messages, nil
+}
@@ -0,0 +1,119 @@
+package keccakgf2
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/YolaYing/Expander-Sha256-gf2/keccak_gf2/verifier"
+)
+
+// Witness diffing:
+// Two runs that should be identical (same seed, same circuit) must write byte-identical witness envelopes.
+// When they do not, DiffWitnesses names the first place they diverge: the shape of the batch (assignment
+// count, caller indices, inputs per assignment, field) first, then the values assignment by assignment,
+// public inputs only unless full is set (the private inputs of a seeded run are just as deterministic,
+// but may be sensitive). A differing value is reported with its witness position and, given the
+// annotation table of the circuit (inputNames), the circuit field it belongs to.
+
+// WitnessDifference is the first divergence between two witness envelopes. For a differing value,
+// Assignment is the batch position, Index its caller index, Position the witness position within the
+// assignment and Name its annotation ("" without a table); for a differing shape only What is set.
+type WitnessDifference struct {
+	What        string
+	Assignment  int
+	Index       int
+	Position    int
+	Name        string
+	Left, Right string
+}
+
+func (d *WitnessDifference) String() string {
+	if d.Left == "" && d.Right == "" {
+		return d.What
+	}
+	name := ""
+	if d.Name != "" {
+		name = " (" + d.Name + ")"
+	}
+	return fmt.Sprintf("%s: assignment %d (index %d), position %d%s: %s vs %s", d.What, d.Assignment, d.Index, d.Position, name, d.Left, d.Right)
+}
+
+// DiffWitnesses returns the first difference between a and b, or nil if they agree; names is the
+// annotation table of their circuit, or nil.
+func DiffWitnesses(a, b *verifier.Envelope, names []string, full bool) *WitnessDifference {
+	x, y := a.Witness, b.Witness
+	shape := func(format string, args ...interface{}) *WitnessDifference {
+		return &WitnessDifference{What: fmt.Sprintf(format, args...)}
+	}
+	switch {
+	case x.NumWitnesses != y.NumWitnesses:
+		return shape("assignment count: %d vs %d", x.NumWitnesses, y.NumWitnesses)
+	case x.NumInputsPerWitness != y.NumInputsPerWitness:
+		return shape("private inputs per assignment: %d vs %d", x.NumInputsPerWitness, y.NumInputsPerWitness)
+	case x.NumPublicInputsPerWitness != y.NumPublicInputsPerWitness:
+		return shape("public inputs per assignment: %d vs %d", x.NumPublicInputsPerWitness, y.NumPublicInputsPerWitness)
+	case x.Field.Cmp(y.Field) != 0:
+		return shape("field: %s vs %s", x.Field, y.Field)
+	}
+	if len(a.Indices) != len(b.Indices) {
+		return shape("caller indices: %d vs %d", len(a.Indices), len(b.Indices))
+	}
+	for z := range a.Indices {
+		if a.Indices[z] != b.Indices[z] {
+			return shape("assignment %d: index %d vs %d", z, a.Indices[z], b.Indices[z])
+		}
+	}
+	per := x.NumInputsPerWitness + x.NumPublicInputsPerWitness
+	if len(names) != per {
+		names = nil
+	}
+	first := x.NumInputsPerWitness
+	if full {
+		first = 0
+	}
+	for z := 0; z < x.NumWitnesses; z++ {
+		for pos := first; pos < per; pos++ {
+			l, r := x.Values[z*per+pos], y.Values[z*per+pos]
+			if l.Cmp(r) == 0 {
+				continue
+			}
+			d := &WitnessDifference{What: "public input", Assignment: z, Index: a.Indices[z], Position: pos, Left: l.String(), Right: r.String()}
+			if pos < x.NumInputsPerWitness {
+				d.What = "private input"
+			}
+			if names != nil {
+				d.Name = names[pos]
+			}
+			return d
+		}
+	}
+	return nil
+}
+
+// DiffWitnessFiles loads two witness envelopes and writes their first difference to w, if any; it
+// reports whether they agree.
+func DiffWitnessFiles(w io.Writer, left, right string, names []string, full bool) (bool, error) {
+	a, err := verifier.LoadEnvelope(left)
+	if err != nil {
+		return false, err
+	}
+	b, err := verifier.LoadEnvelope(right)
+	if err != nil {
+		return false, err
+	}
+	d := DiffWitnesses(a, b, names, full)
+	if d == nil {
+		return true, nil
+	}
+	_, err = fmt.Fprintf(w, "%s and %s differ: %s\n", left, right, d)
+	return false, err
+}
+
+// WitnessNames is the annotation table of the batch circuit of o (public messages if o.PublicInput).
+func WitnessNames(o Options) []string {
+	template := newKeccak256Circuit(o.Instances, len(o.Context))
+	if o.PublicInput {
+		template.withPublicMessages()
+	}
+	return inputNames(template)
+}